@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/audit"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// NewAuditCommand returns the command which lists audit events kept by the simulator api
+func NewAuditCommand() *cobra.Command {
+	var server, output, stage string
+	var since time.Duration
+	var filter audit.Filter
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "List audit events of apiserver kept by simulator",
+		Example: `  # who created replicasets in the last 10 minutes
+  kube-simulator audit --verb create --resource replicasets --since 10m`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter.Stage = auditv1.Stage(stage)
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			list := &auditv1.EventList{}
+			if err := simapi.Get(server, audit.EventsPath, filter.Query(), list); err != nil {
+				return err
+			}
+			if output == "json" {
+				return printJSON(cmd.OutOrStdout(), list)
+			}
+			printAuditEvents(cmd.OutOrStdout(), list.Items)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&server, "server", simapi.DefaultListen, "the address of the simulator api")
+	fs.StringVarP(&output, "output", "o", "", "output format, json or empty for text")
+	fs.StringVar(&filter.User, "user", "", "only list requests of the user")
+	fs.StringVar(&filter.Verb, "verb", "", "only list requests of the verb, e.g. create, list or watch")
+	fs.StringVar(&filter.Resource, "resource", "", "only list requests of the resource, e.g. pods or pods/status")
+	fs.StringVarP(&filter.Namespace, "namespace", "n", "", "only list requests in the namespace")
+	fs.StringVar(&filter.Name, "name", "", "only list requests of the object")
+	fs.StringVar(&stage, "stage", string(auditv1.StageResponseComplete), "only list events of the stage, empty for all stages")
+	fs.DurationVar(&since, "since", 0, "only list requests received in the duration")
+	fs.IntVar(&filter.Limit, "limit", 100, "list at most the latest n events, 0 for all")
+	return cmd
+}
+
+func printAuditEvents(out io.Writer, events []auditv1.Event) {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tUSER\tVERB\tOBJECT\tCODE")
+	for _, event := range events {
+		code := ""
+		if event.ResponseStatus != nil {
+			code = fmt.Sprint(event.ResponseStatus.Code)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", event.RequestReceivedTimestamp.Format(time.RFC3339), event.User.Username, event.Verb, auditObject(&event), code)
+	}
+	writer.Flush()
+}
+
+// auditObject returns resource[/subresource] namespace/name of the request, or its uri for non resource requests
+func auditObject(event *auditv1.Event) string {
+	ref := event.ObjectRef
+	if ref == nil {
+		return event.RequestURI
+	}
+	resource := ref.Resource
+	if ref.APIGroup != "" {
+		resource += "." + ref.APIGroup
+	}
+	if ref.Subresource != "" {
+		resource += "/" + ref.Subresource
+	}
+	object := []string{resource}
+	if ref.Namespace != "" || ref.Name != "" {
+		object = append(object, strings.TrimPrefix(strings.TrimSuffix(ref.Namespace+"/"+ref.Name, "/"), "/"))
+	}
+	return strings.Join(object, " ")
+}
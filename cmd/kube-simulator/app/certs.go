@@ -0,0 +1,131 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/cmd/kube-simulator/options"
+	"3Xpl0it3r.com/kube-simulator/pkg/simulator"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// NewCertsCommand returns the command which checks and renews certificates of the simulated cluster, like kubeadm certs.
+// it takes the same flags as starting simulator so certificates are found at the same paths
+func NewCertsCommand() *cobra.Command {
+	opts := options.NewOptions()
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Check and renew certificates of the simulated cluster",
+	}
+	cmd.PersistentFlags().AddFlagSet(opts.FlagsSets())
+	cmd.AddCommand(newCertsCheckExpirationCommand(opts), newCertsRenewCommand(opts))
+	return cmd
+}
+
+func newCertsCheckExpirationCommand(opts *options.Options) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "check-expiration",
+		Short: "Check expiration and validity of certificates and kubeconfigs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			statuses, err := simulator.CheckCertificates(&config)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return printJSON(cmd.OutOrStdout(), statuses)
+			}
+			printCertificateStatuses(cmd.OutOrStdout(), statuses)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "output format, json or empty for text")
+	return cmd
+}
+
+func newCertsRenewCommand(opts *options.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "renew <all|name>...",
+		Short: "Renew certificates and kubeconfigs signed by the cluster cas",
+		Long: "Renew certificates and kubeconfigs signed by the cluster cas no matter whether they are valid, cas are never renewed. " +
+			"names are apiserver, apiserver-etcd-client, etcd-server, front-proxy-client, admin.conf, controller-manager.conf, scheduler.conf, <node>.conf of bootstrap nodes, " +
+			"and oidc-issuer, webhook and apiservice if they are enabled",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			if err := simulator.RenewCertificates(&config, args...); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "certificates renewed, restart kube-simulator to use them")
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+// completedConfig completes options like starting simulator does
+func completedConfig(opts *options.Options) (simulator.Config, error) {
+	if err := opts.Complete(); err != nil {
+		return simulator.Config{}, err
+	}
+	config := opts.Config()
+	if err := config.Complete(); err != nil {
+		return simulator.Config{}, err
+	}
+	return config, nil
+}
+
+// printCertificateStatuses prints certificates first and then cas, like kubeadm certs check-expiration
+func printCertificateStatuses(out io.Writer, statuses []simulator.CertificateStatus) {
+	writer := tabwriter.NewWriter(out, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(writer, strings.Join([]string{"CERTIFICATE", "EXPIRES", "RESIDUAL TIME", "CERTIFICATE AUTHORITY", "PROBLEM"}, "\t"))
+	for _, status := range statuses {
+		if status.CA != "" {
+			fmt.Fprintln(writer, strings.Join([]string{status.Name, expiresOf(status), residualTimeOf(status), status.CA, problemOf(status)}, "\t"))
+		}
+	}
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, strings.Join([]string{"CERTIFICATE AUTHORITY", "EXPIRES", "RESIDUAL TIME", "PROBLEM"}, "\t"))
+	for _, status := range statuses {
+		if status.CA == "" {
+			fmt.Fprintln(writer, strings.Join([]string{status.Name, expiresOf(status), residualTimeOf(status), problemOf(status)}, "\t"))
+		}
+	}
+	writer.Flush()
+}
+
+func expiresOf(status simulator.CertificateStatus) string {
+	if status.NotAfter.IsZero() {
+		return "<missing>"
+	}
+	return status.NotAfter.Format("Jan 02, 2006 15:04 MST")
+}
+
+func residualTimeOf(status simulator.CertificateStatus) string {
+	residual := time.Until(status.NotAfter)
+	if status.NotAfter.IsZero() || residual <= 0 {
+		return "<invalid>"
+	}
+	return duration.ShortHumanDuration(residual)
+}
+
+func problemOf(status simulator.CertificateStatus) string {
+	if status.Problem == "" {
+		return "-"
+	}
+	return status.Problem
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/cmd/kube-simulator/options"
+	"3Xpl0it3r.com/kube-simulator/pkg/kuberes"
+	"3Xpl0it3r.com/kube-simulator/pkg/simulator"
+	"github.com/spf13/cobra"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewKubeconfigCommand returns the command which creates kubeconfigs of arbitrary identities of the simulated cluster.
+// it takes the same flags as starting simulator so the cluster ca is found at the same path
+func NewKubeconfigCommand() *cobra.Command {
+	opts := options.NewOptions()
+	cmd := &cobra.Command{
+		Use:   "kubeconfig",
+		Short: "Create kubeconfigs of users and service accounts of the simulated cluster",
+	}
+	cmd.PersistentFlags().AddFlagSet(opts.FlagsSets())
+	cmd.AddCommand(newKubeconfigCreateCommand(opts))
+	return cmd
+}
+
+func newKubeconfigCreateCommand(opts *options.Options) *cobra.Command {
+	var request simulator.KubeconfigRequest
+	var out string
+	cmd := &cobra.Command{
+		Use:   "create --user <user> [--group <group>]... | --service-account <namespace/name>",
+		Short: "Create a kubeconfig with a client certificate of a user, or with a token of a service account",
+		Long: "Create a kubeconfig with a client certificate signed by the cluster ca for a user and groups, " +
+			"or with a token of a service account which is requested from the running cluster with the admin kubeconfig",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := request.Validate(); err != nil {
+				return err
+			}
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			var client kubeclientset.Interface
+			if request.ServiceAccount != "" {
+				if client, err = kuberes.NewClusterClient("", config.Cluster.ClientConfigFile.Administrator); err != nil {
+					return err
+				}
+			}
+			kubeconfig, err := simulator.CreateKubeconfig(context.Background(), &config, client, request)
+			if err != nil {
+				return err
+			}
+			if out != "" {
+				return clientcmd.WriteToFile(*kubeconfig, out)
+			}
+			content, err := clientcmd.Write(*kubeconfig)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprint(cmd.OutOrStdout(), string(content))
+			return err
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&request.User, "user", "", "common name of the client certificate")
+	fs.StringSliceVar(&request.Groups, "group", nil, "groups of the client certificate, can be repeated")
+	fs.StringVar(&request.ServiceAccount, "service-account", "", "namespace/name of the service account whose token is used instead of a client certificate")
+	fs.DurationVar(&request.TTL, "ttl", 24*time.Hour, "lifetime of the client certificate or the token")
+	fs.StringVar(&request.Server, "server", "", "url of apiserver in the kubeconfig, defaults to the address apiserver listens on")
+	fs.StringVar(&out, "out", "", "file the kubeconfig is written to, stdout if it's empty")
+	return cmd
+}
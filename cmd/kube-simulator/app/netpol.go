@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/agent/netpol"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"github.com/spf13/cobra"
+)
+
+// NewNetPolCommand returns the command which evaluates network policies of the simulated cluster
+func NewNetPolCommand() *cobra.Command {
+	var server, output string
+	cmd := &cobra.Command{
+		Use:   "netpol",
+		Short: "Evaluate network policies against simulated pods",
+	}
+	cmd.PersistentFlags().StringVar(&server, "server", simapi.DefaultListen, "the address of the simulator api")
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "", "output format, json or empty for text")
+	cmd.AddCommand(newNetPolCheckCommand(&server, &output), newNetPolMatrixCommand(&server, &output))
+	return cmd
+}
+
+func newNetPolCheckCommand(server, output *string) *cobra.Command {
+	var request netpol.Request
+	var protocol string
+	cmd := &cobra.Command{
+		Use:   "check --from <namespace/pod|ip> --to <namespace/pod|ip> --port <port>",
+		Short: "Check whether a connection is allowed by network policies",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if request.Protocol, err = netpol.ParseProtocol(protocol); err != nil {
+				return err
+			}
+			result := &netpol.Result{}
+			if err := simapi.Get(*server, netpol.CheckPath, request.Query(), result); err != nil {
+				return err
+			}
+			if *output == "json" {
+				return printJSON(cmd.OutOrStdout(), result)
+			}
+			printNetPolResult(cmd.OutOrStdout(), result)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&request.From, "from", "", "namespace/name of the source pod, or an ip out of cluster")
+	fs.StringVar(&request.To, "to", "", "namespace/name of the destination pod, or an ip out of cluster")
+	fs.StringVar(&request.Port, "port", "", "number or name of the destination port")
+	fs.StringVar(&protocol, "protocol", "TCP", "protocol of the connection, TCP, UDP or SCTP")
+	return cmd
+}
+
+func newNetPolMatrixCommand(server, output *string) *cobra.Command {
+	var namespaces []string
+	var port, protocol string
+	cmd := &cobra.Command{
+		Use:   "matrix --port <port>",
+		Short: "Report connectivity between pods of namespaces",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := netpol.ParseProtocol(protocol); err != nil {
+				return err
+			}
+			query := url.Values{}
+			query.Set("port", port)
+			query.Set("protocol", protocol)
+			if len(namespaces) != 0 {
+				query.Set("namespaces", strings.Join(namespaces, ","))
+			}
+			matrix := &netpol.Matrix{}
+			if err := simapi.Get(*server, netpol.MatrixPath, query, matrix); err != nil {
+				return err
+			}
+			if *output == "json" {
+				return printJSON(cmd.OutOrStdout(), matrix)
+			}
+			printNetPolMatrix(cmd.OutOrStdout(), matrix)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringSliceVar(&namespaces, "namespaces", nil, "namespaces of pods in the report, all namespaces if it's empty")
+	fs.StringVar(&port, "port", "", "number or name of the destination port")
+	fs.StringVar(&protocol, "protocol", "TCP", "protocol of connections, TCP, UDP or SCTP")
+	return cmd
+}
+
+func printNetPolResult(out io.Writer, result *netpol.Result) {
+	verdict := "DENIED"
+	if result.Allowed {
+		verdict = "ALLOWED"
+	}
+	fmt.Fprintf(out, "%s -> %s port %d/%s: %s\n", result.From, result.To, result.Port, result.Protocol, verdict)
+	printNetPolVerdict(out, "egress of "+result.From, result.Egress)
+	printNetPolVerdict(out, "ingress of "+result.To, result.Ingress)
+}
+
+func printNetPolVerdict(out io.Writer, direction string, verdict netpol.Verdict) {
+	switch {
+	case !verdict.Isolated:
+		fmt.Fprintf(out, "  %s: not isolated\n", direction)
+	case verdict.Allowed:
+		fmt.Fprintf(out, "  %s: allowed by %s\n", direction, strings.Join(verdict.AllowedBy, ","))
+	default:
+		fmt.Fprintf(out, "  %s: denied, selected by %s\n", direction, strings.Join(verdict.Policies, ","))
+	}
+}
+
+// printNetPolMatrix prints rows of sources and columns of destinations, columns are numbered to keep the table narrow
+func printNetPolMatrix(out io.Writer, matrix *netpol.Matrix) {
+	fmt.Fprintf(out, "Connectivity to port %s/%s, '.' is allowed and 'X' is denied\n", matrix.Port, matrix.Protocol)
+	writer := tabwriter.NewWriter(out, 0, 4, 1, ' ', 0)
+	header := []string{"FROM\\TO"}
+	for idx := range matrix.Pods {
+		header = append(header, fmt.Sprint(idx))
+	}
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for i, pod := range matrix.Pods {
+		row := []string{fmt.Sprintf("%d %s", i, pod)}
+		for _, allowed := range matrix.Allowed[i] {
+			if allowed {
+				row = append(row, ".")
+			} else {
+				row = append(row, "X")
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+}
+
+func printJSON(out io.Writer, obj interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(obj)
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/cmd/kube-simulator/options"
+	"3Xpl0it3r.com/kube-simulator/pkg/oidc"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// NewOIDCCommand returns the command which issues id tokens of the oidc issuer stand-in.
+// it takes the same flags as starting simulator so the signing key is found at the same path
+func NewOIDCCommand() *cobra.Command {
+	opts := options.NewOptions()
+	cmd := &cobra.Command{
+		Use:   "oidc",
+		Short: "Issue id tokens of the oidc issuer stand-in of the simulated cluster",
+	}
+	cmd.PersistentFlags().AddFlagSet(opts.FlagsSets())
+	cmd.AddCommand(newOIDCTokenCommand(opts))
+	return cmd
+}
+
+func newOIDCTokenCommand(opts *options.Options) *cobra.Command {
+	var request oidc.TokenRequest
+	var output string
+	cmd := &cobra.Command{
+		Use:   "token --user <user> [--groups <group>]...",
+		Short: "Issue an id token of a user and groups",
+		Long: "Issue an id token signed by the oidc issuer stand-in, which apiserver trusts when simulator is started with --oidc-issuer-listen. " +
+			"with -o exec-credential the output is an ExecCredential, so the command can be an exec credential plugin of kubeconfigs",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "" && output != "exec-credential" {
+				return errors.Errorf("output %s is not supported, should be exec-credential or empty", output)
+			}
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			if !config.OIDC.Enabled() {
+				return errors.New("oidc issuer is disabled, --oidc-issuer-listen is required")
+			}
+			issuer, err := oidc.LoadIssuer(&config.OIDC)
+			if err != nil {
+				return err
+			}
+			token, expiry, err := issuer.Token(request)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), token)
+				return err
+			}
+			expirationTimestamp := metav1.NewTime(expiry)
+			return printJSON(cmd.OutOrStdout(), &clientauthv1.ExecCredential{
+				TypeMeta: metav1.TypeMeta{APIVersion: clientauthv1.SchemeGroupVersion.String(), Kind: "ExecCredential"},
+				Status:   &clientauthv1.ExecCredentialStatus{Token: token, ExpirationTimestamp: &expirationTimestamp},
+			})
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&request.User, "user", "", "subject of the id token")
+	fs.StringSliceVar(&request.Groups, "groups", nil, "groups claim of the id token, can be repeated or separated by comma")
+	fs.DurationVar(&request.TTL, "ttl", time.Hour, "lifetime of the id token")
+	fs.StringVarP(&output, "output", "o", "", "output format, exec-credential or empty for the raw token")
+	return cmd
+}
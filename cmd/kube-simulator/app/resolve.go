@@ -0,0 +1,117 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/proxy"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"github.com/spf13/cobra"
+)
+
+// resolveOptions represent options of the resolve command
+type resolveOptions struct {
+	server  string
+	request proxy.Request
+	count   int
+	output  string
+}
+
+// NewResolveCommand returns the command which tells which pod requests to a service would hit
+func NewResolveCommand() *cobra.Command {
+	opts := &resolveOptions{}
+	cmd := &cobra.Command{
+		Use:   "resolve svc/<namespace>/<name>",
+		Short: "Show which simulated pod requests to a service would hit",
+		Long: "Show which simulated pod requests to a service would hit under kube-proxy semantics, " +
+			"including session affinity, topology aware routing and internalTrafficPolicy",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, name, err := parseServiceRef(args[0])
+			if err != nil {
+				return err
+			}
+			opts.request.Namespace, opts.request.Name = namespace, name
+			return runResolve(cmd.OutOrStdout(), opts)
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&opts.server, "server", simapi.DefaultListen, "the address of the simulator api")
+	fs.StringVar(&opts.request.Port, "port", "", "name or number of the service port, required if service has multiple ports")
+	fs.StringVar(&opts.request.ClientIP, "client-ip", "", "ip of the client, used by session affinity")
+	fs.StringVar(&opts.request.SourceNode, "from-node", "", "node the request is sent from")
+	fs.StringVar(&opts.request.SourcePod, "from-pod", "", "namespace/name of the pod the request is sent from")
+	fs.StringVar(&opts.request.Mode, "mode", "", "proxy mode, iptables or ipvs, defaults to the mode of simulator")
+	fs.IntVarP(&opts.count, "count", "n", 1, "number of requests to send")
+	fs.StringVarP(&opts.output, "output", "o", "", "output format, json or empty for text")
+	return cmd
+}
+
+// parseServiceRef parses svc/<namespace>/<name>, namespace defaults to default if it's omitted
+func parseServiceRef(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	switch {
+	case len(parts) == 3 && isServiceKind(parts[0]) && parts[1] != "" && parts[2] != "":
+		return parts[1], parts[2], nil
+	case len(parts) == 2 && isServiceKind(parts[0]) && parts[1] != "":
+		return "default", parts[1], nil
+	}
+	return "", "", fmt.Errorf("%s is invalid, it should be svc/<namespace>/<name>", ref)
+}
+
+func isServiceKind(kind string) bool {
+	return kind == "svc" || kind == "service" || kind == "services"
+}
+
+func runResolve(out io.Writer, opts *resolveOptions) error {
+	if opts.count < 1 {
+		opts.count = 1
+	}
+	var results []*proxy.Result
+	for idx := 0; idx < opts.count; idx++ {
+		result := &proxy.Result{}
+		if err := simapi.Get(opts.server, proxy.ResolvePath, opts.request.Query(), result); err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	if opts.output == "json" {
+		return printJSON(out, results)
+	}
+	printResolveResults(out, results)
+	return nil
+}
+
+func printResolveResults(out io.Writer, results []*proxy.Result) {
+	first := results[0]
+	fmt.Fprintf(out, "Service %s port %d (%s), cluster ips %s\n", first.Service, first.Port, first.Mode, strings.Join(first.ClusterIPs, ","))
+	for _, note := range first.Notes {
+		fmt.Fprintf(out, "  * %s\n", note)
+	}
+
+	hits := make(map[string]int)
+	for _, result := range results {
+		if result.Endpoint != nil {
+			hits[result.Endpoint.String()]++
+		}
+	}
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ENDPOINT\tPOD\tNODE\tZONE\tHITS")
+	for _, ep := range first.Candidates {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n", ep.String(), valueOrNone(ep.Pod), valueOrNone(ep.NodeName), valueOrNone(ep.Zone), hits[ep.String()])
+	}
+	writer.Flush()
+	if len(hits) == 0 {
+		fmt.Fprintln(out, "requests are rejected, no endpoints are usable")
+	}
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"3Xpl0it3r.com/kube-simulator/cmd/kube-simulator/options"
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// rewriteHint tells how to rewrite resources with the current key, like the key rotation of kubernetes docs
+const rewriteHint = "kubectl get secrets --all-namespaces -o json | kubectl replace -f -"
+
+// NewSecretsCommand returns the command which checks and rotates encryption at rest of the simulated cluster.
+// it takes the same flags as starting simulator so the kine database and the encryption config are found
+func NewSecretsCommand() *cobra.Command {
+	opts := options.NewOptions()
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Verify and rotate encryption at rest enabled with --encryption-provider",
+	}
+	cmd.PersistentFlags().AddFlagSet(opts.FlagsSets())
+	cmd.AddCommand(newSecretsVerifyEncryptionCommand(opts), newSecretsRotateKeyCommand(opts))
+	return cmd
+}
+
+// verifyResult is the output of verify-encryption
+type verifyResult struct {
+	// WriteProvider and WriteKey are what resources are written with now
+	WriteProvider encryption.Provider       `json:"writeProvider,omitempty"`
+	WriteKey      string                    `json:"writeKey,omitempty"`
+	Objects       []encryption.StoredObject `json:"objects"`
+}
+
+func newSecretsVerifyEncryptionCommand(opts *options.Options) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "verify-encryption",
+		Short: "Read objects of encrypted resources from the kine database and check they aren't stored in plain text",
+		Long: "Read the latest revisions of objects of --encryption-resources from the kine database and check they aren't stored in plain text. " +
+			"objects written with an old key are listed as well, they are read with the old key until they are rewritten",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			objects, err := encryption.ReadStoredObjects(config.KineDatabase(), config.Encryption.Resources)
+			if err != nil {
+				return err
+			}
+			result := verifyResult{Objects: objects}
+			if _, err := os.Stat(config.Encryption.ConfigFile); err == nil {
+				if result.WriteProvider, result.WriteKey, err = encryption.WriteKey(&config.Encryption); err != nil {
+					return err
+				}
+			}
+			if output == "json" {
+				if err := printJSON(cmd.OutOrStdout(), result); err != nil {
+					return err
+				}
+			} else {
+				printStoredObjects(cmd.OutOrStdout(), &result)
+			}
+			plain := 0
+			for _, object := range objects {
+				if !object.Encrypted() {
+					plain++
+				}
+			}
+			if plain != 0 {
+				return errors.Errorf("%d of %d objects are stored in plain text", plain, len(objects))
+			}
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "output format, json or empty for text")
+	return cmd
+}
+
+func newSecretsRotateKeyCommand(opts *options.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Add a new key which resources are written with, old keys are kept to read resources written with them",
+		Long: "Add a new key to the encryption config, or to the keys of the kms plugin if resources are written with kms. " +
+			"apiserver reloads the encryption config and the kms plugin reloads its keys on the fly, " +
+			"then rewrite resources with the new key and check them with verify-encryption",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := completedConfig(opts)
+			if err != nil {
+				return err
+			}
+			key, err := encryption.RotateKey(&config.Encryption)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "key %s is added, rewrite resources with it by:\n  %s\n", key, rewriteHint)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+func printStoredObjects(out io.Writer, result *verifyResult) {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RESOURCE\tNAMESPACE\tNAME\tPROVIDER\tKEY\tCURRENT")
+	encrypted, current := 0, 0
+	for _, object := range result.Objects {
+		isCurrent := object.Provider == result.WriteProvider && object.Key == result.WriteKey
+		if object.Encrypted() {
+			encrypted++
+		}
+		if isCurrent {
+			current++
+		}
+		key := object.Key
+		if key == "" {
+			key = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%t\n", object.Resource, object.Namespace, object.Name, object.Provider, key, isCurrent)
+	}
+	writer.Flush()
+	fmt.Fprintln(out)
+	if result.WriteProvider == "" {
+		fmt.Fprintln(out, "encryption config isn't generated, objects are written in plain text")
+	} else {
+		fmt.Fprintf(out, "objects are written with %s %s\n", result.WriteProvider, result.WriteKey)
+	}
+	fmt.Fprintf(out, "%d of %d objects are encrypted, %d are written with the current key\n", encrypted, len(result.Objects), current)
+	if current != len(result.Objects) {
+		fmt.Fprintf(out, "rewrite the others with the current key by:\n  %s\n", rewriteHint)
+	}
+}
@@ -43,6 +43,7 @@ func NewKubeSimulatorCommand() *cobra.Command {
 	}
 	fs := cmd.Flags()
 	fs.AddFlagSet(opts.FlagsSets())
+	cmd.AddCommand(NewResolveCommand(), NewNetPolCommand(), NewCertsCommand(), NewKubeconfigCommand(), NewOIDCCommand(), NewAuditCommand(), NewWebhookCommand(), NewSecretsCommand())
 
 	return cmd
 }
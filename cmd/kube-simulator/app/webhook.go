@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"3Xpl0it3r.com/kube-simulator/pkg/webhook"
+	"github.com/spf13/cobra"
+)
+
+// NewWebhookCommand returns the command which inspects and replays calls of admission webhooks captured by simulator
+func NewWebhookCommand() *cobra.Command {
+	var server, output string
+	cmd := &cobra.Command{
+		Use:   "webhook",
+		Short: "Inspect and replay calls of admission webhooks registered with --webhook",
+	}
+	cmd.PersistentFlags().StringVar(&server, "server", simapi.DefaultListen, "the address of the simulator api")
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "", "output format, json for the full AdmissionReviews or empty for text")
+	cmd.AddCommand(newWebhookListCommand(&server, &output), newWebhookReplayCommand(&server, &output))
+	return cmd
+}
+
+func newWebhookListCommand(server, output *string) *cobra.Command {
+	var name string
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List captured calls of admission webhooks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if name != "" {
+				query.Set("webhook", name)
+			}
+			query.Set("limit", strconv.Itoa(limit))
+			var exchanges []webhook.Exchange
+			if err := simapi.Get(*server, webhook.ExchangesPath, query, &exchanges); err != nil {
+				return err
+			}
+			if *output == "json" {
+				return printJSON(cmd.OutOrStdout(), exchanges)
+			}
+			printWebhookExchanges(cmd.OutOrStdout(), exchanges)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&name, "webhook", "", "only list calls of the webhook, e.g. validating-0")
+	fs.IntVar(&limit, "limit", 50, "list at most the latest n calls, 0 for all")
+	return cmd
+}
+
+func newWebhookReplayCommand(server, output *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay <id>",
+		Short: "Send the request of a captured call to its webhook again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("id %s should be a number", args[0])
+			}
+			exchange := &webhook.Exchange{}
+			if err := simapi.Post(*server, webhook.ReplayPath, url.Values{"id": {args[0]}}, exchange); err != nil {
+				return err
+			}
+			if *output == "json" {
+				return printJSON(cmd.OutOrStdout(), exchange)
+			}
+			printWebhookExchanges(cmd.OutOrStdout(), []webhook.Exchange{*exchange})
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+func printWebhookExchanges(out io.Writer, exchanges []webhook.Exchange) {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTIME\tWEBHOOK\tOPERATION\tOBJECT\tUSER\tRESULT\tLATENCY")
+	for _, exchange := range exchanges {
+		operation, object, user := "", "", ""
+		if exchange.Request != nil && exchange.Request.Request != nil {
+			request := exchange.Request.Request
+			operation = string(request.Operation)
+			resource := request.Resource.Resource
+			if request.SubResource != "" {
+				resource += "/" + request.SubResource
+			}
+			// name of objects created with generateName isn't known yet
+			name := request.Name
+			if name == "" {
+				name = "<generated>"
+			}
+			object = resource + " " + strings.TrimPrefix(request.Namespace+"/"+name, "/")
+			user = request.UserInfo.Username
+		}
+		id := strconv.Itoa(exchange.ID)
+		if exchange.ReplayOf != 0 {
+			id += fmt.Sprintf(" (replay of %d)", exchange.ReplayOf)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", id, exchange.Time.Format(time.RFC3339), exchange.Webhook,
+			operation, object, user, webhookResult(&exchange), exchange.Latency.Round(time.Millisecond))
+	}
+	writer.Flush()
+}
+
+// webhookResult summarizes the response of webhook: allowed, patched or why it's denied or failed
+func webhookResult(exchange *webhook.Exchange) string {
+	if exchange.Error != "" {
+		return "error: " + exchange.Error
+	}
+	response := exchange.Response.Response
+	if !response.Allowed {
+		result := "denied"
+		if response.Result != nil && response.Result.Message != "" {
+			result += ": " + response.Result.Message
+		}
+		return result
+	}
+	if len(response.Patch) != 0 {
+		return "allowed, patched"
+	}
+	return "allowed"
+}
@@ -6,9 +6,23 @@ import (
 	"net"
 	"path/filepath"
 
+	"3Xpl0it3r.com/kube-simulator/pkg/agent"
+	agtmanager "3Xpl0it3r.com/kube-simulator/pkg/agent/manager"
+	"3Xpl0it3r.com/kube-simulator/pkg/audit"
+	"3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"3Xpl0it3r.com/kube-simulator/pkg/cluster"
+	"3Xpl0it3r.com/kube-simulator/pkg/dns"
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+	"3Xpl0it3r.com/kube-simulator/pkg/loadbalancer"
+	"3Xpl0it3r.com/kube-simulator/pkg/oidc"
+	"3Xpl0it3r.com/kube-simulator/pkg/proxy"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
 	"3Xpl0it3r.com/kube-simulator/pkg/simulator"
+	"3Xpl0it3r.com/kube-simulator/pkg/storage"
 	"3Xpl0it3r.com/kube-simulator/pkg/util"
+	"3Xpl0it3r.com/kube-simulator/pkg/webhook"
 	"github.com/spf13/pflag"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
 )
 
 var (
@@ -41,7 +55,70 @@ func (o *Options) Validate() error {
 	if o.Simulator.Etcd.CACert.CertFile != "" && o.Simulator.Etcd.CACert.KeyFile == "" {
 		return errors.New("etcd ca invalid")
 	}
+	if o.Simulator.Proxy.Mode != proxy.ModeIPTables && o.Simulator.Proxy.Mode != proxy.ModeIPVS {
+		return fmt.Errorf("proxy mode %s is not supported", o.Simulator.Proxy.Mode)
+	}
+	if _, err := loadbalancer.NewPool(o.Simulator.LoadBalancer.Pool); err != nil {
+		return fmt.Errorf("load balancer pool invalid: %v", err)
+	}
+	if _, _, err := net.SplitHostPort(o.Simulator.DNS.Listen); err != nil {
+		return fmt.Errorf("dns listen invalid: %v", err)
+	}
+	if err := agent.ValidateNodeZones(o.Simulator.Agent.NodeZones); err != nil {
+		return fmt.Errorf("node zones invalid: %v", err)
+	}
+	if o.Simulator.Storage.StorageClass == "" {
+		return errors.New("storage class is required")
+	}
+	if o.Simulator.Agent.Volume.MountDelay < 0 {
+		return errors.New("volume mount delay should not be negative")
+	}
+	authModes, err := cluster.ParseAuthorizationModes(o.Simulator.Cluster.AuthorizationMode, o.Simulator.Cluster.AuthorizationWebhookConfigFile)
+	if err != nil {
+		return fmt.Errorf("authorization mode invalid: %v", err)
+	}
+	if o.Simulator.Agent.NodeIdentity.Enabled && !cluster.AuthorizesNodes(authModes) {
+		return fmt.Errorf("node identity requires authorization mode Node, AlwaysAllow or Webhook, got %s", o.Simulator.Cluster.AuthorizationMode)
+	}
+	if err := oidc.ValidateListen(o.Simulator.OIDC.Listen); err != nil {
+		return fmt.Errorf("oidc issuer listen invalid: %v", err)
+	}
+	if o.Simulator.Cluster.Audit.PolicyFile != "" {
+		if err := audit.ValidatePolicyFile(o.Simulator.Cluster.Audit.PolicyFile); err != nil {
+			return err
+		}
+	}
+	if o.Simulator.AuditBufferSize < 0 {
+		return errors.New("audit buffer size should not be negative")
+	}
+	if err := o.Simulator.Encryption.Validate(); err != nil {
+		return fmt.Errorf("encryption invalid: %v", err)
+	}
+	if err := o.Simulator.Webhook.Validate(); err != nil {
+		return fmt.Errorf("admission webhooks invalid: %v", err)
+	}
+	if err := o.Simulator.Aggregator.Validate(); err != nil {
+		return fmt.Errorf("apiservices invalid: %v", err)
+	}
+	if err := o.Simulator.Cluster.RequestHeader.Validate(); err != nil {
+		return fmt.Errorf("request header invalid: %v", err)
+	}
+	if err := simulator.ValidateCertSANs(o.Simulator.Cluster.CertSANs); err != nil {
+		return fmt.Errorf("apiserver cert extra sans invalid: %v", err)
+	}
+	if _, err := cert.NewGenerator(o.Simulator.Cert); err != nil {
+		return fmt.Errorf("certificate options invalid: %v", err)
+	}
+	if o.Simulator.DNS.Domain == "" {
+		return errors.New("cluster domain is required")
+	}
 	// if cluster cidr provided, then validate cluster cidr
+	if _, err := util.ParseDualStackCIDRs(o.Simulator.Cluster.ClusterCIDR); err != nil {
+		return fmt.Errorf("cluster cidr invalid: %v", err)
+	}
+	if _, err := util.ParseDualStackCIDRs(o.Simulator.Cluster.ServiceCIDR); err != nil {
+		return fmt.Errorf("service cidr invalid: %v", err)
+	}
 
 	return nil
 }
@@ -85,6 +162,7 @@ func (o *Options) FlagsSets() *pflag.FlagSet {
 	fs.StringVar(&o.ClusterListen, "cluster-listen", "", "the address that kube-apiserver listen")
 	fs.StringVar(&o.DataDir, "data-dir", DefaultSimulatorDir, "data dir")
 	fs.StringVar(&o.CertificateDir, "certificate-dir", DefaultCertificateDir, "certificated dir")
+	fs.StringVar(&o.Simulator.ApiListen, "simulator-api-listen", simapi.DefaultListen, "the address that simulator api listen, it's used by commands like resolve")
 
 	// etcd options
 	fs.StringVar(&o.Simulator.Etcd.Listener, "etcd-listen", "127.0.0.1:2379", "etcd-bind")
@@ -93,8 +171,11 @@ func (o *Options) FlagsSets() *pflag.FlagSet {
 	fs.StringVar(&o.Simulator.Etcd.DataDir, "db-dir", DefaultEtcdDataDir, "the dir of db ")
 
 	// apiserver
-	fs.StringVar(&o.Simulator.Cluster.ClusterCIDR, "cluster-cidr", "10.244.0.0/16", "pod cidr")
-	fs.StringVar(&o.Simulator.Cluster.ServiceCIDR, "service-cidr", "10.96.0.0/12", "service cidr")
+	fs.StringVar(&o.Simulator.Cluster.ClusterCIDR, "cluster-cidr", "10.244.0.0/16", "pod cidr, a pair of IPv4 and IPv6 cidrs separated by comma for dual-stack, e.g. 10.244.0.0/16,fd00:10:244::/56")
+	fs.BoolVar(&o.Simulator.Cluster.AllocateNodeCIDRs, "allocate-node-cidrs", false, "let kube-controller-manager allocate pod cidrs of nodes instead of the agent")
+	fs.IntVar(&o.Simulator.Cluster.NodeCIDRMaskSizeIPv4, "node-cidr-mask-size-ipv4", 24, "mask size of IPv4 pod cidrs of nodes")
+	fs.IntVar(&o.Simulator.Cluster.NodeCIDRMaskSizeIPv6, "node-cidr-mask-size-ipv6", 64, "mask size of IPv6 pod cidrs of nodes")
+	fs.StringVar(&o.Simulator.Cluster.ServiceCIDR, "service-cidr", "10.96.0.0/12", "service cidr, a pair of IPv4 and IPv6 cidrs separated by comma for dual-stack, e.g. 10.96.0.0/12,fd00:10:96::/112")
 	fs.StringVar(&o.Simulator.Cluster.TLS.CA.KeyFile, "ca-key", "", "ca key file for cluster")
 	fs.StringVar(&o.Simulator.Cluster.TLS.CA.CertFile, "ca-cert", "", "ca cert file for cluster")
 	fs.StringVar(&o.Simulator.Cluster.TLS.EtcdClient.KeyFile, "etcd-client-key", "", "ca key file for etcd")
@@ -103,9 +184,63 @@ func (o *Options) FlagsSets() *pflag.FlagSet {
 	fs.StringVar(&o.Simulator.Cluster.TLS.Server.CertFile, "server-cert", "", "apiserver cert file")
 	fs.StringVar(&o.Simulator.Cluster.TLS.ServiceAccountKeyFile, "service-account-priv-key", "", "")
 	fs.StringVar(&o.Simulator.Cluster.TLS.ServiceAccountSigningKeyFile, "service-accont-pub-key", "", "")
+	fs.StringVar(&o.Simulator.Cluster.AuthorizationMode, "authorization-mode", cluster.DefaultAuthorizationMode, "comma separated authorization modes of apiserver tried in order, from AlwaysAllow, AlwaysDeny, Node, RBAC and Webhook")
+	fs.StringVar(&o.Simulator.Cluster.AuthorizationWebhookConfigFile, "authorization-webhook-config-file", "", "kubeconfig of the authorization webhook, required when --authorization-mode contains Webhook")
+	fs.StringVar(&o.Simulator.Cluster.Authentication.TokenAuthFile, "token-auth-file", "", "csv file of static tokens apiserver authenticates, lines are token,user,uid,\"group1,group2\"")
+	fs.StringVar(&o.Simulator.Cluster.Authentication.WebhookConfigFile, "authentication-token-webhook-config-file", "", "kubeconfig of the webhook apiserver sends TokenReviews of bearer tokens to")
+	fs.StringVar(&o.Simulator.OIDC.Listen, "oidc-issuer-listen", "", "address the oidc issuer stand-in serves on over https, apiserver trusts id tokens of https://<address> issued by 'kube-simulator oidc token'. disabled if empty, e.g. 127.0.0.1:10281")
+	fs.StringVar(&o.Simulator.OIDC.ClientID, "oidc-client-id", oidc.DefaultClientID, "audience of id tokens the oidc issuer stand-in issues")
+	fs.StringVar(&o.Simulator.OIDC.UsernamePrefix, "oidc-username-prefix", oidc.DefaultPrefix, "prefix of users from id tokens, '-' for no prefix")
+	fs.StringVar(&o.Simulator.OIDC.GroupsPrefix, "oidc-groups-prefix", oidc.DefaultPrefix, "prefix of groups from id tokens")
+	fs.StringVar(&o.Simulator.Cluster.Audit.PolicyFile, "audit-policy", "", "audit policy file of apiserver, defaults to audit-policy.yaml generated in the data dir")
+	fs.StringVar(&o.Simulator.Cluster.Audit.LogPath, "audit-log-path", "", "file apiserver writes audit events to, '-' for stdout. disabled if empty")
+	fs.IntVar(&o.Simulator.AuditBufferSize, "audit-buffer-size", audit.DefaultBufferSize, "how many latest audit events the simulator api keeps for 'kube-simulator audit', 0 to disable")
+	fs.StringVar((*string)(&o.Simulator.Encryption.Provider), "encryption-provider", "", "provider apiserver encrypts resources at rest with, one of aescbc, aesgcm, secretbox, kms and identity. the EncryptionConfiguration is generated as encryption-config.yaml in the data dir, disabled if empty")
+	fs.StringSliceVar(&o.Simulator.Encryption.Resources, "encryption-resources", encryption.DefaultResources, "resources encrypted at rest as resource[.group], e.g. secrets,configmaps")
+	fs.StringArrayVar(&o.Simulator.Webhook.Webhooks, "webhook", nil, "admission webhook under development as <validating|mutating>:<url>, e.g. validating:http://127.0.0.1:8080/validate. apiserver calls it through a proxy serving with a certificate of the cluster ca, can be repeated")
+	fs.StringVar(&o.Simulator.Webhook.Listen, "webhook-listen", webhook.DefaultListen, "address the proxy of admission webhooks serves apiserver on over https")
+	fs.StringSliceVar(&o.Simulator.Webhook.Resources, "webhook-resources", webhook.DefaultResources, "resources admission webhooks are called for as resource[.group][/subresource], e.g. pods,deployments.apps,pods/exec")
+	fs.StringSliceVar(&o.Simulator.Webhook.Operations, "webhook-operations", webhook.DefaultOperations, "operations admission webhooks are called for, from CREATE, UPDATE, DELETE, CONNECT and *")
+	fs.StringVar(&o.Simulator.Webhook.FailurePolicy, "webhook-failure-policy", string(admissionregv1.Fail), "failure policy of admission webhooks, Fail or Ignore")
+	fs.IntVar(&o.Simulator.Webhook.CaptureSize, "webhook-capture-size", webhook.DefaultCaptureSize, "how many latest calls of admission webhooks are kept for 'kube-simulator webhook', 0 to disable")
+	fs.StringArrayVar(&o.Simulator.Aggregator.APIServices, "apiservice", nil, "local aggregated apiserver as <version>.<group>=https://<host>:<port>, e.g. v1alpha1.wardle.example.com=https://127.0.0.1:8443. it's registered as an APIService of an ExternalName service in kube-system, can be repeated")
+	fs.BoolVar(&o.Simulator.Aggregator.InsecureSkipTLSVerify, "apiservice-insecure-skip-tls-verify", false, "apiserver doesn't verify serving certificates of aggregated apiservers, otherwise they should serve with certificates of the cluster ca like apiservice.crt")
+	fs.StringSliceVar(&o.Simulator.Cluster.RequestHeader.AllowedNames, "requestheader-allowed-names", cluster.DefaultRequestHeader.AllowedNames, "common names of front proxy client certificates aggregated apiservers accept, empty to accept any signed by front-proxy-ca")
+	fs.StringSliceVar(&o.Simulator.Cluster.RequestHeader.UsernameHeaders, "requestheader-username-headers", cluster.DefaultRequestHeader.UsernameHeaders, "headers apiserver passes usernames of proxied requests in")
+	fs.StringSliceVar(&o.Simulator.Cluster.RequestHeader.GroupHeaders, "requestheader-group-headers", cluster.DefaultRequestHeader.GroupHeaders, "headers apiserver passes groups of proxied requests in")
+	fs.StringSliceVar(&o.Simulator.Cluster.RequestHeader.ExtraHeaderPrefixes, "requestheader-extra-headers-prefix", cluster.DefaultRequestHeader.ExtraHeaderPrefixes, "prefixes of headers apiserver passes extra info of users of proxied requests in")
+	fs.StringSliceVar(&o.Simulator.Cluster.CertSANs, "apiserver-cert-extra-sans", nil, "extra ips and dns names of the apiserver certificate besides the kubernetes service, the listen host and the local host names")
+	fs.StringVar((*string)(&o.Simulator.Cert.KeyAlgorithm), "cert-key-algorithm", string(cert.DefaultKeyAlgorithm), "algorithm of keys generated for certificates, one of RSA-2048, RSA-3072, RSA-4096, ECDSA-P256, ECDSA-P384, ED25519")
+	fs.DurationVar(&o.Simulator.Cert.CAValidity, "ca-validity", cert.DefaultCAValidity, "validity of generated ca certificates")
+	fs.DurationVar(&o.Simulator.Cert.CertValidity, "cert-validity", cert.DefaultCertValidity, "validity of generated certificates signed by ca")
+	fs.DurationVar(&o.Simulator.Cert.RenewBefore, "cert-renew-before", 0, "how long before expiration certificates are renewed on start, defaults to 720h or a third of --cert-validity if that's shorter")
+
+	// service proxy
+	fs.StringVar(&o.Simulator.Proxy.Mode, "proxy-mode", proxy.ModeIPTables, "which mode of kube-proxy the service proxy emulates, iptables or ipvs")
+
+	// load balancer
+	fs.StringVar(&o.Simulator.LoadBalancer.Pool, "load-balancer-pool", loadbalancer.DefaultPool, "ip ranges ingress ips of LoadBalancer services are allocated from, cidrs or first-last separated by comma")
+	fs.StringVar(&o.Simulator.LoadBalancer.Class, "load-balancer-class", loadbalancer.DefaultClass, "loadBalancerClass handled by simulator, services without loadBalancerClass are handled as well")
+
+	// cluster dns
+	fs.StringVar(&o.Simulator.DNS.Listen, "dns-listen", dns.DefaultListen, "the address that cluster dns listen on, both udp and tcp")
+	fs.StringVar(&o.Simulator.DNS.Domain, "cluster-domain", dns.DefaultDomain, "the domain of cluster, services are resolved as <service>.<namespace>.svc.<cluster-domain>")
+
+	// storage
+	fs.StringVar(&o.Simulator.Storage.StorageClass, "storage-class", storage.DefaultStorageClass, "name of the default StorageClass provisioned by the fake csi driver "+storage.DriverName)
+	fs.DurationVar(&o.Simulator.Agent.Volume.MountDelay, "volume-mount-delay", 0, "how long pods wait for their persistent volumes to be mounted after they are attached")
 
 	// agent
 	fs.IntVar(&o.Simulator.Agent.NodeNum, "node-num", 4, "the numebr of node")
+	fs.StringVar(&o.Simulator.Agent.NodeIPRange, "node-ip-range", "10.10.10.0/24", "the range internal ips of nodes are allocated from")
+	fs.BoolVar(&o.Simulator.Agent.NodeIdentity.Enabled, "node-identity", true, "nodes update their status, leases, pods and events as system:node:<name> with client certificates signed by the cluster ca, instead of the admin identity")
+	fs.StringVar(&o.Simulator.Agent.NodeZones, "node-zones", "", "zones nodes are spread over in turn, region/zone or zone separated by comma, e.g. region-1/zone-a,region-1/zone-b")
+	fs.StringVar(&o.Simulator.Agent.Eviction.Hard, "eviction-hard", "memory.available<100Mi,nodefs.available<10%", "hard eviction thresholds of simulated nodes, e.g. memory.available<100Mi")
+	fs.StringVar(&o.Simulator.Agent.Eviction.Soft, "eviction-soft", "", "soft eviction thresholds of simulated nodes, e.g. memory.available<1Gi")
+	fs.StringVar(&o.Simulator.Agent.Eviction.SoftGracePeriod, "eviction-soft-grace-period", "", "grace periods of soft eviction thresholds, e.g. memory.available=1m30s")
+	fs.DurationVar(&o.Simulator.Agent.Job.Duration, "job-duration", agtmanager.DefaultJobDuration, "how long pods of jobs run before completing, overridden by annotation "+agtmanager.AnnotationJobDuration)
+	fs.Float64Var(&o.Simulator.Agent.Job.FailureRatio, "job-failure-ratio", 0, "probability in [0, 1] that pods of jobs fail, overridden by annotation "+agtmanager.AnnotationJobFailureRatio)
+	fs.Int32Var(&o.Simulator.Agent.Job.FailureExitCode, "job-failure-exit-code", agtmanager.DefaultJobFailureExitCode, "exit code of containers of failed job pods, overridden by annotation "+agtmanager.AnnotationJobExitCode)
 
 	return fs
 }
@@ -115,6 +250,10 @@ func (o *Options) Config() simulator.Config {
 	config := o.Simulator
 	config.DataDir = o.DataDir
 	config.CertificateDir = o.CertificateDir
+	config.Agent.ClusterCIDR = config.Cluster.ClusterCIDR
+	config.Agent.NodeCIDRMaskSizeIPv4 = config.Cluster.NodeCIDRMaskSizeIPv4
+	config.Agent.NodeCIDRMaskSizeIPv6 = config.Cluster.NodeCIDRMaskSizeIPv6
+	config.Agent.DelegateNodeCIDRs = config.Cluster.AllocateNodeCIDRs
 	return config
 }
 
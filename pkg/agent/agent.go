@@ -14,7 +14,6 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	kubeclientset "k8s.io/client-go/kubernetes"
-	coretyped "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
@@ -27,11 +26,20 @@ type SimuAgent struct {
 	nodeController    *agtcontroller.NodeController
 	nodeStatusManager agtmanager.Manager
 	podManager        agtmanager.Manager
+	podAdmitter       agtmanager.PodAdmitter
+	podIPRestorer     agtmanager.PodIPRestorer
+	evictionManager   *agtmanager.EvictionManager
+	volumeManager     *agtmanager.VolumeManager
+	eventRecorder     record.EventRecorder
+	nodeAddresses     *nodeAddressAllocator
+	nodeTopology      *nodeTopology
 	maxPods           int
 	maxNodes          int
 	nodeNum           int
 	recorder          record.EventBroadcaster
 	clusterClient     kubeclientset.Interface
+	// nodeClients are used by nodes to write their own objects, clusterClient is used if it's nil
+	nodeClients agtmanager.NodeClients
 }
 
 func Run(config *Config) error {
@@ -39,24 +47,66 @@ func Run(config *Config) error {
 	if err != nil {
 		return errors.Wrap(err, "build clientconfig for agent failed")
 	}
+	evictionThresholds, err := agtmanager.ParseEvictionThresholds(config.Eviction.Hard, config.Eviction.Soft, config.Eviction.SoftGracePeriod)
+	if err != nil {
+		return err
+	}
+	if config.Job.FailureRatio < 0 || config.Job.FailureRatio > 1 {
+		return errors.Errorf("job failure ratio %v should be in [0, 1]", config.Job.FailureRatio)
+	}
+	nodeAddresses, err := newNodeAddressAllocator(config)
+	if err != nil {
+		return err
+	}
+	topology, err := newNodeTopology(config.NodeZones)
+	if err != nil {
+		return err
+	}
+	nodeClients := agtmanager.NewSharedNodeClients(client)
+	if config.NodeIdentity.Enabled {
+		restConfig, err := kuberesource.NewClusterRestConfig("", config.ClientConfig)
+		if err != nil {
+			return errors.Wrap(err, "build clientconfig for nodes failed")
+		}
+		pool, err := newNodeClientPool(restConfig, client, config.NodeIdentity)
+		if err != nil {
+			return err
+		}
+		nodeClients = pool
+	}
 	agent := SimuAgent{
+		nodeAddresses: nodeAddresses,
+		nodeTopology:  topology,
 		maxPods:       110,
 		maxNodes:      100,
 		clusterClient: client,
+		nodeClients:   nodeClients,
 		nodeNum:       config.NodeNum,
 	}
 
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(klog.V(2).Infof)
-	eventBroadcaster.StartRecordingToSink(&coretyped.EventSinkImpl{Interface: client.CoreV1().Events(coreapi.NamespaceAll)})
+	eventBroadcaster.StartRecordingToSink(&nodeEventSink{clients: nodeClients})
 	agent.recorder = eventBroadcaster
+	agent.eventRecorder = newNodeEventRecorder(eventBroadcaster, "kubelet")
 
 	clusterInformers := buildKubeStandardResourceInformerFactory(client)
 
 	agent.nodeController = agtcontroller.NewNodeController(client, clusterInformers.Core().V1().Nodes())
 	agent.podController = agtcontroller.NewPodController(client, clusterInformers.Core().V1().Pods())
-	agent.nodeStatusManager = agtmanager.NewNodeManager(client)
-	agent.podManager = agtmanager.NewPodStatusManager(client)
+	nodeManager := agtmanager.NewNodeManager(client)
+	nodeManager.UseNodeClients(nodeClients)
+	agent.nodeStatusManager = nodeManager
+	agent.podAdmitter = nodeManager
+	podManager := agtmanager.NewPodStatusManager(client)
+	podManager.ConfigureJobs(config.Job)
+	podManager.UseNodeClients(nodeClients)
+	agent.podManager = podManager
+	agent.podIPRestorer = podManager
+	agent.evictionManager = agtmanager.NewEvictionManager(client, nodeManager, agent.eventRecorder, evictionThresholds)
+	agent.evictionManager.UseNodeClients(nodeClients)
+	agent.volumeManager = agtmanager.NewVolumeManager(client, agent.eventRecorder, config.Volume)
+	agent.volumeManager.UseNodeClients(nodeClients)
 
 	go func() {
 		loggerForAgent.Info("begin run simu-agent")
@@ -70,7 +120,7 @@ func (a *SimuAgent) run() error {
 	defer cancel()
 
 	for idx := 0; idx < a.nodeNum; idx++ {
-		if node, err := registerBootstrapNode(idx, a.clusterClient); err != nil {
+		if node, err := registerBootstrapNode(idx, a.clientForNode(BootstrapNodeName(idx)), a.nodeAddresses, a.nodeTopology); err != nil {
 			return err
 		} else {
 			a.nodeStatusManager.OnNodeAdd(node)
@@ -80,10 +130,15 @@ func (a *SimuAgent) run() error {
 		}
 	}
 
+	if err := a.restorePodIPs(ctx); err != nil {
+		loggerForAgent.WithError(err).Warn("restore ips of existing pods failed")
+	}
+
 	go a.nodeController.Run(ctx)
 	go a.podController.Run(ctx)
 	go a.nodeStatusManager.Run(ctx)
 	go a.podManager.Run(ctx)
+	go a.evictionManager.Run(ctx)
 
 	return a.mainLoop(ctx)
 }
@@ -121,8 +176,44 @@ func (a *SimuAgent) mainLoop(ctx context.Context) error {
 	}
 }
 
+// clientForNode returns the client nodeName writes its own objects with
+func (a *SimuAgent) clientForNode(nodeName string) kubeclientset.Interface {
+	if a.nodeClients == nil {
+		return a.clusterClient
+	}
+	return a.nodeClients.ForNode(nodeName)
+}
+
+// restorePodIPs rebuilds ipams from pods which were running before simulator restarted,
+// duplicate ips are reported on the pods that use them
+func (a *SimuAgent) restorePodIPs(ctx context.Context) error {
+	if a.podIPRestorer == nil {
+		return nil
+	}
+	podList, err := a.clusterClient.CoreV1().Pods(coreapi.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	pods := make([]*coreapi.Pod, 0, len(podList.Items))
+	for idx := range podList.Items {
+		pods = append(pods, &podList.Items[idx])
+	}
+	for _, duplicate := range a.podIPRestorer.RestorePodIPs(pods) {
+		for _, pod := range duplicate.Pods {
+			loggerForAgent.Warnf("ip %s is used by %d pods, including %s/%s", duplicate.IP, len(duplicate.Pods), pod.Namespace, pod.Name)
+			if a.eventRecorder != nil {
+				a.eventRecorder.Eventf(pod, coreapi.EventTypeWarning, "DuplicatePodIP", "IP %s is used by %d pods", duplicate.IP, len(duplicate.Pods))
+			}
+		}
+	}
+	return nil
+}
+
 // for pod add
 func (a *SimuAgent) HandleForPodOnAdd(pod *coreapi.Pod) {
+	if agtmanager.IsPodTerminated(pod) || !a.admitPod(pod) || !a.mountVolumes(pod) {
+		return
+	}
 	if err := a.podManager.OnPodAdd(pod); err != nil {
 		return
 	}
@@ -131,15 +222,68 @@ func (a *SimuAgent) HandleForPodOnAdd(pod *coreapi.Pod) {
 
 // for pod update
 func (a *SimuAgent) HandleForPodOnUpdate(pod *coreapi.Pod) {
+	if agtmanager.IsPodTerminated(pod) {
+		a.unmountVolumes(pod)
+		a.nodeStatusManager.OnPodUpdate(pod)
+		return
+	}
+	if !a.admitPod(pod) || !a.mountVolumes(pod) {
+		return
+	}
 	if err := a.podManager.OnPodUpdate(pod); err != nil {
 		return
 	}
 	a.nodeStatusManager.OnPodUpdate(pod)
 }
 
-// for pod delete
+// admitPod runs kubelet-style admission, pods that don't fit the node are set to Failed like kubelet does
+func (a *SimuAgent) admitPod(pod *coreapi.Pod) bool {
+	if a.podAdmitter == nil {
+		return true
+	}
+	result := a.podAdmitter.Admit(pod)
+	if result.Admit {
+		return true
+	}
+	loggerForAgent.Infof("pod %s/%s rejected by node %s: %s", pod.Namespace, pod.Name, pod.Spec.NodeName, result.Message)
+	if err := a.rejectPod(pod, result.Reason, result.Message); err != nil {
+		loggerForAgent.WithError(err).Errorf("failed to reject pod %s/%s", pod.Namespace, pod.Name)
+	}
+	return false
+}
+
+// rejectPod set pod to Failed with the given reason and message
+func (a *SimuAgent) rejectPod(pod *coreapi.Pod, reason, message string) error {
+	if a.eventRecorder != nil {
+		a.eventRecorder.Eventf(pod, coreapi.EventTypeWarning, reason, message)
+	}
+	rejected := pod.DeepCopy()
+	rejected.Status.Phase = coreapi.PodFailed
+	rejected.Status.Reason = reason
+	rejected.Status.Message = "Pod was rejected: " + message
+	_, err := a.clientForNode(pod.Spec.NodeName).CoreV1().Pods(pod.Namespace).UpdateStatus(context.TODO(), rejected, metav1.UpdateOptions{})
+	return err
+}
+
+// mountVolumes returns true once volumes of pod are mounted, pods stay pending until then
+func (a *SimuAgent) mountVolumes(pod *coreapi.Pod) bool {
+	if a.volumeManager == nil {
+		return true
+	}
+	return a.volumeManager.MountVolumes(pod)
+}
+
+func (a *SimuAgent) unmountVolumes(pod *coreapi.Pod) {
+	if a.volumeManager != nil {
+		a.volumeManager.UnmountVolumes(pod)
+	}
+}
+
+// for pod delete, the pod no longer counts for admission and eviction once its deletion is requested
 func (a *SimuAgent) HandleForPodOnDelete(pod *coreapi.Pod) {
 	a.podManager.OnPodDelete(pod)
+	a.unmountVolumes(pod)
+	a.nodeStatusManager.OnPodDelete(pod)
 }
 
 // when node added ,first update nodeManager, then create nodelease or update nodelease if it existed
@@ -154,7 +298,7 @@ func (a *SimuAgent) HandleForNodeOnAdd(node *coreapi.Node) {
 
 // for node update
 func (a *SimuAgent) HandleForNodeOnUpdate(node *coreapi.Node) {
-	if err := a.nodeStatusManager.OnNodeAdd(node); err != nil {
+	if err := a.nodeStatusManager.OnNodeUpdate(node); err != nil {
 		loggerForAgent.WithError(err).Error("failed update node lease")
 	}
 	if err := a.podManager.OnNodeUpdate(node); err != nil {
@@ -1,7 +1,32 @@
 package agent
 
+import agtmanager "3Xpl0it3r.com/kube-simulator/pkg/agent/manager"
+
 // Config represent config
 type Config struct {
 	ClientConfig string
 	NodeNum      int
+	// ClusterCIDR is the pod cidrs of cluster, e.g. 10.244.0.0/16,fd00:10:244::/56
+	ClusterCIDR string
+	// NodeCIDRMaskSizeIPv4 and NodeCIDRMaskSizeIPv6 are the sizes of pod cidrs carved out of ClusterCIDR for nodes
+	NodeCIDRMaskSizeIPv4 int
+	NodeCIDRMaskSizeIPv6 int
+	// DelegateNodeCIDRs leaves pod cidrs of nodes to the nodeipam controller of kube-controller-manager
+	DelegateNodeCIDRs bool
+	// NodeIPRange is the range internal ips of nodes are allocated from, e.g. 10.10.10.0/24
+	NodeIPRange string
+	// NodeZones are the zones nodes are spread over, e.g. region-1/zone-a,region-1/zone-b
+	NodeZones string
+	Eviction  EvictionConfig
+	Job       agtmanager.JobConfig
+	Volume    agtmanager.VolumeConfig
+	// NodeIdentity lets nodes write their own objects as system:node:<name> like kubelets
+	NodeIdentity NodeIdentityConfig
+}
+
+// EvictionConfig represent node-pressure eviction thresholds, in the same format as kubelet flags
+type EvictionConfig struct {
+	Hard            string
+	Soft            string
+	SoftGracePeriod string
 }
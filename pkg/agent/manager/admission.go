@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+	"k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
+)
+
+// reasons reported on pods rejected by admission, same as the ones kubelet uses
+const (
+	AdmissionReasonNodeAffinity = "NodeAffinity"
+	AdmissionReasonTaint        = "Taint"
+	AdmissionReasonNodePorts    = "NodePorts"
+	AdmissionReasonNodeNotFound = "NodeNotFound"
+	admissionReasonOutOfPrefix  = "OutOf"
+)
+
+// PodAdmitResult represent the result of pod admission
+type PodAdmitResult struct {
+	Admit   bool
+	Reason  string
+	Message string
+}
+
+// PodAdmitter decide whether a pod bound to a node is allowed to run on it
+type PodAdmitter interface {
+	Admit(pod *coreapi.Pod) PodAdmitResult
+}
+
+// admitPod runs kubelet-style admission of pod against node and the pods that are already admitted on it
+func admitPod(node *coreapi.Node, admittedPods []*coreapi.Pod, pod *coreapi.Pod) PodAdmitResult {
+	if matched, _ := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node); !matched {
+		return PodAdmitResult{
+			Reason:  AdmissionReasonNodeAffinity,
+			Message: "Predicate NodeAffinity failed",
+		}
+	}
+
+	// kubelet only cares about NoExecute taints, NoSchedule is the business of scheduler
+	if _, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(t *coreapi.Taint) bool {
+		return t.Effect == coreapi.TaintEffectNoExecute
+	}); untolerated {
+		return PodAdmitResult{
+			Reason:  AdmissionReasonTaint,
+			Message: "Predicate Taint failed",
+		}
+	}
+
+	if conditions := nodePressureConditionsFor(node, pod); len(conditions) != 0 {
+		return PodAdmitResult{
+			Reason:  PodReasonEvicted,
+			Message: fmt.Sprintf("The node had condition: %v. ", conditions),
+		}
+	}
+
+	if conflicts := conflictedHostPorts(admittedPods, pod); len(conflicts) != 0 {
+		return PodAdmitResult{
+			Reason:  AdmissionReasonNodePorts,
+			Message: fmt.Sprintf("Predicate NodePorts failed: host port(s) %s already in use", strings.Join(conflicts, ",")),
+		}
+	}
+
+	if name, msg, ok := fitsNodeResources(node, admittedPods, pod); !ok {
+		return PodAdmitResult{
+			Reason:  admissionReasonOutOfPrefix + string(name),
+			Message: msg,
+		}
+	}
+
+	return PodAdmitResult{Admit: true}
+}
+
+// nodePressureConditionsFor returns the pressure conditions of node that prevent pod from running,
+// under memory pressure only BestEffort pods are rejected, other pressures reject all non-critical pods
+func nodePressureConditionsFor(node *coreapi.Node, pod *coreapi.Pod) []coreapi.NodeConditionType {
+	if isCriticalPod(pod) {
+		return nil
+	}
+	var conditions []coreapi.NodeConditionType
+	for _, condition := range node.Status.Conditions {
+		if condition.Status != coreapi.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case coreapi.NodeMemoryPressure:
+			if qos.GetPodQOS(pod) == coreapi.PodQOSBestEffort {
+				conditions = append(conditions, condition.Type)
+			}
+		case coreapi.NodeDiskPressure, coreapi.NodePIDPressure:
+			conditions = append(conditions, condition.Type)
+		}
+	}
+	return conditions
+}
+
+// fitsNodeResources checks requests of pod against the allocatable of node, returns the first resource that's insufficient
+func fitsNodeResources(node *coreapi.Node, admittedPods []*coreapi.Pod, pod *coreapi.Pod) (coreapi.ResourceName, string, bool) {
+	allocatable := node.Status.Allocatable
+	if allocatable == nil {
+		allocatable = node.Status.Capacity
+	}
+	if maxPods, ok := allocatable[coreapi.ResourcePods]; ok && int64(len(admittedPods)+1) > maxPods.Value() {
+		return coreapi.ResourcePods, fmt.Sprintf("Node didn't have enough resource: pods, requested: 1, used: %d, capacity: %d", len(admittedPods), maxPods.Value()), false
+	}
+
+	used := coreapi.ResourceList{}
+	for _, admitted := range admittedPods {
+		addResourceList(used, resourcehelper.PodRequests(admitted, resourcehelper.PodResourcesOptions{}))
+	}
+	requested := resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{})
+	for _, name := range []coreapi.ResourceName{coreapi.ResourceCPU, coreapi.ResourceMemory, coreapi.ResourceEphemeralStorage} {
+		request, ok := requested[name]
+		if !ok || request.IsZero() {
+			continue
+		}
+		capacity, ok := allocatable[name]
+		if !ok {
+			continue
+		}
+		inUse := used[name]
+		available := capacity.DeepCopy()
+		available.Sub(inUse)
+		if available.Cmp(request) < 0 {
+			return name, fmt.Sprintf("Node didn't have enough resource: %s, requested: %s, used: %s, capacity: %s",
+				name, quantityString(name, request), quantityString(name, inUse), quantityString(name, capacity)), false
+		}
+	}
+	return "", "", true
+}
+
+// conflictedHostPorts returns the host ports requested by pod but already taken by admitted pods
+func conflictedHostPorts(admittedPods []*coreapi.Pod, pod *coreapi.Pod) []string {
+	var usedPorts []coreapi.ContainerPort
+	for _, admitted := range admittedPods {
+		usedPorts = append(usedPorts, podHostPorts(admitted)...)
+	}
+	var conflicts []string
+	for _, wanted := range podHostPorts(pod) {
+		for _, used := range usedPorts {
+			if hostPortConflict(wanted, used) {
+				conflicts = append(conflicts, fmt.Sprintf("%s/%d", wanted.Protocol, wanted.HostPort))
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+func podHostPorts(pod *coreapi.Pod) []coreapi.ContainerPort {
+	var ports []coreapi.ContainerPort
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort <= 0 {
+				continue
+			}
+			if port.Protocol == "" {
+				port.Protocol = coreapi.ProtocolTCP
+			}
+			if port.HostIP == "" {
+				port.HostIP = "0.0.0.0"
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func hostPortConflict(a, b coreapi.ContainerPort) bool {
+	if a.HostPort != b.HostPort || a.Protocol != b.Protocol {
+		return false
+	}
+	return a.HostIP == b.HostIP || a.HostIP == "0.0.0.0" || b.HostIP == "0.0.0.0"
+}
+
+func addResourceList(total, delta coreapi.ResourceList) {
+	for name, quantity := range delta {
+		if value, ok := total[name]; ok {
+			value.Add(quantity)
+			total[name] = value
+		} else {
+			total[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func quantityString(name coreapi.ResourceName, quantity resource.Quantity) string {
+	if name == coreapi.ResourceCPU {
+		return fmt.Sprintf("%dm", quantity.MilliValue())
+	}
+	return fmt.Sprintf("%d", quantity.Value())
+}
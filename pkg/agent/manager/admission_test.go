@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newAdmissionTestNode() *coreapi.Node {
+	node := NewManagerTestHelper(nil).CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24")
+	node.Labels = map[string]string{"disktype": "ssd"}
+	node.Status.Allocatable = coreapi.ResourceList{
+		coreapi.ResourceCPU:    resource.MustParse("1"),
+		coreapi.ResourceMemory: resource.MustParse("1Gi"),
+		coreapi.ResourcePods:   resource.MustParse("2"),
+	}
+	return node
+}
+
+func newAdmissionTestPod(name, cpu, mem string) *coreapi.Pod {
+	pod := NewManagerTestHelper(nil).CreateTestPod(name, "default", "test-node")
+	pod.Spec.Containers[0].Resources.Requests = coreapi.ResourceList{
+		coreapi.ResourceCPU:    resource.MustParse(cpu),
+		coreapi.ResourceMemory: resource.MustParse(mem),
+	}
+	return pod
+}
+
+func TestAdmitPod(t *testing.T) {
+	hostPortPod := func(name string, port int32) *coreapi.Pod {
+		pod := newAdmissionTestPod(name, "10m", "10Mi")
+		pod.Spec.Containers[0].Ports = []coreapi.ContainerPort{{ContainerPort: 80, HostPort: port}}
+		return pod
+	}
+
+	testCases := []struct {
+		name           string
+		mutateNode     func(node *coreapi.Node)
+		admitted       []*coreapi.Pod
+		pod            *coreapi.Pod
+		expectAdmit    bool
+		expectedReason string
+	}{
+		{
+			name:        "Pod fits node",
+			pod:         newAdmissionTestPod("pod", "500m", "512Mi"),
+			expectAdmit: true,
+		},
+		{
+			name:           "Out of cpu",
+			admitted:       []*coreapi.Pod{newAdmissionTestPod("running", "800m", "100Mi")},
+			pod:            newAdmissionTestPod("pod", "500m", "100Mi"),
+			expectedReason: "OutOfcpu",
+		},
+		{
+			name:           "Out of memory",
+			pod:            newAdmissionTestPod("pod", "100m", "2Gi"),
+			expectedReason: "OutOfmemory",
+		},
+		{
+			name: "Out of pods",
+			admitted: []*coreapi.Pod{
+				newAdmissionTestPod("running-1", "10m", "10Mi"),
+				newAdmissionTestPod("running-2", "10m", "10Mi"),
+			},
+			pod:            newAdmissionTestPod("pod", "10m", "10Mi"),
+			expectedReason: "OutOfpods",
+		},
+		{
+			name: "Node selector mismatch",
+			pod: func() *coreapi.Pod {
+				pod := newAdmissionTestPod("pod", "10m", "10Mi")
+				pod.Spec.NodeSelector = map[string]string{"disktype": "hdd"}
+				return pod
+			}(),
+			expectedReason: AdmissionReasonNodeAffinity,
+		},
+		{
+			name: "Untolerated NoExecute taint",
+			mutateNode: func(node *coreapi.Node) {
+				node.Spec.Taints = []coreapi.Taint{{Key: "dedicated", Value: "infra", Effect: coreapi.TaintEffectNoExecute}}
+			},
+			pod:            newAdmissionTestPod("pod", "10m", "10Mi"),
+			expectedReason: AdmissionReasonTaint,
+		},
+		{
+			name: "NoSchedule taint is ignored",
+			mutateNode: func(node *coreapi.Node) {
+				node.Spec.Taints = []coreapi.Taint{{Key: "dedicated", Value: "infra", Effect: coreapi.TaintEffectNoSchedule}}
+			},
+			pod:         newAdmissionTestPod("pod", "10m", "10Mi"),
+			expectAdmit: true,
+		},
+		{
+			name:           "Host port conflict",
+			admitted:       []*coreapi.Pod{hostPortPod("running", 8080)},
+			pod:            hostPortPod("pod", 8080),
+			expectedReason: AdmissionReasonNodePorts,
+		},
+		{
+			name:        "Different host ports",
+			admitted:    []*coreapi.Pod{hostPortPod("running", 8080)},
+			pod:         hostPortPod("pod", 8081),
+			expectAdmit: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newAdmissionTestNode()
+			if tc.mutateNode != nil {
+				tc.mutateNode(node)
+			}
+			result := admitPod(node, tc.admitted, tc.pod)
+			if result.Admit != tc.expectAdmit {
+				t.Fatalf("Expected admit %v, got %v (%s: %s)", tc.expectAdmit, result.Admit, result.Reason, result.Message)
+			}
+			if result.Reason != tc.expectedReason {
+				t.Errorf("Expected reason %q, got %q", tc.expectedReason, result.Reason)
+			}
+		})
+	}
+}
+
+func TestNodeManager_Admit(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewNodeManager(helper.Client)
+	helper.AssertNoError(manager.OnNodeAdd(newAdmissionTestNode()), "OnNodeAdd should not return error")
+
+	// 节点不存在
+	orphan := newAdmissionTestPod("orphan", "10m", "10Mi")
+	orphan.Spec.NodeName = "non-existent-node"
+	if result := manager.Admit(orphan); result.Admit || result.Reason != AdmissionReasonNodeNotFound {
+		t.Errorf("Expected pod on unknown node to be rejected with %s, got %+v", AdmissionReasonNodeNotFound, result)
+	}
+
+	first := newAdmissionTestPod("first", "800m", "10Mi")
+	first.UID = types.UID("first")
+	if result := manager.Admit(first); !result.Admit {
+		t.Fatalf("Expected first pod to be admitted, got %+v", result)
+	}
+	helper.AssertNoError(manager.OnPodAdd(first), "OnPodAdd should not return error")
+
+	// 已经准入的 Pod 再次准入不会重复计算资源
+	if result := manager.Admit(first); !result.Admit {
+		t.Errorf("Expected admitted pod to be admitted again, got %+v", result)
+	}
+
+	second := newAdmissionTestPod("second", "800m", "10Mi")
+	second.UID = types.UID("second")
+	if result := manager.Admit(second); result.Admit || result.Reason != "OutOfcpu" {
+		t.Errorf("Expected second pod to be rejected with OutOfcpu, got %+v", result)
+	}
+
+	// 第一个 Pod 结束后资源被释放
+	first.Status.Phase = coreapi.PodSucceeded
+	helper.AssertNoError(manager.OnPodUpdate(first), "OnPodUpdate should not return error")
+	if result := manager.Admit(second); !result.Admit {
+		t.Errorf("Expected second pod to be admitted after first one terminated, got %+v", result)
+	}
+}
@@ -0,0 +1,25 @@
+package manager
+
+import (
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+// NodeClients returns the client a node writes its own objects with, like kubelet uses its own credential
+// instead of the one of the agent
+type NodeClients interface {
+	ForNode(nodeName string) kubeclientset.Interface
+}
+
+// sharedNodeClients lets all nodes write with the same client
+type sharedNodeClients struct {
+	client kubeclientset.Interface
+}
+
+// NewSharedNodeClients returns NodeClients which returns client for every node
+func NewSharedNodeClients(client kubeclientset.Interface) NodeClients {
+	return sharedNodeClients{client: client}
+}
+
+func (c sharedNodeClients) ForNode(string) kubeclientset.Interface {
+	return c.client
+}
@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingNodeClients returns the same client for all nodes and records which nodes asked for it
+type recordingNodeClients struct {
+	client *fake.Clientset
+	nodes  []string
+}
+
+func (c *recordingNodeClients) ForNode(nodeName string) kubeclientset.Interface {
+	c.nodes = append(c.nodes, nodeName)
+	return c.client
+}
+
+func TestPodStatusManager_UseNodeClients(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	pod := helper.CreateTestPod("test-pod", "default", "test-node")
+	nodeClient := fake.NewSimpleClientset(pod.DeepCopy())
+	clients := &recordingNodeClients{client: nodeClient}
+
+	manager := NewPodStatusManager(helper.Client)
+	manager.UseNodeClients(clients)
+	pod.Status.Phase = coreapi.PodRunning
+	helper.AssertNoError(manager.updatePodStatus(pod), "updatePodStatus should not return error")
+
+	// 状态由 pod 所在节点的客户端上报
+	if len(clients.nodes) != 1 || clients.nodes[0] != "test-node" {
+		t.Errorf("Expected client of test-node to be used, got %v", clients.nodes)
+	}
+	updated, err := nodeClient.CoreV1().Pods("default").Get(context.TODO(), "test-pod", metav1.GetOptions{})
+	helper.AssertNoError(err, "pod should exist")
+	helper.AssertEqual(coreapi.PodRunning, updated.Status.Phase, "status should be updated with client of node")
+}
+
+func TestNodeManager_UseNodeClients(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	clients := &recordingNodeClients{client: fake.NewSimpleClientset()}
+
+	manager := NewNodeManager(helper.Client)
+	manager.UseNodeClients(clients)
+	helper.AssertNoError(manager.OnNodeAdd(helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24")), "OnNodeAdd should not return error")
+
+	// lease 由节点自己创建
+	if _, err := clients.client.CoordinationV1().Leases(KubeNamespaceNodeLease).Get(context.TODO(), "test-node", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected lease to be created with client of node: %v", err)
+	}
+	leases, _ := helper.Client.CoordinationV1().Leases(KubeNamespaceNodeLease).List(context.TODO(), metav1.ListOptions{})
+	if len(leases.Items) != 0 {
+		t.Error("Expected client of agent not to be used for leases")
+	}
+}
@@ -0,0 +1,478 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+	"k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
+)
+
+var loggerForEviction = logrus.WithField("component", "eviction-manager")
+
+// eviction signals supported by simulator, the same as kubelet
+const (
+	SignalMemoryAvailable = "memory.available"
+	SignalNodeFsAvailable = "nodefs.available"
+	SignalPIDAvailable    = "pid.available"
+)
+
+const (
+	PodReasonEvicted = "Evicted"
+
+	DefaultEvictionInterval = 10 * time.Second
+)
+
+// evictionSignal describe which resource/condition/taint a signal relates to
+type evictionSignal struct {
+	resource  coreapi.ResourceName
+	condition coreapi.NodeConditionType
+	taint     string
+}
+
+// orderedSignals keeps the order of taints added on node stable
+var orderedSignals = []string{SignalMemoryAvailable, SignalNodeFsAvailable, SignalPIDAvailable}
+
+var evictionSignals = map[string]evictionSignal{
+	SignalMemoryAvailable: {resource: coreapi.ResourceMemory, condition: coreapi.NodeMemoryPressure, taint: coreapi.TaintNodeMemoryPressure},
+	SignalNodeFsAvailable: {resource: coreapi.ResourceEphemeralStorage, condition: coreapi.NodeDiskPressure, taint: coreapi.TaintNodeDiskPressure},
+	SignalPIDAvailable:    {resource: ResourcePIDs, condition: coreapi.NodePIDPressure, taint: coreapi.TaintNodePIDPressure},
+}
+
+// EvictionThreshold represent a threshold like `memory.available<100Mi`
+type EvictionThreshold struct {
+	Signal string
+	// one of Quantity or Percentage is set
+	Quantity    *resource.Quantity
+	Percentage  float64
+	GracePeriod time.Duration
+	Soft        bool
+}
+
+// ParseEvictionThresholds parses thresholds in kubelet flag format, e.g
+// hard: `memory.available<100Mi,nodefs.available<10%`, soft the same as hard,
+// softGracePeriod: `memory.available=1m30s`. every soft threshold must have a grace period
+func ParseEvictionThresholds(hard, soft, softGracePeriod string) ([]EvictionThreshold, error) {
+	var thresholds []EvictionThreshold
+	hardThresholds, err := parseThresholdStatements(hard)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid hard eviction thresholds")
+	}
+	thresholds = append(thresholds, hardThresholds...)
+
+	softThresholds, err := parseThresholdStatements(soft)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid soft eviction thresholds")
+	}
+	gracePeriods, err := parseKeyValueStatements(softGracePeriod)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid soft eviction grace period")
+	}
+	for _, threshold := range softThresholds {
+		period, ok := gracePeriods[threshold.Signal]
+		if !ok {
+			return nil, errors.Errorf("grace period must be specified for the soft eviction threshold %s", threshold.Signal)
+		}
+		if threshold.GracePeriod, err = time.ParseDuration(period); err != nil || threshold.GracePeriod < 0 {
+			return nil, errors.Errorf("invalid soft eviction grace period %s for %s", period, threshold.Signal)
+		}
+		threshold.Soft = true
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+func parseThresholdStatements(statements string) ([]EvictionThreshold, error) {
+	var thresholds []EvictionThreshold
+	for _, statement := range splitStatements(statements) {
+		parts := strings.SplitN(statement, "<", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("threshold %s must be in form of signal<value", statement)
+		}
+		signal, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if _, ok := evictionSignals[signal]; !ok {
+			return nil, errors.Errorf("unsupported eviction signal %s", signal)
+		}
+		threshold := EvictionThreshold{Signal: signal}
+		if strings.HasSuffix(value, "%") {
+			percentage, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil || percentage <= 0 || percentage > 100 {
+				return nil, errors.Errorf("invalid percentage %s for %s", value, signal)
+			}
+			threshold.Percentage = percentage / 100
+		} else {
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil || quantity.Sign() < 0 {
+				return nil, errors.Errorf("invalid quantity %s for %s", value, signal)
+			}
+			threshold.Quantity = &quantity
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+func parseKeyValueStatements(statements string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, statement := range splitStatements(statements) {
+		parts := strings.SplitN(statement, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("%s must be in form of key=value", statement)
+		}
+		result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return result, nil
+}
+
+func splitStatements(statements string) []string {
+	var result []string
+	for _, statement := range strings.Split(statements, ",") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+// thresholdQuantity returns the minimum available amount of resource for the threshold
+func (t EvictionThreshold) thresholdQuantity(capacity resource.Quantity) resource.Quantity {
+	if t.Quantity != nil {
+		return t.Quantity.DeepCopy()
+	}
+	return *resource.NewQuantity(int64(float64(capacity.Value())*t.Percentage), resource.BinarySI)
+}
+
+// EvictionManager watches simulated usage of nodes, when the available resource of a node
+// crosses eviction thresholds, it sets the pressure condition and taint of the node and
+// evicts pods from it in the same order kubelet does
+type EvictionManager struct {
+	sync.Mutex
+	thresholds    []EvictionThreshold
+	nodeManager   *NodeManager
+	clusterClient kubeclientset.Interface
+	// nodeClients report pressure conditions of nodes and evicted pods on them
+	nodeClients NodeClients
+	recorder    record.EventRecorder
+	// the first time a soft threshold was observed crossed, key by node/signal
+	firstObservedAt map[string]time.Time
+	clock           func() time.Time
+}
+
+func NewEvictionManager(client kubeclientset.Interface, nodeManager *NodeManager, recorder record.EventRecorder, thresholds []EvictionThreshold) *EvictionManager {
+	return &EvictionManager{
+		thresholds:      thresholds,
+		nodeManager:     nodeManager,
+		clusterClient:   client,
+		nodeClients:     NewSharedNodeClients(client),
+		recorder:        recorder,
+		firstObservedAt: make(map[string]time.Time),
+		clock:           time.Now,
+	}
+}
+
+// UseNodeClients makes nodes write their own objects with clients from clients
+func (m *EvictionManager) UseNodeClients(clients NodeClients) {
+	m.nodeClients = clients
+}
+
+// Run [#TODO](should add some comments)
+func (m *EvictionManager) Run(ctx context.Context) {
+	if len(m.thresholds) == 0 {
+		return
+	}
+	evictionTick := time.NewTicker(DefaultEvictionInterval)
+	defer evictionTick.Stop()
+	for {
+		select {
+		case <-evictionTick.C:
+			for _, nodeName := range m.nodeManager.allNodes() {
+				if err := m.synchronize(nodeName); err != nil {
+					loggerForEviction.WithError(err).Warnf("eviction sync for node %s failed", nodeName)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// synchronize checks thresholds for node, updates its conditions/taints and evicts at most one pod
+func (m *EvictionManager) synchronize(nodeName string) error {
+	node, pods, ok := m.nodeManager.snapshot(nodeName)
+	if !ok {
+		return nil
+	}
+	capacity := nodeCapacity(node)
+	available := capacity.DeepCopy()
+	for _, pod := range pods {
+		for name, quantity := range podUsage(pod) {
+			if value, ok := available[name]; ok {
+				value.Sub(quantity)
+				available[name] = value
+			}
+		}
+	}
+
+	met, evictable := m.thresholdsMet(nodeName, capacity, available)
+	if err := m.updateNodePressure(node, met); err != nil {
+		return err
+	}
+	for _, threshold := range evictable {
+		signal := evictionSignals[threshold.Signal]
+		victim := rankPodsForEviction(pods, signal.resource)
+		if victim == nil {
+			return nil
+		}
+		capacityQuantity := capacity[signal.resource]
+		thresholdQuantity := threshold.thresholdQuantity(capacityQuantity)
+		availableQuantity := available[signal.resource]
+		message := fmt.Sprintf("The node was low on resource: %s. Threshold quantity: %s, available: %s. ",
+			signal.resource, thresholdQuantity.String(), availableQuantity.String())
+		return m.evictPod(victim, signal.resource, message)
+	}
+	return nil
+}
+
+// thresholdsMet returns thresholds that node has crossed and those of them pods should be evicted for, which are
+// hard thresholds and soft ones met longer than their grace period. it records when soft thresholds are first observed
+func (m *EvictionManager) thresholdsMet(nodeName string, capacity, available coreapi.ResourceList) ([]EvictionThreshold, []EvictionThreshold) {
+	m.Lock()
+	defer m.Unlock()
+	var met, evictable []EvictionThreshold
+	for _, threshold := range m.thresholds {
+		name := evictionSignals[threshold.Signal].resource
+		key := thresholdKey(nodeName, threshold.Signal)
+		thresholdQuantity := threshold.thresholdQuantity(capacity[name])
+		availableQuantity := available[name]
+		if availableQuantity.Cmp(thresholdQuantity) >= 0 {
+			if threshold.Soft {
+				delete(m.firstObservedAt, key)
+			}
+			continue
+		}
+		if _, ok := m.firstObservedAt[key]; threshold.Soft && !ok {
+			m.firstObservedAt[key] = m.clock()
+		}
+		met = append(met, threshold)
+		if !threshold.Soft || m.clock().Sub(m.firstObservedAt[key]) >= threshold.GracePeriod {
+			evictable = append(evictable, threshold)
+		}
+	}
+	return met, evictable
+}
+
+// updateNodePressure sets pressure conditions and taints on node according the thresholds met
+func (m *EvictionManager) updateNodePressure(node *coreapi.Node, met []EvictionThreshold) error {
+	pressures := make(map[coreapi.NodeConditionType]bool)
+	for _, threshold := range met {
+		pressures[evictionSignals[threshold.Signal].condition] = true
+	}
+
+	latest, err := m.clusterClient.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	conditionChanged := false
+	for idx := range latest.Status.Conditions {
+		condition := &latest.Status.Conditions[idx]
+		underPressure, tracked := pressureConditionStatus(condition.Type, pressures)
+		if !tracked {
+			continue
+		}
+		expected := coreapi.ConditionFalse
+		if underPressure {
+			expected = coreapi.ConditionTrue
+		}
+		condition.LastHeartbeatTime = now
+		if condition.Status != expected {
+			condition.Status = expected
+			condition.LastTransitionTime = now
+			condition.Reason, condition.Message = pressureConditionReason(condition.Type, underPressure)
+			conditionChanged = true
+		}
+	}
+	if conditionChanged {
+		if latest, err = m.nodeClients.ForNode(node.Name).CoreV1().Nodes().UpdateStatus(context.TODO(), latest, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	var taints []coreapi.Taint
+	taintChanged := false
+	for _, taint := range latest.Spec.Taints {
+		if condition, ok := pressureConditionForTaint(taint.Key); ok && !pressures[condition] {
+			taintChanged = true
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	for _, signalName := range orderedSignals {
+		signal := evictionSignals[signalName]
+		if !pressures[signal.condition] || hasTaint(taints, signal.taint) {
+			continue
+		}
+		taints = append(taints, coreapi.Taint{Key: signal.taint, Effect: coreapi.TaintEffectNoSchedule, TimeAdded: &now})
+		taintChanged = true
+	}
+	if !taintChanged {
+		return nil
+	}
+	// taints are set by node lifecycle controller, nodes aren't allowed to modify them
+	latest.Spec.Taints = taints
+	_, err = m.clusterClient.CoreV1().Nodes().Update(context.TODO(), latest, metav1.UpdateOptions{})
+	return err
+}
+
+// evictPod sets pod to Failed with reason Evicted, the same as what kubelet does
+func (m *EvictionManager) evictPod(pod *coreapi.Pod, name coreapi.ResourceName, message string) error {
+	usage, request := podUsage(pod)[name], podRequestOf(pod, name)
+	message += fmt.Sprintf("Pod was using %s, request is %s. ", usage.String(), request.String())
+	if m.recorder != nil {
+		m.recorder.Eventf(pod, coreapi.EventTypeWarning, PodReasonEvicted, message)
+	}
+	evicted := pod.DeepCopy()
+	evicted.Status.Phase = coreapi.PodFailed
+	evicted.Status.Reason = PodReasonEvicted
+	evicted.Status.Message = message
+	for idx := range evicted.Status.Conditions {
+		evicted.Status.Conditions[idx].Status = coreapi.ConditionFalse
+	}
+	// containers of the evicted pod are killed
+	now := metav1.Now()
+	for idx := range evicted.Status.ContainerStatuses {
+		status := &evicted.Status.ContainerStatuses[idx]
+		if status.State.Terminated != nil {
+			continue
+		}
+		*status = newTerminatedContainerStatus(status, now, true)
+		status.State.Terminated.Reason = PodReasonEvicted
+	}
+	loggerForEviction.Infof("evicting pod %s/%s from node %s: %s", pod.Namespace, pod.Name, pod.Spec.NodeName, message)
+	if _, err := m.nodeClients.ForNode(pod.Spec.NodeName).CoreV1().Pods(pod.Namespace).UpdateStatus(context.TODO(), evicted, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "evict pod %s/%s", pod.Namespace, pod.Name)
+	}
+	// release resources of the evicted pod right away, don't wait for the informer
+	return m.nodeManager.OnPodUpdate(evicted)
+}
+
+// rankPodsForEviction returns the pod that should be evicted first for resource name:
+// BestEffort before Burstable before Guaranteed, then lower priority first, then the pod whose usage exceeds its request the most
+func rankPodsForEviction(pods []*coreapi.Pod, name coreapi.ResourceName) *coreapi.Pod {
+	var candidates []*coreapi.Pod
+	for _, pod := range pods {
+		if !IsPodTerminated(pod) && !isCriticalPod(pod) {
+			candidates = append(candidates, pod)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		qi, qj := qosRank(candidates[i]), qosRank(candidates[j])
+		if qi != qj {
+			return qi < qj
+		}
+		pi, pj := corev1helpers.PodPriority(candidates[i]), corev1helpers.PodPriority(candidates[j])
+		if pi != pj {
+			return pi < pj
+		}
+		ui, uj := usageOverRequest(candidates[i], name), usageOverRequest(candidates[j], name)
+		return ui.Cmp(uj) > 0
+	})
+	return candidates[0]
+}
+
+func qosRank(pod *coreapi.Pod) int {
+	switch qos.GetPodQOS(pod) {
+	case coreapi.PodQOSBestEffort:
+		return 0
+	case coreapi.PodQOSBurstable:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func usageOverRequest(pod *coreapi.Pod, name coreapi.ResourceName) resource.Quantity {
+	usage := podUsage(pod)[name]
+	usage.Sub(podRequestOf(pod, name))
+	return usage
+}
+
+func podRequestOf(pod *coreapi.Pod, name coreapi.ResourceName) resource.Quantity {
+	if name == ResourcePIDs {
+		return resource.Quantity{}
+	}
+	requests := resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{})
+	return requests[name]
+}
+
+// isCriticalPod returns true for pods with system critical priority, kubelet never evicts them
+func isCriticalPod(pod *coreapi.Pod) bool {
+	return pod.Spec.Priority != nil && *pod.Spec.Priority >= 2000000000
+}
+
+func pressureConditionStatus(conditionType coreapi.NodeConditionType, pressures map[coreapi.NodeConditionType]bool) (bool, bool) {
+	for _, signal := range evictionSignals {
+		if signal.condition == conditionType {
+			return pressures[conditionType], true
+		}
+	}
+	return false, false
+}
+
+func pressureConditionForTaint(key string) (coreapi.NodeConditionType, bool) {
+	for _, signal := range evictionSignals {
+		if signal.taint == key {
+			return signal.condition, true
+		}
+	}
+	return "", false
+}
+
+func pressureConditionReason(conditionType coreapi.NodeConditionType, underPressure bool) (string, string) {
+	switch conditionType {
+	case coreapi.NodeMemoryPressure:
+		if underPressure {
+			return "KubeletHasInsufficientMemory", "kubelet has insufficient memory available"
+		}
+		return "KubeletHasSufficientMemory", "kubelet has sufficient memory available"
+	case coreapi.NodeDiskPressure:
+		if underPressure {
+			return "KubeletHasDiskPressure", "kubelet has disk pressure"
+		}
+		return "KubeletHasNoDiskPressure", "kubelet has no disk pressure"
+	default:
+		if underPressure {
+			return "KubeletHasInsufficientPID", "kubelet has insufficient PID available"
+		}
+		return "KubeletHasSufficientPID", "kubelet has sufficient PID available"
+	}
+}
+
+func hasTaint(taints []coreapi.Taint, key string) bool {
+	for _, taint := range taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func thresholdKey(nodeName, signal string) string {
+	return nodeName + "/" + signal
+}
@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseEvictionThresholds(t *testing.T) {
+	testCases := []struct {
+		name          string
+		hard          string
+		soft          string
+		gracePeriod   string
+		expectedCount int
+		expectError   bool
+	}{
+		{
+			name:          "Empty thresholds",
+			expectedCount: 0,
+		},
+		{
+			name:          "Hard thresholds",
+			hard:          "memory.available<100Mi,nodefs.available<10%",
+			expectedCount: 2,
+		},
+		{
+			name:          "Soft thresholds with grace period",
+			hard:          "memory.available<100Mi",
+			soft:          "memory.available<1Gi,pid.available<10%",
+			gracePeriod:   "memory.available=1m30s,pid.available=30s",
+			expectedCount: 3,
+		},
+		{
+			name:        "Soft threshold without grace period",
+			soft:        "memory.available<1Gi",
+			expectError: true,
+		},
+		{
+			name:        "Unsupported signal",
+			hard:        "imagefs.available<15%",
+			expectError: true,
+		},
+		{
+			name:        "Invalid quantity",
+			hard:        "memory.available<abc",
+			expectError: true,
+		},
+		{
+			name:        "Invalid percentage",
+			hard:        "memory.available<120%",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			thresholds, err := ParseEvictionThresholds(tc.hard, tc.soft, tc.gracePeriod)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got thresholds %+v", thresholds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(thresholds) != tc.expectedCount {
+				t.Errorf("Expected %d thresholds, got %d", tc.expectedCount, len(thresholds))
+			}
+		})
+	}
+}
+
+func TestRankPodsForEviction(t *testing.T) {
+	guaranteed := newAdmissionTestPod("guaranteed", "100m", "100Mi")
+	guaranteed.Spec.Containers[0].Resources.Limits = guaranteed.Spec.Containers[0].Resources.Requests
+	guaranteed.Annotations = map[string]string{AnnotationMemoryUsage: "900Mi"}
+
+	burstableLow := newAdmissionTestPod("burstable-low", "100m", "100Mi")
+	burstableLow.Annotations = map[string]string{AnnotationMemoryUsage: "150Mi"}
+	burstableHigh := newAdmissionTestPod("burstable-high", "100m", "100Mi")
+	burstableHigh.Annotations = map[string]string{AnnotationMemoryUsage: "500Mi"}
+
+	bestEffort := NewManagerTestHelper(t).CreateTestPod("best-effort", "default", "test-node")
+
+	// BestEffort 优先被驱逐
+	victim := rankPodsForEviction([]*coreapi.Pod{guaranteed, burstableLow, bestEffort, burstableHigh}, coreapi.ResourceMemory)
+	if victim == nil || victim.Name != "best-effort" {
+		t.Fatalf("Expected best-effort pod to be evicted first, got %v", victim)
+	}
+
+	// 同为 Burstable 时，超出 request 最多的优先
+	victim = rankPodsForEviction([]*coreapi.Pod{guaranteed, burstableLow, burstableHigh}, coreapi.ResourceMemory)
+	if victim == nil || victim.Name != "burstable-high" {
+		t.Fatalf("Expected burstable-high pod to be evicted, got %v", victim)
+	}
+
+	// 低优先级优先
+	var lowPriority int32 = -10
+	burstableLow.Spec.Priority = &lowPriority
+	victim = rankPodsForEviction([]*coreapi.Pod{guaranteed, burstableLow, burstableHigh}, coreapi.ResourceMemory)
+	if victim == nil || victim.Name != "burstable-low" {
+		t.Fatalf("Expected burstable-low pod to be evicted, got %v", victim)
+	}
+}
+
+func TestEvictionManager_Synchronize(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	node := newAdmissionTestNode()
+	node.Status.Capacity = coreapi.ResourceList{coreapi.ResourceMemory: resource.MustParse("1Gi")}
+	node.Status.Conditions = []coreapi.NodeCondition{{Type: coreapi.NodeMemoryPressure, Status: coreapi.ConditionFalse}}
+	_, err := helper.Client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	helper.AssertNoError(err, "create node should not return error")
+
+	nodeManager := NewNodeManager(helper.Client)
+	helper.AssertNoError(nodeManager.OnNodeAdd(node), "OnNodeAdd should not return error")
+
+	hog := newAdmissionTestPod("hog", "100m", "100Mi")
+	hog.UID = types.UID("hog")
+	hog.Annotations = map[string]string{AnnotationMemoryUsage: "1000Mi"}
+	hog.Status.ContainerStatuses = []coreapi.ContainerStatus{newRunningContainerStatus(&hog.Spec.Containers[0])}
+	_, err = helper.Client.CoreV1().Pods(hog.Namespace).Create(context.TODO(), hog, metav1.CreateOptions{})
+	helper.AssertNoError(err, "create pod should not return error")
+	helper.AssertNoError(nodeManager.OnPodAdd(hog), "OnPodAdd should not return error")
+
+	thresholds, err := ParseEvictionThresholds("memory.available<100Mi", "", "")
+	helper.AssertNoError(err, "ParseEvictionThresholds should not return error")
+	evictionManager := NewEvictionManager(helper.Client, nodeManager, nil, thresholds)
+
+	helper.AssertNoError(evictionManager.synchronize(node.Name), "synchronize should not return error")
+
+	latestNode, err := helper.Client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+	helper.AssertNoError(err, "get node should not return error")
+	if latestNode.Status.Conditions[0].Status != coreapi.ConditionTrue {
+		t.Errorf("Expected MemoryPressure condition to be True, got %s", latestNode.Status.Conditions[0].Status)
+	}
+	if !hasTaint(latestNode.Spec.Taints, coreapi.TaintNodeMemoryPressure) {
+		t.Errorf("Expected node to be tainted with %s", coreapi.TaintNodeMemoryPressure)
+	}
+
+	evicted, err := helper.Client.CoreV1().Pods(hog.Namespace).Get(context.TODO(), hog.Name, metav1.GetOptions{})
+	helper.AssertNoError(err, "get pod should not return error")
+	if evicted.Status.Phase != coreapi.PodFailed || evicted.Status.Reason != PodReasonEvicted {
+		t.Errorf("Expected pod to be evicted, got phase %s reason %s", evicted.Status.Phase, evicted.Status.Reason)
+	}
+	// 被驱逐 pod 的容器被终止
+	if terminated := evicted.Status.ContainerStatuses[0].State.Terminated; terminated == nil || terminated.Reason != PodReasonEvicted {
+		t.Errorf("Expected container to be terminated with reason %s, got %+v", PodReasonEvicted, evicted.Status.ContainerStatuses[0].State)
+	}
+
+	// 驱逐后压力解除，条件和污点被清除
+	helper.AssertNoError(evictionManager.synchronize(node.Name), "synchronize should not return error")
+	latestNode, err = helper.Client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+	helper.AssertNoError(err, "get node should not return error")
+	if latestNode.Status.Conditions[0].Status != coreapi.ConditionFalse {
+		t.Errorf("Expected MemoryPressure condition to be False, got %s", latestNode.Status.Conditions[0].Status)
+	}
+	if hasTaint(latestNode.Spec.Taints, coreapi.TaintNodeMemoryPressure) {
+		t.Errorf("Expected taint %s to be removed", coreapi.TaintNodeMemoryPressure)
+	}
+}
+
+func TestEvictionManager_SoftThresholdGracePeriod(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	node := newAdmissionTestNode()
+	node.Status.Capacity = coreapi.ResourceList{coreapi.ResourceMemory: resource.MustParse("1Gi")}
+	_, err := helper.Client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	helper.AssertNoError(err, "create node should not return error")
+
+	nodeManager := NewNodeManager(helper.Client)
+	helper.AssertNoError(nodeManager.OnNodeAdd(node), "OnNodeAdd should not return error")
+
+	hog := newAdmissionTestPod("hog", "100m", "100Mi")
+	hog.Annotations = map[string]string{AnnotationMemoryUsage: "800Mi"}
+	_, err = helper.Client.CoreV1().Pods(hog.Namespace).Create(context.TODO(), hog, metav1.CreateOptions{})
+	helper.AssertNoError(err, "create pod should not return error")
+	helper.AssertNoError(nodeManager.OnPodAdd(hog), "OnPodAdd should not return error")
+
+	thresholds, err := ParseEvictionThresholds("", "memory.available<500Mi", "memory.available=1m")
+	helper.AssertNoError(err, "ParseEvictionThresholds should not return error")
+	evictionManager := NewEvictionManager(helper.Client, nodeManager, nil, thresholds)
+	now := time.Now()
+	evictionManager.clock = func() time.Time { return now }
+
+	// 宽限期内不驱逐
+	helper.AssertNoError(evictionManager.synchronize(node.Name), "synchronize should not return error")
+	pod, _ := helper.Client.CoreV1().Pods(hog.Namespace).Get(context.TODO(), hog.Name, metav1.GetOptions{})
+	if pod.Status.Phase == coreapi.PodFailed {
+		t.Fatal("Pod should not be evicted within the soft grace period")
+	}
+
+	// 宽限期过后驱逐
+	now = now.Add(2 * time.Minute)
+	helper.AssertNoError(evictionManager.synchronize(node.Name), "synchronize should not return error")
+	pod, _ = helper.Client.CoreV1().Pods(hog.Namespace).Get(context.TODO(), hog.Name, metav1.GetOptions{})
+	if pod.Status.Phase != coreapi.PodFailed || pod.Status.Reason != PodReasonEvicted {
+		t.Errorf("Expected pod to be evicted after the soft grace period, got phase %s", pod.Status.Phase)
+	}
+}
@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// DuplicatePodIP represent an ip used by more than one pod
+type DuplicatePodIP struct {
+	IP   string
+	Pods []*coreapi.Pod
+}
+
+// PodIPRestorer rebuilds ip allocations from pods which already exist in cluster
+type PodIPRestorer interface {
+	RestorePodIPs(pods []*coreapi.Pod) []DuplicatePodIP
+}
+
+// RestorePodIPs marks ips of running pods as allocated, so that ipams stay consistent after simulator
+// restarted. ips used by more than one pod are returned, only the first pod keeps the ip in ipam
+func (m *PodStatusManager) RestorePodIPs(pods []*coreapi.Pod) []DuplicatePodIP {
+	users := make(map[string][]*coreapi.Pod)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || IsPodTerminated(pod) {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			users[podIP.IP] = append(users[podIP.IP], pod)
+			if err := m.claimPodIP(pod, podIP.IP); err != nil {
+				loggerForPodManager.WithError(err).Debugf("failed restore ip of pod %s/%s", pod.Namespace, pod.Name)
+			}
+		}
+	}
+
+	var duplicates []DuplicatePodIP
+	for ip, pods := range users {
+		if len(pods) > 1 {
+			duplicates = append(duplicates, DuplicatePodIP{IP: ip, Pods: pods})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].IP < duplicates[j].IP })
+	return duplicates
+}
+
+// restoreNodePodIPs restores ips of pods running on node once its ipams are created, pod cidrs may be assigned
+// after pods were restored on start, e.g. by kube-controller-manager
+func (m *PodStatusManager) restoreNodePodIPs(nodeName string) {
+	podList, err := m.clusterClient.CoreV1().Pods(coreapi.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		loggerForPodManager.WithError(err).Warnf("failed list pods of node %s to restore their ips", nodeName)
+		return
+	}
+	pods := make([]*coreapi.Pod, 0, len(podList.Items))
+	for idx := range podList.Items {
+		if podList.Items[idx].Spec.NodeName == nodeName {
+			pods = append(pods, &podList.Items[idx])
+		}
+	}
+	for _, duplicate := range m.RestorePodIPs(pods) {
+		loggerForPodManager.Warnf("ip %s is used by %d pods on node %s", duplicate.IP, len(duplicate.Pods), nodeName)
+	}
+}
+
+// claimPodIP records ip as allocated to pod in the ipam of its node, it fails if ip is used by another pod.
+// nothing is recorded if the node has no ipam for ip yet
+func (m *PodStatusManager) claimPodIP(pod *coreapi.Pod, ip string) error {
+	m.ipLock.Lock()
+	defer m.ipLock.Unlock()
+	if owner, ok := m.ipOwners[ip]; ok {
+		if owner == pod.UID {
+			return nil
+		}
+		return errors.Errorf("ip %s of pod %s/%s is already used by another pod", ip, pod.Namespace, pod.Name)
+	}
+	for _, ipam := range m.ipams[pod.Spec.NodeName] {
+		if !ipam.Contains(ip) {
+			continue
+		}
+		if err := ipam.Reserve(ip); err != nil {
+			return err
+		}
+		m.ipOwners[ip] = pod.UID
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStatusManager_RestorePodIPs(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	testNode := helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24")
+	helper.AssertNoError(manager.OnNodeAdd(testNode), "OnNodeAdd should not return error")
+
+	running := helper.CreateTestPod("running", "default", testNode.Name)
+	running.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.5"}}
+	duplicate := helper.CreateTestPod("duplicate", "default", testNode.Name)
+	duplicate.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.5"}}
+	completed := helper.CreateTestPod("completed", "default", testNode.Name)
+	completed.Status.Phase = coreapi.PodSucceeded
+	completed.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.6"}}
+
+	duplicates := manager.RestorePodIPs([]*coreapi.Pod{running, duplicate, completed})
+
+	// 重复的 IP 被报告
+	if len(duplicates) != 1 || duplicates[0].IP != "10.244.1.5" || len(duplicates[0].Pods) != 2 {
+		t.Fatalf("Expected 10.244.1.5 to be reported as duplicate, got %+v", duplicates)
+	}
+	ipam := manager.ipams[testNode.Name][0]
+	if !ipam.Has("10.244.1.5") {
+		t.Error("Expected ip of running pod to be restored")
+	}
+	// 已结束的 Pod 不占用 IP
+	if ipam.Has("10.244.1.6") {
+		t.Error("Expected ip of completed pod not to be restored")
+	}
+
+	// 重启后新分配的 IP 不会与已有 Pod 冲突
+	newPod := helper.CreateTestPod("new", "default", testNode.Name)
+	manager.assignPodIP(newPod)
+	if newPod.Status.PodIP == "" || newPod.Status.PodIP == "10.244.1.5" {
+		t.Errorf("Expected a new ip for pod, got %q", newPod.Status.PodIP)
+	}
+
+	// 重复使用 IP 的 Pod 删除时不会释放其他 Pod 的 IP
+	manager.releasePodIPs(duplicate)
+	if !ipam.Has("10.244.1.5") {
+		t.Error("Expected ip of running pod to be kept after duplicate pod released")
+	}
+	manager.releasePodIPs(running)
+	if ipam.Has("10.244.1.5") {
+		t.Error("Expected ip to be released by its owner")
+	}
+}
+
+func TestPodStatusManager_ClaimPodIPAfterNodeAdded(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	running := helper.CreateTestPod("running", "default", "test-node")
+	running.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.5"}}
+
+	// 节点的 IPAM 尚未创建时不记录
+	manager.RestorePodIPs([]*coreapi.Pod{running})
+
+	testNode := helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24")
+	helper.AssertNoError(manager.OnNodeAdd(testNode), "OnNodeAdd should not return error")
+	manager.assignPodIP(running)
+	if !manager.ipams[testNode.Name][0].Has("10.244.1.5") {
+		t.Error("Expected ip of pod to be claimed once ipam of node exists")
+	}
+}
+
+func TestPodStatusManager_RestorePodIPsOnNodeAdd(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	running := helper.CreateTestPod("running", "default", "test-node")
+	running.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.5"}}
+	other := helper.CreateTestPod("other", "default", "other-node")
+	other.Status.PodIPs = []coreapi.PodIP{{IP: "10.244.1.6"}}
+	for _, pod := range []*coreapi.Pod{running, other} {
+		_, err := helper.Client.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+		helper.AssertNoError(err, "create pod should not return error")
+	}
+
+	// 节点的 pod cidr 尚未分配时没有 IPAM
+	testNode := helper.CreateTestNode("test-node", "10.10.10.1", "")
+	helper.AssertNoError(manager.OnNodeAdd(testNode), "OnNodeAdd should not return error")
+
+	// pod cidr 分配后创建 IPAM, 并恢复节点上已有 Pod 的 IP
+	testNode = helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24")
+	helper.AssertNoError(manager.OnNodeUpdate(testNode), "OnNodeUpdate should not return error")
+	ipam := manager.ipams[testNode.Name][0]
+	if !ipam.Has("10.244.1.5") {
+		t.Error("Expected ip of running pod to be restored once ipam of node is created")
+	}
+	// 其他节点上的 Pod 不占用该节点的 IP
+	if ipam.Has("10.244.1.6") {
+		t.Error("Expected ip of pod on other node not to be restored")
+	}
+}
@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	batchapi "k8s.io/api/batch/v1"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotations on the pod template of Jobs to control how their pods complete
+const (
+	// AnnotationJobDuration is how long the pod runs before its containers exit, e.g. "30s"
+	AnnotationJobDuration = "kube-simulator.io/job-duration"
+	// AnnotationJobFailureRatio is the probability in [0, 1] that a pod fails, e.g. "0.3"
+	AnnotationJobFailureRatio = "kube-simulator.io/job-failure-ratio"
+	// AnnotationJobExitCode is the exit code of containers when the pod fails, e.g. "42"
+	AnnotationJobExitCode = "kube-simulator.io/job-exit-code"
+	// AnnotationJobFailedIndexes lists completion indexes of an Indexed Job that always fail, e.g. "0,3-5"
+	AnnotationJobFailedIndexes = "kube-simulator.io/job-failed-indexes"
+)
+
+const (
+	DefaultJobDuration        = 10 * time.Second
+	DefaultJobFailureExitCode = 1
+)
+
+// JobConfig represent the defaults used to complete pods owned by Jobs, annotations on pods override them
+type JobConfig struct {
+	Duration        time.Duration
+	FailureRatio    float64
+	FailureExitCode int32
+}
+
+// jobCompletion represent how a Job pod completes
+type jobCompletion struct {
+	duration time.Duration
+	exitCode int32
+}
+
+// isJobPod returns true if pod is controlled by a Job, pods of CronJobs are controlled by Jobs as well
+func isJobPod(pod *coreapi.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	return owner != nil && owner.Kind == "Job" && strings.HasPrefix(owner.APIVersion, batchapi.GroupName+"/")
+}
+
+// newJobCompletion decides how long pod runs and whether it fails, failed indexes are checked first,
+// then a failure is rolled according to the failure ratio
+func newJobCompletion(pod *coreapi.Pod, config JobConfig, roll func() float64) jobCompletion {
+	completion := jobCompletion{
+		duration: durationFromAnnotation(pod.Annotations, AnnotationJobDuration, config.Duration),
+		exitCode: exitCodeCompleted,
+	}
+	failureExitCode := config.FailureExitCode
+	if value, err := strconv.ParseInt(pod.Annotations[AnnotationJobExitCode], 10, 32); err == nil && value != exitCodeCompleted {
+		failureExitCode = int32(value)
+	}
+	if failureExitCode == exitCodeCompleted {
+		failureExitCode = DefaultJobFailureExitCode
+	}
+
+	if index, ok := pod.Annotations[batchapi.JobCompletionIndexAnnotation]; ok && indexInList(index, pod.Annotations[AnnotationJobFailedIndexes]) {
+		completion.exitCode = failureExitCode
+		return completion
+	}
+	failureRatio := config.FailureRatio
+	if value, err := strconv.ParseFloat(pod.Annotations[AnnotationJobFailureRatio], 64); err == nil {
+		failureRatio = value
+	}
+	if failureRatio > 0 && roll() < failureRatio {
+		completion.exitCode = failureExitCode
+	}
+	return completion
+}
+
+// indexInList returns true if index is in list like "0,3-5"
+func indexInList(index, list string) bool {
+	value, err := strconv.Atoi(index)
+	if err != nil {
+		return false
+	}
+	for _, item := range splitStatements(list) {
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			continue
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				continue
+			}
+		}
+		if value >= first && value <= last {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureJobs sets the defaults used to complete pods owned by Jobs
+func (m *PodStatusManager) ConfigureJobs(config JobConfig) {
+	if config.Duration <= 0 {
+		config.Duration = DefaultJobDuration
+	}
+	if config.FailureExitCode == exitCodeCompleted {
+		config.FailureExitCode = DefaultJobFailureExitCode
+	}
+	m.jobConfig = config
+}
+
+// scheduleJobCompletion arranges the containers of a running Job pod to exit once its duration passed
+func (m *PodStatusManager) scheduleJobCompletion(pod *coreapi.Pod) {
+	if !isJobPod(pod) {
+		return
+	}
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	if _, ok := m.jobTimers[pod.UID]; ok {
+		return
+	}
+	completion := newJobCompletion(pod, m.jobConfig, rand.Float64)
+	// the pod may have been running before simulator restarted
+	delay := completion.duration
+	if startedAt := latestContainerStartedAt(pod); !startedAt.IsZero() {
+		delay -= time.Since(startedAt)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	m.jobTimers[pod.UID] = time.AfterFunc(delay, func() {
+		m.completedQueue <- jobCompletionEvent{pod: pod, exitCode: completion.exitCode}
+	})
+}
+
+// cancelJobCompletion stops the pending completion of pod
+func (m *PodStatusManager) cancelJobCompletion(pod *coreapi.Pod) {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	if timer, ok := m.jobTimers[pod.UID]; ok {
+		timer.Stop()
+		delete(m.jobTimers, pod.UID)
+	}
+}
+
+// jobCompletionEvent represent containers of a Job pod exiting
+type jobCompletionEvent struct {
+	pod      *coreapi.Pod
+	exitCode int32
+}
+
+// completeJobPod reports containers of pod exited with exitCode. the pod becomes Succeeded or Failed,
+// except that containers failed with restartPolicy OnFailure are restarted in place like kubelet does
+func (m *PodStatusManager) completeJobPod(event jobCompletionEvent) {
+	m.jobLock.Lock()
+	delete(m.jobTimers, event.pod.UID)
+	m.jobLock.Unlock()
+
+	pod, err := m.clusterClient.CoreV1().Pods(event.pod.Namespace).Get(context.TODO(), event.pod.Name, metav1.GetOptions{})
+	if err != nil || pod.UID != event.pod.UID || pod.DeletionTimestamp != nil || IsPodTerminated(pod) {
+		return
+	}
+
+	now := metav1.Now()
+	restart := event.exitCode != exitCodeCompleted && pod.Spec.RestartPolicy == coreapi.RestartPolicyOnFailure
+	for idx := range pod.Status.ContainerStatuses {
+		status := &pod.Status.ContainerStatuses[idx]
+		terminated := &coreapi.ContainerStateTerminated{ExitCode: event.exitCode, Reason: "Completed", FinishedAt: now}
+		if event.exitCode != exitCodeCompleted {
+			terminated.Reason = "Error"
+		}
+		if status.State.Running != nil {
+			terminated.StartedAt = status.State.Running.StartedAt
+		}
+		if restart {
+			status.LastTerminationState = coreapi.ContainerState{Terminated: terminated}
+			status.RestartCount++
+			status.State = coreapi.ContainerState{Running: &coreapi.ContainerStateRunning{StartedAt: now}}
+			continue
+		}
+		*status = newTerminatedContainerStatus(status, now, false)
+		status.State.Terminated = terminated
+	}
+
+	if !restart {
+		m.setPodConditionStatuses(pod, false)
+		pod.Status.Phase = terminatedPodPhase(pod)
+	}
+	updated, err := m.nodeClients.ForNode(pod.Spec.NodeName).CoreV1().Pods(pod.Namespace).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			loggerForPodManager.WithError(err).Warnf("failed complete job pod %s/%s", pod.Namespace, pod.Name)
+		}
+		return
+	}
+	if restart {
+		m.scheduleJobCompletion(updated)
+	}
+}
+
+func latestContainerStartedAt(pod *coreapi.Pod) time.Time {
+	var startedAt time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil && status.State.Running.StartedAt.Time.After(startedAt) {
+			startedAt = status.State.Running.StartedAt.Time
+		}
+	}
+	return startedAt
+}
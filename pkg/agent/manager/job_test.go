@@ -0,0 +1,212 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchapi "k8s.io/api/batch/v1"
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newJobTestPod(t *testing.T, name string) *coreapi.Pod {
+	controller := true
+	pod := NewManagerTestHelper(t).CreateTestPod(name, "default", "test-node")
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "batch/v1", Kind: "Job", Name: "test-job", UID: "test-job-uid", Controller: &controller},
+	}
+	pod.Spec.RestartPolicy = coreapi.RestartPolicyNever
+	return pod
+}
+
+func TestIsJobPod(t *testing.T) {
+	pod := newJobTestPod(t, "job-pod")
+	if !isJobPod(pod) {
+		t.Error("Pod controlled by Job should be recognised")
+	}
+
+	pod.OwnerReferences[0].APIVersion = "example.com/v1"
+	if isJobPod(pod) {
+		t.Error("Pod controlled by other Job kinds should not be recognised")
+	}
+
+	standalone := NewManagerTestHelper(t).CreateTestPod("standalone", "default", "test-node")
+	if isJobPod(standalone) {
+		t.Error("Pod without owner should not be recognised")
+	}
+}
+
+func TestNewJobCompletion(t *testing.T) {
+	config := JobConfig{Duration: 10 * time.Second, FailureRatio: 0.5, FailureExitCode: 1}
+
+	testCases := []struct {
+		name             string
+		annotations      map[string]string
+		roll             float64
+		expectedDuration time.Duration
+		expectedExitCode int32
+	}{
+		{
+			name:             "Succeeded with defaults",
+			roll:             0.9,
+			expectedDuration: 10 * time.Second,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "Failed with defaults",
+			roll:             0.1,
+			expectedDuration: 10 * time.Second,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "Annotations override defaults",
+			annotations:      map[string]string{AnnotationJobDuration: "1m", AnnotationJobFailureRatio: "1", AnnotationJobExitCode: "42"},
+			roll:             0.9,
+			expectedDuration: time.Minute,
+			expectedExitCode: 42,
+		},
+		{
+			name:             "Never fails with zero ratio",
+			annotations:      map[string]string{AnnotationJobFailureRatio: "0"},
+			roll:             0,
+			expectedDuration: 10 * time.Second,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "Failed index of indexed job",
+			annotations:      map[string]string{batchapi.JobCompletionIndexAnnotation: "4", AnnotationJobFailedIndexes: "0,3-5", AnnotationJobFailureRatio: "0"},
+			roll:             0.9,
+			expectedDuration: 10 * time.Second,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "Index not in failed indexes",
+			annotations:      map[string]string{batchapi.JobCompletionIndexAnnotation: "2", AnnotationJobFailedIndexes: "0,3-5", AnnotationJobFailureRatio: "0"},
+			roll:             0.9,
+			expectedDuration: 10 * time.Second,
+			expectedExitCode: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := newJobTestPod(t, "job-pod")
+			pod.Annotations = tc.annotations
+			completion := newJobCompletion(pod, config, func() float64 { return tc.roll })
+			if completion.duration != tc.expectedDuration {
+				t.Errorf("Expected duration %s, got %s", tc.expectedDuration, completion.duration)
+			}
+			if completion.exitCode != tc.expectedExitCode {
+				t.Errorf("Expected exit code %d, got %d", tc.expectedExitCode, completion.exitCode)
+			}
+		})
+	}
+}
+
+func TestPodStatusManager_CompleteJobPod(t *testing.T) {
+	testCases := []struct {
+		name          string
+		restartPolicy coreapi.RestartPolicy
+		exitCode      int32
+		expectedPhase coreapi.PodPhase
+		expectedState string
+	}{
+		{
+			name:          "Succeeded",
+			restartPolicy: coreapi.RestartPolicyNever,
+			exitCode:      0,
+			expectedPhase: coreapi.PodSucceeded,
+			expectedState: "Completed",
+		},
+		{
+			name:          "Failed",
+			restartPolicy: coreapi.RestartPolicyNever,
+			exitCode:      2,
+			expectedPhase: coreapi.PodFailed,
+			expectedState: "Error",
+		},
+		{
+			// OnFailure 时容器原地重启，Pod 保持 Running
+			name:          "Restarted on failure",
+			restartPolicy: coreapi.RestartPolicyOnFailure,
+			exitCode:      2,
+			expectedPhase: coreapi.PodRunning,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := NewManagerTestHelper(t)
+			manager := NewPodStatusManager(helper.Client)
+			manager.ConfigureJobs(JobConfig{Duration: time.Hour})
+
+			pod := newJobTestPod(t, "job-pod")
+			pod.Spec.RestartPolicy = tc.restartPolicy
+			_, err := helper.Client.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+			helper.AssertNoError(err, "create pod should not return error")
+			manager.startAllContainers(pod)
+
+			manager.completeJobPod(jobCompletionEvent{pod: pod, exitCode: tc.exitCode})
+
+			completed, err := helper.Client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+			helper.AssertNoError(err, "get pod should not return error")
+			if completed.Status.Phase != tc.expectedPhase {
+				t.Errorf("Expected pod phase %s, got %s", tc.expectedPhase, completed.Status.Phase)
+			}
+			status := completed.Status.ContainerStatuses[0]
+			if tc.restartPolicy == coreapi.RestartPolicyOnFailure {
+				if status.RestartCount != 1 || status.LastTerminationState.Terminated == nil || status.LastTerminationState.Terminated.ExitCode != tc.exitCode {
+					t.Errorf("Expected container to be restarted once after exiting with %d, got %+v", tc.exitCode, status)
+				}
+				// 重启后状态更新不应丢失重启次数
+				manager.startAllContainers(completed)
+				if completed.Status.ContainerStatuses[0].RestartCount != 1 {
+					t.Error("Restart count should be kept when containers are set ready again")
+				}
+				return
+			}
+			if status.State.Terminated == nil || status.State.Terminated.ExitCode != tc.exitCode || status.State.Terminated.Reason != tc.expectedState {
+				t.Errorf("Expected container to exit with %d (%s), got %+v", tc.exitCode, tc.expectedState, status.State)
+			}
+		})
+	}
+}
+
+func TestPodStatusManager_ScheduleJobCompletion(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	manager := NewPodStatusManager(helper.Client)
+	manager.ConfigureJobs(JobConfig{Duration: 50 * time.Millisecond})
+
+	standalone := helper.CreateTestPod("standalone", "default", "test-node")
+	manager.scheduleJobCompletion(standalone)
+
+	pod := newJobTestPod(t, "job-pod")
+	manager.scheduleJobCompletion(pod)
+	// 重复调度不会重复完成
+	manager.scheduleJobCompletion(pod)
+
+	select {
+	case event := <-manager.completedQueue:
+		if event.pod.UID != pod.UID {
+			t.Errorf("Expected completion of %s, got %s", pod.Name, event.pod.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected job pod to complete after its duration")
+	}
+	select {
+	case event := <-manager.completedQueue:
+		t.Fatalf("Unexpected completion of %s", event.pod.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 删除 Pod 取消完成
+	cancelled := newJobTestPod(t, "cancelled")
+	manager.scheduleJobCompletion(cancelled)
+	manager.cancelJobCompletion(cancelled)
+	select {
+	case <-manager.completedQueue:
+		t.Fatal("Completion should be cancelled")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -2,122 +2,202 @@ package manager
 
 import (
 	"fmt"
-	"net"
-	"strconv"
-	"strings"
+	"math/big"
+	"net/netip"
 	"sync"
 
+	"3Xpl0it3r.com/kube-simulator/pkg/util"
 	"github.com/pkg/errors"
 )
 
+// maxHostBits bounds the size of the allocation bitmap, a /8 of IPv4 has 16M addresses.
+// only the first 16M addresses of larger IPv6 networks are allocated, like kube-apiserver does for service ranges
+const maxHostBits = 24
+
+// CNIPlugin represent the IPAM of a node, it allocates pod IPs out of the pod CIDR of node.
+// the network address, the gateway (first address) and the IPv4 broadcast address are never allocated
 type CNIPlugin struct {
 	sync.Mutex
-	// IP 前缀,例如192.168.0.
-	ipPrefix string
-	// 子网掩码位数，例如24
-	subnetMaskBits string
-	// 子网包含的最大IP个数
-	maxIPCount uint16
-	// 最后分配的IP索引
-	lastAssignedIPIndex uint16
-	// 已分配的IP地址集合,只存放IP最后一位
-	usedIPs map[uint16]struct{}
-	// 可用的IP地址集合,只存放IP最后一位
-	freeIPs map[uint16]struct{}
+	// 节点的 Pod 网段，例如 10.244.1.0/24 或 fd00:10:244:1::/64
+	prefix netip.Prefix
+	// 可分配范围包含的地址个数，包括保留地址
+	size uint64
+	// 已分配的地址，第 n 位表示网段中偏移为 n 的地址
+	allocated *big.Int
+	// 已分配的地址个数，不包括保留地址
+	count uint64
+	// 最后分配的地址偏移，下一次从这里开始查找
+	lastOffset uint64
 }
 
 func NewCNIPlugin(networkCIDR string) (*CNIPlugin, error) {
-	_, ipNet, err := net.ParseCIDR(networkCIDR)
+	prefix, err := netip.ParsePrefix(networkCIDR)
 	if err != nil {
 		return nil, fmt.Errorf("networkcidr %s is invalid: %v", networkCIDR, err)
 	}
-
-	if ipNet.IP.To4() == nil {
-		return nil, fmt.Errorf("networkcidr %s must be IPv4", networkCIDR)
-	}
-
-	ipParts := strings.Split(ipNet.IP.String(), ".")
-	if len(ipParts) != 4 {
-		return nil, fmt.Errorf("networkcidr %s has invalid IP format", networkCIDR)
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > maxHostBits {
+		if prefix.Addr().Is4() {
+			return nil, fmt.Errorf("networkcidr %s is too large, the prefix length should be at least /%d", networkCIDR, prefix.Addr().BitLen()-maxHostBits)
+		}
+		hostBits = maxHostBits
 	}
-
-	mask, _ := ipNet.Mask.Size()
-
-	// 计算主机位数和最大IP个数，防止溢出
-	hostBits := 32 - mask
-	var maxIPs uint16
-	if hostBits >= 16 {
-		maxIPs = 65535 // uint16最大值
-	} else {
-		maxIPs = uint16(1 << hostBits)
+	// network, gateway and broadcast addresses are reserved, at least one address should be left
+	if prefix.Addr().BitLen()-prefix.Bits() < 2 {
+		return nil, fmt.Errorf("networkcidr %s is too small, the prefix length should be at most /%d", networkCIDR, prefix.Addr().BitLen()-2)
 	}
 
 	plugin := &CNIPlugin{
-		maxIPCount:          maxIPs,
-		lastAssignedIPIndex: 0,
-		usedIPs:             make(map[uint16]struct{}),
-		freeIPs:             make(map[uint16]struct{}),
-		ipPrefix:            strings.Join(ipParts[:3], "."),
-		subnetMaskBits:      strconv.Itoa(mask),
+		prefix:    prefix.Masked(),
+		size:      uint64(1) << hostBits,
+		allocated: big.NewInt(0),
 	}
-
+	plugin.allocated.SetBit(plugin.allocated, 0, 1)
+	plugin.allocated.SetBit(plugin.allocated, 1, 1)
+	if plugin.hasBroadcast() {
+		plugin.allocated.SetBit(plugin.allocated, int(plugin.size-1), 1)
+	}
+	plugin.lastOffset = 1
 	return plugin, nil
 }
 
-// AllocatePodIp [#TODO](should add some comments)
+// AllocatePodIp allocates the next free address after the last allocated one, so that released
+// addresses are not reused immediately
 func (p *CNIPlugin) AllocatePodIp() (string, error) {
 	p.Lock()
 	defer p.Unlock()
 
-	freeCnt := len(p.freeIPs)
-	if freeCnt != 0 {
-		var allocated uint16
-		for k, _ := range p.freeIPs {
-			allocated = k
-			break
+	for idx := uint64(1); idx < p.size; idx++ {
+		offset := (p.lastOffset + idx) % p.size
+		if p.allocated.Bit(int(offset)) == 1 {
+			continue
 		}
-		delete(p.freeIPs, allocated)
-		p.usedIPs[allocated] = struct{}{}
-		return fmt.Sprintf("%s.%d", p.ipPrefix, allocated), nil
+		p.allocated.SetBit(p.allocated, int(offset), 1)
+		p.count++
+		p.lastOffset = offset
+		return p.addrOf(offset).String(), nil
 	}
+	return "", errors.Errorf("no more available IP addresses in %s", p.prefix)
+}
+
+// DealloctePodIp releases ip, it fails if ip doesn't belong to the network, is reserved or isn't allocated
+func (p *CNIPlugin) DealloctePodIp(ip string) error {
+	p.Lock()
+	defer p.Unlock()
 
-	if p.lastAssignedIPIndex >= p.maxIPCount || p.lastAssignedIPIndex >= 255 {
-		return "", errors.New("no more available IP addresses on the node.")
+	offset, err := p.offsetOf(ip)
+	if err != nil {
+		return err
+	}
+	if p.isReserved(offset) {
+		return errors.Errorf("IP %s is reserved in network %s", ip, p.prefix)
+	}
+	if p.allocated.Bit(int(offset)) == 0 {
+		return errors.Errorf("IP %s is not allocated in network %s", ip, p.prefix)
 	}
+	p.allocated.SetBit(p.allocated, int(offset), 0)
+	p.count--
+	return nil
+}
 
-	p.lastAssignedIPIndex += 1
-	p.usedIPs[p.lastAssignedIPIndex] = struct{}{}
+// Reserve marks ip as allocated, e.g. the ip of a pod which was running before simulator restarted.
+// it fails if ip is already allocated
+func (p *CNIPlugin) Reserve(ip string) error {
+	p.Lock()
+	defer p.Unlock()
 
-	return fmt.Sprintf("%s.%d", p.ipPrefix, p.lastAssignedIPIndex), nil
+	offset, err := p.offsetOf(ip)
+	if err != nil {
+		return err
+	}
+	if p.isReserved(offset) {
+		return errors.Errorf("IP %s is reserved in network %s", ip, p.prefix)
+	}
+	if p.allocated.Bit(int(offset)) == 1 {
+		return errors.Errorf("IP %s is already allocated in network %s", ip, p.prefix)
+	}
+	p.allocated.SetBit(p.allocated, int(offset), 1)
+	p.count++
+	// continue after restored addresses, so that new pods don't get recently used addresses
+	if offset > p.lastOffset {
+		p.lastOffset = offset
+	}
+	return nil
 }
 
-// DealloctePodIp [#TODO](should add some comments)
-func (p *CNIPlugin) DealloctePodIp(ip string) error {
+// Has returns true if ip is allocated
+func (p *CNIPlugin) Has(ip string) bool {
 	p.Lock()
 	defer p.Unlock()
 
-	// 更安全的IP解析：先检查IP是否属于当前子网
-	if !strings.HasPrefix(ip, p.ipPrefix+".") {
-		return fmt.Errorf("IP %s does not belong to network %s", ip, p.ipPrefix)
+	offset, err := p.offsetOf(ip)
+	if err != nil || p.isReserved(offset) {
+		return false
 	}
+	return p.allocated.Bit(int(offset)) == 1
+}
+
+// Used returns the number of allocated addresses
+func (p *CNIPlugin) Used() int {
+	p.Lock()
+	defer p.Unlock()
+	return int(p.count)
+}
 
-	// 提取主机部分
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return fmt.Errorf("invalid IP format: %s", ip)
+// Capacity returns the number of addresses which can be allocated
+func (p *CNIPlugin) Capacity() int {
+	if p.hasBroadcast() {
+		return int(p.size - 3)
 	}
+	return int(p.size - 2)
+}
+
+// Utilization returns the ratio of allocated addresses, in [0, 1]
+func (p *CNIPlugin) Utilization() float64 {
+	return float64(p.Used()) / float64(p.Capacity())
+}
+
+// CIDR returns the network of the plugin
+func (p *CNIPlugin) CIDR() string {
+	return p.prefix.String()
+}
+
+// IsIPv6 returns true if the plugin allocates IPv6 addresses
+func (p *CNIPlugin) IsIPv6() bool {
+	return p.prefix.Addr().Is6()
+}
+
+// Contains returns true if ip belongs to the network of the plugin
+func (p *CNIPlugin) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && p.prefix.Contains(addr.Unmap())
+}
+
+// hasBroadcast returns true if the last address is the IPv4 broadcast address, IPv6 has no broadcast
+func (p *CNIPlugin) hasBroadcast() bool {
+	return p.prefix.Addr().Is4()
+}
+
+func (p *CNIPlugin) isReserved(offset uint64) bool {
+	return offset == 0 || offset == 1 || (p.hasBroadcast() && offset == p.size-1)
+}
 
-	hostAdr, err := strconv.Atoi(parts[3])
+func (p *CNIPlugin) addrOf(offset uint64) netip.Addr {
+	return util.AddToAddr(p.prefix.Addr(), new(big.Int).SetUint64(offset))
+}
+
+func (p *CNIPlugin) offsetOf(ip string) (uint64, error) {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
-		return fmt.Errorf("invalid host address in IP %s: %v", ip, err)
+		return 0, errors.Errorf("invalid IP format: %s", ip)
 	}
-
-	// 检查主机地址范围
-	if hostAdr < 0 || hostAdr > 255 {
-		return fmt.Errorf("host address %d out of range in IP %s", hostAdr, ip)
+	addr = addr.Unmap()
+	if !p.prefix.Contains(addr) {
+		return 0, errors.Errorf("IP %s does not belong to network %s", ip, p.prefix)
 	}
-
-	delete(p.usedIPs, uint16(hostAdr))
-	p.freeIPs[uint16(hostAdr)] = struct{}{}
-	return nil
+	offset := util.AddrOffset(p.prefix.Addr(), addr)
+	if !offset.IsUint64() || offset.Uint64() >= p.size {
+		return 0, errors.Errorf("IP %s is out of the allocatable range of network %s", ip, p.prefix)
+	}
+	return offset.Uint64(), nil
 }
@@ -31,9 +31,9 @@ func TestNewCNIPlugin(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name:        "IPv6 CIDR (not supported)",
+			name:        "IPv6 CIDR",
 			cidr:        "2001:db8::/64",
-			expectError: true,
+			expectError: false,
 		},
 	}
 
@@ -177,6 +177,165 @@ func TestCNIPlugin_DeallocatePodIp_InvalidIP(t *testing.T) {
 	}
 }
 
+func TestCNIPlugin_ReservedAddresses(t *testing.T) {
+	plugin, err := NewCNIPlugin("192.168.1.0/29")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+	if plugin.Capacity() != 5 {
+		t.Errorf("Expected capacity 5, got %d", plugin.Capacity())
+	}
+
+	// 网络地址、网关和广播地址不会被分配
+	var allocatedIPs []string
+	for {
+		ip, err := plugin.AllocatePodIp()
+		if err != nil {
+			break
+		}
+		allocatedIPs = append(allocatedIPs, ip)
+	}
+	expected := []string{"192.168.1.2", "192.168.1.3", "192.168.1.4", "192.168.1.5", "192.168.1.6"}
+	if len(allocatedIPs) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, allocatedIPs)
+	}
+	for idx := range expected {
+		if allocatedIPs[idx] != expected[idx] {
+			t.Errorf("Expected %v, got %v", expected, allocatedIPs)
+			break
+		}
+	}
+
+	for _, ip := range []string{"192.168.1.0", "192.168.1.1", "192.168.1.7"} {
+		if err := plugin.DealloctePodIp(ip); err == nil {
+			t.Errorf("Expected error when releasing reserved IP %s", ip)
+		}
+	}
+}
+
+func TestCNIPlugin_LargeNetwork(t *testing.T) {
+	plugin, err := NewCNIPlugin("10.244.0.0/16")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+	if plugin.Capacity() != 65533 {
+		t.Errorf("Expected capacity 65533, got %d", plugin.Capacity())
+	}
+
+	// 超过 255 个地址后进位到第三段
+	var ip string
+	for idx := 0; idx < 300; idx++ {
+		if ip, err = plugin.AllocatePodIp(); err != nil {
+			t.Fatalf("Failed to allocate IP: %v", err)
+		}
+	}
+	if ip != "10.244.1.45" {
+		t.Errorf("Expected 300th IP 10.244.1.45, got %s", ip)
+	}
+	if !plugin.Has("10.244.0.255") {
+		t.Error("Expected 10.244.0.255 to be allocated in a /16")
+	}
+	if plugin.Used() != 300 {
+		t.Errorf("Expected 300 used addresses, got %d", plugin.Used())
+	}
+}
+
+func TestCNIPlugin_InvalidPrefixLength(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/4", "192.168.1.0/31", "192.168.1.1/32"} {
+		if _, err := NewCNIPlugin(cidr); err == nil {
+			t.Errorf("Expected error for CIDR %s", cidr)
+		}
+	}
+}
+
+func TestCNIPlugin_DoubleFree(t *testing.T) {
+	plugin, err := NewCNIPlugin("192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+	ip, err := plugin.AllocatePodIp()
+	if err != nil {
+		t.Fatalf("Failed to allocate IP: %v", err)
+	}
+	if plugin.Utilization() == 0 {
+		t.Error("Expected utilization to be reported after allocation")
+	}
+
+	if err := plugin.DealloctePodIp(ip); err != nil {
+		t.Fatalf("Failed to deallocate IP %s: %v", ip, err)
+	}
+	// 重复释放返回错误
+	if err := plugin.DealloctePodIp(ip); err == nil {
+		t.Errorf("Expected error when releasing IP %s twice", ip)
+	}
+	if plugin.Used() != 0 {
+		t.Errorf("Expected no used addresses, got %d", plugin.Used())
+	}
+}
+
+func TestCNIPlugin_IPv6(t *testing.T) {
+	plugin, err := NewCNIPlugin("fd00:10:244:1::/64")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+	// 大于 /104 的 IPv6 网段只分配前 16M 个地址
+	if plugin.Capacity() != 1<<24-2 {
+		t.Errorf("Expected capacity %d, got %d", 1<<24-2, plugin.Capacity())
+	}
+
+	ip, err := plugin.AllocatePodIp()
+	if err != nil {
+		t.Fatalf("Failed to allocate IP: %v", err)
+	}
+	if ip != "fd00:10:244:1::2" {
+		t.Errorf("Expected first IP fd00:10:244:1::2, got %s", ip)
+	}
+	if err := plugin.DealloctePodIp("fd00:10:244:1:ffff::1"); err == nil {
+		t.Error("Expected error when releasing IP out of the allocatable range")
+	}
+	if err := plugin.DealloctePodIp(ip); err != nil {
+		t.Errorf("Failed to deallocate IP %s: %v", ip, err)
+	}
+
+	// IPv6 没有广播地址
+	small, err := NewCNIPlugin("fd00::/126")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+	if small.Capacity() != 2 {
+		t.Errorf("Expected capacity 2, got %d", small.Capacity())
+	}
+}
+
+func TestCNIPlugin_Reserve(t *testing.T) {
+	plugin, err := NewCNIPlugin("192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Failed to create CNIPlugin: %v", err)
+	}
+
+	if err := plugin.Reserve("192.168.1.10"); err != nil {
+		t.Fatalf("Failed to reserve IP: %v", err)
+	}
+	// 重复预留返回错误
+	if err := plugin.Reserve("192.168.1.10"); err == nil {
+		t.Error("Expected error when reserving allocated IP")
+	}
+	for _, ip := range []string{"192.168.1.1", "192.168.1.255", "10.0.0.1"} {
+		if err := plugin.Reserve(ip); err == nil {
+			t.Errorf("Expected error when reserving IP %s", ip)
+		}
+	}
+
+	// 新分配的地址从预留的地址之后开始
+	ip, err := plugin.AllocatePodIp()
+	if err != nil {
+		t.Fatalf("Failed to allocate IP: %v", err)
+	}
+	if ip != "192.168.1.11" {
+		t.Errorf("Expected 192.168.1.11, got %s", ip)
+	}
+}
+
 // isValidIPInSubnet 检查IP是否在指定子网内
 func isValidIPInSubnet(ip, cidr string) bool {
 	// 简单实现，只检查IP前缀
@@ -2,12 +2,14 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	kuberesource "3Xpl0it3r.com/kube-simulator/pkg/kuberes"
 	coreapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	kubeclientset "k8s.io/client-go/kubernetes"
 )
 
@@ -19,6 +21,10 @@ type nodeStatus struct {
 	hostName string
 	hostIp   string
 	cgroup   *CGrpupManager
+	// node is the latest node object seen, used by admission
+	node *coreapi.Node
+	// pods is the ledger of pods admitted on this node
+	pods map[types.UID]*coreapi.Pod
 }
 
 // NodeManager is reponsible for mantain all node information
@@ -26,10 +32,17 @@ type NodeManager struct {
 	sync.RWMutex
 	nodeStorage   map[string]*nodeStatus
 	clusterClient kubeclientset.Interface
+	// nodeClients renew leases of nodes
+	nodeClients NodeClients
 }
 
 func NewNodeManager(client kubeclientset.Interface) *NodeManager {
-	return &NodeManager{nodeStorage: make(map[string]*nodeStatus), clusterClient: client}
+	return &NodeManager{nodeStorage: make(map[string]*nodeStatus), clusterClient: client, nodeClients: NewSharedNodeClients(client)}
+}
+
+// UseNodeClients makes nodes write their own objects with clients from clients
+func (m *NodeManager) UseNodeClients(clients NodeClients) {
+	m.nodeClients = clients
 }
 
 // Run [#TODO](should add some comments)
@@ -47,10 +60,10 @@ func (m *NodeManager) Run(ctx context.Context) {
 }
 
 func (m *NodeManager) OnPodAdd(pod *coreapi.Pod) error {
-	nodeName := pod.Spec.NodeName
-	if nodeStatus, ok := m.nodeStorage[nodeName]; ok {
+	if nodeStatus, ok := m.nodeStatusOf(pod.Spec.NodeName); ok {
 		nodeStatus.Lock()
 		nodeStatus.cgroup.OnAdd(pod)
+		nodeStatus.pods[pod.UID] = pod
 		nodeStatus.Unlock()
 	} else {
 	}
@@ -58,25 +71,39 @@ func (m *NodeManager) OnPodAdd(pod *coreapi.Pod) error {
 }
 
 func (m *NodeManager) OnPodUpdate(newPod *coreapi.Pod) error {
-	nodeName := newPod.Spec.NodeName
-	if nodeStatus, ok := m.nodeStorage[nodeName]; ok {
+	if nodeStatus, ok := m.nodeStatusOf(newPod.Spec.NodeName); ok {
 		nodeStatus.Lock()
 		nodeStatus.cgroup.OnUpdate(newPod)
+		if IsPodTerminated(newPod) {
+			delete(nodeStatus.pods, newPod.UID)
+		} else {
+			nodeStatus.pods[newPod.UID] = newPod
+		}
 		nodeStatus.Unlock()
 	}
 	return nil
 }
 
 func (m *NodeManager) OnPodDelete(pod *coreapi.Pod) error {
-	nodeName := pod.Spec.NodeName
-	if nodeStatus, ok := m.nodeStorage[nodeName]; ok {
+	if nodeStatus, ok := m.nodeStatusOf(pod.Spec.NodeName); ok {
 		nodeStatus.Lock()
-		nodeStatus.cgroup.OnDelete(pod)
+		if _, admitted := nodeStatus.pods[pod.UID]; admitted {
+			nodeStatus.cgroup.OnDelete(pod)
+			delete(nodeStatus.pods, pod.UID)
+		}
 		nodeStatus.Unlock()
 	}
 	return nil
 }
 
+// nodeStatusOf returns status of the node, pod events come from informers as well as other managers, e.g. eviction
+func (m *NodeManager) nodeStatusOf(nodeName string) (*nodeStatus, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	nodeStatus, ok := m.nodeStorage[nodeName]
+	return nodeStatus, ok
+}
+
 // 添加一个node
 func (m *NodeManager) OnNodeAdd(node *coreapi.Node) error {
 	m.Lock()
@@ -84,7 +111,7 @@ func (m *NodeManager) OnNodeAdd(node *coreapi.Node) error {
 	// if node not existed, then added
 	if _, ok := m.nodeStorage[node.Name]; !ok {
 		m.nodeStorage[node.Name] = nodeStatusFromNodeObj(node)
-		tryResyncNodeLease(m.clusterClient, node.Name)
+		tryResyncNodeLease(m.nodeClients.ForNode(node.Name), node.Name)
 	}
 	return nil
 }
@@ -96,9 +123,13 @@ func (m *NodeManager) OnNodeUpdate(node *coreapi.Node) error {
 	originNodeStatus, ok := m.nodeStorage[node.Name]
 	if !ok {
 		m.nodeStorage[node.Name] = nodeStatusFromNodeObj(node)
+		return nil
 	}
 	newNodeStatus := nodeStatusFromNodeObj(node)
+	originNodeStatus.Lock()
 	originNodeStatus.cgroup.Merge(newNodeStatus.cgroup)
+	originNodeStatus.node = node
+	originNodeStatus.Unlock()
 	return nil
 }
 
@@ -110,11 +141,49 @@ func (m *NodeManager) OnNodeDelete(node *coreapi.Node) error {
 	return nil
 }
 
+// Admit runs kubelet-style admission for pod against the node it's bound to.
+// pods already in the ledger of the node are admitted again without check
+func (m *NodeManager) Admit(pod *coreapi.Pod) PodAdmitResult {
+	nodeStatus, ok := m.nodeStatusOf(pod.Spec.NodeName)
+	if !ok {
+		return PodAdmitResult{
+			Reason:  AdmissionReasonNodeNotFound,
+			Message: fmt.Sprintf("node %s is not managed by simulator", pod.Spec.NodeName),
+		}
+	}
+
+	nodeStatus.Lock()
+	defer nodeStatus.Unlock()
+	if _, admitted := nodeStatus.pods[pod.UID]; admitted {
+		return PodAdmitResult{Admit: true}
+	}
+	admittedPods := make([]*coreapi.Pod, 0, len(nodeStatus.pods))
+	for _, admitted := range nodeStatus.pods {
+		admittedPods = append(admittedPods, admitted)
+	}
+	return admitPod(nodeStatus.node, admittedPods, pod)
+}
+
+// snapshot returns the node object and the pods admitted on it
+func (m *NodeManager) snapshot(nodeName string) (*coreapi.Node, []*coreapi.Pod, bool) {
+	nodeStatus, ok := m.nodeStatusOf(nodeName)
+	if !ok {
+		return nil, nil, false
+	}
+	nodeStatus.Lock()
+	defer nodeStatus.Unlock()
+	pods := make([]*coreapi.Pod, 0, len(nodeStatus.pods))
+	for _, pod := range nodeStatus.pods {
+		pods = append(pods, pod)
+	}
+	return nodeStatus.node, pods, true
+}
+
 // AllNodes [#TODO](should add some comments)
 func (m *NodeManager) allNodes() []string {
 	m.RLock()
 	defer m.RUnlock()
-	nodes := make([]string, len(m.nodeStorage))
+	nodes := make([]string, 0, len(m.nodeStorage))
 	for node, _ := range m.nodeStorage {
 		nodes = append(nodes, node)
 	}
@@ -132,6 +201,8 @@ func nodeStatusFromNodeObj(node *coreapi.Node) *nodeStatus {
 		cgroup:   NewCGroupManager(node),
 		hostName: node.Name,
 		hostIp:   nodeInternalIp,
+		node:     node,
+		pods:     make(map[types.UID]*coreapi.Pod),
 	}
 }
 
@@ -139,7 +210,7 @@ func nodeStatusFromNodeObj(node *coreapi.Node) *nodeStatus {
 func (m *NodeManager) syncAllNodes() {
 	allNodes := m.allNodes()
 	for _, node := range allNodes {
-		tryResyncNodeLease(m.clusterClient, node)
+		tryResyncNodeLease(m.nodeClients.ForNode(node), node)
 	}
 }
 
@@ -2,30 +2,61 @@ package manager
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	kubeclientset "k8s.io/client-go/kubernetes"
 )
 
+var loggerForPodManager = logrus.WithField("component", "pod-manager")
+
 type PodStatusManager struct {
-	ipams         map[string]*CNIPlugin
-	removedQueue  chan *coreapi.Pod
-	workingQueue  chan *coreapi.Pod
-	clusterClient kubeclientset.Interface
+	// ipams of nodes, one per ip family in the order of node.Spec.PodCIDRs
+	ipams map[string][]*CNIPlugin
+	// ipOwners records which pod every allocated ip belongs to
+	ipLock         sync.Mutex
+	ipOwners       map[string]types.UID
+	removedQueue   chan *coreapi.Pod
+	workingQueue   chan *coreapi.Pod
+	completedQueue chan jobCompletionEvent
+	clusterClient  kubeclientset.Interface
+	// nodeClients report status of pods and delete them as the nodes they are bound to
+	nodeClients NodeClients
+	// terminating records when the termination of pods began
+	terminatingLock sync.Mutex
+	terminating     map[types.UID]time.Time
+	// jobTimers completes containers of Job pods once they ran long enough
+	jobLock   sync.Mutex
+	jobTimers map[types.UID]*time.Timer
+	jobConfig JobConfig
 }
 
 func NewPodStatusManager(client kubeclientset.Interface) *PodStatusManager {
 	pm := &PodStatusManager{
-		removedQueue:  make(chan *coreapi.Pod, 1024),
-		workingQueue:  make(chan *coreapi.Pod, 1024),
-		clusterClient: client,
-		ipams:         make(map[string]*CNIPlugin),
+		removedQueue:   make(chan *coreapi.Pod, 1024),
+		workingQueue:   make(chan *coreapi.Pod, 1024),
+		completedQueue: make(chan jobCompletionEvent, 1024),
+		clusterClient:  client,
+		nodeClients:    NewSharedNodeClients(client),
+		ipams:          make(map[string][]*CNIPlugin),
+		ipOwners:       make(map[string]types.UID),
+		terminating:    make(map[types.UID]time.Time),
+		jobTimers:      make(map[types.UID]*time.Timer),
+		jobConfig:      JobConfig{Duration: DefaultJobDuration, FailureExitCode: DefaultJobFailureExitCode},
 	}
 	return pm
 }
 
+// UseNodeClients makes nodes write their own objects with clients from clients
+func (m *PodStatusManager) UseNodeClients(clients NodeClients) {
+	m.nodeClients = clients
+}
+
 // Run [#TODO](should add some comments)
 func (m *PodStatusManager) Run(ctx context.Context) {
 	for {
@@ -34,6 +65,8 @@ func (m *PodStatusManager) Run(ctx context.Context) {
 			m.stopAllContainers(pod)
 		case pod := <-m.workingQueue:
 			m.startAllContainers(pod)
+		case event := <-m.completedQueue:
+			m.completeJobPod(event)
 		case <-ctx.Done():
 			return
 		}
@@ -52,28 +85,66 @@ func (m *PodStatusManager) OnPodUpdate(pod *coreapi.Pod) error {
 	return nil
 }
 
+// OnPodDelete begins the graceful termination of pod: the pod stays terminating while the simulated
+// preStop hooks and container shutdown run, bounded by its grace period, then it's stopped and deleted
 func (m *PodStatusManager) OnPodDelete(pod *coreapi.Pod) error {
-	m.stopAllContainers(pod)
-	m.removedQueue <- pod
+	m.cancelJobCompletion(pod)
+	// containers of pod are already stopped, nothing to wait for
+	if canBeDeleted(pod) {
+		m.finishTermination(pod)
+		m.releasePodIPs(pod)
+		if pod.DeletionTimestamp != nil {
+			if err := m.deletePodImmediatly(pod); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if !m.beginTermination(pod) {
+		return nil
+	}
+	plan := newTerminationPlan(pod)
+	loggerForPodManager.Infof("terminating pod %s/%s in %s, grace period %s", pod.Namespace, pod.Name, plan.duration, plan.gracePeriod)
+	if err := m.setPodTerminating(pod); err != nil && !apierrors.IsNotFound(err) {
+		loggerForPodManager.WithError(err).Warnf("failed update status of terminating pod %s/%s", pod.Namespace, pod.Name)
+	}
+	if plan.duration == 0 {
+		m.removedQueue <- pod
+		return nil
+	}
+	time.AfterFunc(plan.duration, func() {
+		m.removedQueue <- pod
+	})
 	return nil
 }
 
-// OnNodeAdd [#TODO](should add some comments)
+// OnNodeAdd creates ipams for every pod cidr of node, ips of pods already running on node are restored in them
 func (m *PodStatusManager) OnNodeAdd(node *coreapi.Node) error {
-	_, ok := m.ipams[node.Name]
-	if ok {
+	if len(m.ipams[node.Name]) != 0 {
 		return nil
 	}
-	if cniPlg, err := NewCNIPlugin(node.Spec.PodCIDR); err != nil {
-		return err
-	} else {
-		m.ipams[node.Name] = cniPlg
+	podCIDRs := node.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && node.Spec.PodCIDR != "" {
+		podCIDRs = []string{node.Spec.PodCIDR}
+	}
+	var plugins []*CNIPlugin
+	for _, podCIDR := range podCIDRs {
+		cniPlg, err := NewCNIPlugin(podCIDR)
+		if err != nil {
+			return err
+		}
+		plugins = append(plugins, cniPlg)
+	}
+	m.ipams[node.Name] = plugins
+	if len(plugins) != 0 {
+		m.restoreNodePodIPs(node.Name)
 	}
 	return nil
 }
 
+// OnNodeUpdate creates ipams once pod cidrs are assigned to node, e.g. by kube-controller-manager
 func (m *PodStatusManager) OnNodeUpdate(node *coreapi.Node) error {
-	return nil
+	return m.OnNodeAdd(node)
 }
 
 func (m *PodStatusManager) OnNodeDelete(node *coreapi.Node) error {
@@ -81,61 +152,170 @@ func (m *PodStatusManager) OnNodeDelete(node *coreapi.Node) error {
 	return nil
 }
 
+// IsPodTerminated returns true if pod has reached a terminal phase and should never be restarted
+func IsPodTerminated(pod *coreapi.Pod) bool {
+	return pod.Status.Phase == coreapi.PodFailed || pod.Status.Phase == coreapi.PodSucceeded
+}
+
 // startAllContainers
 func (m *PodStatusManager) startAllContainers(pod *coreapi.Pod) {
 	m.setContainersToReadyState(pod)
 	m.assignPodIP(pod)
 	m.setPodConditionStatuses(pod, true)
 	pod.Status.Phase = coreapi.PodRunning
-	m.updatePodStatus(pod)
-
+	if err := m.updatePodStatus(pod); err != nil {
+		return
+	}
+	m.scheduleJobCompletion(pod)
 }
 
-// stopAllContainers
+// stopAllContainers reports the final state of containers, then force deletes the pod
 func (m *PodStatusManager) stopAllContainers(pod *coreapi.Pod) {
+	if latest, err := m.clusterClient.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{}); err == nil && latest.UID == pod.UID {
+		pod = latest
+	} else {
+		pod = pod.DeepCopy()
+	}
 	m.setAllContainersTerminated(pod)
 	m.deSetNetwork(pod)
 	m.setPodConditionStatuses(pod, false)
-	pod.Status.Phase = coreapi.PodSucceeded
-	if m.canBeDeleted(pod) {
-		m.deletePodImmediatly(pod)
+	pod.Status.Phase = terminatedPodPhase(pod)
+	if err := m.updatePodStatus(pod); err != nil && !apierrors.IsNotFound(err) {
+		loggerForPodManager.WithError(err).Warnf("failed report final status of pod %s/%s", pod.Namespace, pod.Name)
+	}
+	if canBeDeleted(pod) {
+		if err := m.deletePodImmediatly(pod); err != nil && !apierrors.IsNotFound(err) {
+			loggerForPodManager.WithError(err).Warnf("failed delete pod %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+}
+
+// beginTermination records pod as terminating, returns false if it's already terminating
+func (m *PodStatusManager) beginTermination(pod *coreapi.Pod) bool {
+	m.terminatingLock.Lock()
+	defer m.terminatingLock.Unlock()
+	if _, ok := m.terminating[pod.UID]; ok {
+		return false
+	}
+	m.terminating[pod.UID] = time.Now()
+	return true
+}
+
+func (m *PodStatusManager) finishTermination(pod *coreapi.Pod) {
+	m.terminatingLock.Lock()
+	defer m.terminatingLock.Unlock()
+	delete(m.terminating, pod.UID)
+}
+
+func (m *PodStatusManager) terminationStartedAt(pod *coreapi.Pod) time.Time {
+	m.terminatingLock.Lock()
+	defer m.terminatingLock.Unlock()
+	if startedAt, ok := m.terminating[pod.UID]; ok {
+		return startedAt
 	}
+	return time.Now()
 }
 
+// setPodTerminating marks pod not ready while its containers are shutting down
+func (m *PodStatusManager) setPodTerminating(pod *coreapi.Pod) error {
+	terminating := pod.DeepCopy()
+	var now = metav1.Now()
+	for idx := range terminating.Status.Conditions {
+		condition := &terminating.Status.Conditions[idx]
+		if (condition.Type == coreapi.PodReady || condition.Type == coreapi.ContainersReady) && condition.Status != coreapi.ConditionFalse {
+			condition.Status = coreapi.ConditionFalse
+			condition.LastTransitionTime = now
+		}
+	}
+	for idx := range terminating.Status.ContainerStatuses {
+		terminating.Status.ContainerStatuses[idx].Ready = false
+	}
+	return m.updatePodStatus(terminating)
+}
+
+// assignPodIP allocates one ip per family for pod, the ip of the primary family comes first
 func (m *PodStatusManager) assignPodIP(pod *coreapi.Pod) {
 	if len(pod.Status.PodIPs) != 0 {
 		pod.Status.PodIP = pod.Status.PodIPs[0].IP
+		// the ips may be assigned before the ipam of node existed, e.g. before simulator restarted
+		for _, podIP := range pod.Status.PodIPs {
+			if err := m.claimPodIP(pod, podIP.IP); err != nil {
+				loggerForPodManager.WithError(err).Warnf("pod %s/%s has duplicate ip", pod.Namespace, pod.Name)
+			}
+		}
 		return
 	}
-	ipam, ok := m.ipams[pod.Spec.NodeName]
-	if !ok {
+	ipams, ok := m.ipams[pod.Spec.NodeName]
+	if !ok || len(ipams) == 0 {
 		return
 	}
 
-	if ip, err := ipam.AllocatePodIp(); err == nil {
-		pod.Status.PodIP = ip
-		pod.Status.PodIPs = []coreapi.PodIP{{IP: ip}}
-		pod.Status.Phase = coreapi.PodRunning
+	var podIPs []coreapi.PodIP
+	for _, ipam := range ipams {
+		ip, err := ipam.AllocatePodIp()
+		if err != nil {
+			loggerForPodManager.WithError(err).Warnf("failed allocate ip for pod %s/%s on node %s", pod.Namespace, pod.Name, pod.Spec.NodeName)
+			m.releaseIPs(pod, podIPs)
+			return
+		}
+		m.ipLock.Lock()
+		m.ipOwners[ip] = pod.UID
+		m.ipLock.Unlock()
+		podIPs = append(podIPs, coreapi.PodIP{IP: ip})
+		loggerForPodManager.Debugf("ipam of node %s: %d/%d addresses in %s are used", pod.Spec.NodeName, ipam.Used(), ipam.Capacity(), ipam.CIDR())
 	}
+	pod.Status.PodIP = podIPs[0].IP
+	pod.Status.PodIPs = podIPs
+	pod.Status.Phase = coreapi.PodRunning
 }
 
 func (m *PodStatusManager) deSetNetwork(pod *coreapi.Pod) {
+	m.releasePodIPs(pod)
 	pod.Status.PodIP = ""
 	pod.Status.PodIPs = []coreapi.PodIP{}
 }
 
+// releasePodIPs returns addresses of pod to the ipams of its node
+func (m *PodStatusManager) releasePodIPs(pod *coreapi.Pod) {
+	m.releaseIPs(pod, pod.Status.PodIPs)
+}
+
+// releaseIPs releases podIPs which are allocated to pod, ips of other pods are kept
+func (m *PodStatusManager) releaseIPs(pod *coreapi.Pod, podIPs []coreapi.PodIP) {
+	m.ipLock.Lock()
+	defer m.ipLock.Unlock()
+	for _, podIP := range podIPs {
+		if owner, ok := m.ipOwners[podIP.IP]; !ok || owner != pod.UID {
+			continue
+		}
+		delete(m.ipOwners, podIP.IP)
+		for _, ipam := range m.ipams[pod.Spec.NodeName] {
+			if !ipam.Contains(podIP.IP) {
+				continue
+			}
+			if err := ipam.DealloctePodIp(podIP.IP); err != nil {
+				loggerForPodManager.WithError(err).Debugf("failed release ip of pod %s/%s", pod.Namespace, pod.Name)
+			}
+		}
+	}
+}
+
 // setContainersToReadyState simulates container starting by setting container states to Ready.
-// No actual containers are running
+// No actual containers are running. containers already running keep their start time and restart history
 func (m *PodStatusManager) setContainersToReadyState(pod *coreapi.Pod) {
 	var (
 		containersStatus []coreapi.ContainerStatus
 		ready            bool = true
 	)
+	origins := make(map[string]coreapi.ContainerStatus, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		origins[status.Name] = status
+	}
 	for _, containerSpec := range pod.Spec.InitContainers {
-		containersStatus = append(containersStatus, newRunningContainerStatus(&containerSpec))
+		containersStatus = append(containersStatus, keepContainerHistory(newRunningContainerStatus(&containerSpec), origins))
 	}
 	for _, containerSpec := range pod.Spec.Containers {
-		containersStatus = append(containersStatus, newRunningContainerStatus(&containerSpec))
+		containersStatus = append(containersStatus, keepContainerHistory(newRunningContainerStatus(&containerSpec), origins))
 	}
 	for _, containerSpec := range pod.Spec.EphemeralContainers {
 		containersStatus = append(containersStatus, coreapi.ContainerStatus{
@@ -152,12 +332,14 @@ func (m *PodStatusManager) setContainersToReadyState(pod *coreapi.Pod) {
 }
 
 // setAllContainersTerminated simulates container stopping by setting container states to Terminated.
-// No actual containers are stopped since none are running.
+// No actual containers are stopped since none are running. containers that didn't stop within the
+// grace period are reported as killed
 func (m *PodStatusManager) setAllContainersTerminated(pod *coreapi.Pod) {
-	var finishedAt metav1.Time = metav1.NewTime(time.Now().Add(30 * time.Second))
-	m.setContainerStatusTerminated(pod.Status.InitContainerStatuses, finishedAt)
-	m.setContainerStatusTerminated(pod.Status.ContainerStatuses, finishedAt)
-	m.setContainerStatusTerminated(pod.Status.EphemeralContainerStatuses, finishedAt)
+	plan := newTerminationPlan(pod)
+	startedAt := m.terminationStartedAt(pod)
+	m.setContainerStatusTerminated(pod.Status.InitContainerStatuses, plan, startedAt)
+	m.setContainerStatusTerminated(pod.Status.ContainerStatuses, plan, startedAt)
+	m.setContainerStatusTerminated(pod.Status.EphemeralContainerStatuses, plan, startedAt)
 
 	for idx := range pod.Status.Conditions {
 		pod.Status.Conditions[idx].Status = coreapi.ConditionFalse
@@ -165,13 +347,25 @@ func (m *PodStatusManager) setAllContainersTerminated(pod *coreapi.Pod) {
 }
 
 // setContainerStatusTerminated [#TODO](should add some comments)
-func (m *PodStatusManager) setContainerStatusTerminated(containerStatuses []coreapi.ContainerStatus, finishedAt metav1.Time) {
+func (m *PodStatusManager) setContainerStatusTerminated(containerStatuses []coreapi.ContainerStatus, plan terminationPlan, startedAt time.Time) {
 	for idx, originContainerStatus := range containerStatuses {
 		if originContainerStatus.State.Terminated != nil {
-			finishedAt = originContainerStatus.State.Terminated.FinishedAt
+			continue
+		}
+		termination := plan.containers[originContainerStatus.Name]
+		finishedAt := metav1.NewTime(startedAt.Add(termination.duration))
+		containerStatuses[idx] = newTerminatedContainerStatus(&originContainerStatus, finishedAt, termination.killed)
+	}
+}
+
+// terminatedPodPhase returns Succeeded if all containers exited with 0, otherwise Failed
+func terminatedPodPhase(pod *coreapi.Pod) coreapi.PodPhase {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode != exitCodeCompleted {
+			return coreapi.PodFailed
 		}
-		containerStatuses[idx] = newTerminatedContainerStatus(&originContainerStatus, finishedAt)
 	}
+	return coreapi.PodSucceeded
 }
 
 func (m *PodStatusManager) setPodConditionStatuses(pod *coreapi.Pod, toReady bool) {
@@ -190,13 +384,26 @@ func (m *PodStatusManager) setPodConditionStatuses(pod *coreapi.Pod, toReady boo
 }
 
 //go:inline
-func newTerminatedContainerStatus(originStatus *coreapi.ContainerStatus, finishAt metav1.Time) coreapi.ContainerStatus {
+func newTerminatedContainerStatus(originStatus *coreapi.ContainerStatus, finishAt metav1.Time, killed bool) coreapi.ContainerStatus {
+	terminated := &coreapi.ContainerStateTerminated{ExitCode: exitCodeCompleted, Reason: "Completed", FinishedAt: finishAt}
+	if killed {
+		terminated.ExitCode = exitCodeKilled
+		terminated.Reason = "Error"
+	}
+	if originStatus.State.Running != nil {
+		terminated.StartedAt = originStatus.State.Running.StartedAt
+	}
+	var started = false
 	return coreapi.ContainerStatus{
-		Name:    originStatus.Name,
-		Ready:   false,
-		Started: nil,
-		Image:   originStatus.Image,
-		State:   coreapi.ContainerState{Running: nil, Waiting: nil, Terminated: &coreapi.ContainerStateTerminated{ExitCode: 0, FinishedAt: finishAt}},
+		Name:                 originStatus.Name,
+		Ready:                false,
+		Started:              &started,
+		Image:                originStatus.Image,
+		ImageID:              originStatus.ImageID,
+		ContainerID:          originStatus.ContainerID,
+		RestartCount:         originStatus.RestartCount,
+		LastTerminationState: originStatus.LastTerminationState,
+		State:                coreapi.ContainerState{Running: nil, Waiting: nil, Terminated: terminated},
 	}
 }
 
@@ -214,14 +421,38 @@ func newRunningContainerStatus(containerSpec *coreapi.Container) coreapi.Contain
 	}
 }
 
-// canBeDeleted [#TODO](should add some comments)
-func (m *PodStatusManager) canBeDeleted(pod *coreapi.Pod) bool {
+// keepContainerHistory copies the start time and restarts of the origin status of container into status
+func keepContainerHistory(status coreapi.ContainerStatus, origins map[string]coreapi.ContainerStatus) coreapi.ContainerStatus {
+	origin, ok := origins[status.Name]
+	if !ok {
+		return status
+	}
+	status.RestartCount = origin.RestartCount
+	status.LastTerminationState = origin.LastTerminationState
+	status.ImageID = origin.ImageID
+	status.ContainerID = origin.ContainerID
+	if origin.State.Running != nil {
+		status.State.Running.StartedAt = origin.State.Running.StartedAt
+	}
+	return status
+}
+
+// canBeDeleted returns true once all containers of pod have been reported as terminated
+func canBeDeleted(pod *coreapi.Pod) bool {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return pod.Status.Phase == coreapi.PodSucceeded || pod.Status.Phase == coreapi.PodFailed
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated == nil {
+			return false
+		}
+	}
 	return true
 }
 
 // updatePodStatus [#TODO](should add some comments)
 func (m *PodStatusManager) updatePodStatus(pod *coreapi.Pod) error {
-	_, err := m.clusterClient.CoreV1().Pods(pod.GetNamespace()).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+	_, err := m.nodeClients.ForNode(pod.Spec.NodeName).CoreV1().Pods(pod.GetNamespace()).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
 	return err
 }
 
@@ -231,5 +462,5 @@ func (m *PodStatusManager) deletePodImmediatly(pod *coreapi.Pod) error {
 	deleteOptions := metav1.DeleteOptions{
 		GracePeriodSeconds: &zero,
 	}
-	return m.clusterClient.CoreV1().Pods(pod.GetNamespace()).Delete(context.TODO(), pod.GetName(), deleteOptions)
+	return m.nodeClients.ForNode(pod.Spec.NodeName).CoreV1().Pods(pod.GetNamespace()).Delete(context.TODO(), pod.GetName(), deleteOptions)
 }
@@ -372,3 +372,30 @@ func TestPodStatusManager_Run_ContextCancellation(t *testing.T) {
 
 	// 如果运行到这里而没有超时，说明正确处理了上下文取消
 }
+
+func TestPodStatusManager_DualStack(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	testNode := helper.CreateTestNode("test-node", "10.10.10.1", "fd00:10:244:1::/64")
+	testNode.Spec.PodCIDRs = []string{"fd00:10:244:1::/64", "10.244.1.0/24"}
+	helper.AssertNoError(manager.OnNodeAdd(testNode), "OnNodeAdd should not return error")
+
+	testPod := helper.CreateTestPod("test-pod", "default", testNode.Name)
+	manager.assignPodIP(testPod)
+
+	// 每个地址族分配一个 IP，主地址族在前
+	if len(testPod.Status.PodIPs) != 2 {
+		t.Fatalf("Expected 2 pod IPs, got %v", testPod.Status.PodIPs)
+	}
+	if testPod.Status.PodIP != "fd00:10:244:1::2" || testPod.Status.PodIPs[1].IP != "10.244.1.2" {
+		t.Errorf("Expected pod IPs [fd00:10:244:1::2 10.244.1.2], got %v", testPod.Status.PodIPs)
+	}
+
+	manager.deSetNetwork(testPod)
+	for _, ipam := range manager.ipams[testNode.Name] {
+		if ipam.Used() != 0 {
+			t.Errorf("Expected IPs in %s to be released, got %d used", ipam.CIDR(), ipam.Used())
+		}
+	}
+}
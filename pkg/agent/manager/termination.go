@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+)
+
+// annotations on pods to emulate how long they take to shut down
+const (
+	// AnnotationShutdownDuration is how long containers take to exit after SIGTERM, e.g. "45s"
+	AnnotationShutdownDuration = "kube-simulator.io/shutdown-duration"
+	// AnnotationPreStopDuration overrides how long the preStop hooks of containers run, e.g. "10s"
+	AnnotationPreStopDuration = "kube-simulator.io/prestop-duration"
+)
+
+const (
+	DefaultTerminationGracePeriod = 30 * time.Second
+
+	exitCodeCompleted = 0
+	exitCodeKilled    = 137
+)
+
+// containerTermination represent how a container is stopped during pod termination
+type containerTermination struct {
+	preStop  time.Duration
+	duration time.Duration
+	killed   bool
+}
+
+// terminationPlan represent the simulated timeline of a pod termination
+type terminationPlan struct {
+	gracePeriod time.Duration
+	// duration is how long the pod stays terminating
+	duration   time.Duration
+	containers map[string]containerTermination
+}
+
+// newTerminationPlan computes how long every container takes to stop: the preStop hook runs first,
+// then the container shuts down, containers not stopped within the grace period are killed
+func newTerminationPlan(pod *coreapi.Pod) terminationPlan {
+	plan := terminationPlan{
+		gracePeriod: podTerminationGracePeriod(pod),
+		containers:  make(map[string]containerTermination),
+	}
+	shutdown := durationFromAnnotation(pod.Annotations, AnnotationShutdownDuration, 0)
+	for _, container := range pod.Spec.Containers {
+		preStop := durationFromAnnotation(pod.Annotations, AnnotationPreStopDuration, preStopHookDuration(&container))
+		termination := containerTermination{preStop: preStop, duration: preStop + shutdown}
+		if termination.duration > plan.gracePeriod {
+			termination.duration = plan.gracePeriod
+			termination.killed = true
+		}
+		if termination.duration > plan.duration {
+			plan.duration = termination.duration
+		}
+		plan.containers[container.Name] = termination
+	}
+	return plan
+}
+
+// podTerminationGracePeriod returns the grace period requested by the deletion, or the one in pod spec
+func podTerminationGracePeriod(pod *coreapi.Pod) time.Duration {
+	if pod.DeletionGracePeriodSeconds != nil {
+		return time.Duration(*pod.DeletionGracePeriodSeconds) * time.Second
+	}
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return DefaultTerminationGracePeriod
+}
+
+// preStopHookDuration simulates preStop hook of container, only sleep action and exec `sleep N` take time
+func preStopHookDuration(container *coreapi.Container) time.Duration {
+	if container.Lifecycle == nil || container.Lifecycle.PreStop == nil {
+		return 0
+	}
+	preStop := container.Lifecycle.PreStop
+	if preStop.Sleep != nil {
+		return time.Duration(preStop.Sleep.Seconds) * time.Second
+	}
+	if preStop.Exec != nil {
+		return sleepDurationOfCommand(preStop.Exec.Command)
+	}
+	return 0
+}
+
+// sleepDurationOfCommand finds `sleep N` in commands like ["sleep", "5"] or ["sh", "-c", "sleep 5"]
+func sleepDurationOfCommand(command []string) time.Duration {
+	fields := strings.Fields(strings.Join(command, " "))
+	for idx := 0; idx+1 < len(fields); idx++ {
+		if fields[idx] != "sleep" && !strings.HasSuffix(fields[idx], "/sleep") {
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(strings.Trim(fields[idx+1], `;&"'`), 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+func durationFromAnnotation(annotations map[string]string, key string, defaultValue time.Duration) time.Duration {
+	if value, ok := annotations[key]; ok {
+		if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+			return duration
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,178 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTerminationPlan(t *testing.T) {
+	grace := func(seconds int64) *int64 { return &seconds }
+
+	testCases := []struct {
+		name             string
+		gracePeriod      *int64
+		annotations      map[string]string
+		lifecycle        *coreapi.Lifecycle
+		expectedDuration time.Duration
+		expectKilled     bool
+	}{
+		{
+			name:             "Default exits immediately",
+			expectedDuration: 0,
+		},
+		{
+			name:             "Slow shutdown within grace period",
+			gracePeriod:      grace(30),
+			annotations:      map[string]string{AnnotationShutdownDuration: "10s"},
+			expectedDuration: 10 * time.Second,
+		},
+		{
+			name:             "Slow shutdown exceeds grace period",
+			gracePeriod:      grace(5),
+			annotations:      map[string]string{AnnotationShutdownDuration: "10s"},
+			expectedDuration: 5 * time.Second,
+			expectKilled:     true,
+		},
+		{
+			name:        "PreStop sleep action",
+			gracePeriod: grace(30),
+			lifecycle: &coreapi.Lifecycle{
+				PreStop: &coreapi.LifecycleHandler{Sleep: &coreapi.SleepAction{Seconds: 7}},
+			},
+			expectedDuration: 7 * time.Second,
+		},
+		{
+			name:        "PreStop exec sleep",
+			gracePeriod: grace(30),
+			annotations: map[string]string{AnnotationShutdownDuration: "1s"},
+			lifecycle: &coreapi.Lifecycle{
+				PreStop: &coreapi.LifecycleHandler{Exec: &coreapi.ExecAction{Command: []string{"/bin/sh", "-c", "sleep 15; nginx -s quit"}}},
+			},
+			expectedDuration: 16 * time.Second,
+		},
+		{
+			name:        "PreStop duration overridden by annotation",
+			gracePeriod: grace(30),
+			annotations: map[string]string{AnnotationPreStopDuration: "3s"},
+			lifecycle: &coreapi.Lifecycle{
+				PreStop: &coreapi.LifecycleHandler{Sleep: &coreapi.SleepAction{Seconds: 20}},
+			},
+			expectedDuration: 3 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := NewManagerTestHelper(t).CreateTestPod("test-pod", "default", "test-node")
+			pod.Annotations = tc.annotations
+			pod.Spec.TerminationGracePeriodSeconds = tc.gracePeriod
+			pod.Spec.Containers[0].Lifecycle = tc.lifecycle
+
+			plan := newTerminationPlan(pod)
+			if plan.duration != tc.expectedDuration {
+				t.Errorf("Expected termination duration %s, got %s", tc.expectedDuration, plan.duration)
+			}
+			if killed := plan.containers["test-container"].killed; killed != tc.expectKilled {
+				t.Errorf("Expected killed %v, got %v", tc.expectKilled, killed)
+			}
+		})
+	}
+}
+
+func TestPodTerminationGracePeriod(t *testing.T) {
+	pod := NewManagerTestHelper(t).CreateTestPod("test-pod", "default", "test-node")
+	if period := podTerminationGracePeriod(pod); period != DefaultTerminationGracePeriod {
+		t.Errorf("Expected default grace period %s, got %s", DefaultTerminationGracePeriod, period)
+	}
+
+	var specGrace, deletionGrace int64 = 60, 10
+	pod.Spec.TerminationGracePeriodSeconds = &specGrace
+	if period := podTerminationGracePeriod(pod); period != 60*time.Second {
+		t.Errorf("Expected grace period from spec, got %s", period)
+	}
+
+	// 删除请求中的宽限期优先
+	pod.DeletionGracePeriodSeconds = &deletionGrace
+	if period := podTerminationGracePeriod(pod); period != 10*time.Second {
+		t.Errorf("Expected grace period from deletion, got %s", period)
+	}
+}
+
+func TestPodStatusManager_GracefulTermination(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	testPod := helper.CreateTestPod("test-pod", "default", "test-node")
+	testPod.Annotations = map[string]string{AnnotationShutdownDuration: "200ms"}
+	manager.setContainersToReadyState(testPod)
+	manager.setPodConditionStatuses(testPod, true)
+	now := metav1.Now()
+	testPod.DeletionTimestamp = &now
+	_, err := helper.Client.CoreV1().Pods(testPod.Namespace).Create(context.TODO(), testPod, metav1.CreateOptions{})
+	helper.AssertNoError(err, "create pod should not return error")
+
+	helper.AssertNoError(manager.OnPodDelete(testPod), "OnPodDelete should not return error")
+
+	// 终止期间 Pod 变为未就绪，但不会立即停止
+	terminating, err := helper.Client.CoreV1().Pods(testPod.Namespace).Get(context.TODO(), testPod.Name, metav1.GetOptions{})
+	helper.AssertNoError(err, "get pod should not return error")
+	for _, condition := range terminating.Status.Conditions {
+		if condition.Type == coreapi.PodReady && condition.Status != coreapi.ConditionFalse {
+			t.Error("Terminating pod should not be ready")
+		}
+	}
+	select {
+	case <-manager.removedQueue:
+		t.Fatal("Pod should stay terminating during its shutdown duration")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 重复的删除事件不会重新开始终止
+	helper.AssertNoError(manager.OnPodDelete(testPod), "OnPodDelete should not return error")
+
+	var stopped *coreapi.Pod
+	select {
+	case stopped = <-manager.removedQueue:
+	case <-time.After(time.Second):
+		t.Fatal("Expected pod to be stopped after its shutdown duration")
+	}
+	select {
+	case <-manager.removedQueue:
+		t.Fatal("Pod should be stopped only once")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	manager.stopAllContainers(stopped)
+	_, err = helper.Client.CoreV1().Pods(testPod.Namespace).Get(context.TODO(), testPod.Name, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Expected pod to be deleted after termination, got %v", err)
+	}
+}
+
+func TestPodStatusManager_StopAllContainers_Killed(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+
+	manager := NewPodStatusManager(helper.Client)
+	var grace int64 = 1
+	testPod := helper.CreateTestPod("test-pod", "default", "test-node")
+	testPod.Annotations = map[string]string{AnnotationShutdownDuration: "1m"}
+	testPod.Spec.TerminationGracePeriodSeconds = &grace
+	manager.setContainersToReadyState(testPod)
+
+	manager.setAllContainersTerminated(testPod)
+	terminated := testPod.Status.ContainerStatuses[0].State.Terminated
+	if terminated == nil || terminated.ExitCode != exitCodeKilled {
+		t.Fatalf("Expected container to be killed, got %+v", terminated)
+	}
+	if phase := terminatedPodPhase(testPod); phase != coreapi.PodFailed {
+		t.Errorf("Expected pod phase %s, got %s", coreapi.PodFailed, phase)
+	}
+	if !canBeDeleted(testPod) {
+		t.Error("Pod should be deletable once all containers are terminated")
+	}
+}
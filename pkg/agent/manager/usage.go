@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"strconv"
+
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+)
+
+// annotations on pods to simulate their resource usage, if absent the usage equals to requests
+const (
+	AnnotationMemoryUsage           = "kube-simulator.io/memory-usage"
+	AnnotationEphemeralStorageUsage = "kube-simulator.io/ephemeral-storage-usage"
+	AnnotationPIDUsage              = "kube-simulator.io/pid-usage"
+	// AnnotationPIDCapacity on node overrides the default number of pids a simulated node has
+	AnnotationPIDCapacity = "kube-simulator.io/pid-capacity"
+)
+
+const (
+	// ResourcePIDs is the pseudo resource used to account process ids of pods
+	ResourcePIDs coreapi.ResourceName = "pids"
+
+	DefaultNodePIDCapacity = 32768
+	defaultContainerPIDs   = 8
+)
+
+// podUsage returns the simulated usage of memory, ephemeral-storage and pids of pod
+func podUsage(pod *coreapi.Pod) coreapi.ResourceList {
+	requests := resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{})
+	usage := coreapi.ResourceList{
+		coreapi.ResourceMemory:           usageFromAnnotation(pod.Annotations, AnnotationMemoryUsage, requests[coreapi.ResourceMemory]),
+		coreapi.ResourceEphemeralStorage: usageFromAnnotation(pod.Annotations, AnnotationEphemeralStorageUsage, requests[coreapi.ResourceEphemeralStorage]),
+		ResourcePIDs:                     usageFromAnnotation(pod.Annotations, AnnotationPIDUsage, *resource.NewQuantity(int64(defaultContainerPIDs*len(pod.Spec.Containers)), resource.DecimalSI)),
+	}
+	return usage
+}
+
+// nodeCapacity returns capacity of node including the simulated pids capacity
+func nodeCapacity(node *coreapi.Node) coreapi.ResourceList {
+	capacity := coreapi.ResourceList{}
+	for _, name := range []coreapi.ResourceName{coreapi.ResourceMemory, coreapi.ResourceEphemeralStorage} {
+		if quantity, ok := node.Status.Capacity[name]; ok {
+			capacity[name] = quantity.DeepCopy()
+		}
+	}
+	pids := int64(DefaultNodePIDCapacity)
+	if value, err := strconv.ParseInt(node.Annotations[AnnotationPIDCapacity], 10, 64); err == nil && value > 0 {
+		pids = value
+	}
+	capacity[ResourcePIDs] = *resource.NewQuantity(pids, resource.DecimalSI)
+	return capacity
+}
+
+func usageFromAnnotation(annotations map[string]string, key string, defaultValue resource.Quantity) resource.Quantity {
+	if value, ok := annotations[key]; ok {
+		if quantity, err := resource.ParseQuantity(value); err == nil {
+			return quantity
+		}
+	}
+	return defaultValue.DeepCopy()
+}
@@ -0,0 +1,265 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// EventReasonFailedMount is reported on pods waiting for their volumes, the same as kubelet
+const EventReasonFailedMount = "FailedMount"
+
+// VolumeConfig represent how volumes of pods are mounted
+type VolumeConfig struct {
+	// MountDelay is how long pods wait for their volumes to be mounted after they are attached
+	MountDelay time.Duration
+}
+
+// podVolumes represent volumes of a pod attached to its node
+type podVolumes struct {
+	nodeName   string
+	volumes    []coreapi.UniqueVolumeName
+	attachedAt time.Time
+	mounted    bool
+}
+
+// VolumeManager simulates attach and mount of persistent volumes like kubelet does. pods wait until their
+// claims are bound and the csi volumes are attached to the node, which are reported in the node status
+type VolumeManager struct {
+	sync.Mutex
+	clusterClient kubeclientset.Interface
+	// nodeClients report volumes in use in status of nodes
+	nodeClients NodeClients
+	recorder    record.EventRecorder
+	config      VolumeConfig
+	// attached are csi volumes attached to nodes and the pods using them
+	attached map[string]map[coreapi.UniqueVolumeName]sets.Set[types.UID]
+	pods     map[types.UID]*podVolumes
+	// waiting are pods that FailedMount has been reported for
+	waiting sets.Set[types.UID]
+}
+
+func NewVolumeManager(client kubeclientset.Interface, recorder record.EventRecorder, config VolumeConfig) *VolumeManager {
+	return &VolumeManager{
+		clusterClient: client,
+		nodeClients:   NewSharedNodeClients(client),
+		recorder:      recorder,
+		config:        config,
+		attached:      make(map[string]map[coreapi.UniqueVolumeName]sets.Set[types.UID]),
+		pods:          make(map[types.UID]*podVolumes),
+		waiting:       sets.New[types.UID](),
+	}
+}
+
+// UseNodeClients makes nodes write their own objects with clients from clients
+func (m *VolumeManager) UseNodeClients(clients NodeClients) {
+	m.nodeClients = clients
+}
+
+// MountVolumes attaches volumes of pod to its node, it returns true once all of them are mounted and the
+// containers of pod can be started. pods waiting for volumes are checked again on the next resync
+func (m *VolumeManager) MountVolumes(pod *coreapi.Pod) bool {
+	if len(podClaimNames(pod)) == 0 {
+		return true
+	}
+	m.Lock()
+	state, ok := m.pods[pod.UID]
+	m.Unlock()
+	if ok && state.mounted {
+		return true
+	}
+
+	volumes, err := m.attachableVolumes(pod)
+	if err != nil {
+		m.reportWaiting(pod, err.Error())
+		return false
+	}
+	m.Lock()
+	if state, ok = m.pods[pod.UID]; !ok {
+		state = &podVolumes{nodeName: pod.Spec.NodeName, attachedAt: time.Now()}
+		m.pods[pod.UID] = state
+	}
+	state.volumes = volumes
+	changed := m.attach(pod.Spec.NodeName, pod.UID, volumes)
+	// pods already running before simulator restarted don't wait again
+	state.mounted = pod.Status.Phase == coreapi.PodRunning || time.Since(state.attachedAt) >= m.config.MountDelay
+	mounted := state.mounted
+	m.Unlock()
+
+	if changed {
+		if err := m.updateNodeStatus(pod.Spec.NodeName); err != nil {
+			loggerForPodManager.WithError(err).Warnf("failed report volumes of node %s", pod.Spec.NodeName)
+		}
+	}
+	if !mounted {
+		m.reportWaiting(pod, "volumes are being mounted")
+		return false
+	}
+	m.Lock()
+	m.waiting.Delete(pod.UID)
+	m.Unlock()
+	return true
+}
+
+// UnmountVolumes unmounts volumes of pod, volumes no other pod on the node uses are detached
+func (m *VolumeManager) UnmountVolumes(pod *coreapi.Pod) {
+	m.Lock()
+	state, ok := m.pods[pod.UID]
+	delete(m.pods, pod.UID)
+	m.waiting.Delete(pod.UID)
+	changed := false
+	if ok {
+		nodeVolumes := m.attached[state.nodeName]
+		for _, volume := range state.volumes {
+			if users, ok := nodeVolumes[volume]; ok {
+				users.Delete(pod.UID)
+				if users.Len() == 0 {
+					delete(nodeVolumes, volume)
+					changed = true
+				}
+			}
+		}
+	}
+	m.Unlock()
+	if changed {
+		if err := m.updateNodeStatus(state.nodeName); err != nil {
+			loggerForPodManager.WithError(err).Warnf("failed report volumes of node %s", state.nodeName)
+		}
+	}
+}
+
+// attach records volumes as used by pod on node, it returns true if any volume is newly attached to node
+func (m *VolumeManager) attach(nodeName string, uid types.UID, volumes []coreapi.UniqueVolumeName) bool {
+	nodeVolumes, ok := m.attached[nodeName]
+	if !ok {
+		nodeVolumes = make(map[coreapi.UniqueVolumeName]sets.Set[types.UID])
+		m.attached[nodeName] = nodeVolumes
+	}
+	changed := false
+	for _, volume := range volumes {
+		if _, ok := nodeVolumes[volume]; !ok {
+			nodeVolumes[volume] = sets.New[types.UID]()
+			changed = true
+		}
+		nodeVolumes[volume].Insert(uid)
+	}
+	return changed
+}
+
+// attachableVolumes returns unique names of csi volumes of pod, it fails if any claim of pod isn't bound yet
+func (m *VolumeManager) attachableVolumes(pod *coreapi.Pod) ([]coreapi.UniqueVolumeName, error) {
+	var volumes []coreapi.UniqueVolumeName
+	for _, claimName := range podClaimNames(pod) {
+		claim, err := m.clusterClient.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(context.TODO(), claimName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, errors.Errorf("persistentvolumeclaim %q not found", claimName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if claim.Status.Phase != coreapi.ClaimBound || claim.Spec.VolumeName == "" {
+			return nil, errors.Errorf("persistentvolumeclaim %q is not bound", claimName)
+		}
+		volume, err := m.clusterClient.CoreV1().PersistentVolumes().Get(context.TODO(), claim.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "get volume of persistentvolumeclaim %q", claimName)
+		}
+		// only csi volumes are attached, others like hostPath are mounted directly
+		if volume.Spec.CSI != nil {
+			volumes = append(volumes, csiVolumeName(volume.Spec.CSI))
+		}
+	}
+	return volumes, nil
+}
+
+// reportWaiting reports FailedMount once for pod until its volumes are mounted
+func (m *VolumeManager) reportWaiting(pod *coreapi.Pod, message string) {
+	m.Lock()
+	reported := m.waiting.Has(pod.UID)
+	m.waiting.Insert(pod.UID)
+	m.Unlock()
+	if reported || m.recorder == nil {
+		return
+	}
+	m.recorder.Eventf(pod, coreapi.EventTypeWarning, EventReasonFailedMount, "Unable to attach or mount volumes: %s", message)
+}
+
+// updateNodeStatus reports volumes attached to node, all of them are in use since they are attached for pods
+// nodes aren't annotated as controller-managed-attach-detach, so the attach-detach controller leaves both to us
+func (m *VolumeManager) updateNodeStatus(nodeName string) error {
+	m.Lock()
+	names := make([]string, 0, len(m.attached[nodeName]))
+	for volume := range m.attached[nodeName] {
+		names = append(names, string(volume))
+	}
+	m.Unlock()
+	sort.Strings(names)
+	attached := make([]coreapi.AttachedVolume, 0, len(names))
+	inUse := make([]coreapi.UniqueVolumeName, 0, len(names))
+	for _, name := range names {
+		attached = append(attached, coreapi.AttachedVolume{Name: coreapi.UniqueVolumeName(name)})
+		inUse = append(inUse, coreapi.UniqueVolumeName(name))
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := m.clusterClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if volumesEqual(node.Status.VolumesInUse, inUse) && len(node.Status.VolumesAttached) == len(attached) {
+			return nil
+		}
+		node.Status.VolumesAttached = attached
+		node.Status.VolumesInUse = inUse
+		_, err = m.nodeClients.ForNode(nodeName).CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// podClaimNames returns names of claims used by pod, including claims of generic ephemeral volumes
+func podClaimNames(pod *coreapi.Pod) []string {
+	var names []string
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			names = append(names, volume.PersistentVolumeClaim.ClaimName)
+		case volume.Ephemeral != nil:
+			names = append(names, pod.Name+"-"+volume.Name)
+		}
+	}
+	return names
+}
+
+// csiVolumeName returns the unique name of a csi volume, the same as kubelet
+func csiVolumeName(source *coreapi.CSIPersistentVolumeSource) coreapi.UniqueVolumeName {
+	return coreapi.UniqueVolumeName(fmt.Sprintf("kubernetes.io/csi/%s^%s", source.Driver, source.VolumeHandle))
+}
+
+func volumesEqual(current, desired []coreapi.UniqueVolumeName) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	sorted := make([]string, 0, len(current))
+	for _, volume := range current {
+		sorted = append(sorted, string(volume))
+	}
+	sort.Strings(sorted)
+	for idx := range sorted {
+		if sorted[idx] != string(desired[idx]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const testDriver = "csi.kube-simulator.io"
+
+// createTestClaim 创建 claim, volumeName 不为空时同时创建已绑定的 csi PV
+func createTestClaim(t *testing.T, helper *ManagerTestHelper, name, volumeName string) {
+	t.Helper()
+	ctx := context.Background()
+	claim := &coreapi.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Status:     coreapi.PersistentVolumeClaimStatus{Phase: coreapi.ClaimPending},
+	}
+	if volumeName != "" {
+		claim.Spec.VolumeName = volumeName
+		claim.Status.Phase = coreapi.ClaimBound
+		volume := &coreapi.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: volumeName},
+			Spec: coreapi.PersistentVolumeSpec{PersistentVolumeSource: coreapi.PersistentVolumeSource{
+				CSI: &coreapi.CSIPersistentVolumeSource{Driver: testDriver, VolumeHandle: volumeName + "-handle"},
+			}},
+		}
+		if _, err := helper.Client.CoreV1().PersistentVolumes().Create(ctx, volume, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("create volume failed: %v", err)
+		}
+	}
+	if _, err := helper.Client.CoreV1().PersistentVolumeClaims("default").Create(ctx, claim, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create claim failed: %v", err)
+	}
+}
+
+func createTestVolumePod(helper *ManagerTestHelper, name string, claims ...string) *coreapi.Pod {
+	pod := helper.CreateTestPod(name, "default", "test-node")
+	for _, claim := range claims {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, coreapi.Volume{
+			Name:         claim,
+			VolumeSource: coreapi.VolumeSource{PersistentVolumeClaim: &coreapi.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+		})
+	}
+	return pod
+}
+
+func getNodeVolumes(t *testing.T, helper *ManagerTestHelper) ([]coreapi.AttachedVolume, []coreapi.UniqueVolumeName) {
+	t.Helper()
+	node, err := helper.Client.CoreV1().Nodes().Get(context.Background(), "test-node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get node failed: %v", err)
+	}
+	return node.Status.VolumesAttached, node.Status.VolumesInUse
+}
+
+func TestVolumeManager_MountVolumes(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	helper.Client.CoreV1().Nodes().Create(context.Background(), helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24"), metav1.CreateOptions{})
+	createTestClaim(t, helper, "pending", "")
+	createTestClaim(t, helper, "data", "pv-data")
+	recorder := record.NewFakeRecorder(10)
+	manager := NewVolumeManager(helper.Client, recorder, VolumeConfig{})
+
+	// 没有 PVC 的 Pod 直接启动
+	if !manager.MountVolumes(helper.CreateTestPod("plain", "default", "test-node")) {
+		t.Error("Expected pod without claims to be mounted")
+	}
+
+	// claim 未绑定时 Pod 等待, 且只上报一次 FailedMount
+	waiting := createTestVolumePod(helper, "waiting", "pending")
+	if manager.MountVolumes(waiting) || manager.MountVolumes(waiting) {
+		t.Error("Expected pod with unbound claim to wait")
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected 1 FailedMount event, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, EventReasonFailedMount) || !strings.Contains(event, `"pending" is not bound`) {
+		t.Errorf("Unexpected event %s", event)
+	}
+
+	first := createTestVolumePod(helper, "first", "data")
+	second := createTestVolumePod(helper, "second", "data")
+	if !manager.MountVolumes(first) || !manager.MountVolumes(second) {
+		t.Fatal("Expected pods with bound claim to be mounted")
+	}
+	attached, inUse := getNodeVolumes(t, helper)
+	expected := coreapi.UniqueVolumeName("kubernetes.io/csi/" + testDriver + "^pv-data-handle")
+	if len(attached) != 1 || attached[0].Name != expected || len(inUse) != 1 || inUse[0] != expected {
+		t.Errorf("Expected volume %s attached and in use, got %v %v", expected, attached, inUse)
+	}
+
+	// 仍有 Pod 使用时不会 detach
+	manager.UnmountVolumes(first)
+	if attached, _ := getNodeVolumes(t, helper); len(attached) != 1 {
+		t.Errorf("Expected volume to stay attached while second pod uses it, got %v", attached)
+	}
+	manager.UnmountVolumes(second)
+	if attached, inUse := getNodeVolumes(t, helper); len(attached) != 0 || len(inUse) != 0 {
+		t.Errorf("Expected volume to be detached, got %v %v", attached, inUse)
+	}
+}
+
+func TestVolumeManager_MountDelay(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	helper.Client.CoreV1().Nodes().Create(context.Background(), helper.CreateTestNode("test-node", "10.10.10.1", "10.244.1.0/24"), metav1.CreateOptions{})
+	createTestClaim(t, helper, "data", "pv-data")
+	manager := NewVolumeManager(helper.Client, nil, VolumeConfig{MountDelay: 50 * time.Millisecond})
+
+	pod := createTestVolumePod(helper, "app", "data")
+	if manager.MountVolumes(pod) {
+		t.Error("Expected pod to wait for mount delay")
+	}
+	// 卷已经 attach, 但尚未 mount
+	if attached, _ := getNodeVolumes(t, helper); len(attached) != 1 {
+		t.Errorf("Expected volume to be attached before mounted, got %v", attached)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !manager.MountVolumes(pod) {
+		t.Error("Expected pod to be mounted after mount delay")
+	}
+
+	// simulator 重启前已运行的 Pod 不再等待
+	running := createTestVolumePod(helper, "running", "data")
+	running.Status.Phase = coreapi.PodRunning
+	if !manager.MountVolumes(running) {
+		t.Error("Expected running pod not to wait for mount delay")
+	}
+}
+
+func TestPodClaimNames(t *testing.T) {
+	helper := NewManagerTestHelper(t)
+	pod := createTestVolumePod(helper, "app", "data")
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		coreapi.Volume{Name: "scratch", VolumeSource: coreapi.VolumeSource{Ephemeral: &coreapi.EphemeralVolumeSource{}}},
+		coreapi.Volume{Name: "config", VolumeSource: coreapi.VolumeSource{ConfigMap: &coreapi.ConfigMapVolumeSource{}}},
+	)
+	names := podClaimNames(pod)
+	if len(names) != 2 || names[0] != "data" || names[1] != "app-scratch" {
+		t.Errorf("Unexpected claim names %v", names)
+	}
+}
@@ -0,0 +1,228 @@
+package netpol
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	netlisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var loggerForNetPol = logrus.WithField("component", "network-policy")
+
+// Request represent a connection to check, From and To are either namespace/name of pods or ips
+type Request struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Port is the number or the name of the port of destination, named ports are resolved by containers of destination
+	Port     string           `json:"port"`
+	Protocol coreapi.Protocol `json:"protocol,omitempty"`
+}
+
+// Result represent whether the connection is allowed, it's allowed only if both egress of source and ingress of
+// destination allow it
+type Result struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Port     int32            `json:"port"`
+	Protocol coreapi.Protocol `json:"protocol"`
+	Allowed  bool             `json:"allowed"`
+	Egress   Verdict          `json:"egress"`
+	Ingress  Verdict          `json:"ingress"`
+}
+
+// Matrix represent connectivity between all pods of namespaces, Allowed[i][j] tells whether Pods[i] can connect to Pods[j].
+// a pod without the named port is reported as not allowed
+type Matrix struct {
+	Port     string           `json:"port"`
+	Protocol coreapi.Protocol `json:"protocol"`
+	Pods     []string         `json:"pods"`
+	Allowed  [][]bool         `json:"allowed"`
+}
+
+// Engine evaluates network policies against pods and namespaces of cluster
+type Engine struct {
+	factory    informers.SharedInformerFactory
+	pods       corelisters.PodLister
+	namespaces corelisters.NamespaceLister
+	policies   netlisters.NetworkPolicyLister
+}
+
+func NewEngine(client kubeclientset.Interface) *Engine {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	return &Engine{
+		factory:    factory,
+		pods:       factory.Core().V1().Pods().Lister(),
+		namespaces: factory.Core().V1().Namespaces().Lister(),
+		policies:   factory.Networking().V1().NetworkPolicies().Lister(),
+	}
+}
+
+// Run starts watching pods, namespaces and policies, it returns once caches are synced
+func (e *Engine) Run(ctx context.Context) error {
+	e.factory.Start(ctx.Done())
+	for informerType, synced := range e.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("sync cache of %v failed", informerType)
+		}
+	}
+	return nil
+}
+
+// Check decides whether the connection of request is allowed by policies of cluster
+func (e *Engine) Check(request Request) (*Result, error) {
+	protocol := request.Protocol
+	if protocol == "" {
+		protocol = coreapi.ProtocolTCP
+	}
+	src, err := e.peerOf(request.From)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := e.peerOf(request.To)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := ContainerPort(dst.Pod, request.Port, protocol)
+	if !ok {
+		return nil, errors.Errorf("port %s/%s is not found in %s", request.Port, protocol, dst.Name())
+	}
+	evaluator, err := e.evaluator()
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		From:     src.Name(),
+		To:       dst.Name(),
+		Port:     port,
+		Protocol: protocol,
+		Egress:   evaluator.Egress(src, dst, port, protocol),
+		Ingress:  evaluator.Ingress(src, dst, port, protocol),
+	}
+	result.Allowed = result.Egress.Allowed && result.Ingress.Allowed
+	return result, nil
+}
+
+// Matrix checks connections between every pair of pods in namespaces, all namespaces are used if it's empty
+func (e *Engine) Matrix(namespaces []string, port string, protocol coreapi.Protocol) (*Matrix, error) {
+	if protocol == "" {
+		protocol = coreapi.ProtocolTCP
+	}
+	pods, err := e.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, namespace := range namespaces {
+		wanted[namespace] = true
+	}
+	var peers []Peer
+	for _, pod := range pods {
+		if len(wanted) != 0 && !wanted[pod.Namespace] {
+			continue
+		}
+		if pod.Status.Phase == coreapi.PodSucceeded || pod.Status.Phase == coreapi.PodFailed {
+			continue
+		}
+		peers = append(peers, e.peerOfPod(pod))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name() < peers[j].Name() })
+
+	evaluator, err := e.evaluator()
+	if err != nil {
+		return nil, err
+	}
+	matrix := &Matrix{Port: port, Protocol: protocol, Allowed: make([][]bool, len(peers))}
+	for i, src := range peers {
+		matrix.Pods = append(matrix.Pods, src.Name())
+		matrix.Allowed[i] = make([]bool, len(peers))
+		for j, dst := range peers {
+			number, ok := ContainerPort(dst.Pod, port, protocol)
+			if !ok {
+				continue
+			}
+			matrix.Allowed[i][j] = evaluator.Egress(src, dst, number, protocol).Allowed && evaluator.Ingress(src, dst, number, protocol).Allowed
+		}
+	}
+	return matrix, nil
+}
+
+func (e *Engine) evaluator() (*Evaluator, error) {
+	policies, err := e.policies.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	return NewEvaluator(policies), nil
+}
+
+// peerOf returns the pod of namespace/name, or the ip out of cluster
+func (e *Engine) peerOf(name string) (Peer, error) {
+	if addr, err := netip.ParseAddr(name); err == nil {
+		return Peer{IPs: []netip.Addr{addr}}, nil
+	}
+	if name == "" {
+		return Peer{}, errors.New("namespace/name of pod or ip is required")
+	}
+	namespace, podName, err := cache.SplitMetaNamespaceKey(name)
+	if err != nil {
+		return Peer{}, err
+	}
+	if namespace == "" {
+		namespace = coreapi.NamespaceDefault
+	}
+	pod, err := e.pods.Pods(namespace).Get(podName)
+	if err != nil {
+		return Peer{}, errors.Wrapf(err, "get pod %s failed", name)
+	}
+	return e.peerOfPod(pod), nil
+}
+
+func (e *Engine) peerOfPod(pod *coreapi.Pod) Peer {
+	peer := Peer{Pod: pod, IPs: podIPs(pod)}
+	if namespace, err := e.namespaces.Get(pod.Namespace); err == nil {
+		peer.Namespace = namespace
+	} else {
+		// namespaceSelector matches namespaces by their labels, the name label is always set by apiserver
+		peer.Namespace = &coreapi.Namespace{}
+		peer.Namespace.Name = pod.Namespace
+		peer.Namespace.Labels = map[string]string{coreapi.LabelMetadataName: pod.Namespace}
+	}
+	return peer
+}
+
+func podIPs(pod *coreapi.Pod) []netip.Addr {
+	var ips []netip.Addr
+	for _, podIP := range pod.Status.PodIPs {
+		if addr, err := netip.ParseAddr(podIP.IP); err == nil {
+			ips = append(ips, addr)
+		}
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		if addr, err := netip.ParseAddr(pod.Status.PodIP); err == nil {
+			ips = append(ips, addr)
+		}
+	}
+	return ips
+}
+
+// ParseProtocol parses protocol case-insensitively, TCP is returned if it's empty
+func ParseProtocol(protocol string) (coreapi.Protocol, error) {
+	switch strings.ToUpper(protocol) {
+	case "", string(coreapi.ProtocolTCP):
+		return coreapi.ProtocolTCP, nil
+	case string(coreapi.ProtocolUDP):
+		return coreapi.ProtocolUDP, nil
+	case string(coreapi.ProtocolSCTP):
+		return coreapi.ProtocolSCTP, nil
+	}
+	return "", errors.Errorf("protocol %s is not supported", protocol)
+}
@@ -0,0 +1,141 @@
+package netpol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	netapi "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestEngine(t *testing.T, objects ...runtime.Object) *Engine {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	engine := NewEngine(fake.NewSimpleClientset(objects...))
+	if err := engine.Run(ctx); err != nil {
+		t.Fatalf("Run should not return error: %v", err)
+	}
+	return engine
+}
+
+func newTestObjects() []runtime.Object {
+	client := newTestPeer("frontend", "client", "10.244.1.2", map[string]string{"app": "client"}, map[string]string{"team": "web"})
+	server := newTestPeer("backend", "server", "10.244.2.2", map[string]string{"app": "server"}, map[string]string{"team": "api"})
+	policy := newTestPolicy("backend", "allow-web", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+		Ingress: []netapi.NetworkPolicyIngressRule{{
+			From:  []netapi.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}}}},
+			Ports: portOf(intstr.FromString("http")),
+		}},
+	})
+	return []runtime.Object{client.Pod, client.Namespace, server.Pod, server.Namespace, policy}
+}
+
+func TestEngine_Check(t *testing.T) {
+	engine := newTestEngine(t, newTestObjects()...)
+
+	result, err := engine.Check(Request{From: "frontend/client", To: "backend/server", Port: "http"})
+	if err != nil {
+		t.Fatalf("Check should not return error: %v", err)
+	}
+	if !result.Allowed || result.Port != 8080 || result.Protocol != coreapi.ProtocolTCP {
+		t.Errorf("Expected connection to be allowed, got %+v", result)
+	}
+
+	// 反方向不受策略限制
+	result, _ = engine.Check(Request{From: "backend/server", To: "frontend/client", Port: "8080"})
+	if !result.Allowed {
+		t.Errorf("Expected connection to client to be allowed, got %+v", result)
+	}
+
+	// 集群外的 IP 被拒绝
+	result, _ = engine.Check(Request{From: "1.2.3.4", To: "backend/server", Port: "8080"})
+	if result.Allowed || !result.Ingress.Isolated {
+		t.Errorf("Expected connection from ip out of cluster to be denied, got %+v", result)
+	}
+
+	for _, request := range []Request{
+		{From: "frontend/missing", To: "backend/server", Port: "8080"},
+		{From: "frontend/client", To: "backend/server", Port: "grpc"},
+		{From: "", To: "backend/server", Port: "8080"},
+	} {
+		if _, err := engine.Check(request); err == nil {
+			t.Errorf("Expected error for request %+v", request)
+		}
+	}
+}
+
+func TestEngine_Matrix(t *testing.T) {
+	engine := newTestEngine(t, newTestObjects()...)
+
+	matrix, err := engine.Matrix(nil, "http", coreapi.ProtocolTCP)
+	if err != nil {
+		t.Fatalf("Matrix should not return error: %v", err)
+	}
+	// Pods 按 namespace/name 排序: backend/server, frontend/client
+	if len(matrix.Pods) != 2 || matrix.Pods[0] != "backend/server" {
+		t.Fatalf("Unexpected pods %v", matrix.Pods)
+	}
+	expected := [][]bool{{false, true}, {true, true}}
+	for i := range expected {
+		for j := range expected[i] {
+			if matrix.Allowed[i][j] != expected[i][j] {
+				t.Errorf("Expected %s -> %s allowed=%v", matrix.Pods[i], matrix.Pods[j], expected[i][j])
+			}
+		}
+	}
+
+	matrix, _ = engine.Matrix([]string{"frontend"}, "http", coreapi.ProtocolTCP)
+	if len(matrix.Pods) != 1 {
+		t.Errorf("Expected only pods of frontend, got %v", matrix.Pods)
+	}
+}
+
+func TestEngine_Handlers(t *testing.T) {
+	engine := newTestEngine(t, newTestObjects()...)
+	mux := http.NewServeMux()
+	mux.Handle(CheckPath, engine.CheckHandler())
+	mux.Handle(MatrixPath, engine.MatrixHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	request := Request{From: "frontend/client", To: "backend/server", Port: "http"}
+	resp, err := http.Get(server.URL + CheckPath + "?" + request.Query().Encode())
+	if err != nil {
+		t.Fatalf("Request should not return error: %v", err)
+	}
+	var result Result
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !result.Allowed {
+		t.Errorf("Unexpected response %d %+v", resp.StatusCode, result)
+	}
+
+	resp, err = http.Get(server.URL + MatrixPath + "?port=8080&protocol=udp&namespaces=backend")
+	if err != nil {
+		t.Fatalf("Request should not return error: %v", err)
+	}
+	var matrix Matrix
+	json.NewDecoder(resp.Body).Decode(&matrix)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || matrix.Protocol != coreapi.ProtocolUDP || len(matrix.Pods) != 1 {
+		t.Errorf("Unexpected response %d %+v", resp.StatusCode, matrix)
+	}
+
+	resp, err = http.Get(server.URL + CheckPath + "?from=frontend/client&to=backend/server&port=80&protocol=icmp")
+	if err != nil {
+		t.Fatalf("Request should not return error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected bad request for unsupported protocol, got %d", resp.StatusCode)
+	}
+}
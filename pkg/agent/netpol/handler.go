@@ -0,0 +1,76 @@
+package netpol
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+)
+
+// paths of the simulator api to evaluate network policies
+const (
+	CheckPath  = "/netpol/check"
+	MatrixPath = "/netpol/matrix"
+)
+
+// CheckHandler checks the connection described by query parameters, e.g.
+// /netpol/check?from=default/client&to=default/nginx&port=http&protocol=TCP
+func (e *Engine) CheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		protocol, err := ParseProtocol(query.Get("protocol"))
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		result, err := e.Check(Request{From: query.Get("from"), To: query.Get("to"), Port: query.Get("port"), Protocol: protocol})
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		simapi.WriteJSON(w, http.StatusOK, result)
+	})
+}
+
+// MatrixHandler reports connectivity between pods of namespaces, e.g. /netpol/matrix?namespaces=a,b&port=80
+func (e *Engine) MatrixHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		protocol, err := ParseProtocol(query.Get("protocol"))
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var namespaces []string
+		if value := query.Get("namespaces"); value != "" {
+			namespaces = strings.Split(value, ",")
+		}
+		matrix, err := e.Matrix(namespaces, query.Get("port"), protocol)
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		simapi.WriteJSON(w, http.StatusOK, matrix)
+	})
+}
+
+// Query returns the query parameters of request for CheckPath
+func (r Request) Query() url.Values {
+	query := url.Values{}
+	query.Set("from", r.From)
+	query.Set("to", r.To)
+	query.Set("port", r.Port)
+	if r.Protocol != "" {
+		query.Set("protocol", string(r.Protocol))
+	}
+	return query
+}
@@ -0,0 +1,258 @@
+package netpol
+
+import (
+	"net/netip"
+	"sort"
+	"strconv"
+
+	coreapi "k8s.io/api/core/v1"
+	netapi "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Peer represent one side of a connection, either a pod or an ip out of cluster
+type Peer struct {
+	Pod       *coreapi.Pod
+	Namespace *coreapi.Namespace
+	// IPs are ips of pod, or the ip if peer isn't a pod
+	IPs []netip.Addr
+}
+
+// Name returns namespace/name of pod, or the ip of peer
+func (p Peer) Name() string {
+	if p.Pod != nil {
+		return p.Pod.Namespace + "/" + p.Pod.Name
+	}
+	if len(p.IPs) != 0 {
+		return p.IPs[0].String()
+	}
+	return ""
+}
+
+// Verdict represent the decision of one direction, a pod is isolated if any policy selects it for the direction,
+// and then only traffic allowed by the policies is accepted
+type Verdict struct {
+	Isolated bool `json:"isolated"`
+	Allowed  bool `json:"allowed"`
+	// Policies are the policies selecting the pod, AllowedBy are those of them allowing the traffic
+	Policies  []string `json:"policies,omitempty"`
+	AllowedBy []string `json:"allowedBy,omitempty"`
+}
+
+// Evaluator decides whether connections are allowed by a set of policies, it doesn't depend on a cluster so
+// that policies can be evaluated against any set of pods and namespaces
+type Evaluator struct {
+	policies []*netapi.NetworkPolicy
+}
+
+func NewEvaluator(policies []*netapi.NetworkPolicy) *Evaluator {
+	sorted := append([]*netapi.NetworkPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return &Evaluator{policies: sorted}
+}
+
+// Egress decides whether src can send traffic to port of dst, ips out of cluster are never isolated
+func (e *Evaluator) Egress(src, dst Peer, port int32, protocol coreapi.Protocol) Verdict {
+	verdict := Verdict{Allowed: true}
+	if src.Pod == nil {
+		return verdict
+	}
+	for _, policy := range e.selecting(src.Pod, netapi.PolicyTypeEgress) {
+		verdict.Isolated = true
+		verdict.Policies = append(verdict.Policies, policyName(policy))
+		for _, rule := range policy.Spec.Egress {
+			if peersMatch(policy.Namespace, rule.To, dst) && portsMatch(rule.Ports, dst, port, protocol) {
+				verdict.AllowedBy = append(verdict.AllowedBy, policyName(policy))
+				break
+			}
+		}
+	}
+	verdict.Allowed = !verdict.Isolated || len(verdict.AllowedBy) != 0
+	return verdict
+}
+
+// Ingress decides whether port of dst accepts traffic from src, ips out of cluster are never isolated
+func (e *Evaluator) Ingress(src, dst Peer, port int32, protocol coreapi.Protocol) Verdict {
+	verdict := Verdict{Allowed: true}
+	if dst.Pod == nil {
+		return verdict
+	}
+	for _, policy := range e.selecting(dst.Pod, netapi.PolicyTypeIngress) {
+		verdict.Isolated = true
+		verdict.Policies = append(verdict.Policies, policyName(policy))
+		for _, rule := range policy.Spec.Ingress {
+			if peersMatch(policy.Namespace, rule.From, src) && portsMatch(rule.Ports, dst, port, protocol) {
+				verdict.AllowedBy = append(verdict.AllowedBy, policyName(policy))
+				break
+			}
+		}
+	}
+	verdict.Allowed = !verdict.Isolated || len(verdict.AllowedBy) != 0
+	return verdict
+}
+
+// selecting returns policies in namespace of pod which select pod for policyType
+func (e *Evaluator) selecting(pod *coreapi.Pod, policyType netapi.PolicyType) []*netapi.NetworkPolicy {
+	var selected []*netapi.NetworkPolicy
+	for _, policy := range e.policies {
+		if policy.Namespace != pod.Namespace || !affects(policy, policyType) {
+			continue
+		}
+		if selectorMatches(&policy.Spec.PodSelector, pod.Labels) {
+			selected = append(selected, policy)
+		}
+	}
+	return selected
+}
+
+// affects returns true if policy applies to policyType, policyTypes defaults to Ingress, plus Egress if policy
+// has any egress rules
+func affects(policy *netapi.NetworkPolicy, policyType netapi.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return policyType == netapi.PolicyTypeIngress || len(policy.Spec.Egress) != 0
+	}
+	for _, t := range policy.Spec.PolicyTypes {
+		if t == policyType {
+			return true
+		}
+	}
+	return false
+}
+
+// peersMatch returns true if peer matches any of peers, an empty list matches everything
+func peersMatch(namespace string, peers []netapi.NetworkPolicyPeer, peer Peer) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, p := range peers {
+		if peerMatches(namespace, p, peer) {
+			return true
+		}
+	}
+	return false
+}
+
+func peerMatches(namespace string, p netapi.NetworkPolicyPeer, peer Peer) bool {
+	if p.IPBlock != nil {
+		return ipBlockMatches(p.IPBlock, peer.IPs)
+	}
+	if peer.Pod == nil {
+		return false
+	}
+	if p.NamespaceSelector == nil {
+		if peer.Pod.Namespace != namespace {
+			return false
+		}
+	} else {
+		var namespaceLabels map[string]string
+		if peer.Namespace != nil {
+			namespaceLabels = peer.Namespace.Labels
+		}
+		if !selectorMatches(p.NamespaceSelector, namespaceLabels) {
+			return false
+		}
+	}
+	return p.PodSelector == nil || selectorMatches(p.PodSelector, peer.Pod.Labels)
+}
+
+// ipBlockMatches returns true if any of ips is in cidr of block but not in its exceptions
+func ipBlockMatches(block *netapi.IPBlock, ips []netip.Addr) bool {
+	cidr, err := netip.ParsePrefix(block.CIDR)
+	if err != nil {
+		loggerForNetPol.WithError(err).Warnf("invalid cidr %s of ip block", block.CIDR)
+		return false
+	}
+	for _, ip := range ips {
+		if !cidr.Contains(ip) {
+			continue
+		}
+		excepted := false
+		for _, except := range block.Except {
+			if prefix, err := netip.ParsePrefix(except); err == nil && prefix.Contains(ip) {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			return true
+		}
+	}
+	return false
+}
+
+// portsMatch returns true if port of dst matches any of ports, an empty list matches every port. named ports
+// are resolved against containers of dst
+func portsMatch(ports []netapi.NetworkPolicyPort, dst Peer, port int32, protocol coreapi.Protocol) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		policyProtocol := coreapi.ProtocolTCP
+		if p.Protocol != nil {
+			policyProtocol = *p.Protocol
+		}
+		if policyProtocol != protocol {
+			continue
+		}
+		if p.Port == nil {
+			return true
+		}
+		if p.Port.Type == intstr.String {
+			if named, ok := ContainerPort(dst.Pod, p.Port.StrVal, protocol); ok && named == port {
+				return true
+			}
+			continue
+		}
+		start := int32(p.Port.IntValue())
+		end := start
+		if p.EndPort != nil {
+			end = *p.EndPort
+		}
+		if port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerPort resolves name of port to the number by containers of pod, port can be a number as well
+func ContainerPort(pod *coreapi.Pod, port string, protocol coreapi.Protocol) (int32, bool) {
+	if number, err := strconv.ParseInt(port, 10, 32); err == nil {
+		return int32(number), number > 0
+	}
+	if pod == nil {
+		return 0, false
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			portProtocol := containerPort.Protocol
+			if portProtocol == "" {
+				portProtocol = coreapi.ProtocolTCP
+			}
+			if containerPort.Name == port && portProtocol == protocol {
+				return containerPort.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		loggerForNetPol.WithError(err).Warn("invalid label selector")
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
+
+func policyName(policy *netapi.NetworkPolicy) string {
+	return policy.Namespace + "/" + policy.Name
+}
@@ -0,0 +1,212 @@
+package netpol
+
+import (
+	"net/netip"
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	netapi "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func newTestPeer(namespace, name, ip string, podLabels map[string]string, namespaceLabels map[string]string) Peer {
+	pod := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: podLabels},
+		Spec: coreapi.PodSpec{Containers: []coreapi.Container{{
+			Name:  "app",
+			Ports: []coreapi.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "dns", ContainerPort: 53, Protocol: coreapi.ProtocolUDP}},
+		}}},
+		Status: coreapi.PodStatus{PodIP: ip},
+	}
+	ns := &coreapi.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: namespaceLabels}}
+	return Peer{Pod: pod, Namespace: ns, IPs: podIPs(pod)}
+}
+
+func newTestPolicy(namespace, name string, podSelector map[string]string, spec netapi.NetworkPolicySpec) *netapi.NetworkPolicy {
+	spec.PodSelector = metav1.LabelSelector{MatchLabels: podSelector}
+	return &netapi.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+}
+
+func portOf(port intstr.IntOrString) []netapi.NetworkPolicyPort {
+	return []netapi.NetworkPolicyPort{{Port: &port}}
+}
+
+func TestEvaluator_NotIsolated(t *testing.T) {
+	client := newTestPeer("default", "client", "10.244.1.2", map[string]string{"app": "client"}, nil)
+	server := newTestPeer("default", "server", "10.244.1.3", map[string]string{"app": "server"}, nil)
+	// 只选中了其他 Pod 的策略不影响 server
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("default", "other", map[string]string{"app": "other"}, netapi.NetworkPolicySpec{}),
+	})
+
+	ingress := evaluator.Ingress(client, server, 8080, coreapi.ProtocolTCP)
+	if ingress.Isolated || !ingress.Allowed {
+		t.Errorf("Expected server not to be isolated, got %+v", ingress)
+	}
+	egress := evaluator.Egress(client, server, 8080, coreapi.ProtocolTCP)
+	if egress.Isolated || !egress.Allowed {
+		t.Errorf("Expected client not to be isolated, got %+v", egress)
+	}
+}
+
+func TestEvaluator_DefaultDeny(t *testing.T) {
+	client := newTestPeer("default", "client", "10.244.1.2", map[string]string{"app": "client"}, nil)
+	server := newTestPeer("default", "server", "10.244.1.3", map[string]string{"app": "server"}, nil)
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("default", "deny-all", nil, netapi.NetworkPolicySpec{
+			PolicyTypes: []netapi.PolicyType{netapi.PolicyTypeIngress, netapi.PolicyTypeEgress},
+		}),
+	})
+
+	ingress := evaluator.Ingress(client, server, 8080, coreapi.ProtocolTCP)
+	if !ingress.Isolated || ingress.Allowed || len(ingress.Policies) != 1 {
+		t.Errorf("Expected ingress to be denied, got %+v", ingress)
+	}
+	egress := evaluator.Egress(client, server, 8080, coreapi.ProtocolTCP)
+	if !egress.Isolated || egress.Allowed {
+		t.Errorf("Expected egress to be denied, got %+v", egress)
+	}
+	// 集群外的 IP 不受策略限制, 但发往它的流量仍受 egress 限制
+	external := Peer{IPs: []netip.Addr{netip.MustParseAddr("8.8.8.8")}}
+	if verdict := evaluator.Ingress(client, external, 53, coreapi.ProtocolUDP); !verdict.Allowed {
+		t.Errorf("Expected ip out of cluster not to be isolated")
+	}
+	if verdict := evaluator.Egress(client, external, 53, coreapi.ProtocolUDP); verdict.Allowed {
+		t.Errorf("Expected egress to ip out of cluster to be denied")
+	}
+}
+
+func TestEvaluator_PolicyTypesDefault(t *testing.T) {
+	server := newTestPeer("default", "server", "10.244.1.3", map[string]string{"app": "server"}, nil)
+	client := newTestPeer("default", "client", "10.244.1.2", map[string]string{"app": "client"}, nil)
+	// 未指定 policyTypes 且没有 egress 规则时只影响 ingress
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("default", "ingress-only", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{}),
+	})
+	if verdict := evaluator.Egress(server, client, 8080, coreapi.ProtocolTCP); verdict.Isolated {
+		t.Errorf("Expected egress not to be isolated without egress rules, got %+v", verdict)
+	}
+	if verdict := evaluator.Ingress(client, server, 8080, coreapi.ProtocolTCP); !verdict.Isolated || verdict.Allowed {
+		t.Errorf("Expected ingress to be isolated, got %+v", verdict)
+	}
+}
+
+func TestEvaluator_Selectors(t *testing.T) {
+	server := newTestPeer("prod", "server", "10.244.1.3", map[string]string{"app": "server"}, map[string]string{"env": "prod"})
+	frontend := newTestPeer("prod", "frontend", "10.244.1.4", map[string]string{"role": "frontend"}, map[string]string{"env": "prod"})
+	other := newTestPeer("prod", "other", "10.244.1.5", map[string]string{"role": "other"}, map[string]string{"env": "prod"})
+	monitor := newTestPeer("monitoring", "prometheus", "10.244.2.2", map[string]string{"app": "prometheus"}, map[string]string{"team": "ops"})
+	devFrontend := newTestPeer("dev", "frontend", "10.244.3.2", map[string]string{"role": "frontend"}, map[string]string{"env": "dev"})
+
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("prod", "allow", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+			Ingress: []netapi.NetworkPolicyIngressRule{{
+				From: []netapi.NetworkPolicyPeer{
+					// 同一 namespace 的 frontend
+					{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "frontend"}}},
+					// ops 团队 namespace 中的 prometheus
+					{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "ops"}},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "prometheus"}},
+					},
+				},
+			}},
+		}),
+	})
+
+	cases := []struct {
+		src     Peer
+		allowed bool
+	}{
+		{frontend, true},
+		{other, false},
+		{monitor, true},
+		// podSelector 不带 namespaceSelector 时只匹配策略所在 namespace
+		{devFrontend, false},
+	}
+	for _, c := range cases {
+		verdict := evaluator.Ingress(c.src, server, 8080, coreapi.ProtocolTCP)
+		if verdict.Allowed != c.allowed {
+			t.Errorf("Expected %s -> server allowed=%v, got %+v", c.src.Name(), c.allowed, verdict)
+		}
+	}
+}
+
+func TestEvaluator_IPBlock(t *testing.T) {
+	server := newTestPeer("default", "server", "10.244.1.3", map[string]string{"app": "server"}, nil)
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("default", "allow-office", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+			Ingress: []netapi.NetworkPolicyIngressRule{{
+				From: []netapi.NetworkPolicyPeer{{IPBlock: &netapi.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.1.0/24"}}}},
+			}},
+		}),
+	})
+
+	cases := map[string]bool{
+		"192.168.2.10": true,
+		"192.168.1.10": false,
+		"10.0.0.1":     false,
+	}
+	for ip, allowed := range cases {
+		src := Peer{IPs: []netip.Addr{netip.MustParseAddr(ip)}}
+		if verdict := evaluator.Ingress(src, server, 8080, coreapi.ProtocolTCP); verdict.Allowed != allowed {
+			t.Errorf("Expected %s allowed=%v, got %+v", ip, allowed, verdict)
+		}
+	}
+}
+
+func TestEvaluator_Ports(t *testing.T) {
+	client := newTestPeer("default", "client", "10.244.1.2", map[string]string{"app": "client"}, nil)
+	server := newTestPeer("default", "server", "10.244.1.3", map[string]string{"app": "server"}, nil)
+	evaluator := NewEvaluator([]*netapi.NetworkPolicy{
+		newTestPolicy("default", "named", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+			Ingress: []netapi.NetworkPolicyIngressRule{{Ports: portOf(intstr.FromString("http"))}},
+		}),
+		newTestPolicy("default", "range", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+			Ingress: []netapi.NetworkPolicyIngressRule{{Ports: []netapi.NetworkPolicyPort{{Port: ptr.To(intstr.FromInt32(9000)), EndPort: ptr.To(int32(9100))}}}},
+		}),
+		newTestPolicy("default", "udp", map[string]string{"app": "server"}, netapi.NetworkPolicySpec{
+			Ingress: []netapi.NetworkPolicyIngressRule{{Ports: []netapi.NetworkPolicyPort{{Protocol: ptr.To(coreapi.ProtocolUDP), Port: ptr.To(intstr.FromString("dns"))}}}},
+		}),
+	})
+
+	cases := []struct {
+		port      int32
+		protocol  coreapi.Protocol
+		allowedBy string
+	}{
+		// 命名端口按目标 Pod 的容器端口解析
+		{8080, coreapi.ProtocolTCP, "default/named"},
+		{9050, coreapi.ProtocolTCP, "default/range"},
+		{53, coreapi.ProtocolUDP, "default/udp"},
+		{53, coreapi.ProtocolTCP, ""},
+		{9200, coreapi.ProtocolTCP, ""},
+	}
+	for _, c := range cases {
+		verdict := evaluator.Ingress(client, server, c.port, c.protocol)
+		if c.allowedBy == "" {
+			if verdict.Allowed {
+				t.Errorf("Expected port %d/%s to be denied, got %+v", c.port, c.protocol, verdict)
+			}
+			continue
+		}
+		if !verdict.Allowed || len(verdict.AllowedBy) != 1 || verdict.AllowedBy[0] != c.allowedBy {
+			t.Errorf("Expected port %d/%s allowed by %s, got %+v", c.port, c.protocol, c.allowedBy, verdict)
+		}
+	}
+}
+
+func TestContainerPort(t *testing.T) {
+	peer := newTestPeer("default", "server", "10.244.1.3", nil, nil)
+	if port, ok := ContainerPort(peer.Pod, "http", coreapi.ProtocolTCP); !ok || port != 8080 {
+		t.Errorf("Expected http to be 8080, got %d", port)
+	}
+	if _, ok := ContainerPort(peer.Pod, "dns", coreapi.ProtocolTCP); ok {
+		t.Error("Expected dns not to be found for TCP")
+	}
+	if port, ok := ContainerPort(nil, "443", coreapi.ProtocolTCP); !ok || port != 443 {
+		t.Errorf("Expected numeric port to be parsed, got %d", port)
+	}
+}
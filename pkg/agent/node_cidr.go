@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"math/big"
+	"net/netip"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultClusterCIDR = "10.244.0.0/16"
+	DefaultNodeIPRange = "10.10.10.0/24"
+	// DefaultNodeCIDRMaskSizeIPv4 and DefaultNodeCIDRMaskSizeIPv6 are the same as kube-controller-manager
+	DefaultNodeCIDRMaskSizeIPv4 = 24
+	DefaultNodeCIDRMaskSizeIPv6 = 64
+)
+
+// nodeAddressAllocator represent how pod cidrs and host ips of simulated nodes are carved out of
+// the cluster cidr and the node ip range, the nodeIdx-th node gets the (nodeIdx+1)-th subnet and address
+type nodeAddressAllocator struct {
+	clusterCIDRs []netip.Prefix
+	maskSizeIPv4 int
+	maskSizeIPv6 int
+	nodeIPRange  netip.Prefix
+	// delegated means pod cidrs are allocated by the nodeipam controller of kube-controller-manager
+	delegated bool
+}
+
+// newNodeAddressAllocator build allocator from config, zero values are replaced by defaults
+func newNodeAddressAllocator(config *Config) (*nodeAddressAllocator, error) {
+	allocator := &nodeAddressAllocator{
+		maskSizeIPv4: DefaultNodeCIDRMaskSizeIPv4,
+		maskSizeIPv6: DefaultNodeCIDRMaskSizeIPv6,
+		nodeIPRange:  netip.MustParsePrefix(DefaultNodeIPRange),
+		delegated:    config.DelegateNodeCIDRs,
+	}
+	clusterCIDR := config.ClusterCIDR
+	if clusterCIDR == "" {
+		clusterCIDR = DefaultClusterCIDR
+	}
+	clusterCIDRs, err := util.ParseDualStackCIDRs(clusterCIDR)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse cluster cidr failed")
+	}
+	allocator.clusterCIDRs = clusterCIDRs
+	if config.NodeCIDRMaskSizeIPv4 != 0 {
+		allocator.maskSizeIPv4 = config.NodeCIDRMaskSizeIPv4
+	}
+	if config.NodeCIDRMaskSizeIPv6 != 0 {
+		allocator.maskSizeIPv6 = config.NodeCIDRMaskSizeIPv6
+	}
+	for _, prefix := range clusterCIDRs {
+		maskSize := allocator.maskSizeOf(prefix)
+		if maskSize < prefix.Bits() || maskSize > prefix.Addr().BitLen()-2 {
+			return nil, errors.Errorf("node cidr mask size /%d is invalid for cluster cidr %s", maskSize, prefix)
+		}
+	}
+	if config.NodeIPRange != "" {
+		if allocator.nodeIPRange, err = netip.ParsePrefix(config.NodeIPRange); err != nil {
+			return nil, errors.Wrapf(err, "parse node ip range %s failed", config.NodeIPRange)
+		}
+		allocator.nodeIPRange = allocator.nodeIPRange.Masked()
+	}
+	return allocator, nil
+}
+
+// podCIDRs returns pod cidrs of the nodeIdx-th node in the family order of cluster cidrs,
+// nothing is returned if it's up to kube-controller-manager
+func (a *nodeAddressAllocator) podCIDRs(nodeIdx int) ([]string, error) {
+	if a.delegated {
+		return nil, nil
+	}
+	var podCIDRs []string
+	for _, clusterCIDR := range a.clusterCIDRs {
+		subnet, err := util.SubnetOf(clusterCIDR, a.maskSizeOf(clusterCIDR), uint64(nodeIdx+1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "allocate pod cidr for node %d failed", nodeIdx)
+		}
+		podCIDRs = append(podCIDRs, subnet.String())
+	}
+	return podCIDRs, nil
+}
+
+// hostIP returns the internal ip of the nodeIdx-th node, the network and broadcast addresses are skipped
+func (a *nodeAddressAllocator) hostIP(nodeIdx int) (string, error) {
+	addr := util.AddToAddr(a.nodeIPRange.Addr(), big.NewInt(int64(nodeIdx+1)))
+	broadcast := a.nodeIPRange.Addr().Is4() && !a.nodeIPRange.Contains(util.AddToAddr(addr, big.NewInt(1)))
+	if !a.nodeIPRange.Contains(addr) || broadcast {
+		return "", errors.Errorf("node ip range %s has no address for node %d", a.nodeIPRange, nodeIdx)
+	}
+	return addr.String(), nil
+}
+
+func (a *nodeAddressAllocator) maskSizeOf(prefix netip.Prefix) int {
+	if prefix.Addr().Is4() {
+		return a.maskSizeIPv4
+	}
+	return a.maskSizeIPv6
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestNodeAddressAllocator(t *testing.T) {
+	testCases := []struct {
+		name             string
+		config           Config
+		nodeIdx          int
+		expectedPodCIDRs []string
+		expectedHostIP   string
+	}{
+		{
+			name:             "Defaults",
+			nodeIdx:          0,
+			expectedPodCIDRs: []string{"10.244.1.0/24"},
+			expectedHostIP:   "10.10.10.1",
+		},
+		{
+			name:             "Custom cluster cidr and mask size",
+			config:           Config{ClusterCIDR: "172.16.0.0/12", NodeCIDRMaskSizeIPv4: 26, NodeIPRange: "192.168.0.0/16"},
+			nodeIdx:          299,
+			expectedPodCIDRs: []string{"172.16.75.0/26"},
+			expectedHostIP:   "192.168.1.44",
+		},
+		{
+			name:             "Dual-stack",
+			config:           Config{ClusterCIDR: "10.244.0.0/16,fd00:10:244::/48", NodeCIDRMaskSizeIPv6: 80},
+			nodeIdx:          2,
+			expectedPodCIDRs: []string{"10.244.3.0/24", "fd00:10:244:0:3::/80"},
+			expectedHostIP:   "10.10.10.3",
+		},
+		{
+			// 由 kube-controller-manager 分配 Pod CIDR
+			name:           "Delegated to nodeipam",
+			config:         Config{DelegateNodeCIDRs: true},
+			nodeIdx:        0,
+			expectedHostIP: "10.10.10.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			allocator, err := newNodeAddressAllocator(&tc.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			podCIDRs, err := allocator.podCIDRs(tc.nodeIdx)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(podCIDRs) != len(tc.expectedPodCIDRs) {
+				t.Fatalf("Expected pod cidrs %v, got %v", tc.expectedPodCIDRs, podCIDRs)
+			}
+			for idx := range podCIDRs {
+				if podCIDRs[idx] != tc.expectedPodCIDRs[idx] {
+					t.Errorf("Expected pod cidrs %v, got %v", tc.expectedPodCIDRs, podCIDRs)
+				}
+			}
+			hostIP, err := allocator.hostIP(tc.nodeIdx)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if hostIP != tc.expectedHostIP {
+				t.Errorf("Expected host ip %s, got %s", tc.expectedHostIP, hostIP)
+			}
+		})
+	}
+}
+
+func TestNodeAddressAllocator_Exhausted(t *testing.T) {
+	allocator, err := newNodeAddressAllocator(&Config{ClusterCIDR: "10.244.0.0/22", NodeIPRange: "10.10.10.0/30"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// /22 只能划分 4 个 /24，第 4 个节点使用第 5 个子网
+	if _, err := allocator.podCIDRs(3); err == nil {
+		t.Error("Expected error when cluster cidr is exhausted")
+	}
+	// /30 只有 2 个可用地址，不分配广播地址
+	if _, err := allocator.hostIP(1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := allocator.hostIP(2); err == nil {
+		t.Error("Expected error when broadcast address would be allocated")
+	}
+}
+
+func TestNewNodeAddressAllocator_Invalid(t *testing.T) {
+	testCases := []Config{
+		{ClusterCIDR: "10.244.0.0"},
+		{ClusterCIDR: "10.244.0.0/16", NodeCIDRMaskSizeIPv4: 8},
+		{ClusterCIDR: "10.244.0.0/16", NodeCIDRMaskSizeIPv4: 31},
+		{NodeIPRange: "10.10.10.0"},
+	}
+	for _, config := range testCases {
+		if _, err := newNodeAddressAllocator(&config); err == nil {
+			t.Errorf("Expected error for config %+v", config)
+		}
+	}
+}
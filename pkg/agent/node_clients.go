@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	agtmanager "3Xpl0it3r.com/kube-simulator/pkg/agent/manager"
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"github.com/pkg/errors"
+	"k8s.io/apiserver/pkg/authentication/user"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NodeUserPrefix is the prefix of users of nodes, the Node authorizer and NodeRestriction admission only
+// recognize users like system:node:<name> in group system:nodes
+const NodeUserPrefix = "system:node:"
+
+// NodeIdentityConfig represent how nodes get their own identities
+type NodeIdentityConfig struct {
+	// Enabled makes nodes write their own objects as system:node:<name> instead of the identity of the agent
+	Enabled bool
+	// CACertFile and CAKeyFile are the cluster ca which client certificates of nodes are signed by
+	CACertFile string
+	CAKeyFile  string
+	Cert       mycertutil.Options
+	// KubeconfigDir is where kubeconfigs of nodes are, named <node>.conf
+	KubeconfigDir string
+}
+
+// KubeconfigFile returns the kubeconfig of nodeName in KubeconfigDir
+func (c *NodeIdentityConfig) KubeconfigFile(nodeName string) string {
+	return filepath.Join(c.KubeconfigDir, nodeName+".conf")
+}
+
+// nodeClient represent a client with the client certificate of a node
+type nodeClient struct {
+	client   kubeclientset.Interface
+	notAfter time.Time
+}
+
+// nodeClientPool creates clients of nodes with their kubeconfigs, nodes without one get a kubeconfig issued with the
+// cluster ca. clients are created on the first use and cached until their certificates are about to expire
+type nodeClientPool struct {
+	sync.Mutex
+	config     NodeIdentityConfig
+	restConfig *rest.Config
+	generator  *mycertutil.Generator
+	// fallback is used for objects which aren't bound to a node, or if client of the node can't be created
+	fallback kubeclientset.Interface
+	clients  map[string]*nodeClient
+}
+
+// newNodeClientPool returns clients of nodes which connect to the apiserver of restConfig, the credential of
+// restConfig is never used by them
+func newNodeClientPool(restConfig *rest.Config, fallback kubeclientset.Interface, config NodeIdentityConfig) (*nodeClientPool, error) {
+	generator, err := mycertutil.NewGenerator(config.Cert)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeClientPool{
+		config:     config,
+		restConfig: restConfig,
+		generator:  generator,
+		fallback:   fallback,
+		clients:    make(map[string]*nodeClient),
+	}, nil
+}
+
+// ForNode implements agtmanager.NodeClients
+func (p *nodeClientPool) ForNode(nodeName string) kubeclientset.Interface {
+	if nodeName == "" {
+		return p.fallback
+	}
+	p.Lock()
+	defer p.Unlock()
+	if cached, ok := p.clients[nodeName]; ok && time.Until(cached.notAfter) > p.generator.RenewBefore() {
+		return cached.client
+	}
+	client, err := p.newNodeClient(nodeName)
+	if err != nil {
+		loggerForAgent.WithError(err).Errorf("create client of node %s failed, use the client of agent instead", nodeName)
+		return p.fallback
+	}
+	p.clients[nodeName] = client
+	return client.client
+}
+
+// newNodeClient returns a client of nodeName with its kubeconfig. a kubeconfig is issued if the node has none, e.g.
+// nodes created after simulator started, or the certificate of it is about to expire
+func (p *nodeClientPool) newNodeClient(nodeName string) (*nodeClient, error) {
+	restConfig, notAfter, err := p.kubeconfigRestConfig(nodeName)
+	if err != nil || time.Until(notAfter) <= p.generator.RenewBefore() {
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			loggerForAgent.WithError(err).Warnf("load kubeconfig of node %s failed", nodeName)
+		}
+		if err := p.issueKubeconfig(nodeName); err != nil {
+			return nil, err
+		}
+		if restConfig, notAfter, err = p.kubeconfigRestConfig(nodeName); err != nil {
+			return nil, err
+		}
+	}
+	client, err := kubeclientset.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeClient{client: client, notAfter: notAfter}, nil
+}
+
+// kubeconfigRestConfig loads the kubeconfig of nodeName, it returns the rest config and when its certificate expires
+func (p *nodeClientPool) kubeconfigRestConfig(nodeName string) (*rest.Config, time.Time, error) {
+	file := p.config.KubeconfigFile(nodeName)
+	if _, err := os.Stat(file); err != nil {
+		return nil, time.Time{}, err
+	}
+	restConfig, err := clientcmd.BuildConfigFromFlags("", file)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "load kubeconfig %s", file)
+	}
+	_, cert, err := mycertutil.ParseCertAndKeyPEM(restConfig.KeyData, restConfig.CertData)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "load client certificate of kubeconfig %s", file)
+	}
+	return restConfig, cert.NotAfter, nil
+}
+
+// issueKubeconfig writes the kubeconfig of nodeName with a client certificate of system:node:<nodeName> in group
+// system:nodes, the same as kubeconfigs of bootstrap nodes. the cluster ca is only loaded to sign it
+func (p *nodeClientPool) issueKubeconfig(nodeName string) error {
+	caKey, caCert, err := mycertutil.TryLoadCertAndKeyFromFile(p.config.CAKeyFile, p.config.CACertFile)
+	if err != nil {
+		return errors.Wrap(err, "load ca of node identities")
+	}
+	certConfig := mycertutil.NewClientCertificateConfig(NodeUserPrefix+nodeName, user.NodesGroup)
+	if err := p.generator.WriteCertKubeconfig(caKey, caCert, p.restConfig.Host, certConfig, p.config.KubeconfigFile(nodeName)); err != nil {
+		return errors.Wrapf(err, "issue kubeconfig of node %s", nodeName)
+	}
+	loggerForAgent.Infof("issued kubeconfig %s of node %s", p.config.KubeconfigFile(nodeName), nodeName)
+	return nil
+}
+
+var _ agtmanager.NodeClients = &nodeClientPool{}
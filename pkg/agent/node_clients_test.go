@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestNodeClientPool(t *testing.T, fallback kubeclientset.Interface) *nodeClientPool {
+	t.Helper()
+	dir := t.TempDir()
+	ca := mycertutil.CertKeyPair{Name: "ca", KeyFile: filepath.Join(dir, "ca.key"), CertFile: filepath.Join(dir, "ca.crt")}
+	if err := mycertutil.CreateCACertFiles(ca, mycertutil.NewCACertificateConfig("kubernetes")); err != nil {
+		t.Fatalf("create ca failed: %v", err)
+	}
+	restConfig := &rest.Config{Host: "https://127.0.0.1:6443", BearerToken: "admin-token"}
+	pool, err := newNodeClientPool(restConfig, fallback, NodeIdentityConfig{
+		Enabled:       true,
+		CACertFile:    ca.CertFile,
+		CAKeyFile:     ca.KeyFile,
+		Cert:          mycertutil.Options{KeyAlgorithm: mycertutil.KeyAlgorithmECDSAP256},
+		KubeconfigDir: filepath.Join(dir, "nodes"),
+	})
+	if err != nil {
+		t.Fatalf("newNodeClientPool failed: %v", err)
+	}
+	return pool
+}
+
+func TestNodeClientPool_IssueKubeconfig(t *testing.T) {
+	pool := newTestNodeClientPool(t, fake.NewSimpleClientset())
+
+	// 没有 kubeconfig 的节点(如启动后创建的节点)签发新的 kubeconfig
+	if _, _, err := pool.kubeconfigRestConfig("mock-node-0"); !os.IsNotExist(err) {
+		t.Fatalf("Expected no kubeconfig of mock-node-0, got %v", err)
+	}
+	client, err := pool.newNodeClient("mock-node-0")
+	if err != nil {
+		t.Fatalf("newNodeClient failed: %v", err)
+	}
+	restConfig, notAfter, err := pool.kubeconfigRestConfig("mock-node-0")
+	if err != nil {
+		t.Fatalf("kubeconfigRestConfig failed: %v", err)
+	}
+	// 节点只使用自己的证书, 不能带上 agent 的凭证
+	if restConfig.Host != "https://127.0.0.1:6443" || restConfig.BearerToken != "" {
+		t.Errorf("Expected host of agent without its token, got %s %q", restConfig.Host, restConfig.BearerToken)
+	}
+	block, _ := pem.Decode(restConfig.CertData)
+	if block == nil {
+		t.Fatal("Expected pem encoded client certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse client certificate failed: %v", err)
+	}
+	if cert.Subject.CommonName != "system:node:mock-node-0" || !slices.Equal(cert.Subject.Organization, []string{"system:nodes"}) {
+		t.Errorf("Expected system:node:mock-node-0 in system:nodes, got %s %v", cert.Subject.CommonName, cert.Subject.Organization)
+	}
+	if !slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageClientAuth) {
+		t.Error("Expected certificate for client auth")
+	}
+	if !client.notAfter.Equal(notAfter) || !notAfter.Equal(cert.NotAfter) {
+		t.Errorf("Expected expiration %s, got %s", cert.NotAfter, client.notAfter)
+	}
+}
+
+func TestNodeClientPool_Kubeconfig(t *testing.T) {
+	pool := newTestNodeClientPool(t, fake.NewSimpleClientset())
+	if err := pool.issueKubeconfig("mock-node-0"); err != nil {
+		t.Fatalf("issueKubeconfig failed: %v", err)
+	}
+	file := pool.config.KubeconfigFile("mock-node-0")
+	issued, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 初始节点使用启动时生成的 kubeconfig, 不需要集群 ca 的私钥
+	pool.config.CAKeyFile = filepath.Join(t.TempDir(), "missing.key")
+	if _, err := pool.newNodeClient("mock-node-0"); err != nil {
+		t.Fatalf("newNodeClient failed: %v", err)
+	}
+	if current, _ := os.ReadFile(file); !bytes.Equal(current, issued) {
+		t.Error("Expected existing kubeconfig to be used as it is")
+	}
+	if _, err := pool.newNodeClient("mock-node-1"); err == nil {
+		t.Error("Expected kubeconfig of node to be issued with the cluster ca")
+	}
+}
+
+func TestNodeClientPool_ForNode(t *testing.T) {
+	fallback := fake.NewSimpleClientset()
+	pool := newTestNodeClientPool(t, fallback)
+
+	// 不属于任何节点的对象使用 agent 的客户端
+	if pool.ForNode("") != kubeclientset.Interface(fallback) {
+		t.Error("Expected fallback client for empty node name")
+	}
+	client := pool.ForNode("mock-node-0")
+	if client == kubeclientset.Interface(fallback) {
+		t.Fatal("Expected client of node instead of fallback")
+	}
+	if pool.ForNode("mock-node-0") != client {
+		t.Error("Expected client of node to be cached")
+	}
+	if pool.ForNode("mock-node-1") == client {
+		t.Error("Expected different nodes to have different clients")
+	}
+
+	// 证书即将过期时重新签发
+	pool.clients["mock-node-0"].notAfter = time.Now().Add(time.Minute)
+	if pool.ForNode("mock-node-0") == client {
+		t.Error("Expected client to be renewed before its certificate expires")
+	}
+}
+
+// fakeNodeClients returns a fake client for every node
+type fakeNodeClients map[string]*fake.Clientset
+
+func (c fakeNodeClients) ForNode(nodeName string) kubeclientset.Interface {
+	return c[nodeName]
+}
+
+func TestNodeEventRecorder(t *testing.T) {
+	clients := fakeNodeClients{"": fake.NewSimpleClientset(), "mock-node-0": fake.NewSimpleClientset()}
+	broadcaster := record.NewBroadcaster()
+	defer broadcaster.Shutdown()
+	broadcaster.StartRecordingToSink(&nodeEventSink{clients: clients})
+	recorder := newNodeEventRecorder(broadcaster, "kubelet")
+
+	pod := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", UID: "pod-1"},
+		Spec:       coreapi.PodSpec{NodeName: "mock-node-0"},
+	}
+	recorder.Eventf(pod, coreapi.EventTypeWarning, "FailedMount", "volume %s not attached", "data")
+	unbound := &coreapi.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default", UID: "pod-2"}}
+	recorder.Event(unbound, coreapi.EventTypeNormal, "Pending", "pod is not bound")
+
+	// 事件由所在节点的客户端写入, source host 为节点名
+	events := waitForEvents(t, clients["mock-node-0"], 1)
+	if events[0].Source.Host != "mock-node-0" || events[0].Source.Component != "kubelet" || events[0].InvolvedObject.Name != "pod-1" {
+		t.Errorf("Expected event of pod-1 from kubelet on mock-node-0, got %+v", events[0])
+	}
+	events = waitForEvents(t, clients[""], 1)
+	if events[0].Source.Host != "" || events[0].InvolvedObject.Name != "pod-2" {
+		t.Errorf("Expected event of pod-2 without host, got %+v", events[0])
+	}
+}
+
+func waitForEvents(t *testing.T, client kubeclientset.Interface, count int) []coreapi.Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events, err := client.CoreV1().Events("default").List(t.Context(), metav1.ListOptions{})
+		if err == nil && len(events.Items) >= count {
+			return events.Items
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d events, got %v", count, events)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
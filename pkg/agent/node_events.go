@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"sync"
+
+	agtmanager "3Xpl0it3r.com/kube-simulator/pkg/agent/manager"
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	coretyped "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// nodeEventRecorder records events of pods and nodes with the node they are on as the source host, like every
+// kubelet records events of its own. objects not bound to a node are recorded without host
+type nodeEventRecorder struct {
+	sync.Mutex
+	broadcaster record.EventBroadcaster
+	component   string
+	recorders   map[string]record.EventRecorder
+}
+
+func newNodeEventRecorder(broadcaster record.EventBroadcaster, component string) *nodeEventRecorder {
+	return &nodeEventRecorder{broadcaster: broadcaster, component: component, recorders: make(map[string]record.EventRecorder)}
+}
+
+func (r *nodeEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.recorderOf(object).Event(object, eventtype, reason, message)
+}
+
+func (r *nodeEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.recorderOf(object).Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (r *nodeEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.recorderOf(object).AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
+
+// recorderOf returns the recorder of the node object is on
+func (r *nodeEventRecorder) recorderOf(object runtime.Object) record.EventRecorder {
+	var host string
+	switch obj := object.(type) {
+	case *coreapi.Pod:
+		host = obj.Spec.NodeName
+	case *coreapi.Node:
+		host = obj.Name
+	}
+	r.Lock()
+	defer r.Unlock()
+	recorder, ok := r.recorders[host]
+	if !ok {
+		recorder = r.broadcaster.NewRecorder(scheme.Scheme, coreapi.EventSource{Component: r.component, Host: host})
+		r.recorders[host] = recorder
+	}
+	return recorder
+}
+
+// nodeEventSink writes events with the client of the node which recorded them
+type nodeEventSink struct {
+	clients agtmanager.NodeClients
+}
+
+func (s *nodeEventSink) Create(event *coreapi.Event) (*coreapi.Event, error) {
+	return s.sinkOf(event).Create(event)
+}
+
+func (s *nodeEventSink) Update(event *coreapi.Event) (*coreapi.Event, error) {
+	return s.sinkOf(event).Update(event)
+}
+
+func (s *nodeEventSink) Patch(event *coreapi.Event, data []byte) (*coreapi.Event, error) {
+	return s.sinkOf(event).Patch(event, data)
+}
+
+func (s *nodeEventSink) sinkOf(event *coreapi.Event) record.EventSink {
+	return &coretyped.EventSinkImpl{Interface: s.clients.ForNode(event.Source.Host).CoreV1().Events(coreapi.NamespaceAll)}
+}
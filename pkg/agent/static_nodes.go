@@ -18,27 +18,52 @@ const (
 	StaticNodePrefix = "mock-node-%d"
 )
 
-func registerBootstrapNode(nodeIdx int, client kubernetes.Interface) (*coreapi.Node, error) {
-	nodeName := fmt.Sprintf(StaticNodePrefix, nodeIdx)
-	podCIDR := fmt.Sprintf("10.244.%d.0/24", nodeIdx+1)
-	hostIP := fmt.Sprintf("10.10.10.%d", nodeIdx+1)
+// BootstrapNodeName returns the name of the nodeIdx-th node registered on start
+func BootstrapNodeName(nodeIdx int) string {
+	return fmt.Sprintf(StaticNodePrefix, nodeIdx)
+}
 
-	node := kuberes.NewNodeObject(nodeName, hostIP, podCIDR)
-	if err := joinNewNode(client, node); err != nil {
+// registerBootstrapNode registers the nodeIdx-th node with addresses from allocator and the zone from topology,
+// defaults are used if allocator is nil and the node has no zone if topology is nil
+func registerBootstrapNode(nodeIdx int, client kubernetes.Interface, allocator *nodeAddressAllocator, topology *nodeTopology) (*coreapi.Node, error) {
+	if allocator == nil {
+		var err error
+		if allocator, err = newNodeAddressAllocator(&Config{}); err != nil {
+			return nil, err
+		}
+	}
+	nodeName := BootstrapNodeName(nodeIdx)
+	hostIP, err := allocator.hostIP(nodeIdx)
+	if err != nil {
 		return nil, err
 	}
-	return node, nil
+	podCIDRs, err := allocator.podCIDRs(nodeIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	node := kuberes.NewNodeObject(nodeName, hostIP, podCIDRs...)
+	if zone, ok := topology.zoneOf(nodeIdx); ok {
+		kuberes.SetNodeTopology(node, zone.region, zone.zone)
+	}
+	return joinNewNode(client, node)
 }
 
-// create new node, if node existed in cluster, return , else create new node
-func joinNewNode(client kubernetes.Interface, node *coreapi.Node) error {
+// create new node, if node existed in cluster, return , else create new node.
+// the node in cluster is returned, pod cidrs of it may have been assigned by kube-controller-manager
+func joinNewNode(client kubernetes.Interface, node *coreapi.Node) (*coreapi.Node, error) {
 	var nodeName = node.Name
 	// check node existed , if node already then return with error
-	if _, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{}); err != nil {
+	joined, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
 		// create new node
-		if _, err := client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
-			return err
+		if joined, err = client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+			return nil, err
 		}
+	} else if labeled, err := labelNodeTopology(client, joined, node); err != nil {
+		loggerForCli.WithError(err).Warningf("label topology of node %s failed", nodeName)
+	} else {
+		joined = labeled
 	}
 
 	// lease existed, then renew it, elase create new one
@@ -47,7 +72,7 @@ func joinNewNode(client kubernetes.Interface, node *coreapi.Node) error {
 		if _, err := client.CoordinationV1().Leases(KubeNamespaceNodeLease).Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
 			loggerForCli.WithError(err).Warningf("update lease for node %s failed", nodeName)
 		}
-		return nil
+		return joined, nil
 	}
 
 	// create new lease for this ndde
@@ -55,8 +80,23 @@ func joinNewNode(client kubernetes.Interface, node *coreapi.Node) error {
 		// if create faild ,return error directory, don't need delete node that create before for reback
 		// for we will resync node with lease in health check loop
 		loggerForCli.WithError(err).Warningf("create lease for node %s failed", nodeName)
-		return nil
+		return joined, nil
 	}
 
-	return nil
+	return joined, nil
+}
+
+// labelNodeTopology copies topology labels of node to existing one which was registered without a zone,
+// zones of nodes never change once they are set, since volumes may be pinned to them
+func labelNodeTopology(client kubernetes.Interface, existing, node *coreapi.Node) (*coreapi.Node, error) {
+	if _, ok := existing.Labels[coreapi.LabelTopologyZone]; ok {
+		return existing, nil
+	}
+	zone, ok := node.Labels[coreapi.LabelTopologyZone]
+	if !ok {
+		return existing, nil
+	}
+	updated := existing.DeepCopy()
+	kuberes.SetNodeTopology(updated, node.Labels[coreapi.LabelTopologyRegion], zone)
+	return client.CoreV1().Nodes().Update(context.Background(), updated, metav1.UpdateOptions{})
 }
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"testing"
 
 	coreapi "k8s.io/api/core/v1"
@@ -15,7 +16,7 @@ func TestRegisterBootstrapNode(t *testing.T) {
 	t.Run("成功创建新节点", func(t *testing.T) {
 		client := fake.NewSimpleClientset()
 
-		node, err := registerBootstrapNode(0, client)
+		node, err := registerBootstrapNode(0, client, nil, nil)
 
 		helper.AssertNoError(err, "registerBootstrapNode should not return error")
 		if node == nil {
@@ -44,7 +45,7 @@ func TestRegisterBootstrapNode(t *testing.T) {
 
 		for _, tc := range testCases {
 			t.Run(tc.expectedName, func(t *testing.T) {
-				node, err := registerBootstrapNode(tc.idx, client)
+				node, err := registerBootstrapNode(tc.idx, client, nil, nil)
 
 				helper.AssertNoError(err, "registerBootstrapNode should not return error")
 				helper.AssertEqual(tc.expectedName, node.Name, "Node name should match")
@@ -64,6 +65,38 @@ func TestRegisterBootstrapNode(t *testing.T) {
 	})
 }
 
+func TestRegisterBootstrapNode_DualStack(t *testing.T) {
+	helper := NewTestHelper(t)
+	allocator, err := newNodeAddressAllocator(&Config{ClusterCIDR: "fd00:10:244::/56,10.244.0.0/16"})
+	helper.AssertNoError(err, "newNodeAddressAllocator should not return error")
+
+	node, err := registerBootstrapNode(1, fake.NewSimpleClientset(), allocator, nil)
+	helper.AssertNoError(err, "registerBootstrapNode should not return error")
+
+	// 主地址族为 IPv6
+	helper.AssertEqual("fd00:10:244:2::/64", node.Spec.PodCIDR, "PodCIDR should be the IPv6 cidr")
+	if len(node.Spec.PodCIDRs) != 2 || node.Spec.PodCIDRs[1] != "10.244.2.0/24" {
+		t.Errorf("Expected PodCIDRs [fd00:10:244:2::/64 10.244.2.0/24], got %v", node.Spec.PodCIDRs)
+	}
+}
+
+func TestRegisterBootstrapNode_Delegated(t *testing.T) {
+	helper := NewTestHelper(t)
+	allocator, err := newNodeAddressAllocator(&Config{DelegateNodeCIDRs: true})
+	helper.AssertNoError(err, "newNodeAddressAllocator should not return error")
+
+	// pod cidr 由 kube-controller-manager 在上次运行时分配
+	existing := &coreapi.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: BootstrapNodeName(0)},
+		Spec:       coreapi.NodeSpec{PodCIDR: "10.244.3.0/24", PodCIDRs: []string{"10.244.3.0/24"}},
+	}
+	node, err := registerBootstrapNode(0, fake.NewSimpleClientset(existing), allocator, nil)
+	helper.AssertNoError(err, "registerBootstrapNode should not return error")
+	if len(node.Spec.PodCIDRs) != 1 || node.Spec.PodCIDRs[0] != "10.244.3.0/24" {
+		t.Errorf("Expected PodCIDRs of node in cluster [10.244.3.0/24], got %v", node.Spec.PodCIDRs)
+	}
+}
+
 func TestJoinNewNode(t *testing.T) {
 	helper := NewTestHelper(t)
 
@@ -88,7 +121,7 @@ func TestJoinNewNode(t *testing.T) {
 			},
 		}
 
-		err := joinNewNode(client, node)
+		_, err := joinNewNode(client, node)
 		helper.AssertNoError(err, "joinNewNode should not return error")
 	})
 
@@ -110,8 +143,10 @@ func TestJoinNewNode(t *testing.T) {
 			},
 		}
 
-		err := joinNewNode(client, newNode)
+		joined, err := joinNewNode(client, newNode)
 		helper.AssertNoError(err, "joinNewNode should handle existing node gracefully")
+		// 返回集群中已存在的节点
+		helper.AssertEqual(types.UID("existing-uid"), joined.UID, "existing node should be returned")
 	})
 
 	t.Run("创建节点租约", func(t *testing.T) {
@@ -135,7 +170,7 @@ func TestJoinNewNode(t *testing.T) {
 			},
 		}
 
-		err := joinNewNode(client, node)
+		_, err := joinNewNode(client, node)
 		helper.AssertNoError(err, "joinNewNode should create lease successfully")
 	})
 }
@@ -145,8 +180,38 @@ func TestStaticNodeConstants(t *testing.T) {
 
 	helper.AssertEqual("kube-node-lease", KubeNamespaceNodeLease, "KubeNamespaceNodeLease constant should be correct")
 
-	testNode, err := registerBootstrapNode(0, fake.NewSimpleClientset())
+	testNode, err := registerBootstrapNode(0, fake.NewSimpleClientset(), nil, nil)
 	helper.AssertNoError(err, "registerBootstrapNode should not return error")
 	expectedName := "mock-node-0"
 	helper.AssertEqual(expectedName, testNode.Name, "StaticNodePrefix should work correctly")
 }
+
+func TestRegisterBootstrapNode_Topology(t *testing.T) {
+	helper := NewTestHelper(t)
+	topology, err := newNodeTopology("region-1/zone-a,region-1/zone-b")
+	helper.AssertNoError(err, "newNodeTopology should not return error")
+	client := fake.NewSimpleClientset()
+
+	node, err := registerBootstrapNode(1, client, nil, topology)
+	helper.AssertNoError(err, "registerBootstrapNode should not return error")
+	helper.AssertEqual("zone-b", node.Labels[coreapi.LabelTopologyZone], "zone label should match")
+	helper.AssertEqual("region-1", node.Labels[coreapi.LabelTopologyRegion], "region label should match")
+	helper.AssertEqual("zone-b", node.Labels[coreapi.LabelFailureDomainBetaZone], "deprecated zone label should match")
+	helper.AssertEqual("linux", node.Labels[coreapi.LabelOSStable], "os label should match")
+
+	// 已注册但没有 zone 的节点会补上 zone, 已有 zone 的节点保持不变
+	existing, err := registerBootstrapNode(0, client, nil, nil)
+	helper.AssertNoError(err, "registerBootstrapNode should not return error")
+	if _, ok := existing.Labels[coreapi.LabelTopologyZone]; ok {
+		t.Fatal("Expected node without zone")
+	}
+	registerBootstrapNode(0, client, nil, topology)
+	stored, _ := client.CoreV1().Nodes().Get(context.Background(), "mock-node-0", metav1.GetOptions{})
+	helper.AssertEqual("zone-a", stored.Labels[coreapi.LabelTopologyZone], "zone should be added to existing node")
+
+	other, err := newNodeTopology("region-2/zone-c")
+	helper.AssertNoError(err, "newNodeTopology should not return error")
+	registerBootstrapNode(0, client, nil, other)
+	stored, _ = client.CoreV1().Nodes().Get(context.Background(), "mock-node-0", metav1.GetOptions{})
+	helper.AssertEqual("zone-a", stored.Labels[coreapi.LabelTopologyZone], "zone of existing node should not change")
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// nodeZone represent the fault domain a node is placed in, region is optional
+type nodeZone struct {
+	region string
+	zone   string
+}
+
+// nodeTopology represent how simulated nodes are spread over zones, the nodeIdx-th node is placed in
+// zones[nodeIdx%len(zones)], so that every zone gets the same number of nodes
+type nodeTopology struct {
+	zones []nodeZone
+}
+
+// newNodeTopology parses zones like region-1/zone-a,region-1/zone-b,region-2/zone-c, the region part can be
+// omitted. nodes have no topology labels if value is empty
+func newNodeTopology(value string) (*nodeTopology, error) {
+	topology := &nodeTopology{}
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var zone nodeZone
+		if region, name, found := strings.Cut(item, "/"); found {
+			zone = nodeZone{region: region, zone: name}
+		} else {
+			zone = nodeZone{zone: item}
+		}
+		if zone.zone == "" {
+			return nil, errors.Errorf("zone of %q is empty", item)
+		}
+		for _, name := range []string{zone.region, zone.zone} {
+			if name == "" {
+				continue
+			}
+			if errs := validation.IsValidLabelValue(name); len(errs) != 0 {
+				return nil, errors.Errorf("invalid zone %q: %s", item, strings.Join(errs, ","))
+			}
+		}
+		if seen[zone.zone] {
+			return nil, errors.Errorf("zone %s is duplicated", zone.zone)
+		}
+		seen[zone.zone] = true
+		topology.zones = append(topology.zones, zone)
+	}
+	return topology, nil
+}
+
+// ValidateNodeZones returns error if zones of nodes are invalid, see newNodeTopology for the format
+func ValidateNodeZones(value string) error {
+	_, err := newNodeTopology(value)
+	return err
+}
+
+// zoneOf returns the zone of the nodeIdx-th node, false if nodes have no zones
+func (t *nodeTopology) zoneOf(nodeIdx int) (nodeZone, bool) {
+	if t == nil || len(t.zones) == 0 {
+		return nodeZone{}, false
+	}
+	return t.zones[nodeIdx%len(t.zones)], true
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestNewNodeTopology(t *testing.T) {
+	topology, err := newNodeTopology("region-1/zone-a, region-1/zone-b,zone-c")
+	if err != nil {
+		t.Fatalf("newNodeTopology should not return error: %v", err)
+	}
+	expected := []nodeZone{{"region-1", "zone-a"}, {"region-1", "zone-b"}, {"", "zone-c"}}
+	// 节点按顺序轮流分布到各个 zone
+	for idx := 0; idx < 6; idx++ {
+		zone, ok := topology.zoneOf(idx)
+		if !ok || zone != expected[idx%3] {
+			t.Errorf("Expected node %d in %+v, got %+v", idx, expected[idx%3], zone)
+		}
+	}
+
+	empty, err := newNodeTopology("")
+	if err != nil {
+		t.Fatalf("newNodeTopology should accept empty zones: %v", err)
+	}
+	if _, ok := empty.zoneOf(0); ok {
+		t.Error("Expected no zone without zones")
+	}
+	var none *nodeTopology
+	if _, ok := none.zoneOf(0); ok {
+		t.Error("Expected no zone for nil topology")
+	}
+}
+
+func TestNewNodeTopology_Invalid(t *testing.T) {
+	for _, value := range []string{"region-1/", "zone-a,zone-a", "region 1/zone-a", "zone/a/b"} {
+		if err := ValidateNodeZones(value); err == nil {
+			t.Errorf("Expected error for zones %q", value)
+		}
+	}
+}
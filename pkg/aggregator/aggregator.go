@@ -0,0 +1,270 @@
+package aggregator
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	aggregatorclientset "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// LabelManaged marks APIServices and services registered by simulator, stale ones are deleted on start
+	LabelManaged = "kube-simulator.io/apiservice"
+	// ServiceNamespace is where services pointing to local backends are created
+	ServiceNamespace = metav1.NamespaceSystem
+	// priorities are the same as the sample apiserver's, below those of built-in groups
+	groupPriorityMinimum = 1000
+	versionPriority      = 15
+)
+
+var loggerForAggregator = logrus.WithField("component", "aggregator")
+
+// APIService represent a local aggregated apiserver serving a group version, apiserver proxies requests of
+// /apis/<group>/<version> to it
+type APIService struct {
+	Group   string
+	Version string
+	// Host and Port are where the aggregated apiserver serves over https
+	Host string
+	Port int32
+}
+
+// Name is the name of the APIService, <version>.<group>
+func (s *APIService) Name() string {
+	return s.Version + "." + s.Group
+}
+
+// ServiceName is the name of the ExternalName service pointing to the aggregated apiserver, it can be referred
+// to by conversion webhooks of crds as well
+func (s *APIService) ServiceName() string {
+	name := "apiservice-" + strings.ReplaceAll(s.Name(), ".", "-")
+	if len(name) > validation.DNS1035LabelMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1035LabelMaxLength], "-")
+	}
+	return name
+}
+
+// ParseAPIService parses an APIService of <version>.<group>=https://<host>:<port>
+func ParseAPIService(spec string) (APIService, error) {
+	name, rawURL, found := strings.Cut(spec, "=")
+	if !found {
+		return APIService{}, errors.Errorf("apiservice %s should be <version>.<group>=https://<host>:<port>", spec)
+	}
+	version, group, found := strings.Cut(name, ".")
+	if !found || len(validation.IsDNS1035Label(version)) != 0 || len(validation.IsDNS1123Subdomain(group)) != 0 {
+		return APIService{}, errors.Errorf("name of apiservice %s should be <version>.<group>, e.g. v1alpha1.wardle.example.com", spec)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return APIService{}, errors.Wrapf(err, "invalid url of apiservice %s", spec)
+	}
+	// apiserver proxies /apis/<group>/<version> as it is, so there is no path
+	if u.Scheme != "https" || u.Port() == "" || (u.Path != "" && u.Path != "/") {
+		return APIService{}, errors.Errorf("url of apiservice %s should be https://<host>:<port>", spec)
+	}
+	// the host is the external name of a service, which ipv6 addresses can't be
+	if len(validation.IsDNS1123Subdomain(u.Hostname())) != 0 {
+		return APIService{}, errors.Errorf("host of apiservice %s should be a dns name or an ipv4 address", spec)
+	}
+	port, err := strconv.ParseInt(u.Port(), 10, 32)
+	if err != nil || port <= 0 || port > 65535 {
+		return APIService{}, errors.Errorf("invalid port of apiservice %s", spec)
+	}
+	return APIService{Group: group, Version: version, Host: u.Hostname(), Port: int32(port)}, nil
+}
+
+// Config represent local aggregated apiservers registered by simulator, it's disabled if there are no APIServices
+type Config struct {
+	// APIServices are specs of <version>.<group>=https://<host>:<port>
+	APIServices []string
+	// Cert is a serving certificate signed by the cluster ca, aggregated apiservers may serve with it so that
+	// apiserver verifies them with the cluster ca
+	Cert mycertutil.CertKeyPair
+	// CACertFile is the cluster ca apiserver verifies aggregated apiservers with
+	CACertFile string
+	// InsecureSkipTLSVerify lets apiserver skip verifying aggregated apiservers, e.g. with self signed certificates
+	InsecureSkipTLSVerify bool
+}
+
+// Enabled returns true if any APIService is registered
+func (c *Config) Enabled() bool {
+	return len(c.APIServices) != 0
+}
+
+// ParseAPIServices returns APIServices of config, a group version can only be served by one of them
+func (c *Config) ParseAPIServices() ([]APIService, error) {
+	services := make([]APIService, 0, len(c.APIServices))
+	names := sets.New[string]()
+	for _, spec := range c.APIServices {
+		service, err := ParseAPIService(spec)
+		if err != nil {
+			return nil, err
+		}
+		if names.Has(service.Name()) {
+			return nil, errors.Errorf("apiservice %s is registered more than once", service.Name())
+		}
+		names.Insert(service.Name())
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// Validate checks APIServices of config
+func (c *Config) Validate() error {
+	_, err := c.ParseAPIServices()
+	return err
+}
+
+// ServingNames returns names of the serving certificate: hosts of APIServices and dns names of their services,
+// apiserver verifies aggregated apiservers and conversion webhooks of services with the latter
+func (c *Config) ServingNames() []string {
+	var names []string
+	for _, spec := range c.APIServices {
+		if service, err := ParseAPIService(spec); err == nil {
+			names = append(names, service.Host, service.ServiceName()+"."+ServiceNamespace+".svc")
+		}
+	}
+	return names
+}
+
+// EnsureAPIServices registers each APIService with an ExternalName service pointing to its host, which apiserver
+// resolves to https://<host>:<port>. APIServices and services removed since the last start are deleted
+func EnsureAPIServices(ctx context.Context, client kubeclientset.Interface, aggregatorClient aggregatorclientset.Interface, config *Config, services []APIService, caBundle []byte) error {
+	labels := map[string]string{LabelManaged: "true"}
+	names, serviceNames := sets.New[string](), sets.New[string]()
+	for _, service := range services {
+		err := ensureService(ctx, client, &coreapi.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: service.ServiceName(), Namespace: ServiceNamespace, Labels: labels},
+			Spec: coreapi.ServiceSpec{
+				Type:         coreapi.ServiceTypeExternalName,
+				ExternalName: service.Host,
+				Ports:        []coreapi.ServicePort{{Name: "https", Port: service.Port, Protocol: coreapi.ProtocolTCP}},
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "register service of apiservice %s failed", service.Name())
+		}
+		serviceNames.Insert(service.ServiceName())
+
+		apiService := &apiregistrationv1.APIService{
+			ObjectMeta: metav1.ObjectMeta{Name: service.Name(), Labels: labels},
+			Spec: apiregistrationv1.APIServiceSpec{
+				Service: &apiregistrationv1.ServiceReference{
+					Namespace: ServiceNamespace,
+					Name:      service.ServiceName(),
+					Port:      ptr.To(service.Port),
+				},
+				Group:                service.Group,
+				Version:              service.Version,
+				GroupPriorityMinimum: groupPriorityMinimum,
+				VersionPriority:      versionPriority,
+			},
+		}
+		if config.InsecureSkipTLSVerify {
+			apiService.Spec.InsecureSkipTLSVerify = true
+		} else {
+			apiService.Spec.CABundle = caBundle
+		}
+		if err := ensureAPIService(ctx, aggregatorClient, apiService); err != nil {
+			return errors.Wrapf(err, "register apiservice %s failed", service.Name())
+		}
+		names.Insert(service.Name())
+		loggerForAggregator.Infof("apiservice %s is served by https://%s", service.Name(), net.JoinHostPort(service.Host, strconv.Itoa(int(service.Port))))
+	}
+	if err := deleteStaleAPIServices(ctx, aggregatorClient, names); err != nil {
+		return err
+	}
+	return deleteStaleServices(ctx, client, serviceNames)
+}
+
+func ensureService(ctx context.Context, client kubeclientset.Interface, service *coreapi.Service) error {
+	services := client.CoreV1().Services(service.Namespace)
+	existing, err := services.Get(ctx, service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = services.Create(ctx, service, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if existing.Spec.Type != coreapi.ServiceTypeExternalName {
+		return errors.Errorf("service %s/%s exists and isn't an ExternalName service", service.Namespace, service.Name)
+	}
+	service.ResourceVersion = existing.ResourceVersion
+	_, err = services.Update(ctx, service, metav1.UpdateOptions{})
+	return err
+}
+
+func ensureAPIService(ctx context.Context, client aggregatorclientset.Interface, apiService *apiregistrationv1.APIService) error {
+	apiServices := client.ApiregistrationV1().APIServices()
+	existing, err := apiServices.Get(ctx, apiService.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = apiServices.Create(ctx, apiService, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	// built-in group versions are served by apiserver itself and can't be replaced
+	if existing.Spec.Service == nil {
+		return errors.Errorf("apiservice %s is served by apiserver locally", apiService.Name)
+	}
+	apiService.ResourceVersion = existing.ResourceVersion
+	_, err = apiServices.Update(ctx, apiService, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteStaleAPIServices deletes APIServices registered by simulator except those of names
+func deleteStaleAPIServices(ctx context.Context, client aggregatorclientset.Interface, names sets.Set[string]) error {
+	apiServices := client.ApiregistrationV1().APIServices()
+	list, err := apiServices.List(ctx, metav1.ListOptions{LabelSelector: LabelManaged + "=true"})
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, apiService := range list.Items {
+		if names.Has(apiService.Name) {
+			continue
+		}
+		stale = append(stale, apiService.Name)
+		if err := apiServices.Delete(ctx, apiService.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	if len(stale) != 0 {
+		sort.Strings(stale)
+		loggerForAggregator.Infof("deleted stale apiservices %v", stale)
+	}
+	return nil
+}
+
+// deleteStaleServices deletes services registered by simulator except those of names
+func deleteStaleServices(ctx context.Context, client kubeclientset.Interface, names sets.Set[string]) error {
+	services := client.CoreV1().Services(ServiceNamespace)
+	list, err := services.List(ctx, metav1.ListOptions{LabelSelector: LabelManaged + "=true"})
+	if err != nil {
+		return err
+	}
+	for _, service := range list.Items {
+		if names.Has(service.Name) {
+			continue
+		}
+		if err := services.Delete(ctx, service.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
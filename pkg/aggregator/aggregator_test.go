@@ -0,0 +1,113 @@
+package aggregator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	aggregatorfake "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset/fake"
+)
+
+func TestParseAPIService(t *testing.T) {
+	service, err := ParseAPIService("v1alpha1.wardle.example.com=https://127.0.0.1:8443")
+	if err != nil {
+		t.Fatalf("ParseAPIService failed: %v", err)
+	}
+	expected := APIService{Group: "wardle.example.com", Version: "v1alpha1", Host: "127.0.0.1", Port: 8443}
+	if service != expected {
+		t.Errorf("Expected %+v, got %+v", expected, service)
+	}
+	if service.Name() != "v1alpha1.wardle.example.com" || service.ServiceName() != "apiservice-v1alpha1-wardle-example-com" {
+		t.Errorf("Unexpected names %s %s", service.Name(), service.ServiceName())
+	}
+
+	invalid := map[string]string{
+		"no url":      "v1alpha1.wardle.example.com",
+		"no group":    "v1alpha1=https://127.0.0.1:8443",
+		"http":        "v1alpha1.wardle.example.com=http://127.0.0.1:8443",
+		"no port":     "v1alpha1.wardle.example.com=https://127.0.0.1",
+		"path":        "v1alpha1.wardle.example.com=https://127.0.0.1:8443/apis",
+		"ipv6":        "v1alpha1.wardle.example.com=https://[::1]:8443",
+		"bad version": "V1.wardle.example.com=https://127.0.0.1:8443",
+	}
+	for name, spec := range invalid {
+		if _, err := ParseAPIService(spec); err == nil {
+			t.Errorf("Expected apiservice with %s to be rejected: %s", name, spec)
+		}
+	}
+}
+
+func TestAPIService_ServiceName(t *testing.T) {
+	service := APIService{Group: strings.Repeat("group.", 12) + "example.com", Version: "v1"}
+	// service 名称不能超过 63 个字符, 也不能以 - 结尾
+	if name := service.ServiceName(); len(name) > 63 || strings.HasSuffix(name, "-") {
+		t.Errorf("Expected a valid service name, got %s", name)
+	}
+}
+
+func TestConfig_ParseAPIServices_Duplicated(t *testing.T) {
+	config := &Config{APIServices: []string{
+		"v1alpha1.wardle.example.com=https://127.0.0.1:8443",
+		"v1alpha1.wardle.example.com=https://127.0.0.1:8444",
+	}}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected apiservice registered twice to be rejected")
+	}
+}
+
+func TestEnsureAPIServices(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{LabelManaged: "true"}
+	// 上次启动注册的 apiservice 需要被删除
+	client := fake.NewSimpleClientset(&coreapi.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "apiservice-v1-stale-example-com", Namespace: ServiceNamespace, Labels: labels},
+		Spec:       coreapi.ServiceSpec{Type: coreapi.ServiceTypeExternalName, ExternalName: "127.0.0.1"},
+	})
+	aggregatorClient := aggregatorfake.NewSimpleClientset(&apiregistrationv1.APIService{
+		ObjectMeta: metav1.ObjectMeta{Name: "v1.stale.example.com", Labels: labels},
+	})
+	config := &Config{APIServices: []string{"v1alpha1.wardle.example.com=https://localhost:8443"}}
+	services, err := config.ParseAPIServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureAPIServices(ctx, client, aggregatorClient, config, services, []byte("ca")); err != nil {
+		t.Fatalf("EnsureAPIServices failed: %v", err)
+	}
+
+	service, err := client.CoreV1().Services(ServiceNamespace).Get(ctx, "apiservice-v1alpha1-wardle-example-com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected service of apiservice to be created: %v", err)
+	}
+	if service.Spec.Type != coreapi.ServiceTypeExternalName || service.Spec.ExternalName != "localhost" {
+		t.Errorf("Expected ExternalName service to localhost, got %+v", service.Spec)
+	}
+	apiService, err := aggregatorClient.ApiregistrationV1().APIServices().Get(ctx, "v1alpha1.wardle.example.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected apiservice to be created: %v", err)
+	}
+	if ref := apiService.Spec.Service; ref == nil || ref.Name != service.Name || *ref.Port != 8443 || string(apiService.Spec.CABundle) != "ca" {
+		t.Errorf("Expected apiservice to refer to the service with the ca bundle, got %+v", apiService.Spec)
+	}
+
+	if _, err := client.CoreV1().Services(ServiceNamespace).Get(ctx, "apiservice-v1-stale-example-com", metav1.GetOptions{}); err == nil {
+		t.Error("Expected stale service to be deleted")
+	}
+	if _, err := aggregatorClient.ApiregistrationV1().APIServices().Get(ctx, "v1.stale.example.com", metav1.GetOptions{}); err == nil {
+		t.Error("Expected stale apiservice to be deleted")
+	}
+
+	// 再次启动时更新已有的对象
+	config.InsecureSkipTLSVerify = true
+	if err := EnsureAPIServices(ctx, client, aggregatorClient, config, services, []byte("ca")); err != nil {
+		t.Fatalf("EnsureAPIServices failed: %v", err)
+	}
+	apiService, _ = aggregatorClient.ApiregistrationV1().APIServices().Get(ctx, "v1alpha1.wardle.example.com", metav1.GetOptions{})
+	if !apiService.Spec.InsecureSkipTLSVerify || len(apiService.Spec.CABundle) != 0 {
+		t.Errorf("Expected apiservice to skip tls verify, got %+v", apiService.Spec)
+	}
+}
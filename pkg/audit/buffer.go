@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// paths of the simulator api, apiserver posts audit events to WebhookPath and they are listed at EventsPath
+const (
+	WebhookPath = "/audit/webhook"
+	EventsPath  = "/audit/events"
+)
+
+// DefaultBufferSize is how many audit events are kept in memory
+const DefaultBufferSize = 10000
+
+var loggerForAudit = logrus.WithField("component", "audit")
+
+// Buffer keeps the latest audit events in memory, the oldest ones are dropped once it's full
+type Buffer struct {
+	sync.Mutex
+	events []auditv1.Event
+	// next is where the next event is stored, events before it are newer than those after it once it's full
+	next int
+	full bool
+}
+
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Buffer{events: make([]auditv1.Event, size)}
+}
+
+// Add appends events to the buffer
+func (b *Buffer) Add(events ...auditv1.Event) {
+	b.Lock()
+	defer b.Unlock()
+	for _, event := range events {
+		b.events[b.next] = event
+		b.next = (b.next + 1) % len(b.events)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+}
+
+// Events returns events matching filter from the oldest to the newest, at most filter.Limit newest ones if it's set
+func (b *Buffer) Events(filter Filter) []auditv1.Event {
+	b.Lock()
+	ordered := append([]auditv1.Event{}, b.events[:b.next]...)
+	if b.full {
+		ordered = append(append([]auditv1.Event{}, b.events[b.next:]...), ordered...)
+	}
+	b.Unlock()
+
+	matched := make([]auditv1.Event, 0, len(ordered))
+	for _, event := range ordered {
+		if filter.Matches(&event) {
+			matched = append(matched, event)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+// Filter represent which audit events are listed, empty fields match all events
+type Filter struct {
+	User      string
+	Verb      string
+	Resource  string
+	Namespace string
+	Name      string
+	Stage     auditv1.Stage
+	// Since only matches events received at or after it
+	Since time.Time
+	Limit int
+}
+
+// Matches returns true if event matches all fields of filter
+func (f *Filter) Matches(event *auditv1.Event) bool {
+	if f.User != "" && event.User.Username != f.User {
+		return false
+	}
+	if f.Verb != "" && event.Verb != f.Verb {
+		return false
+	}
+	if f.Stage != "" && event.Stage != f.Stage {
+		return false
+	}
+	if !f.Since.IsZero() && event.RequestReceivedTimestamp.Time.Before(f.Since) {
+		return false
+	}
+	if f.Resource == "" && f.Namespace == "" && f.Name == "" {
+		return true
+	}
+	ref := event.ObjectRef
+	if ref == nil {
+		return false
+	}
+	resource := ref.Resource
+	if ref.Subresource != "" {
+		resource += "/" + ref.Subresource
+	}
+	return (f.Resource == "" || f.Resource == resource || f.Resource == ref.Resource) &&
+		(f.Namespace == "" || f.Namespace == ref.Namespace) &&
+		(f.Name == "" || f.Name == ref.Name)
+}
+
+// Query returns the query parameters of filter for EventsPath
+func (f *Filter) Query() url.Values {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"user": f.User, "verb": f.Verb, "resource": f.Resource, "namespace": f.Namespace, "name": f.Name, "stage": string(f.Stage),
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if !f.Since.IsZero() {
+		query.Set("since", f.Since.Format(time.RFC3339Nano))
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return query
+}
+
+// ParseFilter parses query parameters of EventsPath
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		User:      query.Get("user"),
+		Verb:      query.Get("verb"),
+		Resource:  query.Get("resource"),
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Stage:     auditv1.Stage(query.Get("stage")),
+	}
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return Filter{}, errors.Wrap(err, "invalid since")
+		}
+		filter.Since = since
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return Filter{}, errors.Errorf("invalid limit %s", value)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// WebhookHandler receives batches of audit events from the webhook backend of apiserver
+func (b *Buffer) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var list auditv1.EventList
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			loggerForAudit.WithError(err).Warn("decode audit events failed")
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		b.Add(list.Items...)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// EventsHandler lists audit events matching query parameters as an EventList, e.g.
+// /audit/events?user=system:serviceaccount:kube-system:deployment-controller&verb=create&resource=replicasets
+func (b *Buffer) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := ParseFilter(r.URL.Query())
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		simapi.WriteJSON(w, http.StatusOK, &auditv1.EventList{
+			TypeMeta: metav1.TypeMeta{APIVersion: auditv1.SchemeGroupVersion.String(), Kind: "EventList"},
+			Items:    b.Events(filter),
+		})
+	})
+}
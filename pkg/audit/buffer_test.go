@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func newTestEvent(id int, user, verb, resource, namespace, name string) auditv1.Event {
+	event := auditv1.Event{
+		AuditID:                  types.UID(fmt.Sprintf("event-%d", id)),
+		Stage:                    auditv1.StageResponseComplete,
+		Verb:                     verb,
+		User:                     authnv1.UserInfo{Username: user},
+		RequestReceivedTimestamp: metav1.NewMicroTime(time.Unix(int64(id), 0)),
+	}
+	if resource != "" {
+		resource, subresource, _ := strings.Cut(resource, "/")
+		event.ObjectRef = &auditv1.ObjectReference{Resource: resource, Subresource: subresource, Namespace: namespace, Name: name}
+	}
+	return event
+}
+
+func auditIDs(events []auditv1.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, string(event.AuditID))
+	}
+	return ids
+}
+
+func TestBuffer_Wraparound(t *testing.T) {
+	buffer := NewBuffer(3)
+	for i := 0; i < 5; i++ {
+		buffer.Add(newTestEvent(i, "alice", "get", "pods", "default", "web"))
+	}
+	// 满了之后丢弃最旧的事件, 结果从旧到新
+	if ids := fmt.Sprint(auditIDs(buffer.Events(Filter{}))); ids != "[event-2 event-3 event-4]" {
+		t.Errorf("Expected the latest 3 events, got %s", ids)
+	}
+	if ids := fmt.Sprint(auditIDs(buffer.Events(Filter{Limit: 2}))); ids != "[event-3 event-4]" {
+		t.Errorf("Expected the latest 2 events, got %s", ids)
+	}
+}
+
+func TestFilter_Matches(t *testing.T) {
+	buffer := NewBuffer(10)
+	buffer.Add(
+		newTestEvent(1, "alice", "create", "pods", "default", "web"),
+		newTestEvent(2, "bob", "update", "pods/status", "default", "web"),
+		newTestEvent(3, "alice", "list", "secrets", "kube-system", ""),
+		newTestEvent(4, "alice", "get", "", "", ""),
+	)
+	cases := []struct {
+		filter   Filter
+		expected string
+	}{
+		{Filter{User: "alice"}, "[event-1 event-3 event-4]"},
+		{Filter{Verb: "update"}, "[event-2]"},
+		// pods 同时匹配子资源, pods/status 只匹配子资源
+		{Filter{Resource: "pods"}, "[event-1 event-2]"},
+		{Filter{Resource: "pods/status"}, "[event-2]"},
+		{Filter{Namespace: "kube-system"}, "[event-3]"},
+		{Filter{Name: "web", User: "alice"}, "[event-1]"},
+		{Filter{Since: time.Unix(3, 0)}, "[event-3 event-4]"},
+		{Filter{Stage: auditv1.StageRequestReceived}, "[]"},
+	}
+	for _, c := range cases {
+		if ids := fmt.Sprint(auditIDs(buffer.Events(c.filter))); ids != c.expected {
+			t.Errorf("Expected %s for filter %+v, got %s", c.expected, c.filter, ids)
+		}
+	}
+}
+
+func TestParseFilter_Query(t *testing.T) {
+	filter := Filter{User: "alice", Verb: "create", Resource: "pods", Namespace: "default", Name: "web",
+		Stage: auditv1.StageResponseComplete, Since: time.Unix(100, 5).UTC(), Limit: 10}
+	parsed, err := ParseFilter(filter.Query())
+	if err != nil {
+		t.Fatalf("ParseFilter failed: %v", err)
+	}
+	if !parsed.Since.Equal(filter.Since) {
+		t.Errorf("Expected since %s, got %s", filter.Since, parsed.Since)
+	}
+	parsed.Since = filter.Since
+	if parsed != filter {
+		t.Errorf("Expected %+v, got %+v", filter, parsed)
+	}
+	for _, query := range []string{"limit=-1", "limit=x", "since=yesterday"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseFilter(values); err == nil {
+			t.Errorf("Expected %s to be invalid", query)
+		}
+	}
+}
+
+func TestBuffer_Handlers(t *testing.T) {
+	buffer := NewBuffer(10)
+	list := auditv1.EventList{Items: []auditv1.Event{
+		newTestEvent(1, "alice", "create", "pods", "default", "web"),
+		newTestEvent(2, "bob", "delete", "pods", "default", "web"),
+	}}
+	body, _ := json.Marshal(&list)
+	recorder := httptest.NewRecorder()
+	buffer.WebhookHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected events to be accepted, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	buffer.EventsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, EventsPath+"?user=bob", nil))
+	var listed auditv1.EventList
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode events failed: %v", err)
+	}
+	if ids := fmt.Sprint(auditIDs(listed.Items)); ids != "[event-2]" {
+		t.Errorf("Expected events of bob, got %s", ids)
+	}
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"k8s.io/apiserver/pkg/audit/policy"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// DefaultPolicy records who changed what with request bodies, and metadata of everything else except health checks
+// and heartbeats. bodies of secrets, configmaps and tokens are never recorded
+const DefaultPolicy = `apiVersion: audit.k8s.io/v1
+kind: Policy
+omitStages:
+  - RequestReceived
+rules:
+  # health checks and discovery of static documents
+  - level: None
+    nonResourceURLs:
+      - /healthz*
+      - /livez*
+      - /readyz*
+      - /version
+      - /openapi/*
+      - /metrics
+  # loopback requests of apiserver itself
+  - level: None
+    users: ["system:apiserver"]
+  # heartbeats of nodes and leader election
+  - level: None
+    verbs: ["get", "update"]
+    resources:
+      - group: coordination.k8s.io
+        resources: ["leases"]
+  # bodies may contain credentials
+  - level: Metadata
+    resources:
+      - group: ""
+        resources: ["secrets", "configmaps", "serviceaccounts/token"]
+      - group: authentication.k8s.io
+        resources: ["tokenreviews"]
+  - level: RequestResponse
+    verbs: ["create", "update", "patch", "delete", "deletecollection"]
+  - level: Metadata
+`
+
+// EnsurePolicyFile writes DefaultPolicy to file if it doesn't exist, existing policies are kept as they may be
+// customized
+func EnsurePolicyFile(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(DefaultPolicy), 0644)
+}
+
+// ValidatePolicyFile returns error if file isn't a valid audit policy
+func ValidatePolicyFile(file string) error {
+	if _, err := policy.LoadPolicyFromFile(file); err != nil {
+		return errors.Wrapf(err, "invalid audit policy %s", file)
+	}
+	return nil
+}
+
+// WriteWebhookConfig writes the kubeconfig of the webhook backend of apiserver, which posts audit events to
+// WebhookPath of the simulator api at address
+func WriteWebhookConfig(file, address string) error {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["simulator-api"] = &clientcmdapi.Cluster{Server: "http://" + address + WebhookPath}
+	config.AuthInfos["apiserver"] = &clientcmdapi.AuthInfo{}
+	config.Contexts["audit"] = &clientcmdapi.Context{Cluster: "simulator-api", AuthInfo: "apiserver"}
+	config.CurrentContext = "audit"
+	return clientcmd.WriteToFile(*config, file)
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apiserver/pkg/audit/policy"
+)
+
+func TestEnsurePolicyFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data", "audit-policy.yaml")
+	if err := EnsurePolicyFile(file); err != nil {
+		t.Fatalf("EnsurePolicyFile failed: %v", err)
+	}
+	// 默认策略需要能被 apiserver 加载
+	if err := ValidatePolicyFile(file); err != nil {
+		t.Fatalf("Expected default policy to be valid: %v", err)
+	}
+	loaded, err := policy.LoadPolicyFromFile(file)
+	if err != nil {
+		t.Fatalf("LoadPolicyFromFile failed: %v", err)
+	}
+	if len(loaded.Rules) == 0 {
+		t.Error("Expected rules in default policy")
+	}
+
+	// 已存在的策略可能被修改过, 不应被覆盖
+	custom := []byte("apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n  - level: None\n")
+	if err := os.WriteFile(file, custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsurePolicyFile(file); err != nil {
+		t.Fatalf("EnsurePolicyFile failed: %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != string(custom) {
+		t.Errorf("Expected existing policy to be kept, got %s", content)
+	}
+}
+
+func TestValidatePolicyFile_Invalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit-policy.yaml")
+	if err := os.WriteFile(file, []byte("apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n  - level: Everything\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePolicyFile(file); err == nil {
+		t.Error("Expected policy with unknown level to be invalid")
+	}
+	if err := ValidatePolicyFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected missing policy to be invalid")
+	}
+}
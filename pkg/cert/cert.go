@@ -1,8 +1,9 @@
 package cert
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
+	"time"
 
 	"github.com/pkg/errors"
 	"k8s.io/client-go/util/keyutil"
@@ -13,7 +14,11 @@ import (
 const (
 	CertificateBlockType = "CERTIFICATE"
 	PublicKeyBlockType   = "PUBLIC KEY"
-	defaultRsaKeySize    = 2048
+)
+
+const (
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+	DefaultCertValidity = 365 * 24 * time.Hour
 )
 
 // CertKeyPair represent certpair
@@ -23,17 +28,89 @@ type CertKeyPair struct {
 	CertFile string
 }
 
+// Options represent how keys and certificates are generated, zero values are replaced by defaults
+type Options struct {
+	KeyAlgorithm KeyAlgorithm
+	// CAValidity and CertValidity are lifetimes of ca certificates and the certificates signed by them
+	CAValidity   time.Duration
+	CertValidity time.Duration
+	// RenewBefore is how long before expiration certificates are renewed, it defaults to DefaultRenewBefore or a
+	// third of CertValidity if that's shorter
+	RenewBefore time.Duration
+}
+
+// Generator creates keys and certificates according to Options
+type Generator struct {
+	options Options
+}
+
+var defaultGenerator, _ = NewGenerator(Options{})
+
+func NewGenerator(options Options) (*Generator, error) {
+	algorithm, err := ParseKeyAlgorithm(string(options.KeyAlgorithm))
+	if err != nil {
+		return nil, err
+	}
+	options.KeyAlgorithm = algorithm
+	if options.CAValidity < 0 || options.CertValidity < 0 || options.RenewBefore < 0 {
+		return nil, errors.New("validity of certificates should not be negative")
+	}
+	if options.CAValidity == 0 {
+		options.CAValidity = DefaultCAValidity
+	}
+	if options.CertValidity == 0 {
+		options.CertValidity = DefaultCertValidity
+	}
+	if options.RenewBefore == 0 {
+		options.RenewBefore = min(DefaultRenewBefore, options.CertValidity/3)
+	}
+	if options.RenewBefore >= options.CertValidity {
+		return nil, errors.Errorf("certificates should be renewed in less than their validity %s", options.CertValidity)
+	}
+	return &Generator{options: options}, nil
+}
+
 func CreateCACertFiles(caCert CertKeyPair, config k8certutil.Config) error {
-	if _, _, err := TryLoadCertAndKeyFromFile(caCert.KeyFile, caCert.CertFile); err == nil {
+	return defaultGenerator.CreateCACertFiles(caCert, config)
+}
+
+func CreateGenericCertFiles(serverCert, caCert CertKeyPair, config k8certutil.Config) error {
+	return defaultGenerator.CreateGenericCertFiles(serverCert, caCert, config)
+}
+
+func CreateServiceAccountKeyAndPublicKeyFiles(privKeyFile, pubKeyFile string) error {
+	return defaultGenerator.CreateServiceAccountKeyAndPublicKeyFiles(privKeyFile, pubKeyFile)
+}
+
+// RenewBefore returns how long before expiration certificates are renewed
+func (g *Generator) RenewBefore() time.Duration {
+	return g.options.RenewBefore
+}
+
+// CreateCACertFiles creates a self-signed ca unless it's already on disk, the name of caCert is the common name
+// if config has none. a ca on disk isn't renewed since all certificates signed by it would be invalid, it fails
+// if the ca is expired or doesn't match its key
+func (g *Generator) CreateCACertFiles(caCert CertKeyPair, config k8certutil.Config) error {
+	if key, certificate, err := TryLoadCertAndKeyFromFile(caCert.KeyFile, caCert.CertFile); err == nil {
+		if err := CheckCertificate(certificate, key, nil, k8certutil.AltNames{}, 0); err != nil {
+			return errors.Wrapf(err, "ca %s is invalid, remove it to create a new one", caCert.CertFile)
+		}
+		if time.Until(certificate.NotAfter) < g.options.RenewBefore {
+			loggerForCert.Warnf("ca %s expires at %s, certificates signed by it can't be renewed after that",
+				caCert.CertFile, certificate.NotAfter.Format(time.RFC3339))
+		}
 		return nil
 	}
+	if config.CommonName == "" {
+		config.CommonName = caCert.Name
+	}
 
-	key, err := rsa.GenerateKey(rand.Reader, defaultRsaKeySize)
+	key, err := GeneratePrivateKey(g.options.KeyAlgorithm)
 	if err != nil {
-		return errors.Wrapf(err, "failed generate ras key for %s", caCert.KeyFile)
+		return errors.Wrapf(err, "failed generate %s key for %s", g.options.KeyAlgorithm, caCert.KeyFile)
 	}
 
-	certificate, err := k8certutil.NewSelfSignedCACert(config, key)
+	certificate, err := newSelfSignedCACert(key, config, g.options.CAValidity)
 	if err != nil {
 		return errors.Wrapf(err, "failed gen self-signed cert for %s", caCert.CertFile)
 	}
@@ -45,18 +122,36 @@ func CreateCACertFiles(caCert CertKeyPair, config k8certutil.Config) error {
 	return nil
 }
 
-func CreateGenericCertFiles(serverCert, caCert CertKeyPair, config k8certutil.Config) error {
-	// try load server key/certs from files first, if load success then return directlly
-	if _, _, err := TryLoadCertAndKeyFromFile(serverCert.KeyFile, serverCert.CertFile); err == nil {
-		return nil
-	}
+// CreateGenericCertFiles creates a certificate signed by caCert unless a valid one is already on disk, certificates
+// which are expiring, not signed by caCert or missing alt names of config are renewed
+func (g *Generator) CreateGenericCertFiles(serverCert, caCert CertKeyPair, config k8certutil.Config) error {
 	// load ca key and ca certs from file, if load failed, then return error
 	caKeyData, caCertData, err := TryLoadCertAndKeyFromFile(caCert.KeyFile, caCert.CertFile)
 	if err != nil {
 		return errors.Wrapf(err, "failed load ca certificated from %s/%s", caCert.KeyFile, caCert.CertFile)
 	}
+	// try load server key/certs from files first, if they are still valid then return directlly
+	if key, certificate, err := TryLoadCertAndKeyFromFile(serverCert.KeyFile, serverCert.CertFile); err == nil {
+		err = CheckCertificate(certificate, key, caCertData, config.AltNames, g.options.RenewBefore)
+		if err == nil {
+			return nil
+		}
+		loggerForCert.WithError(err).Infof("renew certificate %s", serverCert.CertFile)
+	}
+	return g.writeGenericCertFiles(serverCert, caKeyData, caCertData, config)
+}
+
+// RenewGenericCertFiles creates a new certificate signed by caCert no matter whether the one on disk is valid
+func (g *Generator) RenewGenericCertFiles(serverCert, caCert CertKeyPair, config k8certutil.Config) error {
+	caKeyData, caCertData, err := TryLoadCertAndKeyFromFile(caCert.KeyFile, caCert.CertFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed load ca certificated from %s/%s", caCert.KeyFile, caCert.CertFile)
+	}
+	return g.writeGenericCertFiles(serverCert, caKeyData, caCertData, config)
+}
 
-	keyData, certData, err := NewCertAndKey(caKeyData, caCertData, config)
+func (g *Generator) writeGenericCertFiles(serverCert CertKeyPair, caKeyData crypto.Signer, caCertData *x509.Certificate, config k8certutil.Config) error {
+	keyData, certData, err := g.NewCertAndKey(caKeyData, caCertData, config)
 	if err != nil {
 		return errors.Wrapf(err, "[%s] failed to generate newCertAndKey.", serverCert.KeyFile)
 	}
@@ -68,15 +163,21 @@ func CreateGenericCertFiles(serverCert, caCert CertKeyPair, config k8certutil.Co
 	return nil
 }
 
-func CreateServiceAccountKeyAndPublicKeyFiles(privKeyFile, pubKeyFile string) error {
+// CreateServiceAccountKeyAndPublicKeyFiles creates the key pair signing service account tokens. kube-apiserver
+// only accepts RSA and ECDSA keys for tokens, so ECDSA P-256 is used instead of Ed25519
+func (g *Generator) CreateServiceAccountKeyAndPublicKeyFiles(privKeyFile, pubKeyFile string) error {
 	if _, err := keyutil.PrivateKeyFromFile(privKeyFile); err == nil {
 		return nil
 	}
-	key, err := rsa.GenerateKey(rand.Reader, defaultRsaKeySize)
+	algorithm := g.options.KeyAlgorithm
+	if algorithm == KeyAlgorithmEd25519 {
+		algorithm = KeyAlgorithmECDSAP256
+	}
+	key, err := GeneratePrivateKey(algorithm)
 	if err != nil {
-		return errors.Wrap(err, "cannot genetate new rsa_key")
+		return errors.Wrapf(err, "cannot genetate new %s key", algorithm)
 	}
-	keyBytes, err := keyutil.MarshalPrivateKeyToPEM(key)
+	keyBytes, err := MarshalPrivateKeyToPEM(key)
 	if err != nil {
 		return errors.Wrap(err, "faild marshal privKey to bytes")
 	}
@@ -95,3 +196,18 @@ func CreateServiceAccountKeyAndPublicKeyFiles(privKeyFile, pubKeyFile string) er
 
 	return nil
 }
+
+// NewCertAndKey generates a key and a certificate for it signed by ca
+func (g *Generator) NewCertAndKey(caKey crypto.Signer, caCert *x509.Certificate, config k8certutil.Config) (crypto.Signer, *x509.Certificate, error) {
+	key, err := GeneratePrivateKey(g.options.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := newSignedCert(caKey, caCert, key, config, g.options.CertValidity, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
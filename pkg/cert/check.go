@@ -0,0 +1,70 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	k8certutil "k8s.io/client-go/util/cert"
+)
+
+// DefaultRenewBefore is how long before expiration certificates are renewed
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+var loggerForCert = logrus.WithField("component", "cert")
+
+// CheckCertificate returns why certificate should be renewed, or nil if it's still valid. certificate should match
+// key, be signed by ca, contain all alt names and not expire within renewBefore. ca is not checked if it's nil
+func CheckCertificate(certificate *x509.Certificate, key crypto.Signer, ca *x509.Certificate, altNames k8certutil.AltNames, renewBefore time.Duration) error {
+	if !KeyMatchesCertificate(key, certificate) {
+		return errors.New("private key doesn't match the certificate")
+	}
+	now := time.Now()
+	if now.Before(certificate.NotBefore) {
+		return errors.Errorf("certificate is not valid until %s", certificate.NotBefore.Format(time.RFC3339))
+	}
+	if !now.Before(certificate.NotAfter) {
+		return errors.Errorf("certificate expired at %s", certificate.NotAfter.Format(time.RFC3339))
+	}
+	if now.Add(renewBefore).After(certificate.NotAfter) {
+		return errors.Errorf("certificate expires at %s, within %s", certificate.NotAfter.Format(time.RFC3339), renewBefore)
+	}
+	if ca != nil {
+		if err := certificate.CheckSignatureFrom(ca); err != nil {
+			return errors.Wrapf(err, "certificate is not signed by ca %s", ca.Subject.CommonName)
+		}
+	}
+	for _, name := range altNames.DNSNames {
+		if !slices.Contains(certificate.DNSNames, name) {
+			return errors.Errorf("certificate doesn't contain dns name %s", name)
+		}
+	}
+	for _, ip := range altNames.IPs {
+		if ip != nil && !containsIP(certificate, ip) {
+			return errors.Errorf("certificate doesn't contain ip %s", ip)
+		}
+	}
+	return nil
+}
+
+// KeyMatchesCertificate returns true if the public key of certificate belongs to key
+func KeyMatchesCertificate(key crypto.Signer, certificate *x509.Certificate) bool {
+	if key == nil || certificate == nil {
+		return false
+	}
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(certificate.PublicKey)
+}
+
+func containsIP(certificate *x509.Certificate, ip net.IP) bool {
+	for _, item := range certificate.IPAddresses {
+		if item.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package cert
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8certutil "k8s.io/client-go/util/cert"
+)
+
+// TestCheckCertificate 测试证书有效性检查
+func TestCheckCertificate(t *testing.T) {
+	testData := createTestCertData(t)
+	altNames := k8certutil.AltNames{IPs: []net.IP{net.ParseIP("10.0.0.1")}}
+	config := NewServerCerfiticateConfig("server", altNames)
+	key, certificate, err := NewCertAndKey(testData.CAKey, testData.CACert, config)
+	require.NoError(t, err)
+
+	assert.NoError(t, CheckCertificate(certificate, key, testData.CACert, altNames, time.Hour), "有效的证书")
+	assert.Error(t, CheckCertificate(certificate, testData.ServerKey, testData.CACert, altNames, time.Hour), "私钥不匹配")
+	assert.Error(t, CheckCertificate(certificate, key, testData.CACert, altNames, 2*DefaultCertValidity), "即将过期的证书需要更新")
+	assert.Error(t, CheckCertificate(certificate, key, testData.CACert, k8certutil.AltNames{DNSNames: []string{"kubernetes"}}, time.Hour), "缺少 DNS 名称")
+	assert.Error(t, CheckCertificate(certificate, key, testData.CACert, k8certutil.AltNames{IPs: []net.IP{net.ParseIP("10.0.0.2")}}, time.Hour), "缺少 IP")
+
+	_, otherCA := generateTestCA(t)
+	assert.Error(t, CheckCertificate(certificate, key, otherCA, altNames, time.Hour), "不是由该 CA 签发")
+}
+
+// TestCreateGenericCertFiles_Renew 测试无效的证书在启动时被重新生成
+func TestCreateGenericCertFiles_Renew(t *testing.T) {
+	tempDir := createTestTempDir(t)
+	ca := CertKeyPair{Name: "ca", KeyFile: filepath.Join(tempDir, "ca.key"), CertFile: filepath.Join(tempDir, "ca.crt")}
+	server := CertKeyPair{Name: "server", KeyFile: filepath.Join(tempDir, "server.key"), CertFile: filepath.Join(tempDir, "server.crt")}
+	require.NoError(t, CreateCACertFiles(ca, NewCACertificateConfig("ca")))
+
+	oldAltNames := k8certutil.AltNames{IPs: []net.IP{net.ParseIP("10.0.0.1")}}
+	require.NoError(t, CreateGenericCertFiles(server, ca, NewServerCerfiticateConfig("server", oldAltNames)))
+	_, original, err := TryLoadCertAndKeyFromFile(server.KeyFile, server.CertFile)
+	require.NoError(t, err)
+
+	// 配置未变化时保留现有证书
+	require.NoError(t, CreateGenericCertFiles(server, ca, NewServerCerfiticateConfig("server", oldAltNames)))
+	_, current, err := TryLoadCertAndKeyFromFile(server.KeyFile, server.CertFile)
+	require.NoError(t, err)
+	assert.Equal(t, original.SerialNumber, current.SerialNumber, "有效的证书不应该被重新生成")
+
+	// IP 变化后重新生成
+	newAltNames := k8certutil.AltNames{IPs: []net.IP{net.ParseIP("10.0.0.2")}}
+	require.NoError(t, CreateGenericCertFiles(server, ca, NewServerCerfiticateConfig("server", newAltNames)))
+	key, current, err := TryLoadCertAndKeyFromFile(server.KeyFile, server.CertFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, original.SerialNumber, current.SerialNumber, "证书应该被重新生成")
+	_, caCert, err := TryLoadCertAndKeyFromFile(ca.KeyFile, ca.CertFile)
+	require.NoError(t, err)
+	assert.NoError(t, CheckCertificate(current, key, caCert, newAltNames, time.Hour))
+}
+
+// TestCreateCACertFiles_Expired 测试过期的 CA 返回错误而不是被覆盖
+func TestCreateCACertFiles_Expired(t *testing.T) {
+	tempDir := createTestTempDir(t)
+	ca := CertKeyPair{Name: "ca", KeyFile: filepath.Join(tempDir, "ca.key"), CertFile: filepath.Join(tempDir, "ca.crt")}
+	config := NewCACertificateConfig("ca")
+	config.NotBefore = time.Now().Add(-2 * time.Hour)
+	generator, err := NewGenerator(Options{CAValidity: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, generator.CreateCACertFiles(ca, config))
+
+	err = CreateCACertFiles(ca, NewCACertificateConfig("ca"))
+	require.Error(t, err, "过期的 CA 应该返回错误")
+	assert.Contains(t, err.Error(), "expired")
+}
+
+// TestNewGenerator_RenewBefore 测试更新提前量的默认值
+func TestNewGenerator_RenewBefore(t *testing.T) {
+	generator, err := NewGenerator(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultRenewBefore, generator.RenewBefore())
+
+	generator, err = NewGenerator(Options{CertValidity: 3 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, generator.RenewBefore(), "默认不超过证书有效期的三分之一")
+
+	_, err = NewGenerator(Options{CertValidity: time.Hour, RenewBefore: time.Hour})
+	assert.Error(t, err, "更新提前量不能超过有效期")
+}
@@ -0,0 +1,98 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// KeyAlgorithm represent the algorithm and size of private keys
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048   KeyAlgorithm = "RSA-2048"
+	KeyAlgorithmRSA3072   KeyAlgorithm = "RSA-3072"
+	KeyAlgorithmRSA4096   KeyAlgorithm = "RSA-4096"
+	KeyAlgorithmECDSAP256 KeyAlgorithm = "ECDSA-P256"
+	KeyAlgorithmECDSAP384 KeyAlgorithm = "ECDSA-P384"
+	KeyAlgorithmEd25519   KeyAlgorithm = "ED25519"
+
+	DefaultKeyAlgorithm = KeyAlgorithmRSA2048
+)
+
+// KeyAlgorithms are all supported key algorithms
+var KeyAlgorithms = []KeyAlgorithm{
+	KeyAlgorithmRSA2048,
+	KeyAlgorithmRSA3072,
+	KeyAlgorithmRSA4096,
+	KeyAlgorithmECDSAP256,
+	KeyAlgorithmECDSAP384,
+	KeyAlgorithmEd25519,
+}
+
+// ParseKeyAlgorithm parses value case-insensitively, empty value is the default algorithm
+func ParseKeyAlgorithm(value string) (KeyAlgorithm, error) {
+	if value == "" {
+		return DefaultKeyAlgorithm, nil
+	}
+	for _, algorithm := range KeyAlgorithms {
+		if strings.EqualFold(value, string(algorithm)) {
+			return algorithm, nil
+		}
+	}
+	names := make([]string, 0, len(KeyAlgorithms))
+	for _, algorithm := range KeyAlgorithms {
+		names = append(names, string(algorithm))
+	}
+	return "", errors.Errorf("key algorithm %s is not supported, should be one of %s", value, strings.Join(names, ","))
+}
+
+// GeneratePrivateKey generates a private key of algorithm
+func GeneratePrivateKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, errors.Errorf("key algorithm %s is not supported", algorithm)
+}
+
+// MarshalPrivateKeyToPEM encodes key in PKCS#8, which is the only format supports all key algorithms
+func MarshalPrivateKeyToPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: keyutil.PrivateKeyBlockType, Bytes: der}), nil
+}
+
+// keyUsageOf returns key usages of certificates for key, key encipherment is only meaningful for RSA keys
+func keyUsageOf(key crypto.Signer, isCA bool) x509.KeyUsage {
+	usage := x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		usage |= x509.KeyUsageKeyEncipherment
+	}
+	if isCA {
+		usage |= x509.KeyUsageCertSign
+	}
+	return usage
+}
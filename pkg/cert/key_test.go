@@ -0,0 +1,102 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// TestParseKeyAlgorithm 测试密钥算法解析
+func TestParseKeyAlgorithm(t *testing.T) {
+	algorithm, err := ParseKeyAlgorithm("ecdsa-p256")
+	require.NoError(t, err, "算法名称不区分大小写")
+	assert.Equal(t, KeyAlgorithmECDSAP256, algorithm)
+
+	algorithm, err = ParseKeyAlgorithm("")
+	require.NoError(t, err, "空值使用默认算法")
+	assert.Equal(t, DefaultKeyAlgorithm, algorithm)
+
+	_, err = ParseKeyAlgorithm("DSA-1024")
+	assert.Error(t, err, "不支持的算法应该返回错误")
+}
+
+// TestGeneratePrivateKey 测试生成各种算法的密钥
+func TestGeneratePrivateKey(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{KeyAlgorithmRSA2048, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			key, err := GeneratePrivateKey(algorithm)
+			require.NoError(t, err, "应该能生成密钥")
+			switch k := key.(type) {
+			case *rsa.PrivateKey:
+				assert.Equal(t, 2048, k.N.BitLen())
+			case *ecdsa.PrivateKey:
+				expected := map[KeyAlgorithm]int{KeyAlgorithmECDSAP256: 256, KeyAlgorithmECDSAP384: 384}[algorithm]
+				assert.Equal(t, expected, k.Curve.Params().BitSize)
+			case ed25519.PrivateKey:
+				assert.Equal(t, KeyAlgorithmEd25519, algorithm)
+			default:
+				t.Fatalf("unexpected key type %T", key)
+			}
+		})
+	}
+}
+
+// TestGenerator_Options 测试按配置的算法和有效期生成 CA 及证书
+func TestGenerator_Options(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{KeyAlgorithmECDSAP384, KeyAlgorithmEd25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			tempDir := createTestTempDir(t)
+			generator, err := NewGenerator(Options{KeyAlgorithm: algorithm, CAValidity: 48 * time.Hour, CertValidity: 2 * time.Hour})
+			require.NoError(t, err, "应该能创建 Generator")
+
+			ca := CertKeyPair{Name: "ca", KeyFile: filepath.Join(tempDir, "ca.key"), CertFile: filepath.Join(tempDir, "ca.crt")}
+			require.NoError(t, generator.CreateCACertFiles(ca, NewCACertificateConfig("kubernetes")))
+			server := CertKeyPair{Name: "server", KeyFile: filepath.Join(tempDir, "server.key"), CertFile: filepath.Join(tempDir, "server.crt")}
+			require.NoError(t, generator.CreateGenericCertFiles(server, ca, NewClientCertificateConfig("client")))
+
+			caKey, caCert, err := TryLoadCertAndKeyFromFile(ca.KeyFile, ca.CertFile)
+			require.NoError(t, err, "应该能加载 CA")
+			assert.True(t, caCert.IsCA)
+			assert.InDelta(t, (48 * time.Hour).Seconds(), caCert.NotAfter.Sub(caCert.NotBefore).Seconds(), 1, "CA 有效期应该符合配置")
+			assert.Zero(t, caCert.KeyUsage&x509.KeyUsageKeyEncipherment, "非 RSA 密钥不应该有 KeyEncipherment")
+
+			_, cert, err := TryLoadCertAndKeyFromFile(server.KeyFile, server.CertFile)
+			require.NoError(t, err, "应该能加载证书")
+			assert.InDelta(t, (2 * time.Hour).Seconds(), cert.NotAfter.Sub(cert.NotBefore).Seconds(), 1, "证书有效期应该符合配置")
+			assert.NoError(t, cert.CheckSignatureFrom(caCert), "证书应该由 CA 签发")
+			assert.IsType(t, caKey, mustGenerate(t, algorithm), "密钥类型应该符合配置")
+		})
+	}
+
+	_, err := NewGenerator(Options{KeyAlgorithm: "RSA-512"})
+	assert.Error(t, err, "不支持的算法应该返回错误")
+	_, err = NewGenerator(Options{CertValidity: -time.Hour})
+	assert.Error(t, err, "负的有效期应该返回错误")
+}
+
+// TestGenerator_ServiceAccountKey 测试 Ed25519 时 service account 密钥使用 ECDSA
+func TestGenerator_ServiceAccountKey(t *testing.T) {
+	tempDir := createTestTempDir(t)
+	generator, err := NewGenerator(Options{KeyAlgorithm: KeyAlgorithmEd25519})
+	require.NoError(t, err, "应该能创建 Generator")
+
+	privKeyPath := filepath.Join(tempDir, "sa.key")
+	require.NoError(t, generator.CreateServiceAccountKeyAndPublicKeyFiles(privKeyPath, filepath.Join(tempDir, "sa.pub")))
+	key, err := keyutil.PrivateKeyFromFile(privKeyPath)
+	require.NoError(t, err, "应该能加载 service account 密钥")
+	assert.IsType(t, &ecdsa.PrivateKey{}, key)
+}
+
+func mustGenerate(t *testing.T, algorithm KeyAlgorithm) interface{} {
+	key, err := GeneratePrivateKey(algorithm)
+	require.NoError(t, err)
+	return key
+}
@@ -0,0 +1,63 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	k8certutil "k8s.io/client-go/util/cert"
+)
+
+// KubeconfigClusterName is the name of the cluster in kubeconfigs
+const KubeconfigClusterName = "kuberntetes"
+
+// WriteCertKubeconfig writes a kubeconfig authenticating with a new client certificate of config to file
+func (g *Generator) WriteCertKubeconfig(caKey crypto.Signer, caCert *x509.Certificate, server string, config k8certutil.Config, file string) error {
+	kubeconfig, err := g.NewCertKubeconfig(caKey, caCert, server, config)
+	if err != nil {
+		return err
+	}
+	return clientcmd.WriteToFile(*kubeconfig, file)
+}
+
+// NewCertKubeconfig returns a kubeconfig authenticating with a new client certificate of config
+func (g *Generator) NewCertKubeconfig(caKey crypto.Signer, caCert *x509.Certificate, server string, config k8certutil.Config) (*clientcmdapi.Config, error) {
+	clientKey, clientCert, err := g.NewCertAndKey(caKey, caCert, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "create new cert and key failed")
+	}
+	encodedClientKey, err := MarshalPrivateKeyToPEM(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubeconfig(server, caCert, config.CommonName, &clientcmdapi.AuthInfo{
+		ClientKeyData:         encodedClientKey,
+		ClientCertificateData: EncodeCertPEM(clientCert),
+	}), nil
+}
+
+// NewKubeconfig returns a kubeconfig of user accessing apiserver at server, which is verified with caCert
+func NewKubeconfig(server string, caCert *x509.Certificate, user string, authInfo *clientcmdapi.AuthInfo) *clientcmdapi.Config {
+	contextName := fmt.Sprintf("%s@%s", user, KubeconfigClusterName)
+	return &clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			KubeconfigClusterName: {
+				Server:                   server,
+				CertificateAuthorityData: EncodeCertPEM(caCert),
+			},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			contextName: {
+				Cluster:  KubeconfigClusterName,
+				AuthInfo: user,
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			user: authInfo,
+		},
+		CurrentContext: contextName,
+	}
+}
@@ -3,6 +3,7 @@ package cert
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -22,7 +23,7 @@ func TryWriteCertAndKeyToFile(certData *x509.Certificate, keyData crypto.Signer,
 	if keyData == nil {
 		return errors.New("private cannot be nil")
 	}
-	keyEncoded, err := keyutil.MarshalPrivateKeyToPEM(keyData)
+	keyEncoded, err := MarshalPrivateKeyToPEM(keyData)
 	if err != nil {
 		return errors.Wrapf(err, "unable to marshal private key")
 	}
@@ -44,35 +45,88 @@ func TryLoadCertAndKeyFromFile(keyFile, certFile string) (crypto.Signer, *x509.C
 		return nil, nil, errors.Wrapf(err, "load file [%s] failed", certFile)
 	}
 	privKey, err := keyutil.PrivateKeyFromFile(keyFile)
-	var key crypto.Signer
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "load file [%s] failed", keyFile)
+	}
+	key, ok := signerOf(privKey)
+	if !ok {
+		return nil, nil, errors.Errorf("the private key file %s is not in RSA, ECDSA or Ed25519 format", keyFile)
+	}
+	return key, certs[0], nil
+}
+
+// ParseCertAndKeyPEM parses pem encoded key and certificate, like those embedded in kubeconfigs
+func ParseCertAndKeyPEM(keyData, certData []byte) (crypto.Signer, *x509.Certificate, error) {
+	certs, err := k8certutil.ParseCertsPEM(certData)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse certificate failed")
+	}
+	privKey, err := keyutil.ParsePrivateKeyPEM(keyData)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse private key failed")
+	}
+	key, ok := signerOf(privKey)
+	if !ok {
+		return nil, nil, errors.New("the private key is not in RSA, ECDSA or Ed25519 format")
+	}
+	return key, certs[0], nil
+}
+
+func signerOf(privKey interface{}) (crypto.Signer, bool) {
 	switch k := privKey.(type) {
 	case *rsa.PrivateKey:
-		key = k
+		return k, true
 	case *ecdsa.PrivateKey:
-		key = k
-	default:
-		return nil, nil, errors.Errorf("the private key file %s is neither in RSA nor ECDSA format", keyFile)
+		return k, true
+	case ed25519.PrivateKey:
+		return k, true
 	}
-	return key, certs[0], nil
+	return nil, false
 }
 
 func NewCertAndKey(caKey crypto.Signer, caCert *x509.Certificate, config k8certutil.Config) (crypto.Signer, *x509.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, defaultRsaKeySize)
+	return defaultGenerator.NewCertAndKey(caKey, caCert, config)
+}
+
+// newSelfSignedCACert is the same as NewSelfSignedCACert of client-go, except that the validity is configurable
+func newSelfSignedCACert(key crypto.Signer, config k8certutil.Config, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64-1))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	cert, err := newSignedCert(caKey, caCert, key, config, false)
+	notBefore := config.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	certTmpl := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   config.CommonName,
+			Organization: config.Organization,
+		},
+		DNSNames:              []string{config.CommonName},
+		SerialNumber:          serial,
+		NotBefore:             notBefore.UTC(),
+		NotAfter:              notBefore.Add(validity).UTC(),
+		KeyUsage:              keyUsageOf(key, true),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDERBytes, err := x509.CreateCertificate(rand.Reader, &certTmpl, &certTmpl, key.Public(), key)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	return key, cert, nil
+	return x509.ParseCertificate(certDERBytes)
 }
 
-func newSignedCert(caKey crypto.Signer, caCert *x509.Certificate, privKey crypto.Signer, config k8certutil.Config, isCa bool) (*x509.Certificate, error) {
+func newSignedCert(caKey crypto.Signer, caCert *x509.Certificate, privKey crypto.Signer, config k8certutil.Config, validity time.Duration, isCa bool) (*x509.Certificate, error) {
+	if caKey == nil || caCert == nil {
+		return nil, errors.New("ca key and certificate are required")
+	}
 	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64-1))
-	keyUsage := x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	if err != nil {
+		return nil, err
+	}
+	keyUsage := keyUsageOf(privKey, isCa)
 	certTmpl := x509.Certificate{
 		Subject: pkix.Name{
 			CommonName:   config.CommonName,
@@ -82,7 +136,7 @@ func newSignedCert(caKey crypto.Signer, caCert *x509.Certificate, privKey crypto
 		IPAddresses:           config.AltNames.IPs,
 		SerialNumber:          serial,
 		NotBefore:             config.NotBefore,
-		NotAfter:              config.NotBefore.Add(time.Hour * 24 * 365),
+		NotAfter:              config.NotBefore.Add(validity),
 		KeyUsage:              keyUsage,
 		ExtKeyUsage:           config.Usages,
 		BasicConstraintsValid: true,
@@ -96,6 +150,9 @@ func newSignedCert(caKey crypto.Signer, caCert *x509.Certificate, privKey crypto
 }
 
 func EncodeCertPEM(cert *x509.Certificate) []byte {
+	if cert == nil {
+		return nil
+	}
 	block := pem.Block{
 		Type:  CertificateBlockType,
 		Bytes: cert.Raw,
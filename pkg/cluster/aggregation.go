@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FrontProxyClientName is the common name of the client certificate apiserver proxies requests with
+const FrontProxyClientName = "front-proxy-client"
+
+// DefaultRequestHeader is how kubeadm configures apiserver to proxy requests to aggregated apiservers
+var DefaultRequestHeader = RequestHeader{
+	AllowedNames:        []string{FrontProxyClientName},
+	UsernameHeaders:     []string{"X-Remote-User"},
+	GroupHeaders:        []string{"X-Remote-Group"},
+	ExtraHeaderPrefixes: []string{"X-Remote-Extra-"},
+}
+
+// RequestHeader represent headers apiserver passes the user of proxied requests in, aggregated apiservers trust
+// them if the request is authenticated with a client certificate of AllowedNames signed by the front proxy ca
+type RequestHeader struct {
+	AllowedNames        []string
+	UsernameHeaders     []string
+	GroupHeaders        []string
+	ExtraHeaderPrefixes []string
+}
+
+// Validate checks headers are specified, and apiserver can proxy requests as one of the allowed names
+func (h *RequestHeader) Validate() error {
+	if len(h.AllowedNames) != 0 && !slices.Contains(h.AllowedNames, FrontProxyClientName) {
+		return errors.Errorf("allowed names should contain %s, which apiserver proxies requests as", FrontProxyClientName)
+	}
+	if len(h.UsernameHeaders) == 0 || len(h.GroupHeaders) == 0 || len(h.ExtraHeaderPrefixes) == 0 {
+		return errors.New("username headers, group headers and extra headers prefixes are required")
+	}
+	return nil
+}
+
+// aggregationArgs returns args of apiserver for the aggregation layer, apiserver proxies requests to aggregated
+// apiservers with the front proxy client certificate and publishes the front proxy ca in the
+// extension-apiserver-authentication configmap
+func aggregationArgs(config *Config) map[string]string {
+	if config.TLS.FrontProxyCA.CertFile == "" {
+		return nil
+	}
+	header := config.RequestHeader
+	return map[string]string{
+		"requestheader-client-ca-file":       config.TLS.FrontProxyCA.CertFile,
+		"requestheader-allowed-names":        strings.Join(header.AllowedNames, ","),
+		"requestheader-username-headers":     strings.Join(header.UsernameHeaders, ","),
+		"requestheader-group-headers":        strings.Join(header.GroupHeaders, ","),
+		"requestheader-extra-headers-prefix": strings.Join(header.ExtraHeaderPrefixes, ","),
+		"proxy-client-cert-file":             config.TLS.FrontProxyClient.CertFile,
+		"proxy-client-key-file":              config.TLS.FrontProxyClient.KeyFile,
+	}
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"testing"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+)
+
+func TestAggregationArgs(t *testing.T) {
+	if args := aggregationArgs(&Config{}); len(args) != 0 {
+		t.Errorf("Expected no args without front proxy ca, got %v", args)
+	}
+
+	config := &Config{RequestHeader: DefaultRequestHeader}
+	config.TLS.FrontProxyCA = mycertutil.CertKeyPair{CertFile: "/tmp/pki/front-proxy-ca.crt", KeyFile: "/tmp/pki/front-proxy-ca.key"}
+	config.TLS.FrontProxyClient = mycertutil.CertKeyPair{CertFile: "/tmp/pki/front-proxy-client.crt", KeyFile: "/tmp/pki/front-proxy-client.key"}
+	args := aggregationArgs(config)
+	expected := map[string]string{
+		"requestheader-client-ca-file":       "/tmp/pki/front-proxy-ca.crt",
+		"requestheader-allowed-names":        "front-proxy-client",
+		"requestheader-username-headers":     "X-Remote-User",
+		"requestheader-group-headers":        "X-Remote-Group",
+		"requestheader-extra-headers-prefix": "X-Remote-Extra-",
+		"proxy-client-cert-file":             "/tmp/pki/front-proxy-client.crt",
+		"proxy-client-key-file":              "/tmp/pki/front-proxy-client.key",
+	}
+	for arg, value := range expected {
+		if args[arg] != value {
+			t.Errorf("Expected %s=%s, got %q", arg, value, args[arg])
+		}
+	}
+}
+
+func TestRequestHeader_Validate(t *testing.T) {
+	header := DefaultRequestHeader
+	if err := header.Validate(); err != nil {
+		t.Errorf("Expected default request header to be valid, got %v", err)
+	}
+	// 为空时接受 front-proxy-ca 签发的任意证书
+	header.AllowedNames = []string{}
+	if err := header.Validate(); err != nil {
+		t.Errorf("Expected empty allowed names to be valid, got %v", err)
+	}
+	// apiserver 以 front-proxy-client 的身份转发请求
+	header.AllowedNames = []string{"aggregator"}
+	if err := header.Validate(); err == nil {
+		t.Error("Expected allowed names without front-proxy-client to be rejected")
+	}
+	header = DefaultRequestHeader
+	header.UsernameHeaders = nil
+	if err := header.Validate(); err == nil {
+		t.Error("Expected request header without username headers to be rejected")
+	}
+}
@@ -0,0 +1,29 @@
+package cluster
+
+// Audit represent audit logging of apiserver, it's disabled if PolicyFile is empty
+type Audit struct {
+	PolicyFile string
+	// LogPath is the file audit events are written to, - for stdout
+	LogPath string
+	// WebhookConfigFile is the kubeconfig of the webhook audit events are posted to in batches
+	WebhookConfigFile string
+}
+
+// auditArgs returns args of apiserver for audit backends, batches of the webhook are sent every second so that
+// events can be queried right after requests
+func auditArgs(config *Config) map[string]string {
+	audit := config.Audit
+	if audit.PolicyFile == "" {
+		return nil
+	}
+	args := map[string]string{"audit-policy-file": audit.PolicyFile}
+	if audit.LogPath != "" {
+		args["audit-log-path"] = audit.LogPath
+	}
+	if audit.WebhookConfigFile != "" {
+		args["audit-webhook-config-file"] = audit.WebhookConfigFile
+		args["audit-webhook-mode"] = "batch"
+		args["audit-webhook-batch-max-wait"] = "1s"
+	}
+	return args
+}
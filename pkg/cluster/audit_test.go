@@ -0,0 +1,27 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestAuditArgs(t *testing.T) {
+	if args := auditArgs(&Config{Audit: Audit{LogPath: "-"}}); len(args) != 0 {
+		t.Errorf("Expected no args without policy, got %v", args)
+	}
+
+	args := auditArgs(&Config{Audit: Audit{PolicyFile: "/tmp/audit-policy.yaml", WebhookConfigFile: "/tmp/audit-webhook.conf"}})
+	expected := map[string]string{
+		"audit-policy-file":         "/tmp/audit-policy.yaml",
+		"audit-webhook-config-file": "/tmp/audit-webhook.conf",
+		"audit-webhook-mode":        "batch",
+	}
+	for arg, value := range expected {
+		if args[arg] != value {
+			t.Errorf("Expected %s=%s, got %q", arg, value, args[arg])
+		}
+	}
+	// 未设置日志路径时不写审计日志
+	if _, ok := args["audit-log-path"]; ok {
+		t.Errorf("Expected no audit-log-path, got %v", args)
+	}
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import "strings"
+
+// Authentication represent authenticators of apiserver besides client certificates and service account tokens
+type Authentication struct {
+	// TokenAuthFile is a csv file of static tokens, lines are token,user,uid,"group1,group2"
+	TokenAuthFile string
+	// WebhookConfigFile is the kubeconfig of the webhook TokenReviews are sent to
+	WebhookConfigFile string
+	OIDC              OIDCAuthentication
+}
+
+// OIDCAuthentication represent the oidc issuer apiserver trusts id tokens of, it's disabled if IssuerURL is empty
+type OIDCAuthentication struct {
+	IssuerURL string
+	ClientID  string
+	// CAFile verifies the serving certificate of issuer
+	CAFile         string
+	UsernameClaim  string
+	GroupsClaim    string
+	UsernamePrefix string
+	GroupsPrefix   string
+	SigningAlgs    []string
+}
+
+// authenticationArgs returns args of apiserver for the configured authenticators
+func authenticationArgs(config *Config) map[string]string {
+	authn := config.Authentication
+	args := make(map[string]string)
+	if authn.TokenAuthFile != "" {
+		args["token-auth-file"] = authn.TokenAuthFile
+	}
+	if authn.WebhookConfigFile != "" {
+		args["authentication-token-webhook-config-file"] = authn.WebhookConfigFile
+	}
+	if oidc := authn.OIDC; oidc.IssuerURL != "" {
+		args["oidc-issuer-url"] = oidc.IssuerURL
+		args["oidc-client-id"] = oidc.ClientID
+		args["oidc-ca-file"] = oidc.CAFile
+		args["oidc-username-claim"] = oidc.UsernameClaim
+		args["oidc-groups-claim"] = oidc.GroupsClaim
+		args["oidc-username-prefix"] = oidc.UsernamePrefix
+		args["oidc-groups-prefix"] = oidc.GroupsPrefix
+		args["oidc-signing-algs"] = strings.Join(oidc.SigningAlgs, ",")
+	}
+	// empty values are left to the defaults of apiserver
+	for arg, value := range args {
+		if value == "" {
+			delete(args, arg)
+		}
+	}
+	return args
+}
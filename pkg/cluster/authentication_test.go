@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestAuthenticationArgs(t *testing.T) {
+	if args := authenticationArgs(&Config{}); len(args) != 0 {
+		t.Errorf("Expected no args without authenticators, got %v", args)
+	}
+
+	config := &Config{Authentication: Authentication{
+		TokenAuthFile:     "/tmp/tokens.csv",
+		WebhookConfigFile: "/tmp/authn-webhook.conf",
+		OIDC: OIDCAuthentication{
+			IssuerURL:      "https://127.0.0.1:10281",
+			ClientID:       "kube-simulator",
+			CAFile:         "/tmp/ca.crt",
+			UsernameClaim:  "sub",
+			UsernamePrefix: "oidc:",
+			SigningAlgs:    []string{"RS256", "ES256"},
+		},
+	}}
+	args := authenticationArgs(config)
+	expected := map[string]string{
+		"token-auth-file":                          "/tmp/tokens.csv",
+		"authentication-token-webhook-config-file": "/tmp/authn-webhook.conf",
+		"oidc-issuer-url":                          "https://127.0.0.1:10281",
+		"oidc-client-id":                           "kube-simulator",
+		"oidc-ca-file":                             "/tmp/ca.crt",
+		"oidc-username-claim":                      "sub",
+		"oidc-username-prefix":                     "oidc:",
+		"oidc-signing-algs":                        "RS256,ES256",
+	}
+	for arg, value := range expected {
+		if args[arg] != value {
+			t.Errorf("Expected %s=%s, got %q", arg, value, args[arg])
+		}
+	}
+	// 未设置的参数使用 apiserver 的默认值
+	if _, ok := args["oidc-groups-claim"]; ok {
+		t.Errorf("Expected empty groups claim to be omitted, got %v", args)
+	}
+}
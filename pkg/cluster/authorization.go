@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/kubeapiserver/authorizer/modes"
+)
+
+// DefaultAuthorizationMode is the authorization chain of apiserver, the same as kubeadm
+const DefaultAuthorizationMode = modes.ModeNode + "," + modes.ModeRBAC
+
+// supportedAuthorizationModes are modes simulator can configure, ABAC isn't supported since it needs a policy file
+var supportedAuthorizationModes = []string{modes.ModeAlwaysAllow, modes.ModeAlwaysDeny, modes.ModeNode, modes.ModeRBAC, modes.ModeWebhook}
+
+// ParseAuthorizationModes parses comma separated authorization modes which are tried in order, empty value is the
+// default chain. webhookConfigFile is required by the Webhook mode
+func ParseAuthorizationModes(mode, webhookConfigFile string) ([]string, error) {
+	if mode == "" {
+		mode = DefaultAuthorizationMode
+	}
+	var authModes []string
+	for _, item := range strings.Split(mode, ",") {
+		item = strings.TrimSpace(item)
+		if !sets.New(supportedAuthorizationModes...).Has(item) {
+			return nil, errors.Errorf("authorization mode %q is not supported, should be one of %s", item, strings.Join(supportedAuthorizationModes, ","))
+		}
+		if sets.New(authModes...).Has(item) {
+			return nil, errors.Errorf("authorization mode %s is duplicated", item)
+		}
+		authModes = append(authModes, item)
+	}
+	hasWebhook := sets.New(authModes...).Has(modes.ModeWebhook)
+	if hasWebhook && webhookConfigFile == "" {
+		return nil, errors.New("authorization webhook config file is required by the Webhook mode")
+	}
+	if !hasWebhook && webhookConfigFile != "" {
+		return nil, errors.New("authorization webhook config file is only used by the Webhook mode")
+	}
+	return authModes, nil
+}
+
+// AuthorizesNodes returns true if requests of nodes may be allowed by authModes, RBAC alone doesn't allow them since
+// the system:node role isn't bound to group system:nodes
+func AuthorizesNodes(authModes []string) bool {
+	return slices.ContainsFunc(authModes, func(mode string) bool {
+		return mode == modes.ModeNode || mode == modes.ModeAlwaysAllow || mode == modes.ModeWebhook
+	})
+}
+
+// authorizationArgs returns args of apiserver for the authorization chain, NodeRestriction admission is enabled
+// along with the Node mode so that nodes can only modify themselves and their pods like in kubeadm clusters
+func authorizationArgs(config *Config) (map[string]string, error) {
+	authModes, err := ParseAuthorizationModes(config.AuthorizationMode, config.AuthorizationWebhookConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]string{"authorization-mode": strings.Join(authModes, ",")}
+	if config.AuthorizationWebhookConfigFile != "" {
+		args["authorization-webhook-config-file"] = config.AuthorizationWebhookConfigFile
+	}
+	if sets.New(authModes...).Has(modes.ModeNode) {
+		args["enable-admission-plugins"] = "NodeRestriction"
+	}
+	return args, nil
+}
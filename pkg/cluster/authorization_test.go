@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestParseAuthorizationModes(t *testing.T) {
+	authModes, err := ParseAuthorizationModes("", "")
+	if err != nil || len(authModes) != 2 || authModes[0] != "Node" || authModes[1] != "RBAC" {
+		t.Errorf("Expected default chain Node,RBAC, got %v %v", authModes, err)
+	}
+	if _, err := ParseAuthorizationModes("Node,Webhook,RBAC", "/tmp/webhook.conf"); err != nil {
+		t.Errorf("Expected webhook chain to be valid, got %v", err)
+	}
+
+	invalid := []struct {
+		mode, webhookConfigFile string
+	}{
+		{"ABAC", ""},
+		{"RBAC,RBAC", ""},
+		{"Node,Webhook", ""},
+		{"AlwaysAllow", "/tmp/webhook.conf"},
+		{"RBAC,", ""},
+	}
+	for _, tc := range invalid {
+		if _, err := ParseAuthorizationModes(tc.mode, tc.webhookConfigFile); err == nil {
+			t.Errorf("Expected mode %q with webhook config %q to be invalid", tc.mode, tc.webhookConfigFile)
+		}
+	}
+}
+
+func TestAuthorizationArgs(t *testing.T) {
+	args, err := authorizationArgs(&Config{})
+	if err != nil {
+		t.Fatalf("authorizationArgs failed: %v", err)
+	}
+	if args["authorization-mode"] != DefaultAuthorizationMode || args["enable-admission-plugins"] != "NodeRestriction" {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	args, err = authorizationArgs(&Config{AuthorizationMode: "Webhook,AlwaysAllow", AuthorizationWebhookConfigFile: "/tmp/webhook.conf"})
+	if err != nil {
+		t.Fatalf("authorizationArgs failed: %v", err)
+	}
+	if args["authorization-webhook-config-file"] != "/tmp/webhook.conf" || args["enable-admission-plugins"] != "" {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
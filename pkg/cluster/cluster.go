@@ -3,9 +3,11 @@ package cluster
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	kuberesource "3Xpl0it3r.com/kube-simulator/pkg/kuberes"
+	"3Xpl0it3r.com/kube-simulator/pkg/util"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	apiserverapp "k8s.io/kubernetes/cmd/kube-apiserver/app"
@@ -20,7 +22,9 @@ var (
 
 func Run(config *Config) error {
 	// run apiserver async
-	runApiServer(config)
+	if err := runApiServer(config); err != nil {
+		return errors.Wrap(err, "start apiserver failed")
+	}
 	if err := waitForApiServerRunning(config); err != nil {
 		return errors.Wrap(err, "wait apiserver ready failed")
 	}
@@ -31,7 +35,7 @@ func Run(config *Config) error {
 }
 
 // sync run apiserver
-func runApiServer(config *Config) {
+func runApiServer(config *Config) error {
 	argsMap := map[string]string{
 		"secure-port":                      config.ListenPort,
 		"advertise-address":                config.ListenHost,
@@ -44,12 +48,31 @@ func runApiServer(config *Config) {
 		"service-account-issuer":           "https://kubernetes.default.svc.cluster.local",
 		"service-account-key-file":         config.TLS.ServiceAccountKeyFile,
 		"service-account-signing-key-file": config.TLS.ServiceAccountSigningKeyFile,
-		"authorization-mode":               config.AuthorizationMode,
 		"etcd-cafile":                      config.TLS.EtcdCA,
 		"etcd-certfile":                    config.TLS.EtcdClient.CertFile,
 		"etcd-keyfile":                     config.TLS.EtcdClient.KeyFile,
 		"etcd-servers":                     "127.0.0.1:2379",
 	}
+	authorization, err := authorizationArgs(config)
+	if err != nil {
+		return err
+	}
+	for arg, value := range authorization {
+		argsMap[arg] = value
+	}
+	for arg, value := range authenticationArgs(config) {
+		argsMap[arg] = value
+	}
+	for arg, value := range auditArgs(config) {
+		argsMap[arg] = value
+	}
+	for arg, value := range aggregationArgs(config) {
+		argsMap[arg] = value
+	}
+	if config.EncryptionProviderConfigFile != "" {
+		argsMap["encryption-provider-config"] = config.EncryptionProviderConfigFile
+		argsMap["encryption-provider-config-automatic-reload"] = "true"
+	}
 
 	args := GetArgsList(argsMap, nil)
 
@@ -60,6 +83,7 @@ func runApiServer(config *Config) {
 		loggerForApiServer.Infof("Running kube-apiserver %s", args)
 		loggerForApiServer.Fatalf("apiserver existed %v", command.Execute())
 	}()
+	return nil
 }
 
 func runScheduler(config *Config) {
@@ -88,10 +112,23 @@ func runControllerManager(config *Config) {
 		"cluster-signing-key-file":         config.TLS.CA.KeyFile,
 		"service-account-private-key-file": config.TLS.ServiceAccountSigningKeyFile,
 		"cluster-cidr":                     config.ClusterCIDR,
+		"service-cluster-ip-range":         config.ServiceCIDR,
 		"controllers":                      "*,bootstrapsigner,tokencleaner",
-		"allocate-node-cidrs":              "false",
+		"allocate-node-cidrs":              strconv.FormatBool(config.AllocateNodeCIDRs),
 		"use-service-account-credentials":  "true",
 	}
+	if config.AllocateNodeCIDRs {
+		// mask size flags are only accepted for the families of cluster cidr
+		clusterCIDRs, _ := util.ParseDualStackCIDRs(config.ClusterCIDR)
+		for _, clusterCIDR := range clusterCIDRs {
+			if clusterCIDR.Addr().Is4() && config.NodeCIDRMaskSizeIPv4 != 0 {
+				argsMap["node-cidr-mask-size-ipv4"] = strconv.Itoa(config.NodeCIDRMaskSizeIPv4)
+			}
+			if clusterCIDR.Addr().Is6() && config.NodeCIDRMaskSizeIPv6 != 0 {
+				argsMap["node-cidr-mask-size-ipv6"] = strconv.Itoa(config.NodeCIDRMaskSizeIPv6)
+			}
+		}
+	}
 
 	args := GetArgsList(argsMap, nil)
 
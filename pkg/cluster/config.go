@@ -6,15 +6,30 @@ import (
 
 // Config represent config
 type Config struct {
-	ListenHost            string
-	ListenPort            string
+	ListenHost string
+	ListenPort string
+	// AuthorizationMode is the comma separated authorization chain of apiserver, DefaultAuthorizationMode if it's empty
 	AuthorizationMode     string
 	ServiceClusterIpRange string
 	EtcdServers           string
 	ClusterCIDR           string
 	ServiceCIDR           string
-	ClientConfigFile      ClientConfigFile
-	TLS                   TLS
+	// AllocateNodeCIDRs let the nodeipam controller allocate pod cidrs of nodes out of ClusterCIDR
+	AllocateNodeCIDRs    bool
+	NodeCIDRMaskSizeIPv4 int
+	NodeCIDRMaskSizeIPv6 int
+	ClientConfigFile     ClientConfigFile
+	TLS                  TLS
+	// CertSANs are extra ips and dns names of the apiserver certificate
+	CertSANs       []string
+	Authentication Authentication
+	Audit          Audit
+	// AuthorizationWebhookConfigFile is the kubeconfig of the authorization webhook, required by the Webhook mode
+	AuthorizationWebhookConfigFile string
+	// EncryptionProviderConfigFile is the EncryptionConfiguration of resources at rest, it's reloaded on changes
+	EncryptionProviderConfigFile string
+	// RequestHeader is how apiserver passes users of requests proxied to aggregated apiservers
+	RequestHeader RequestHeader
 }
 
 type ClientConfigFile struct {
@@ -30,4 +45,7 @@ type TLS struct {
 	CA                           mycertutil.CertKeyPair
 	EtcdCA                       string
 	EtcdClient                   mycertutil.CertKeyPair
+	// FrontProxyCA signs FrontProxyClient, which apiserver proxies requests to aggregated apiservers with
+	FrontProxyCA     mycertutil.CertKeyPair
+	FrontProxyClient mycertutil.CertKeyPair
 }
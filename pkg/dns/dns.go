@@ -0,0 +1,266 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+	"k8s.io/client-go/informers"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultListen is the address cluster dns listens on, both udp and tcp
+	DefaultListen = "127.0.0.1:10053"
+	// DefaultDomain is the cluster domain, the same as kubelet defaults
+	DefaultDomain = "cluster.local"
+	// DefaultTTL is the ttl of answers, the same as the kubernetes plugin of CoreDNS
+	DefaultTTL = 5
+
+	// maxUDPSize is the size of udp responses if client doesn't support EDNS0
+	maxUDPSize = 512
+	// maxEDNSSize is the largest udp response we send to EDNS0 clients
+	maxEDNSSize = 1232
+)
+
+var loggerForDNS = logrus.WithField("component", "cluster-dns")
+
+// Config represent config of cluster dns
+type Config struct {
+	Listen string
+	Domain string
+}
+
+// Server answers dns queries of services and pods from informer caches, like CoreDNS does in real clusters
+type Server struct {
+	listen string
+	domain string
+	ttl    uint32
+
+	factory  informers.SharedInformerFactory
+	services corelisters.ServiceLister
+	slices   discoverylisters.EndpointSliceLister
+	pods     corelisters.PodLister
+	// indexers to find objects by ip for reverse lookups
+	serviceIndexer cache.Indexer
+	sliceIndexer   cache.Indexer
+	podIndexer     cache.Indexer
+}
+
+func NewServer(client kubeclientset.Interface, config Config) (*Server, error) {
+	listen, domain := config.Listen, config.Domain
+	if listen == "" {
+		listen = DefaultListen
+	}
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	services := factory.Core().V1().Services().Informer()
+	slices := factory.Discovery().V1().EndpointSlices().Informer()
+	pods := factory.Core().V1().Pods().Informer()
+	if err := services.AddIndexers(cache.Indexers{indexByIP: serviceIPs}); err != nil {
+		return nil, err
+	}
+	if err := slices.AddIndexers(cache.Indexers{indexByIP: endpointSliceIPs}); err != nil {
+		return nil, err
+	}
+	if err := pods.AddIndexers(cache.Indexers{indexByIP: podIPs}); err != nil {
+		return nil, err
+	}
+	return &Server{
+		listen:         listen,
+		domain:         strings.ToLower(strings.Trim(domain, ".")),
+		ttl:            DefaultTTL,
+		factory:        factory,
+		services:       factory.Core().V1().Services().Lister(),
+		slices:         factory.Discovery().V1().EndpointSlices().Lister(),
+		pods:           factory.Core().V1().Pods().Lister(),
+		serviceIndexer: services.GetIndexer(),
+		sliceIndexer:   slices.GetIndexer(),
+		podIndexer:     pods.GetIndexer(),
+	}, nil
+}
+
+// Run syncs caches and serves dns on both udp and tcp until ctx is done, it returns once server is ready
+func (s *Server) Run(ctx context.Context) error {
+	s.factory.Start(ctx.Done())
+	for informerType, synced := range s.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("sync cache of %v failed", informerType)
+		}
+	}
+	udpConn, err := net.ListenPacket("udp", s.listen)
+	if err != nil {
+		return errors.Wrapf(err, "listen udp %s failed", s.listen)
+	}
+	tcpListener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		udpConn.Close()
+		return errors.Wrapf(err, "listen tcp %s failed", s.listen)
+	}
+	go func() {
+		<-ctx.Done()
+		udpConn.Close()
+		tcpListener.Close()
+	}()
+	go s.serveUDP(udpConn)
+	go s.serveTCP(tcpListener)
+	loggerForDNS.Infof("cluster dns for domain %s listen on %s", s.domain, s.listen)
+	return nil
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			loggerForDNS.WithError(err).Warn("read udp query failed")
+			continue
+		}
+		resp, err := s.handle(buf[:n], true)
+		if err != nil {
+			loggerForDNS.WithError(err).Debugf("drop invalid query from %s", addr)
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			loggerForDNS.WithError(err).Debugf("write response to %s failed", addr)
+		}
+	}
+}
+
+func (s *Server) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			loggerForDNS.WithError(err).Warn("accept tcp connection failed")
+			continue
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+// serveTCPConn answers queries of conn, each message is prefixed by its length in two bytes
+func (s *Server) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		resp, err := s.handle(query, false)
+		if err != nil {
+			return
+		}
+		if err := binary.Write(conn, binary.BigEndian, uint16(len(resp))); err != nil {
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers query, udp responses larger than the size the client accepts are truncated
+func (s *Server) handle(query []byte, udp bool) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errors.New("message is not a query")
+	}
+	question, err := parser.Question()
+	if err != nil && err != dnsmessage.ErrSectionDone {
+		return nil, err
+	}
+	hasQuestion := err == nil
+	ednsSize := 0
+	if err := parser.SkipAllQuestions(); err == nil {
+		if err := parser.SkipAllAnswers(); err == nil {
+			if err := parser.SkipAllAuthorities(); err == nil {
+				additionals, _ := parser.AllAdditionals()
+				for _, additional := range additionals {
+					if additional.Header.Type == dnsmessage.TypeOPT {
+						ednsSize = int(additional.Header.Class)
+					}
+				}
+			}
+		}
+	}
+
+	respHeader := dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		OpCode:             header.OpCode,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: false,
+	}
+	var answers, extras []dnsmessage.Resource
+	switch {
+	case !hasQuestion:
+		respHeader.RCode = dnsmessage.RCodeFormatError
+	case header.OpCode != 0:
+		respHeader.RCode = dnsmessage.RCodeNotImplemented
+	case question.Class != dnsmessage.ClassINET && question.Class != dnsmessage.ClassANY:
+		respHeader.RCode = dnsmessage.RCodeRefused
+	default:
+		var authoritative bool
+		answers, extras, respHeader.RCode, authoritative = s.answer(question)
+		respHeader.Authoritative = authoritative
+	}
+
+	limit := 0
+	if udp {
+		limit = maxUDPSize
+		if ednsSize > limit {
+			limit = min(ednsSize, maxEDNSSize)
+		}
+	}
+	resp, err := buildMessage(respHeader, question, hasQuestion, answers, extras, ednsSize != 0)
+	if err != nil {
+		return nil, err
+	}
+	if limit != 0 && len(resp) > limit {
+		// the client should retry over tcp
+		respHeader.Truncated = true
+		return buildMessage(respHeader, question, hasQuestion, nil, nil, ednsSize != 0)
+	}
+	return resp, nil
+}
+
+func buildMessage(header dnsmessage.Header, question dnsmessage.Question, hasQuestion bool, answers, extras []dnsmessage.Resource, edns bool) ([]byte, error) {
+	msg := dnsmessage.Message{Header: header, Answers: answers, Additionals: extras}
+	if hasQuestion {
+		msg.Questions = []dnsmessage.Question{question}
+	}
+	if edns {
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(maxEDNSSize, header.RCode, false); err != nil {
+			return nil, err
+		}
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{Header: opt, Body: &dnsmessage.OPTResource{}})
+	}
+	return msg.Pack()
+}
@@ -0,0 +1,306 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	coreapi "k8s.io/api/core/v1"
+	discoveryapi "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newTestServer(t *testing.T, objects ...runtime.Object) *Server {
+	t.Helper()
+	server, err := NewServer(fake.NewSimpleClientset(objects...), Config{Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("NewServer should not return error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	server.factory.Start(ctx.Done())
+	server.factory.WaitForCacheSync(ctx.Done())
+	return server
+}
+
+func query(t *testing.T, server *Server, name string, qtype dnsmessage.Type, udp bool) *dnsmessage.Message {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack query failed: %v", err)
+	}
+	resp, err := server.handle(packed, udp)
+	if err != nil {
+		t.Fatalf("handle should not return error: %v", err)
+	}
+	var answer dnsmessage.Message
+	if err := answer.Unpack(resp); err != nil {
+		t.Fatalf("Unpack response failed: %v", err)
+	}
+	if answer.Header.ID != 42 || !answer.Header.Response {
+		t.Fatalf("Unexpected header %+v", answer.Header)
+	}
+	return &answer
+}
+
+// answersOf returns answers as strings, e.g. A 10.96.0.10, SRV 80 nginx.default.svc.cluster.local.
+func answersOf(resources []dnsmessage.Resource) []string {
+	var answers []string
+	for _, resource := range resources {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, "A "+netip.AddrFrom4(body.A).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, "AAAA "+netip.AddrFrom16(body.AAAA).String())
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, "CNAME "+body.CNAME.String())
+		case *dnsmessage.PTRResource:
+			answers = append(answers, "PTR "+body.PTR.String())
+		case *dnsmessage.SRVResource:
+			answers = append(answers, fmt.Sprintf("SRV %d %s", body.Port, body.Target.String()))
+		}
+	}
+	return answers
+}
+
+func assertAnswers(t *testing.T, msg *dnsmessage.Message, expected ...string) {
+	t.Helper()
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		t.Fatalf("Expected success, got %v", msg.Header.RCode)
+	}
+	if fmt.Sprint(answersOf(msg.Answers)) != fmt.Sprint(expected) {
+		t.Errorf("Expected answers %v, got %v", expected, answersOf(msg.Answers))
+	}
+}
+
+func newService(name, clusterIP string, clusterIPs ...string) *coreapi.Service {
+	if len(clusterIPs) == 0 {
+		clusterIPs = []string{clusterIP}
+	}
+	return &coreapi.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: coreapi.ServiceSpec{
+			ClusterIP:  clusterIP,
+			ClusterIPs: clusterIPs,
+			Ports:      []coreapi.ServicePort{{Name: "http", Port: 80, Protocol: coreapi.ProtocolTCP}},
+		},
+	}
+}
+
+func newEndpointSlice(service string, hostnames map[string]string, ips ...string) *discoveryapi.EndpointSlice {
+	slice := &discoveryapi.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryapi.LabelServiceName: service},
+		},
+		AddressType: discoveryapi.AddressTypeIPv4,
+		Ports:       []discoveryapi.EndpointPort{{Name: ptr.To("http"), Port: ptr.To(int32(8080)), Protocol: ptr.To(coreapi.ProtocolTCP)}},
+	}
+	for _, ip := range ips {
+		endpoint := discoveryapi.Endpoint{Addresses: []string{ip}, Conditions: discoveryapi.EndpointConditions{Ready: ptr.To(true)}}
+		if hostname, ok := hostnames[ip]; ok {
+			endpoint.Hostname = ptr.To(hostname)
+		}
+		slice.Endpoints = append(slice.Endpoints, endpoint)
+	}
+	return slice
+}
+
+func TestServer_Service(t *testing.T) {
+	server := newTestServer(t,
+		newService("nginx", "10.96.0.20"),
+		newService("dual", "10.96.0.21", "10.96.0.21", "fd00:10:96::21"),
+	)
+
+	assertAnswers(t, query(t, server, "nginx.default.svc.cluster.local.", dnsmessage.TypeA, true), "A 10.96.0.20")
+	// 名称不区分大小写
+	assertAnswers(t, query(t, server, "NGINX.default.svc.Cluster.Local.", dnsmessage.TypeA, true), "A 10.96.0.20")
+	assertAnswers(t, query(t, server, "dual.default.svc.cluster.local.", dnsmessage.TypeAAAA, true), "AAAA fd00:10:96::21")
+	// 没有对应类型的记录时返回空结果
+	assertAnswers(t, query(t, server, "nginx.default.svc.cluster.local.", dnsmessage.TypeAAAA, true))
+
+	msg := query(t, server, "_http._tcp.nginx.default.svc.cluster.local.", dnsmessage.TypeSRV, true)
+	assertAnswers(t, msg, "SRV 80 nginx.default.svc.cluster.local.")
+	if fmt.Sprint(answersOf(msg.Additionals)) != "[A 10.96.0.20]" {
+		t.Errorf("Expected address of srv target in additionals, got %v", answersOf(msg.Additionals))
+	}
+
+	if msg := query(t, server, "missing.default.svc.cluster.local.", dnsmessage.TypeA, true); msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected NXDOMAIN for missing service, got %v", msg.Header.RCode)
+	}
+	if msg := query(t, server, "_https._tcp.nginx.default.svc.cluster.local.", dnsmessage.TypeSRV, true); msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected NXDOMAIN for missing port, got %v", msg.Header.RCode)
+	}
+	// 集群域名之外的名称不做递归解析
+	if msg := query(t, server, "example.com.", dnsmessage.TypeA, true); msg.Header.RCode != dnsmessage.RCodeRefused {
+		t.Errorf("Expected REFUSED for names out of cluster domain, got %v", msg.Header.RCode)
+	}
+}
+
+func TestServer_ExternalName(t *testing.T) {
+	svc := newService("external", "")
+	svc.Spec.Type = coreapi.ServiceTypeExternalName
+	svc.Spec.ExternalName = "example.com"
+	server := newTestServer(t, svc)
+
+	assertAnswers(t, query(t, server, "external.default.svc.cluster.local.", dnsmessage.TypeA, true), "CNAME example.com.")
+}
+
+func TestServer_HeadlessService(t *testing.T) {
+	headless := newService("db", coreapi.ClusterIPNone)
+	slice := newEndpointSlice("db", map[string]string{"10.244.1.2": "db-0"}, "10.244.1.2", "10.244.2.3")
+	pod := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       coreapi.PodSpec{Hostname: "web", Subdomain: "db"},
+		Status:     coreapi.PodStatus{PodIP: "10.244.3.4", PodIPs: []coreapi.PodIP{{IP: "10.244.3.4"}}},
+	}
+	server := newTestServer(t, headless, slice, pod)
+
+	assertAnswers(t, query(t, server, "db.default.svc.cluster.local.", dnsmessage.TypeA, true), "A 10.244.1.2", "A 10.244.2.3")
+	// endpoint 的 hostname 和 IP 形式的名称
+	assertAnswers(t, query(t, server, "db-0.db.default.svc.cluster.local.", dnsmessage.TypeA, true), "A 10.244.1.2")
+	assertAnswers(t, query(t, server, "10-244-2-3.db.default.svc.cluster.local.", dnsmessage.TypeA, true), "A 10.244.2.3")
+	// Pod 的 hostname 和 subdomain
+	assertAnswers(t, query(t, server, "web.db.default.svc.cluster.local.", dnsmessage.TypeA, true), "A 10.244.3.4")
+	// headless service 的 SRV 记录指向每个 endpoint
+	assertAnswers(t, query(t, server, "_http._tcp.db.default.svc.cluster.local.", dnsmessage.TypeSRV, true),
+		"SRV 8080 db-0.db.default.svc.cluster.local.", "SRV 8080 10-244-2-3.db.default.svc.cluster.local.")
+}
+
+func TestServer_Pod(t *testing.T) {
+	pod := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "default"},
+		Status:     coreapi.PodStatus{PodIP: "10.244.1.9", PodIPs: []coreapi.PodIP{{IP: "10.244.1.9"}, {IP: "fd00:10:244:1::9"}}},
+	}
+	server := newTestServer(t, pod)
+
+	assertAnswers(t, query(t, server, "10-244-1-9.default.pod.cluster.local.", dnsmessage.TypeA, true), "A 10.244.1.9")
+	assertAnswers(t, query(t, server, "fd00-10-244-1--9.default.pod.cluster.local.", dnsmessage.TypeAAAA, true), "AAAA fd00:10:244:1::9")
+	// 只解析属于该 namespace 的 Pod IP
+	if msg := query(t, server, "10-244-1-9.other.pod.cluster.local.", dnsmessage.TypeA, true); msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected NXDOMAIN for pod in another namespace, got %v", msg.Header.RCode)
+	}
+}
+
+func TestServer_ReverseLookup(t *testing.T) {
+	headless := newService("db", coreapi.ClusterIPNone)
+	slice := newEndpointSlice("db", map[string]string{"10.244.1.2": "db-0"}, "10.244.1.2")
+	pod := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "default"},
+		Status:     coreapi.PodStatus{PodIP: "10.244.1.9", PodIPs: []coreapi.PodIP{{IP: "10.244.1.9"}, {IP: "fd00:10:244:1::9"}}},
+	}
+	server := newTestServer(t, newService("nginx", "10.96.0.20"), headless, slice, pod)
+
+	assertAnswers(t, query(t, server, "20.0.96.10.in-addr.arpa.", dnsmessage.TypePTR, true), "PTR nginx.default.svc.cluster.local.")
+	assertAnswers(t, query(t, server, "2.1.244.10.in-addr.arpa.", dnsmessage.TypePTR, true), "PTR db-0.db.default.svc.cluster.local.")
+	assertAnswers(t, query(t, server, "9.1.244.10.in-addr.arpa.", dnsmessage.TypePTR, true), "PTR 10-244-1-9.default.pod.cluster.local.")
+	assertAnswers(t, query(t, server, "9.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0.4.4.2.0.0.1.0.0.0.0.d.f.ip6.arpa.", dnsmessage.TypePTR, true),
+		"PTR fd00-10-244-1--9.default.pod.cluster.local.")
+	if msg := query(t, server, "1.1.1.1.in-addr.arpa.", dnsmessage.TypePTR, true); msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected NXDOMAIN for unknown ip, got %v", msg.Header.RCode)
+	}
+}
+
+func TestServer_Truncate(t *testing.T) {
+	var ips []string
+	for i := 1; i <= 40; i++ {
+		ips = append(ips, fmt.Sprintf("10.244.1.%d", i))
+	}
+	server := newTestServer(t, newService("big", coreapi.ClusterIPNone), newEndpointSlice("big", nil, ips...))
+
+	// udp 响应过大时被截断, 客户端应使用 tcp 重试
+	msg := query(t, server, "_http._tcp.big.default.svc.cluster.local.", dnsmessage.TypeSRV, true)
+	if !msg.Header.Truncated || len(msg.Answers) != 0 {
+		t.Errorf("Expected udp response to be truncated, got %d answers", len(msg.Answers))
+	}
+	msg = query(t, server, "_http._tcp.big.default.svc.cluster.local.", dnsmessage.TypeSRV, false)
+	if msg.Header.Truncated || len(msg.Answers) != 40 {
+		t.Errorf("Expected all answers over tcp, got %d answers", len(msg.Answers))
+	}
+}
+
+func TestServiceIPs(t *testing.T) {
+	ips, err := ServiceIPs("10.96.0.0/12,fd00:10:96::/112")
+	if err != nil {
+		t.Fatalf("ServiceIPs should not return error: %v", err)
+	}
+	if fmt.Sprint(ips) != "[10.96.0.10 fd00:10:96::a]" {
+		t.Errorf("Unexpected dns service ips %v", ips)
+	}
+	if _, err := ServiceIPs("10.96.0.0/29"); err == nil {
+		t.Error("Expected error for too small service cidr")
+	}
+}
+
+func TestEnsureService(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	if err := EnsureService(ctx, client, []string{"10.96.0.10"}, DefaultListen); err != nil {
+		t.Fatalf("EnsureService should not return error: %v", err)
+	}
+	svc, err := client.CoreV1().Services(ServiceNamespace).Get(ctx, ServiceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected kube-dns service to be created: %v", err)
+	}
+	if svc.Spec.ClusterIP != "10.96.0.10" || len(svc.Spec.Ports) != 2 || svc.Annotations[AnnotationListen] != DefaultListen {
+		t.Errorf("Unexpected service %+v", svc)
+	}
+
+	// 重复调用只更新监听地址
+	if err := EnsureService(ctx, client, []string{"10.96.0.10"}, "127.0.0.1:5353"); err != nil {
+		t.Fatalf("EnsureService should not return error: %v", err)
+	}
+	svc, _ = client.CoreV1().Services(ServiceNamespace).Get(ctx, ServiceName, metav1.GetOptions{})
+	if svc.Annotations[AnnotationListen] != "127.0.0.1:5353" {
+		t.Errorf("Expected listen annotation to be updated, got %v", svc.Annotations)
+	}
+}
+
+func TestServer_Run(t *testing.T) {
+	// 先找一个空闲端口, udp 和 tcp 使用同一个端口
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	listen := conn.LocalAddr().String()
+	conn.Close()
+
+	server, err := NewServer(fake.NewSimpleClientset(newService("nginx", "10.96.0.20")), Config{Listen: listen})
+	if err != nil {
+		t.Fatalf("NewServer should not return error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Run(ctx); err != nil {
+		t.Fatalf("Run should not return error: %v", err)
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, listen)
+			},
+		}
+		addrs, err := resolver.LookupHost(ctx, "nginx.default.svc.cluster.local")
+		if err != nil {
+			t.Fatalf("Lookup over %s should not return error: %v", network, err)
+		}
+		if fmt.Sprint(addrs) != "[10.96.0.20]" {
+			t.Errorf("Unexpected addresses over %s: %v", network, addrs)
+		}
+	}
+}
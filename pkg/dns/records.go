@@ -0,0 +1,509 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	coreapi "k8s.io/api/core/v1"
+	discoveryapi "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const indexByIP = "ip"
+
+const (
+	reverseZoneIPv4 = "in-addr.arpa"
+	reverseZoneIPv6 = "ip6.arpa"
+)
+
+// record represent a record of a name, target is the name a CNAME, PTR or SRV record points to,
+// addrs of SRV records are added as additional records
+type record struct {
+	rtype  dnsmessage.Type
+	addrs  []netip.Addr
+	target string
+	port   uint16
+}
+
+// answer resolves question, names in cluster domain are answered authoritatively, reverse lookups are answered
+// if the ip is known, other names are refused since we're not a recursive resolver
+func (s *Server) answer(question dnsmessage.Question) ([]dnsmessage.Resource, []dnsmessage.Resource, dnsmessage.RCode, bool) {
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	var records []record
+	var found bool
+	switch {
+	case name == s.domain || strings.HasSuffix(name, "."+s.domain):
+		records, found = s.lookupName(strings.Split(strings.TrimSuffix(strings.TrimSuffix(name, s.domain), "."), "."))
+	case strings.HasSuffix(name, "."+reverseZoneIPv4) || strings.HasSuffix(name, "."+reverseZoneIPv6):
+		addr, ok := parseReverseName(name)
+		if !ok {
+			return nil, nil, dnsmessage.RCodeNameError, true
+		}
+		records, found = s.lookupAddr(addr)
+	default:
+		return nil, nil, dnsmessage.RCodeRefused, false
+	}
+	if !found {
+		return nil, nil, dnsmessage.RCodeNameError, true
+	}
+
+	var answers, extras []dnsmessage.Resource
+	for _, r := range records {
+		// CNAME is the only record of the name, it's returned whatever the type asked
+		if r.rtype != question.Type && r.rtype != dnsmessage.TypeCNAME && question.Type != dnsmessage.TypeALL {
+			continue
+		}
+		resources, additionals, err := s.toResources(question.Name, r, question.Type)
+		if err != nil {
+			loggerForDNS.WithError(err).Warnf("build answer of %s failed", name)
+			continue
+		}
+		answers = append(answers, resources...)
+		extras = append(extras, additionals...)
+	}
+	return answers, extras, dnsmessage.RCodeSuccess, true
+}
+
+// lookupName resolves labels of a name in the cluster domain, e.g. [nginx default svc]
+func (s *Server) lookupName(labels []string) ([]record, bool) {
+	if len(labels) == 1 && labels[0] == "" {
+		return nil, true
+	}
+	n := len(labels)
+	switch labels[n-1] {
+	case "svc":
+		switch {
+		case n == 1 || n == 2:
+			// svc.cluster.local and <namespace>.svc.cluster.local exist but have no records
+			return nil, true
+		case n == 3:
+			return s.lookupService(labels[1], labels[0])
+		case n == 4:
+			return s.lookupEndpoint(labels[2], labels[1], labels[0])
+		case n == 5 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_"):
+			return s.lookupServicePort(labels[3], labels[2], labels[0][1:], labels[1][1:])
+		}
+	case "pod":
+		switch {
+		case n == 1 || n == 2:
+			return nil, true
+		case n == 3:
+			return s.lookupPod(labels[1], labels[0])
+		}
+	}
+	return nil, false
+}
+
+// lookupService returns cluster ips of service, endpoints of headless service, or the alias of ExternalName service.
+// srv records of all ports are returned as well
+func (s *Server) lookupService(namespace, name string) ([]record, bool) {
+	svc, err := s.services.Services(namespace).Get(name)
+	if err != nil {
+		return nil, false
+	}
+	if svc.Spec.Type == coreapi.ServiceTypeExternalName {
+		return []record{{rtype: dnsmessage.TypeCNAME, target: svc.Spec.ExternalName}}, true
+	}
+	fqdn := s.serviceName(svc)
+	var records []record
+	if isHeadless(svc) {
+		for _, ep := range s.endpointsOf(svc) {
+			records = append(records, addrRecord(ep.addr))
+			for _, port := range ep.ports {
+				records = append(records, record{rtype: dnsmessage.TypeSRV, target: ep.hostname + "." + fqdn, port: port.port, addrs: []netip.Addr{ep.addr}})
+			}
+		}
+		return records, true
+	}
+	addrs := clusterIPsOf(svc)
+	for _, addr := range addrs {
+		records = append(records, addrRecord(addr))
+	}
+	for _, port := range svc.Spec.Ports {
+		records = append(records, record{rtype: dnsmessage.TypeSRV, target: fqdn, port: uint16(port.Port), addrs: addrs})
+	}
+	return records, true
+}
+
+// lookupServicePort returns srv records of the named port of service, e.g. _http._tcp.nginx.default.svc
+func (s *Server) lookupServicePort(namespace, name, portName, protocol string) ([]record, bool) {
+	svc, err := s.services.Services(namespace).Get(name)
+	if err != nil || svc.Spec.Type == coreapi.ServiceTypeExternalName {
+		return nil, false
+	}
+	fqdn := s.serviceName(svc)
+	var records []record
+	if isHeadless(svc) {
+		for _, ep := range s.endpointsOf(svc) {
+			for _, port := range ep.ports {
+				if port.name == portName && strings.EqualFold(port.protocol, protocol) {
+					records = append(records, record{rtype: dnsmessage.TypeSRV, target: ep.hostname + "." + fqdn, port: port.port, addrs: []netip.Addr{ep.addr}})
+				}
+			}
+		}
+	} else {
+		for _, port := range svc.Spec.Ports {
+			if port.Name == portName && strings.EqualFold(string(port.Protocol), protocol) {
+				records = append(records, record{rtype: dnsmessage.TypeSRV, target: fqdn, port: uint16(port.Port), addrs: clusterIPsOf(svc)})
+			}
+		}
+	}
+	return records, len(records) != 0
+}
+
+// lookupEndpoint resolves <hostname>.<service>.<namespace>.svc, hostname is either the hostname of endpoint or
+// its ip with dashes. pods with the same hostname and subdomain are used if endpointslices don't know the hostname
+func (s *Server) lookupEndpoint(namespace, serviceName, hostname string) ([]record, bool) {
+	svc, err := s.services.Services(namespace).Get(serviceName)
+	if err != nil || !isHeadless(svc) {
+		return nil, false
+	}
+	var records []record
+	for _, ep := range s.endpointsOf(svc) {
+		if ep.hostname == hostname {
+			records = append(records, addrRecord(ep.addr))
+		}
+	}
+	if len(records) == 0 {
+		pods, _ := s.pods.Pods(namespace).List(labels.Everything())
+		for _, pod := range pods {
+			if pod.Spec.Hostname != hostname || pod.Spec.Subdomain != serviceName {
+				continue
+			}
+			for _, podIP := range pod.Status.PodIPs {
+				if addr, err := netip.ParseAddr(podIP.IP); err == nil {
+					records = append(records, addrRecord(addr))
+				}
+			}
+		}
+	}
+	return records, len(records) != 0
+}
+
+// lookupPod resolves <ip with dashes>.<namespace>.pod, only ips of pods in namespace are resolved
+func (s *Server) lookupPod(namespace, dashedIP string) ([]record, bool) {
+	addr, ok := parseDashedIP(dashedIP)
+	if !ok {
+		return nil, false
+	}
+	objs, _ := s.podIndexer.ByIndex(indexByIP, addr.String())
+	for _, obj := range objs {
+		if pod, ok := obj.(*coreapi.Pod); ok && pod.Namespace == namespace {
+			return []record{addrRecord(addr)}, true
+		}
+	}
+	return nil, false
+}
+
+// lookupAddr returns names of addr, they're cluster ips of services, endpoints of headless services or pods
+func (s *Server) lookupAddr(addr netip.Addr) ([]record, bool) {
+	var records []record
+	seen := make(map[string]bool)
+	add := func(target string) {
+		if !seen[target] {
+			seen[target] = true
+			records = append(records, record{rtype: dnsmessage.TypePTR, target: target})
+		}
+	}
+
+	objs, _ := s.serviceIndexer.ByIndex(indexByIP, addr.String())
+	for _, obj := range objs {
+		if svc, ok := obj.(*coreapi.Service); ok {
+			add(s.serviceName(svc))
+		}
+	}
+	objs, _ = s.sliceIndexer.ByIndex(indexByIP, addr.String())
+	for _, obj := range objs {
+		slice, ok := obj.(*discoveryapi.EndpointSlice)
+		if !ok {
+			continue
+		}
+		svc, err := s.services.Services(slice.Namespace).Get(slice.Labels[discoveryapi.LabelServiceName])
+		if err != nil || !isHeadless(svc) {
+			continue
+		}
+		for _, ep := range endpointsOfSlice(slice) {
+			if ep.addr == addr {
+				add(ep.hostname + "." + s.serviceName(svc))
+			}
+		}
+	}
+	if len(records) == 0 {
+		objs, _ = s.podIndexer.ByIndex(indexByIP, addr.String())
+		for _, obj := range objs {
+			if pod, ok := obj.(*coreapi.Pod); ok {
+				add(s.podName(pod, addr))
+			}
+		}
+	}
+	return records, len(records) != 0
+}
+
+// endpoint represent an address of endpointslice
+type endpoint struct {
+	addr     netip.Addr
+	hostname string
+	ports    []endpointPort
+}
+
+type endpointPort struct {
+	name     string
+	protocol string
+	port     uint16
+}
+
+// endpointsOf returns ready endpoints of svc, not ready ones are included if svc publishes them
+func (s *Server) endpointsOf(svc *coreapi.Service) []endpoint {
+	selector := labels.SelectorFromSet(labels.Set{discoveryapi.LabelServiceName: svc.Name})
+	slices, err := s.slices.EndpointSlices(svc.Namespace).List(selector)
+	if err != nil {
+		return nil
+	}
+	var endpoints []endpoint
+	seen := make(map[string]bool)
+	for _, slice := range slices {
+		for _, ep := range endpointsOfSlice(slice) {
+			if !ep.ready && !svc.Spec.PublishNotReadyAddresses {
+				continue
+			}
+			key := ep.hostname + "/" + ep.addr.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			endpoints = append(endpoints, ep.endpoint)
+		}
+	}
+	return endpoints
+}
+
+type sliceEndpoint struct {
+	endpoint
+	ready bool
+}
+
+func endpointsOfSlice(slice *discoveryapi.EndpointSlice) []sliceEndpoint {
+	if slice.AddressType != discoveryapi.AddressTypeIPv4 && slice.AddressType != discoveryapi.AddressTypeIPv6 {
+		return nil
+	}
+	var ports []endpointPort
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		p := endpointPort{port: uint16(*port.Port), protocol: string(coreapi.ProtocolTCP)}
+		if port.Name != nil {
+			p.name = *port.Name
+		}
+		if port.Protocol != nil {
+			p.protocol = string(*port.Protocol)
+		}
+		ports = append(ports, p)
+	}
+	var endpoints []sliceEndpoint
+	for _, ep := range slice.Endpoints {
+		ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+		for _, address := range ep.Addresses {
+			addr, err := netip.ParseAddr(address)
+			if err != nil {
+				continue
+			}
+			hostname := dashedIP(addr)
+			if ep.Hostname != nil && *ep.Hostname != "" {
+				hostname = *ep.Hostname
+			}
+			endpoints = append(endpoints, sliceEndpoint{endpoint: endpoint{addr: addr, hostname: hostname, ports: ports}, ready: ready})
+		}
+	}
+	return endpoints
+}
+
+func (s *Server) toResources(name dnsmessage.Name, r record, qtype dnsmessage.Type) ([]dnsmessage.Resource, []dnsmessage.Resource, error) {
+	header := dnsmessage.ResourceHeader{Name: name, Type: r.rtype, Class: dnsmessage.ClassINET, TTL: s.ttl}
+	switch r.rtype {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		return []dnsmessage.Resource{addrResource(header, r.addrs[0])}, nil, nil
+	case dnsmessage.TypeCNAME:
+		target, err := dnsmessage.NewName(fqdn(r.target))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.CNAMEResource{CNAME: target}}}, nil, nil
+	case dnsmessage.TypePTR:
+		target, err := dnsmessage.NewName(fqdn(r.target))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.PTRResource{PTR: target}}}, nil, nil
+	case dnsmessage.TypeSRV:
+		target, err := dnsmessage.NewName(fqdn(r.target))
+		if err != nil {
+			return nil, nil, err
+		}
+		srv := dnsmessage.Resource{Header: header, Body: &dnsmessage.SRVResource{Priority: 0, Weight: 100, Port: r.port, Target: target}}
+		var extras []dnsmessage.Resource
+		for _, addr := range r.addrs {
+			extras = append(extras, addrResource(dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET, TTL: s.ttl}, addr))
+		}
+		return []dnsmessage.Resource{srv}, extras, nil
+	}
+	return nil, nil, fmt.Errorf("record type %v is not supported", r.rtype)
+}
+
+func addrResource(header dnsmessage.ResourceHeader, addr netip.Addr) dnsmessage.Resource {
+	if addr.Is4() {
+		header.Type = dnsmessage.TypeA
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}}
+	}
+	header.Type = dnsmessage.TypeAAAA
+	return dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}}
+}
+
+func addrRecord(addr netip.Addr) record {
+	if addr.Is4() {
+		return record{rtype: dnsmessage.TypeA, addrs: []netip.Addr{addr}}
+	}
+	return record{rtype: dnsmessage.TypeAAAA, addrs: []netip.Addr{addr}}
+}
+
+func (s *Server) serviceName(svc *coreapi.Service) string {
+	return svc.Name + "." + svc.Namespace + ".svc." + s.domain
+}
+
+// podName returns <hostname>.<subdomain>.<namespace>.svc if pod has both of them, otherwise the name by its ip
+func (s *Server) podName(pod *coreapi.Pod, addr netip.Addr) string {
+	if pod.Spec.Hostname != "" && pod.Spec.Subdomain != "" {
+		return pod.Spec.Hostname + "." + pod.Spec.Subdomain + "." + pod.Namespace + ".svc." + s.domain
+	}
+	return dashedIP(addr) + "." + pod.Namespace + ".pod." + s.domain
+}
+
+func isHeadless(svc *coreapi.Service) bool {
+	return svc.Spec.ClusterIP == coreapi.ClusterIPNone
+}
+
+func clusterIPsOf(svc *coreapi.Service) []netip.Addr {
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && svc.Spec.ClusterIP != "" {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+	var addrs []netip.Addr
+	for _, ip := range clusterIPs {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// dashedIP returns ip with dashes, e.g. 10-244-1-2 or fd00--1
+func dashedIP(addr netip.Addr) string {
+	if addr.Is4() {
+		return strings.ReplaceAll(addr.String(), ".", "-")
+	}
+	return strings.ReplaceAll(addr.String(), ":", "-")
+}
+
+func parseDashedIP(label string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.ReplaceAll(label, "-", "."))
+	if err == nil && addr.Is4() {
+		return addr, true
+	}
+	addr, err = netip.ParseAddr(strings.ReplaceAll(label, "-", ":"))
+	if err == nil && addr.Is6() {
+		return addr, true
+	}
+	return netip.Addr{}, false
+}
+
+// parseReverseName parses names like 2.1.244.10.in-addr.arpa or the nibble format of ip6.arpa
+func parseReverseName(name string) (netip.Addr, bool) {
+	if prefix, ok := strings.CutSuffix(name, "."+reverseZoneIPv4); ok {
+		octets := strings.Split(prefix, ".")
+		if len(octets) != 4 {
+			return netip.Addr{}, false
+		}
+		var ip [4]byte
+		for idx, octet := range octets {
+			value, err := strconv.ParseUint(octet, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			ip[3-idx] = byte(value)
+		}
+		return netip.AddrFrom4(ip), true
+	}
+	prefix, _ := strings.CutSuffix(name, "."+reverseZoneIPv6)
+	nibbles := strings.Split(prefix, ".")
+	if len(nibbles) != 32 {
+		return netip.Addr{}, false
+	}
+	var ip [16]byte
+	for idx, nibble := range nibbles {
+		value, err := strconv.ParseUint(nibble, 16, 4)
+		if err != nil || len(nibble) != 1 {
+			return netip.Addr{}, false
+		}
+		pos := 31 - idx
+		if pos%2 == 0 {
+			ip[pos/2] |= byte(value) << 4
+		} else {
+			ip[pos/2] |= byte(value)
+		}
+	}
+	return netip.AddrFrom16(ip), true
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// serviceIPs, endpointSliceIPs and podIPs index objects by their ips for reverse lookups
+func serviceIPs(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*coreapi.Service)
+	if !ok || isHeadless(svc) {
+		return nil, nil
+	}
+	var ips []string
+	for _, addr := range clusterIPsOf(svc) {
+		ips = append(ips, addr.String())
+	}
+	return ips, nil
+}
+
+func endpointSliceIPs(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryapi.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, ep := range endpointsOfSlice(slice) {
+		ips = append(ips, ep.addr.String())
+	}
+	return ips, nil
+}
+
+func podIPs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*coreapi.Pod)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		if addr, err := netip.ParseAddr(podIP.IP); err == nil {
+			ips = append(ips, addr.String())
+		}
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		if addr, err := netip.ParseAddr(pod.Status.PodIP); err == nil {
+			ips = append(ips, addr.String())
+		}
+	}
+	return ips, nil
+}
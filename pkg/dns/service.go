@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"math/big"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/util"
+	"github.com/pkg/errors"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	ServiceName      = "kube-dns"
+	ServiceNamespace = metav1.NamespaceSystem
+	// AnnotationListen records the address cluster dns really listens on, since nothing serves the cluster ip
+	AnnotationListen = "kube-simulator.io/dns-listen"
+)
+
+// ServiceIPs returns the cluster ips of kube-dns, the 10th address of each service cidr like kubeadm does
+func ServiceIPs(serviceCIDR string) ([]string, error) {
+	prefixes, err := util.ParseDualStackCIDRs(serviceCIDR)
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, prefix := range prefixes {
+		addr := util.AddToAddr(prefix.Masked().Addr(), big.NewInt(10))
+		if !prefix.Contains(addr) {
+			return nil, errors.Errorf("service cidr %s is too small for the dns service ip", prefix)
+		}
+		ips = append(ips, addr.String())
+	}
+	return ips, nil
+}
+
+// EnsureService registers cluster dns as the kube-dns service, so that clients find it in the same way as in
+// real clusters. only the listen annotation is updated if the service already exists
+func EnsureService(ctx context.Context, client kubeclientset.Interface, clusterIPs []string, listen string) error {
+	if len(clusterIPs) == 0 {
+		return errors.New("cluster ip of dns service is required")
+	}
+	existing, err := client.CoreV1().Services(ServiceNamespace).Get(ctx, ServiceName, metav1.GetOptions{})
+	if err == nil {
+		if existing.Spec.ClusterIP != clusterIPs[0] {
+			loggerForDNS.Warnf("service %s/%s already exists with cluster ip %s", ServiceNamespace, ServiceName, existing.Spec.ClusterIP)
+		}
+		if existing.Annotations[AnnotationListen] != listen {
+			existing = existing.DeepCopy()
+			if existing.Annotations == nil {
+				existing.Annotations = make(map[string]string)
+			}
+			existing.Annotations[AnnotationListen] = listen
+			_, err = client.CoreV1().Services(ServiceNamespace).Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		return err
+	}
+	if !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "get service %s/%s failed", ServiceNamespace, ServiceName)
+	}
+
+	svc := &coreapi.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ServiceName,
+			Namespace: ServiceNamespace,
+			Labels: map[string]string{
+				"k8s-app":                       ServiceName,
+				"kubernetes.io/cluster-service": "true",
+				"kubernetes.io/name":            "KubeDNS",
+			},
+			Annotations: map[string]string{AnnotationListen: listen},
+		},
+		Spec: coreapi.ServiceSpec{
+			ClusterIP:  clusterIPs[0],
+			ClusterIPs: clusterIPs,
+			Ports: []coreapi.ServicePort{
+				{Name: "dns", Port: 53, Protocol: coreapi.ProtocolUDP, TargetPort: intstr.FromInt32(53)},
+				{Name: "dns-tcp", Port: 53, Protocol: coreapi.ProtocolTCP, TargetPort: intstr.FromInt32(53)},
+			},
+		},
+	}
+	if len(clusterIPs) > 1 {
+		policy := coreapi.IPFamilyPolicyPreferDualStack
+		svc.Spec.IPFamilyPolicy = &policy
+	}
+	if _, err := client.CoreV1().Services(ServiceNamespace).Create(ctx, svc, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "create service %s/%s failed", ServiceNamespace, ServiceName)
+	}
+	return nil
+}
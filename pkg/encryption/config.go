@@ -0,0 +1,344 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	apiserverconfig "k8s.io/apiserver/pkg/apis/config"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"k8s.io/apiserver/pkg/apis/config/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Provider is how resources are written to storage, it's the first provider of the EncryptionConfiguration
+type Provider string
+
+const (
+	ProviderAESCBC    Provider = "aescbc"
+	ProviderAESGCM    Provider = "aesgcm"
+	ProviderSecretbox Provider = "secretbox"
+	// ProviderKMS encrypts with the kms v2 plugin stand-in served by simulator
+	ProviderKMS Provider = "kms"
+	// ProviderIdentity writes resources in plain text, it's used to decrypt resources before encryption is disabled
+	ProviderIdentity Provider = "identity"
+)
+
+// Providers are providers simulator can configure
+var Providers = []Provider{ProviderAESCBC, ProviderAESGCM, ProviderSecretbox, ProviderKMS, ProviderIdentity}
+
+// DefaultResources are resources encrypted unless they are specified
+var DefaultResources = []string{"secrets"}
+
+const (
+	// KMSPluginName is the name of the kms v2 plugin stand-in in EncryptionConfiguration
+	KMSPluginName = "kube-simulator"
+	// keySize is the size of keys generated for aescbc, aesgcm and secretbox
+	keySize = 32
+)
+
+var loggerForEncryption = logrus.WithField("component", "encryption")
+
+var codecs serializer.CodecFactory
+
+func init() {
+	scheme := runtime.NewScheme()
+	apiserverconfig.AddToScheme(scheme)
+	apiserverconfigv1.AddToScheme(scheme)
+	codecs = serializer.NewCodecFactory(scheme)
+}
+
+// Config represent encryption at rest of apiserver, it's disabled if Provider is empty
+type Config struct {
+	Provider Provider
+	// Resources are resource[.group] encrypted, e.g. secrets or configmaps
+	Resources []string
+	// ConfigFile is the EncryptionConfiguration of apiserver, it's generated into the data dir and kept across
+	// restarts so that keys can be rotated
+	ConfigFile string
+	// KMSSocket is the unix socket the kms v2 plugin stand-in serves on, its keys are in KMSKeyFile
+	KMSSocket  string
+	KMSKeyFile string
+}
+
+// Enabled returns true if apiserver encrypts resources
+func (c *Config) Enabled() bool {
+	return c.Provider != ""
+}
+
+// Validate checks the provider and resources of config
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if !slices.Contains(Providers, c.Provider) {
+		return errors.Errorf("encryption provider %s is not supported", c.Provider)
+	}
+	if len(c.Resources) == 0 {
+		return errors.New("resources to encrypt are required")
+	}
+	for _, resource := range c.Resources {
+		// verify-encryption looks resources up by their keys in storage, which wildcards don't tell
+		if resource == "" || strings.Contains(resource, "*") {
+			return errors.Errorf("resource %q to encrypt should be explicit like secrets or configmaps", resource)
+		}
+	}
+	return nil
+}
+
+// LoadConfigFile loads and validates the EncryptionConfiguration of file
+func LoadConfigFile(file string) (*apiserverconfigv1.EncryptionConfiguration, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := codecs.UniversalDecoder().Decode(data, nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode encryption config %s", file)
+	}
+	internal, ok := obj.(*apiserverconfig.EncryptionConfiguration)
+	if !ok {
+		return nil, errors.Errorf("%s is not an EncryptionConfiguration", file)
+	}
+	if err := validation.ValidateEncryptionConfiguration(internal, true).ToAggregate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption config %s", file)
+	}
+	versioned, _, err := codecs.UniversalDecoder(apiserverconfigv1.SchemeGroupVersion).Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return versioned.(*apiserverconfigv1.EncryptionConfiguration), nil
+}
+
+func writeConfigFile(file string, encryptionConfig *apiserverconfigv1.EncryptionConfiguration) error {
+	encryptionConfig.APIVersion = apiserverconfigv1.SchemeGroupVersion.String()
+	encryptionConfig.Kind = "EncryptionConfiguration"
+	data, err := yaml.Marshal(encryptionConfig)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// the file contains keys
+	return os.WriteFile(file, data, 0600)
+}
+
+// EnsureConfigFile generates the EncryptionConfiguration of config if it doesn't exist. an existing one is kept as
+// keys of stored resources are in it, but if it writes with another provider, the provider of config is put first
+// so that resources are written with it and still read with the old ones
+func EnsureConfigFile(config *Config) error {
+	if config.Provider == ProviderKMS {
+		if err := EnsureKMSKeyFile(config.KMSKeyFile); err != nil {
+			return errors.Wrap(err, "generate key of kms plugin")
+		}
+	}
+	if _, err := os.Stat(config.ConfigFile); os.IsNotExist(err) {
+		// identity reads resources written before encryption is enabled
+		providers := []apiserverconfigv1.ProviderConfiguration{{Identity: &apiserverconfigv1.IdentityConfiguration{}}}
+		if config.Provider != ProviderIdentity {
+			provider, err := newProvider(config, "key1")
+			if err != nil {
+				return err
+			}
+			providers = append([]apiserverconfigv1.ProviderConfiguration{provider}, providers...)
+		}
+		return writeConfigFile(config.ConfigFile, &apiserverconfigv1.EncryptionConfiguration{
+			Resources: []apiserverconfigv1.ResourceConfiguration{{Resources: config.Resources, Providers: providers}},
+		})
+	} else if err != nil {
+		return err
+	}
+
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range encryptionConfig.Resources {
+		resource := &encryptionConfig.Resources[i]
+		if !slices.Equal(resource.Resources, config.Resources) {
+			loggerForEncryption.Warnf("encryption config %s is kept for resources %v, not %v", config.ConfigFile, resource.Resources, config.Resources)
+		}
+		previous := providerOf(resource.Providers[0])
+		if previous == config.Provider {
+			continue
+		}
+		// a provider used before is moved first with its keys, since names of kms providers should be unique
+		idx := slices.IndexFunc(resource.Providers, func(provider apiserverconfigv1.ProviderConfiguration) bool {
+			return providerOf(provider) == config.Provider
+		})
+		var provider apiserverconfigv1.ProviderConfiguration
+		if idx >= 0 {
+			provider = resource.Providers[idx]
+			resource.Providers = slices.Delete(resource.Providers, idx, idx+1)
+		} else if provider, err = newProvider(config, "key1"); err != nil {
+			return err
+		}
+		loggerForEncryption.Infof("%v are written with %s and read with %s as well", resource.Resources, config.Provider, previous)
+		resource.Providers = append([]apiserverconfigv1.ProviderConfiguration{provider}, resource.Providers...)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return writeConfigFile(config.ConfigFile, encryptionConfig)
+}
+
+// RotateKey adds a new key which resources are written with from now on, old keys are kept to read resources
+// written with them. keys of the kms plugin are rotated in KMSKeyFile instead. it returns the name of the new key
+func RotateKey(config *Config) (string, error) {
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		return "", err
+	}
+	provider := providerOf(encryptionConfig.Resources[0].Providers[0])
+	switch provider {
+	case ProviderKMS:
+		return AppendKMSKey(config.KMSKeyFile)
+	case ProviderIdentity:
+		return "", errors.New("resources are written in plain text, there is no key to rotate")
+	}
+	var name string
+	for i := range encryptionConfig.Resources {
+		first := &encryptionConfig.Resources[i].Providers[0]
+		var keys *[]apiserverconfigv1.Key
+		switch {
+		case first.AESCBC != nil:
+			keys = &first.AESCBC.Keys
+		case first.AESGCM != nil:
+			keys = &first.AESGCM.Keys
+		case first.Secretbox != nil:
+			keys = &first.Secretbox.Keys
+		default:
+			return "", errors.Errorf("keys of provider %s can't be rotated", providerOf(*first))
+		}
+		key, err := newKey(nextKeyName(*keys))
+		if err != nil {
+			return "", err
+		}
+		*keys = append([]apiserverconfigv1.Key{key}, *keys...)
+		name = key.Name
+	}
+	return name, writeConfigFile(config.ConfigFile, encryptionConfig)
+}
+
+// WriteKey returns the provider and the key resources are written with, the key of the kms plugin is its current
+// key id
+func WriteKey(config *Config) (Provider, string, error) {
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		return "", "", err
+	}
+	first := encryptionConfig.Resources[0].Providers[0]
+	switch {
+	case first.AESCBC != nil:
+		return ProviderAESCBC, first.AESCBC.Keys[0].Name, nil
+	case first.AESGCM != nil:
+		return ProviderAESGCM, first.AESGCM.Keys[0].Name, nil
+	case first.Secretbox != nil:
+		return ProviderSecretbox, first.Secretbox.Keys[0].Name, nil
+	case first.KMS != nil:
+		keys, err := loadKMSKeys(config.KMSKeyFile)
+		if err != nil {
+			return "", "", err
+		}
+		return ProviderKMS, first.KMS.Name + "/" + keys[len(keys)-1].id, nil
+	}
+	return providerOf(first), "", nil
+}
+
+// UsesKMSPlugin returns true if the EncryptionConfiguration of config reads or writes with the kms plugin
+// stand-in, which should run even if resources are written with another provider now
+func UsesKMSPlugin(config *Config) bool {
+	if config.Provider == ProviderKMS {
+		return true
+	}
+	if config.ConfigFile == "" {
+		return false
+	}
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		return false
+	}
+	for _, resource := range encryptionConfig.Resources {
+		for _, provider := range resource.Providers {
+			if provider.KMS != nil && provider.KMS.Endpoint == kmsEndpoint(config.KMSSocket) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func providerOf(provider apiserverconfigv1.ProviderConfiguration) Provider {
+	switch {
+	case provider.AESCBC != nil:
+		return ProviderAESCBC
+	case provider.AESGCM != nil:
+		return ProviderAESGCM
+	case provider.Secretbox != nil:
+		return ProviderSecretbox
+	case provider.KMS != nil:
+		return ProviderKMS
+	}
+	return ProviderIdentity
+}
+
+func newProvider(config *Config, keyName string) (apiserverconfigv1.ProviderConfiguration, error) {
+	if config.Provider == ProviderIdentity {
+		return apiserverconfigv1.ProviderConfiguration{Identity: &apiserverconfigv1.IdentityConfiguration{}}, nil
+	}
+	if config.Provider == ProviderKMS {
+		return apiserverconfigv1.ProviderConfiguration{KMS: &apiserverconfigv1.KMSConfiguration{
+			APIVersion: "v2",
+			Name:       KMSPluginName,
+			Endpoint:   kmsEndpoint(config.KMSSocket),
+		}}, nil
+	}
+	key, err := newKey(keyName)
+	if err != nil {
+		return apiserverconfigv1.ProviderConfiguration{}, err
+	}
+	keys := &apiserverconfigv1.AESConfiguration{Keys: []apiserverconfigv1.Key{key}}
+	switch config.Provider {
+	case ProviderAESCBC:
+		return apiserverconfigv1.ProviderConfiguration{AESCBC: keys}, nil
+	case ProviderAESGCM:
+		return apiserverconfigv1.ProviderConfiguration{AESGCM: keys}, nil
+	case ProviderSecretbox:
+		return apiserverconfigv1.ProviderConfiguration{Secretbox: &apiserverconfigv1.SecretboxConfiguration{Keys: keys.Keys}}, nil
+	}
+	return apiserverconfigv1.ProviderConfiguration{}, errors.Errorf("encryption provider %s is not supported", config.Provider)
+}
+
+// newKey returns a random key of name
+func newKey(name string) (apiserverconfigv1.Key, error) {
+	secret := make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return apiserverconfigv1.Key{}, err
+	}
+	return apiserverconfigv1.Key{Name: name, Secret: base64.StdEncoding.EncodeToString(secret)}, nil
+}
+
+// nextKeyName returns key<n> which isn't used by keys
+func nextKeyName(keys []apiserverconfigv1.Key) string {
+	for n := len(keys) + 1; ; n++ {
+		name := "key" + strconv.Itoa(n)
+		if !slices.ContainsFunc(keys, func(key apiserverconfigv1.Key) bool { return key.Name == name }) {
+			return name
+		}
+	}
+}
+
+func kmsEndpoint(socket string) string {
+	return "unix://" + socket
+}
@@ -0,0 +1,176 @@
+package encryption
+
+import (
+	"path/filepath"
+	"testing"
+
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+)
+
+func newTestConfig(t *testing.T, provider Provider) *Config {
+	dir := t.TempDir()
+	return &Config{
+		Provider:   provider,
+		Resources:  DefaultResources,
+		ConfigFile: filepath.Join(dir, "encryption-config.yaml"),
+		KMSSocket:  filepath.Join(dir, "kms.sock"),
+		KMSKeyFile: filepath.Join(dir, "pki", "kms.key"),
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "disabled", config: Config{}},
+		{name: "aescbc", config: Config{Provider: ProviderAESCBC, Resources: []string{"secrets", "configmaps"}}},
+		{name: "unknown provider", config: Config{Provider: "aesctr", Resources: DefaultResources}, wantErr: true},
+		{name: "no resources", config: Config{Provider: ProviderKMS}, wantErr: true},
+		// 通配符无法对应到存储中的 key
+		{name: "wildcard", config: Config{Provider: ProviderAESGCM, Resources: []string{"*.apps"}}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.config.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureConfigFile(t *testing.T) {
+	config := newTestConfig(t, ProviderAESCBC)
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		t.Fatalf("Expected generated config to be valid: %v", err)
+	}
+	providers := encryptionConfig.Resources[0].Providers
+	// 新写入使用 aescbc, identity 用于读取启用加密前写入的资源
+	if len(providers) != 2 || providerOf(providers[0]) != ProviderAESCBC || providerOf(providers[1]) != ProviderIdentity {
+		t.Fatalf("Expected providers [aescbc identity], got %+v", providers)
+	}
+	provider, key, err := WriteKey(config)
+	if err != nil || provider != ProviderAESCBC || key != "key1" {
+		t.Errorf("Expected to write with aescbc key1, got %s %s %v", provider, key, err)
+	}
+
+	// 已存在的配置需要保留, 否则已加密的资源无法读取
+	secret := providers[0].AESCBC.Keys[0].Secret
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	if encryptionConfig, _ = LoadConfigFile(config.ConfigFile); encryptionConfig.Resources[0].Providers[0].AESCBC.Keys[0].Secret != secret {
+		t.Error("Expected keys of existing config to be kept")
+	}
+}
+
+func TestEnsureConfigFile_SwitchProvider(t *testing.T) {
+	config := newTestConfig(t, ProviderAESGCM)
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+
+	// 切换到 kms 后, 旧的 provider 仍需保留用于读取
+	config.Provider = ProviderKMS
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProviders(t, encryptionConfig.Resources[0].Providers, ProviderKMS, ProviderAESGCM, ProviderIdentity)
+	if !UsesKMSPlugin(config) {
+		t.Error("Expected kms plugin to be used")
+	}
+
+	// 切回 aesgcm 时复用原有的 key, kms provider 不能重复出现
+	config.Provider = ProviderAESGCM
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	if encryptionConfig, err = LoadConfigFile(config.ConfigFile); err != nil {
+		t.Fatalf("Expected config to be valid after switching back: %v", err)
+	}
+	assertProviders(t, encryptionConfig.Resources[0].Providers, ProviderAESGCM, ProviderKMS, ProviderIdentity)
+	// 仍有资源可能由 kms 写入, 插件需要继续运行
+	if !UsesKMSPlugin(config) {
+		t.Error("Expected kms plugin to be used while it's in the config")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	config := newTestConfig(t, ProviderSecretbox)
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	name, err := RotateKey(config)
+	if err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if name != "key2" {
+		t.Errorf("Expected new key key2, got %s", name)
+	}
+	encryptionConfig, err := LoadConfigFile(config.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := encryptionConfig.Resources[0].Providers[0].Secretbox.Keys
+	// 新 key 在前用于写入, 旧 key 保留用于读取
+	if len(keys) != 2 || keys[0].Name != "key2" || keys[1].Name != "key1" {
+		t.Errorf("Expected keys [key2 key1], got %+v", keys)
+	}
+}
+
+func TestRotateKey_KMS(t *testing.T) {
+	config := newTestConfig(t, ProviderKMS)
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	_, before, err := WriteKey(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := RotateKey(config)
+	if err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	_, after, err := WriteKey(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || after != KMSPluginName+"/"+id {
+		t.Errorf("Expected to write with %s/%s after rotation, got %s", KMSPluginName, id, after)
+	}
+}
+
+func TestRotateKey_Identity(t *testing.T) {
+	config := newTestConfig(t, ProviderIdentity)
+	if err := EnsureConfigFile(config); err != nil {
+		t.Fatalf("EnsureConfigFile failed: %v", err)
+	}
+	if _, err := RotateKey(config); err == nil {
+		t.Error("Expected no key to rotate for identity")
+	}
+}
+
+func assertProviders(t *testing.T, providers []apiserverconfigv1.ProviderConfiguration, want ...Provider) {
+	t.Helper()
+	var got []Provider
+	for _, provider := range providers {
+		got = append(got, providerOf(provider))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected providers %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected providers %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v2"
+	"k8s.io/kms/pkg/service"
+)
+
+// kmsTimeout is how long the plugin handles a request of apiserver
+const kmsTimeout = 3 * time.Second
+
+// kmsKey is a key encryption key of the kms plugin
+type kmsKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// EnsureKMSKeyFile generates the first key of the kms plugin if file doesn't exist
+func EnsureKMSKeyFile(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	_, err := AppendKMSKey(file)
+	return err
+}
+
+// AppendKMSKey appends a new key to file, the plugin encrypts with the last key of file and decrypts with any of
+// them. it returns the id of the new key
+func AppendKMSKey(file string) (string, error) {
+	secret := make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(secret) + "\n"); err != nil {
+		return "", err
+	}
+	return kmsKeyID(secret), nil
+}
+
+// kmsKeyID identifies a key without revealing it
+func kmsKeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadKMSKeys loads keys of file, one base64 encoded key per line
+func loadKMSKeys(file string) ([]kmsKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "load keys of kms plugin")
+	}
+	var keys []kmsKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key in %s", file)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key in %s", file)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, kmsKey{id: kmsKeyID(secret), aead: aead})
+	}
+	if len(keys) == 0 {
+		return nil, errors.Errorf("no keys in %s", file)
+	}
+	return keys, nil
+}
+
+// KMSPlugin is a kms v2 plugin stand-in which encrypts the data encryption keys of apiserver with local keys.
+// keys are reloaded on status checks of apiserver, so appending a key to the file rotates it on the fly
+type KMSPlugin struct {
+	sync.Mutex
+	keyFile string
+	keys    []kmsKey
+}
+
+var _ service.Service = &KMSPlugin{}
+
+func NewKMSPlugin(keyFile string) (*KMSPlugin, error) {
+	plugin := &KMSPlugin{keyFile: keyFile}
+	if err := plugin.reload(); err != nil {
+		return nil, err
+	}
+	return plugin, nil
+}
+
+func (p *KMSPlugin) reload() error {
+	keys, err := loadKMSKeys(p.keyFile)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.keys = keys
+	return nil
+}
+
+// current returns the key data is encrypted with
+func (p *KMSPlugin) current() kmsKey {
+	p.Lock()
+	defer p.Unlock()
+	return p.keys[len(p.keys)-1]
+}
+
+func (p *KMSPlugin) lookup(id string) (kmsKey, bool) {
+	p.Lock()
+	defer p.Unlock()
+	for _, key := range p.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return kmsKey{}, false
+}
+
+// Encrypt seals data with the current key, the nonce is prepended to the ciphertext
+func (p *KMSPlugin) Encrypt(ctx context.Context, uid string, data []byte) (*service.EncryptResponse, error) {
+	key := p.current()
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &service.EncryptResponse{Ciphertext: key.aead.Seal(nonce, nonce, data, nil), KeyID: key.id}, nil
+}
+
+// Decrypt opens the ciphertext with the key it's encrypted with
+func (p *KMSPlugin) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	key, ok := p.lookup(req.KeyID)
+	if !ok {
+		// the key may be appended after the last status check
+		if err := p.reload(); err != nil {
+			return nil, err
+		}
+		if key, ok = p.lookup(req.KeyID); !ok {
+			return nil, errors.Errorf("key %s isn't found in %s", req.KeyID, p.keyFile)
+		}
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(req.Ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return key.aead.Open(nil, req.Ciphertext[:nonceSize], req.Ciphertext[nonceSize:], nil)
+}
+
+// Status reloads keys and reports the current key, apiserver generates a new data encryption key once it changes
+func (p *KMSPlugin) Status(ctx context.Context) (*service.StatusResponse, error) {
+	if err := p.reload(); err != nil {
+		loggerForEncryption.WithError(err).Warn("reload keys of kms plugin failed, the loaded keys are kept")
+	}
+	return &service.StatusResponse{Version: "v2", Healthz: "ok", KeyID: p.current().id}, nil
+}
+
+// Run serves plugin on the unix socket until ctx is done, it returns once the socket is ready so that apiserver
+// can connect to it as soon as it starts
+func (p *KMSPlugin) Run(ctx context.Context, socket string) error {
+	// the socket of the last run is left if simulator is killed
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrapf(err, "kms plugin listen on %s", socket)
+	}
+	server := grpc.NewServer(grpc.ConnectionTimeout(kmsTimeout))
+	kmsapi.RegisterKeyManagementServiceServer(server, service.NewGRPCService(socket, kmsTimeout, p))
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+	go func() {
+		loggerForEncryption.Infof("kms plugin listen on %s", socket)
+		if err := server.Serve(listener); err != nil {
+			loggerForEncryption.WithError(err).Error("kms plugin exited")
+		}
+	}()
+	return nil
+}
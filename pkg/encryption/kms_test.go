@@ -0,0 +1,54 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+func TestKMSPlugin(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "kms.key")
+	if err := EnsureKMSKeyFile(keyFile); err != nil {
+		t.Fatalf("EnsureKMSKeyFile failed: %v", err)
+	}
+	plugin, err := NewKMSPlugin(keyFile)
+	if err != nil {
+		t.Fatalf("NewKMSPlugin failed: %v", err)
+	}
+	ctx := context.Background()
+	plaintext := []byte("data encryption key")
+	encrypted, err := plugin.Encrypt(ctx, "uid", plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if bytes.Contains(encrypted.Ciphertext, plaintext) {
+		t.Error("Expected ciphertext not to contain plaintext")
+	}
+
+	// 追加新 key 后, status 返回新的 key id, 旧 key 加密的数据仍可解密
+	id, err := AppendKMSKey(keyFile)
+	if err != nil {
+		t.Fatalf("AppendKMSKey failed: %v", err)
+	}
+	status, err := plugin.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.KeyID != id || status.KeyID == encrypted.KeyID {
+		t.Errorf("Expected current key %s after rotation, got %s", id, status.KeyID)
+	}
+	decrypted, err := plugin.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: encrypted.Ciphertext, KeyID: encrypted.KeyID})
+	if err != nil {
+		t.Fatalf("Decrypt with old key failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Expected %s, got %s", plaintext, decrypted)
+	}
+
+	if _, err := plugin.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: encrypted.Ciphertext, KeyID: "unknown"}); err == nil {
+		t.Error("Expected decrypt with unknown key to fail")
+	}
+}
@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	// kine stores objects in sqlite with this driver
+	_ "github.com/mattn/go-sqlite3"
+	kmstypes "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/v2"
+)
+
+const (
+	// registryPrefix is the prefix of keys of objects apiserver stores
+	registryPrefix = "/registry/"
+	// encryptedPrefix is the prefix of values written by encrypting providers, k8s:enc:<provider>:<version>:<name>:
+	encryptedPrefix = "k8s:enc:"
+)
+
+// StoredObject represent how an object is stored by apiserver
+type StoredObject struct {
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Provider is the provider the object is written with, identity if it's plain text
+	Provider Provider `json:"provider"`
+	// Key is the name of the key of aescbc, aesgcm and secretbox, or <plugin>/<key id> of kms v2
+	Key string `json:"key,omitempty"`
+}
+
+// Encrypted returns true if the object isn't stored in plain text
+func (o *StoredObject) Encrypted() bool {
+	return o.Provider != ProviderIdentity
+}
+
+// ReadStoredObjects reads the latest revisions of objects of resources from the kine database, it can be read
+// while apiserver is running
+func ReadStoredObjects(dbFile string, resources []string) ([]StoredObject, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, errors.Wrap(err, "kine database isn't found, has simulator been started?")
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var objects []StoredObject
+	for _, resource := range resources {
+		// objects of resources in groups are stored without the group, e.g. /registry/deployments/
+		prefix := registryPrefix + strings.SplitN(resource, ".", 2)[0] + "/"
+		rows, err := db.Query(`SELECT kv.name, kv.value FROM kine AS kv
+			JOIN (SELECT MAX(id) AS id FROM kine WHERE name LIKE ? GROUP BY name) AS latest ON latest.id = kv.id
+			WHERE kv.deleted = 0 ORDER BY kv.name`, prefix+"%")
+		if err != nil {
+			return nil, errors.Wrap(err, "read kine database")
+		}
+		for rows.Next() {
+			var name string
+			var value []byte
+			if err := rows.Scan(&name, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			object := StoredObject{Resource: resource}
+			parts := strings.Split(strings.TrimPrefix(name, prefix), "/")
+			if len(parts) == 2 {
+				object.Namespace = parts[0]
+			}
+			object.Name = parts[len(parts)-1]
+			object.Provider, object.Key = providerOfValue(value)
+			objects = append(objects, object)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// providerOfValue returns the provider and key a stored value is written with
+func providerOfValue(value []byte) (Provider, string) {
+	if !bytes.HasPrefix(value, []byte(encryptedPrefix)) {
+		return ProviderIdentity, ""
+	}
+	// <provider>:<version>:<name>:<ciphertext>
+	parts := bytes.SplitN(value[len(encryptedPrefix):], []byte(":"), 4)
+	if len(parts) != 4 {
+		return Provider(parts[0]), ""
+	}
+	provider, version, name := Provider(parts[0]), string(parts[1]), string(parts[2])
+	if provider == ProviderKMS && version == "v2" {
+		encrypted := &kmstypes.EncryptedObject{}
+		if err := proto.Unmarshal(parts[3], encrypted); err == nil {
+			return provider, name + "/" + encrypted.KeyID
+		}
+	}
+	return provider, name
+}
@@ -0,0 +1,86 @@
+package encryption
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	kmstypes "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/v2"
+)
+
+func TestProviderOfValue(t *testing.T) {
+	kmsObject, err := proto.Marshal(&kmstypes.EncryptedObject{EncryptedData: []byte("data"), KeyID: "0123", EncryptedDEKSource: []byte("dek")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name         string
+		value        []byte
+		wantProvider Provider
+		wantKey      string
+	}{
+		{name: "plain", value: []byte("k8s\x00\n\x0cv1\x12\x06Secret"), wantProvider: ProviderIdentity},
+		{name: "aescbc", value: []byte("k8s:enc:aescbc:v1:key2:\x01\x02"), wantProvider: ProviderAESCBC, wantKey: "key2"},
+		{name: "secretbox", value: []byte("k8s:enc:secretbox:v1:key1:\x01"), wantProvider: ProviderSecretbox, wantKey: "key1"},
+		// kms v2 的 key id 在 EncryptedObject 中
+		{name: "kms v2", value: append([]byte("k8s:enc:kms:v2:kube-simulator:"), kmsObject...), wantProvider: ProviderKMS, wantKey: "kube-simulator/0123"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider, key := providerOfValue(c.value)
+			if provider != c.wantProvider || key != c.wantKey {
+				t.Errorf("Expected %s %s, got %s %s", c.wantProvider, c.wantKey, provider, key)
+			}
+		})
+	}
+}
+
+func TestReadStoredObjects(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "simukube.db")
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// 与 kine 的表结构一致的最小子集
+	if _, err := db.Exec(`CREATE TABLE kine (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, deleted INTEGER, value BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	rows := []struct {
+		name    string
+		deleted int
+		value   string
+	}{
+		{"/registry/secrets/default/plain", 0, "k8s\x00plain"},
+		// 同一对象的旧版本以明文写入, 最新版本已加密
+		{"/registry/secrets/default/rewritten", 0, "k8s\x00plain"},
+		{"/registry/secrets/default/rewritten", 0, "k8s:enc:aescbc:v1:key1:\x01"},
+		{"/registry/secrets/kube-system/deleted", 0, "k8s:enc:aescbc:v1:key1:\x01"},
+		{"/registry/secrets/kube-system/deleted", 1, "k8s:enc:aescbc:v1:key1:\x01"},
+		{"/registry/configmaps/default/cm", 0, "k8s\x00plain"},
+	}
+	for _, row := range rows {
+		if _, err := db.Exec(`INSERT INTO kine (name, deleted, value) VALUES (?, ?, ?)`, row.name, row.deleted, []byte(row.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := ReadStoredObjects(dbFile, []string{"secrets"})
+	if err != nil {
+		t.Fatalf("ReadStoredObjects failed: %v", err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("Expected 2 secrets, got %+v", objects)
+	}
+	if objects[0].Name != "plain" || objects[0].Namespace != "default" || objects[0].Encrypted() {
+		t.Errorf("Expected default/plain in plain text, got %+v", objects[0])
+	}
+	if objects[1].Name != "rewritten" || objects[1].Provider != ProviderAESCBC || objects[1].Key != "key1" {
+		t.Errorf("Expected default/rewritten encrypted with aescbc key1, got %+v", objects[1])
+	}
+
+	if _, err := ReadStoredObjects(filepath.Join(t.TempDir(), "missing.db"), DefaultResources); err == nil {
+		t.Error("Expected missing database to fail")
+	}
+}
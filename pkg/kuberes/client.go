@@ -14,6 +14,11 @@ func NewClusterClient(masterUrl, kubeConfig string) (*kubernetes.Clientset, erro
 	return kubernetes.NewForConfig(restConfig)
 }
 
+// NewClusterRestConfig returns the rest config of kubeConfig, clients of other identities copy server and ca from it
+func NewClusterRestConfig(masterUrl, kubeConfig string) (*rest.Config, error) {
+	return buildClientConfig(masterUrl, kubeConfig)
+}
+
 func buildClientConfig(masterUrl, kubeConfig string) (*rest.Config, error) {
 	cfgLoadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	cfgLoadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
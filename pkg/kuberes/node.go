@@ -6,16 +6,24 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func NewNodeObject(nodeName, nodeIp, podCIDR string) *coreapi.Node {
+// NewNodeObject returns a ready node, podCIDRs are one per ip family and the first one is the primary
+func NewNodeObject(nodeName, nodeIp string, podCIDRs ...string) *coreapi.Node {
+	var podCIDR string
+	if len(podCIDRs) != 0 {
+		podCIDR = podCIDRs[0]
+	}
 	node := &coreapi.Node{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: nodeName,
 			Labels: map[string]string{
-				"kubernetes.io/hostname": nodeName,
+				coreapi.LabelHostname:   nodeName,
+				coreapi.LabelOSStable:   "linux",
+				coreapi.LabelArchStable: "amd64",
 			},
 		},
 		Spec: coreapi.NodeSpec{
-			PodCIDR: podCIDR,
+			PodCIDR:  podCIDR,
+			PodCIDRs: podCIDRs,
 		},
 		Status: coreapi.NodeStatus{
 			Addresses: []coreapi.NodeAddress{
@@ -90,3 +98,19 @@ func NewNodeObject(nodeName, nodeIp, podCIDR string) *coreapi.Node {
 	}
 	return node
 }
+
+// SetNodeTopology labels node with the region and zone it's placed in, the deprecated failure-domain labels
+// are set as well for clients which still read them. empty region or zone is skipped
+func SetNodeTopology(node *coreapi.Node, region, zone string) {
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	if region != "" {
+		node.Labels[coreapi.LabelTopologyRegion] = region
+		node.Labels[coreapi.LabelFailureDomainBetaRegion] = region
+	}
+	if zone != "" {
+		node.Labels[coreapi.LabelTopologyZone] = zone
+		node.Labels[coreapi.LabelFailureDomainBetaZone] = zone
+	}
+}
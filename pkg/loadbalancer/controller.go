@@ -0,0 +1,316 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	coretyped "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// DefaultPool is the same range that MetalLB is usually given in kind clusters
+	DefaultPool = "172.18.255.200-172.18.255.250"
+	// DefaultClass is the loadBalancerClass of simulator, services without loadBalancerClass are handled as well
+	DefaultClass = "kube-simulator.io/load-balancer"
+)
+
+// event reasons, the same as the service controller of cloud providers where possible
+const (
+	EventReasonAllocated        = "IPAllocated"
+	EventReasonAllocationFailed = "AllocationFailed"
+	EventReasonReleased         = "IPReleased"
+)
+
+var loggerForLoadBalancer = logrus.WithField("component", "service-lb-controller")
+
+// Config represent config of the load balancer controller
+type Config struct {
+	// Pool is the ip ranges of ingress ips, see Pool
+	Pool  string
+	Class string
+}
+
+// Controller allocates ingress ips of LoadBalancer services from a pool, like MetalLB does in local clusters.
+// it doesn't forward any traffic
+type Controller struct {
+	client   kubeclientset.Interface
+	class    string
+	pool     *Pool
+	factory  informers.SharedInformerFactory
+	services corelisters.ServiceLister
+	queue    workqueue.RateLimitingInterface
+	recorder record.EventRecorder
+}
+
+func NewController(client kubeclientset.Interface, config Config) (*Controller, error) {
+	poolRanges := config.Pool
+	if poolRanges == "" {
+		poolRanges = DefaultPool
+	}
+	pool, err := NewPool(poolRanges)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid load balancer pool")
+	}
+	class := config.Class
+	if class == "" {
+		class = DefaultClass
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&coretyped.EventSinkImpl{Interface: client.CoreV1().Events(coreapi.NamespaceAll)})
+
+	controller := &Controller{
+		client:   client,
+		class:    class,
+		pool:     pool,
+		factory:  factory,
+		services: factory.Core().V1().Services().Lister(),
+		queue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder: broadcaster.NewRecorder(scheme.Scheme, coreapi.EventSource{Component: "service-lb-controller"}),
+	}
+	factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.enqueue,
+		UpdateFunc: func(_, newObj interface{}) { controller.enqueue(newObj) },
+		DeleteFunc: controller.enqueue,
+	})
+	return controller, nil
+}
+
+// Run syncs caches, restores allocated ips from status of services and then handles services until ctx is done.
+// it returns once the controller is ready
+func (c *Controller) Run(ctx context.Context) error {
+	c.factory.Start(ctx.Done())
+	for informerType, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("sync cache of %v failed", informerType)
+		}
+	}
+	if err := c.restore(); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+	// the pool isn't thread safe, so there is only one worker
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for c.processNextItem(ctx) {
+		}
+	}, time.Second)
+	loggerForLoadBalancer.Infof("load balancer controller allocates ips from %s", c.pool)
+	return nil
+}
+
+// restore claims ingress ips of services, so that they keep their ips after simulator restarted
+func (c *Controller) restore() error {
+	services, err := c.services.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, svc := range services {
+		if !c.handles(svc) {
+			continue
+		}
+		key := serviceKey(svc)
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			ip, err := netip.ParseAddr(ingress.IP)
+			if err != nil || !c.pool.Contains(ip) {
+				continue
+			}
+			if err := c.pool.Claim(key, ip); err != nil {
+				loggerForLoadBalancer.WithError(err).Warnf("failed restore ingress ip of service %s", key)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+	key := item.(string)
+	if err := c.sync(ctx, key); err != nil {
+		loggerForLoadBalancer.WithError(err).Warnf("sync service %s failed", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync allocates ingress ips of service key, or releases them if the service isn't a LoadBalancer of us anymore
+func (c *Controller) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	svc, err := c.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		c.pool.Release(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !c.handles(svc) {
+		allocated := c.pool.Allocated(key)
+		if len(allocated) == 0 {
+			return nil
+		}
+		c.pool.Release(key)
+		// only ingress of us are removed, the service may be handled by other controllers now
+		if err := c.updateIngress(ctx, svc, c.foreignIngress(svc)); err != nil {
+			return err
+		}
+		c.recorder.Eventf(svc, coreapi.EventTypeNormal, EventReasonReleased, "Released ingress ips %s", joinAddrs(allocated))
+		return nil
+	}
+
+	ips, err := c.allocate(key, svc)
+	if err != nil {
+		c.recorder.Event(svc, coreapi.EventTypeWarning, EventReasonAllocationFailed, err.Error())
+		return err
+	}
+	var ingress []coreapi.LoadBalancerIngress
+	for _, ip := range ips {
+		ingress = append(ingress, coreapi.LoadBalancerIngress{IP: ip.String()})
+	}
+	if ingressEqual(svc.Status.LoadBalancer.Ingress, ingress) {
+		return nil
+	}
+	if err := c.updateIngress(ctx, svc, ingress); err != nil {
+		return err
+	}
+	c.recorder.Eventf(svc, coreapi.EventTypeNormal, EventReasonAllocated, "Assigned ingress ips %s", joinAddrs(ips))
+	return nil
+}
+
+// allocate returns ips of svc, one for each ip family of svc. spec.loadBalancerIP is used if it's set,
+// ips already allocated are kept if they still fit
+func (c *Controller) allocate(key string, svc *coreapi.Service) ([]netip.Addr, error) {
+	families := svc.Spec.IPFamilies
+	if len(families) == 0 {
+		families = []coreapi.IPFamily{coreapi.IPv4Protocol}
+	}
+	var requested netip.Addr
+	if svc.Spec.LoadBalancerIP != "" {
+		ip, err := netip.ParseAddr(svc.Spec.LoadBalancerIP)
+		if err != nil {
+			return nil, errors.Errorf("invalid loadBalancerIP %s", svc.Spec.LoadBalancerIP)
+		}
+		requested = ip
+	}
+
+	existing := make(map[bool]netip.Addr)
+	for _, ip := range c.pool.Allocated(key) {
+		existing[ip.Is6()] = ip
+	}
+	var ips []netip.Addr
+	for _, family := range families {
+		ipv6 := family == coreapi.IPv6Protocol
+		if requested.IsValid() && requested.Is6() == ipv6 {
+			if current, ok := existing[ipv6]; ok && current != requested {
+				c.pool.Release(key)
+				return c.allocate(key, svc)
+			}
+			if err := c.pool.Claim(key, requested); err != nil {
+				return nil, errors.Wrapf(err, "loadBalancerIP %s is unavailable", requested)
+			}
+			ips = append(ips, requested)
+			continue
+		}
+		if current, ok := existing[ipv6]; ok {
+			ips = append(ips, current)
+			continue
+		}
+		ip, err := c.pool.Allocate(key, ipv6)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// handles returns true if svc is a LoadBalancer service of our class, or without any class
+func (c *Controller) handles(svc *coreapi.Service) bool {
+	if svc.Spec.Type != coreapi.ServiceTypeLoadBalancer {
+		return false
+	}
+	return svc.Spec.LoadBalancerClass == nil || *svc.Spec.LoadBalancerClass == c.class
+}
+
+// foreignIngress returns ingress of svc which isn't allocated by us
+func (c *Controller) foreignIngress(svc *coreapi.Service) []coreapi.LoadBalancerIngress {
+	var ingress []coreapi.LoadBalancerIngress
+	for _, item := range svc.Status.LoadBalancer.Ingress {
+		if ip, err := netip.ParseAddr(item.IP); err == nil && c.pool.Contains(ip) {
+			continue
+		}
+		ingress = append(ingress, item)
+	}
+	return ingress
+}
+
+func (c *Controller) updateIngress(ctx context.Context, svc *coreapi.Service, ingress []coreapi.LoadBalancerIngress) error {
+	updated := svc.DeepCopy()
+	updated.Status.LoadBalancer.Ingress = ingress
+	_, err := c.client.CoreV1().Services(svc.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+func ingressEqual(current, desired []coreapi.LoadBalancerIngress) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for idx := range current {
+		if current[idx].IP != desired[idx].IP || current[idx].Hostname != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func serviceKey(svc *coreapi.Service) string {
+	return svc.Namespace + "/" + svc.Name
+}
+
+func joinAddrs(ips []netip.Addr) string {
+	sort.Slice(ips, func(i, j int) bool { return ips[i].Less(ips[j]) })
+	values := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		values = append(values, ip.String())
+	}
+	return strings.Join(values, ",")
+}
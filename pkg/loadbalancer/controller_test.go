@@ -0,0 +1,191 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func newTestController(t *testing.T, pool string, objects ...runtime.Object) (*Controller, *record.FakeRecorder) {
+	t.Helper()
+	controller, err := NewController(fake.NewSimpleClientset(objects...), Config{Pool: pool})
+	if err != nil {
+		t.Fatalf("NewController should not return error: %v", err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	controller.factory.Start(ctx.Done())
+	controller.factory.WaitForCacheSync(ctx.Done())
+	if err := controller.restore(); err != nil {
+		t.Fatalf("restore should not return error: %v", err)
+	}
+	return controller, recorder
+}
+
+func newLoadBalancerService(name string) *coreapi.Service {
+	return &coreapi.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       coreapi.ServiceSpec{Type: coreapi.ServiceTypeLoadBalancer, ClusterIP: "10.96.0.20"},
+	}
+}
+
+func ingressOf(t *testing.T, controller *Controller, name string) []string {
+	t.Helper()
+	svc, err := controller.client.CoreV1().Services("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get service failed: %v", err)
+	}
+	var ips []string
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		ips = append(ips, ingress.IP)
+	}
+	return ips
+}
+
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, reason string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, reason) {
+			t.Errorf("Expected event %s, got %s", reason, event)
+		}
+	default:
+		t.Errorf("Expected event %s, got nothing", reason)
+	}
+}
+
+func TestController_Allocate(t *testing.T) {
+	requested := newLoadBalancerService("requested")
+	requested.Spec.LoadBalancerIP = "172.18.255.201"
+	dual := newLoadBalancerService("dual")
+	dual.Spec.IPFamilies = []coreapi.IPFamily{coreapi.IPv6Protocol, coreapi.IPv4Protocol}
+	controller, recorder := newTestController(t, "172.18.255.200-172.18.255.202,fd00:10:20::/120",
+		newLoadBalancerService("web"), requested, dual)
+	ctx := context.Background()
+
+	for _, key := range []string{"default/web", "default/requested", "default/dual"} {
+		if err := controller.sync(ctx, key); err != nil {
+			t.Fatalf("sync %s should not return error: %v", key, err)
+		}
+		expectEvent(t, recorder, EventReasonAllocated)
+	}
+	if ips := ingressOf(t, controller, "web"); len(ips) != 1 || ips[0] != "172.18.255.200" {
+		t.Errorf("Unexpected ingress of web %v", ips)
+	}
+	// spec.loadBalancerIP 被使用
+	if ips := ingressOf(t, controller, "requested"); len(ips) != 1 || ips[0] != "172.18.255.201" {
+		t.Errorf("Unexpected ingress of requested %v", ips)
+	}
+	// 双栈 Service 每个地址族分配一个 IP, 顺序与 ipFamilies 相同
+	if ips := ingressOf(t, controller, "dual"); len(ips) != 2 || ips[0] != "fd00:10:20::" || ips[1] != "172.18.255.202" {
+		t.Errorf("Unexpected ingress of dual %v", ips)
+	}
+}
+
+func TestController_PoolExhausted(t *testing.T) {
+	conflict := newLoadBalancerService("conflict")
+	conflict.Spec.LoadBalancerIP = "172.18.255.200"
+	controller, recorder := newTestController(t, "172.18.255.200/32", newLoadBalancerService("first"), newLoadBalancerService("second"), conflict)
+	ctx := context.Background()
+
+	if err := controller.sync(ctx, "default/first"); err != nil {
+		t.Fatalf("sync should not return error: %v", err)
+	}
+	expectEvent(t, recorder, EventReasonAllocated)
+	if err := controller.sync(ctx, "default/second"); err == nil {
+		t.Error("Expected error when pool is exhausted")
+	}
+	expectEvent(t, recorder, EventReasonAllocationFailed)
+	if err := controller.sync(ctx, "default/conflict"); err == nil {
+		t.Error("Expected error when loadBalancerIP is used by another service")
+	}
+	expectEvent(t, recorder, EventReasonAllocationFailed)
+
+	// 删除 Service 后释放 IP
+	controller.client.CoreV1().Services("default").Delete(ctx, "first", metav1.DeleteOptions{})
+	controller.factory.Core().V1().Services().Informer().GetIndexer().Delete(newLoadBalancerService("first"))
+	if err := controller.sync(ctx, "default/first"); err != nil {
+		t.Fatalf("sync should not return error: %v", err)
+	}
+	if err := controller.sync(ctx, "default/second"); err != nil {
+		t.Errorf("Expected released ip to be allocated, got %v", err)
+	}
+}
+
+func TestController_LoadBalancerClass(t *testing.T) {
+	other := newLoadBalancerService("other")
+	other.Spec.LoadBalancerClass = ptr.To("example.com/lb")
+	ours := newLoadBalancerService("ours")
+	ours.Spec.LoadBalancerClass = ptr.To(DefaultClass)
+	clusterIP := newLoadBalancerService("cluster-ip")
+	clusterIP.Spec.Type = coreapi.ServiceTypeClusterIP
+	controller, _ := newTestController(t, DefaultPool, other, ours, clusterIP)
+	ctx := context.Background()
+
+	for _, key := range []string{"default/other", "default/ours", "default/cluster-ip"} {
+		if err := controller.sync(ctx, key); err != nil {
+			t.Fatalf("sync %s should not return error: %v", key, err)
+		}
+	}
+	if ips := ingressOf(t, controller, "other"); len(ips) != 0 {
+		t.Errorf("Expected service of another class to be ignored, got %v", ips)
+	}
+	if ips := ingressOf(t, controller, "ours"); len(ips) != 1 {
+		t.Errorf("Expected service of our class to be handled, got %v", ips)
+	}
+	if ips := ingressOf(t, controller, "cluster-ip"); len(ips) != 0 {
+		t.Errorf("Expected ClusterIP service to be ignored, got %v", ips)
+	}
+}
+
+func TestController_ReleaseWhenTypeChanged(t *testing.T) {
+	svc := newLoadBalancerService("web")
+	svc.Status.LoadBalancer.Ingress = []coreapi.LoadBalancerIngress{{IP: "172.18.255.200"}, {Hostname: "lb.example.com"}}
+	svc.Spec.Type = coreapi.ServiceTypeClusterIP
+	controller, recorder := newTestController(t, DefaultPool, svc)
+	// Service 类型修改前分配的 IP
+	controller.pool.Claim("default/web", netip.MustParseAddr("172.18.255.200"))
+
+	if err := controller.sync(context.Background(), "default/web"); err != nil {
+		t.Fatalf("sync should not return error: %v", err)
+	}
+	expectEvent(t, recorder, EventReasonReleased)
+	updated, _ := controller.client.CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if ingress := updated.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].Hostname != "lb.example.com" {
+		t.Errorf("Expected only ingress of us to be removed, got %v", ingress)
+	}
+	if len(controller.pool.Allocated("default/web")) != 0 {
+		t.Error("Expected ip to be released")
+	}
+}
+
+func TestController_Restore(t *testing.T) {
+	existing := newLoadBalancerService("existing")
+	existing.Status.LoadBalancer.Ingress = []coreapi.LoadBalancerIngress{{IP: "172.18.255.200"}}
+	controller, _ := newTestController(t, DefaultPool, existing, newLoadBalancerService("new"))
+	ctx := context.Background()
+
+	if err := controller.sync(ctx, "default/new"); err != nil {
+		t.Fatalf("sync should not return error: %v", err)
+	}
+	if ips := ingressOf(t, controller, "new"); len(ips) != 1 || ips[0] != "172.18.255.201" {
+		t.Errorf("Expected ip of existing service not to be reused, got %v", ips)
+	}
+	if err := controller.sync(ctx, "default/existing"); err != nil {
+		t.Fatalf("sync should not return error: %v", err)
+	}
+	if ips := ingressOf(t, controller, "existing"); len(ips) != 1 || ips[0] != "172.18.255.200" {
+		t.Errorf("Expected existing service to keep its ip, got %v", ips)
+	}
+}
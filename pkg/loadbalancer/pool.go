@@ -0,0 +1,172 @@
+package loadbalancer
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pool represent ip ranges ingress ips of LoadBalancer services are allocated from, ranges are given as cidrs
+// or first-last, e.g. 172.18.255.200-172.18.255.250,fd00:10:20::/120
+type Pool struct {
+	ranges []ipRange
+	// owners are the services that ips are allocated to
+	owners map[netip.Addr]string
+}
+
+func NewPool(ranges string) (*Pool, error) {
+	pool := &Pool{owners: make(map[netip.Addr]string)}
+	for _, value := range strings.Split(ranges, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		r, err := parseRange(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, existing := range pool.ranges {
+			if existing.overlaps(r) {
+				return nil, errors.Errorf("ip range %s overlaps with %s", r, existing)
+			}
+		}
+		pool.ranges = append(pool.ranges, r)
+	}
+	if len(pool.ranges) == 0 {
+		return nil, errors.New("pool has no ip ranges")
+	}
+	return pool, nil
+}
+
+// Contains returns true if ip is in any range of pool
+func (p *Pool) Contains(ip netip.Addr) bool {
+	for _, r := range p.ranges {
+		if r.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allocate allocates the first free ip of the family to owner
+func (p *Pool) Allocate(owner string, ipv6 bool) (netip.Addr, error) {
+	for _, r := range p.ranges {
+		if r.from.Is6() != ipv6 {
+			continue
+		}
+		for ip := r.from; r.contains(ip); ip = ip.Next() {
+			if _, used := p.owners[ip]; !used {
+				p.owners[ip] = owner
+				return ip, nil
+			}
+			if ip == r.to {
+				break
+			}
+		}
+	}
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return netip.Addr{}, errors.Errorf("no %s address is available in pool %s", family, p)
+}
+
+// Claim allocates ip to owner, it fails if ip is out of pool or used by another owner
+func (p *Pool) Claim(owner string, ip netip.Addr) error {
+	if !p.Contains(ip) {
+		return errors.Errorf("%s is not in pool %s", ip, p)
+	}
+	if current, used := p.owners[ip]; used && current != owner {
+		return errors.Errorf("%s is already used by %s", ip, current)
+	}
+	p.owners[ip] = owner
+	return nil
+}
+
+// Release frees all ips of owner
+func (p *Pool) Release(owner string) {
+	for ip, current := range p.owners {
+		if current == owner {
+			delete(p.owners, ip)
+		}
+	}
+}
+
+// Allocated returns ips of owner
+func (p *Pool) Allocated(owner string) []netip.Addr {
+	var ips []netip.Addr
+	for ip, current := range p.owners {
+		if current == owner {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func (p *Pool) String() string {
+	var ranges []string
+	for _, r := range p.ranges {
+		ranges = append(ranges, r.String())
+	}
+	return strings.Join(ranges, ",")
+}
+
+// ipRange represent ips from first to last, both of them are included
+type ipRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+// parseRange parses a cidr or first-last
+func parseRange(value string) (ipRange, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return ipRange{}, errors.Wrapf(err, "invalid cidr %s", value)
+		}
+		prefix = prefix.Masked()
+		return ipRange{from: prefix.Addr(), to: lastAddr(prefix)}, nil
+	}
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		last = first
+	}
+	from, err := netip.ParseAddr(strings.TrimSpace(first))
+	if err != nil {
+		return ipRange{}, errors.Wrapf(err, "invalid ip range %s", value)
+	}
+	to, err := netip.ParseAddr(strings.TrimSpace(last))
+	if err != nil {
+		return ipRange{}, errors.Wrapf(err, "invalid ip range %s", value)
+	}
+	if from.Is4() != to.Is4() || to.Less(from) {
+		return ipRange{}, errors.Errorf("invalid ip range %s", value)
+	}
+	return ipRange{from: from, to: to}, nil
+}
+
+func (r ipRange) contains(ip netip.Addr) bool {
+	return ip.BitLen() == r.from.BitLen() && r.from.Compare(ip) <= 0 && ip.Compare(r.to) <= 0
+}
+
+func (r ipRange) overlaps(other ipRange) bool {
+	return r.contains(other.from) || r.contains(other.to) || other.contains(r.from)
+}
+
+func (r ipRange) String() string {
+	if r.from == r.to {
+		return r.from.String()
+	}
+	return r.from.String() + "-" + r.to.String()
+}
+
+// lastAddr returns the last address of prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
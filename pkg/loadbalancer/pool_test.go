@@ -0,0 +1,74 @@
+package loadbalancer
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestNewPool(t *testing.T) {
+	pool, err := NewPool("172.18.255.200-172.18.255.201, 10.20.0.0/30,fd00:10:20::/126")
+	if err != nil {
+		t.Fatalf("NewPool should not return error: %v", err)
+	}
+	if pool.String() != "172.18.255.200-172.18.255.201,10.20.0.0-10.20.0.3,fd00:10:20::-fd00:10:20::3" {
+		t.Errorf("Unexpected pool %s", pool)
+	}
+
+	invalid := []string{
+		"",
+		"10.20.0.0/33",
+		"10.20.0.5-10.20.0.1",
+		"10.20.0.1-fd00::1",
+		"10.20.0.0/24,10.20.0.100-10.20.1.1",
+	}
+	for _, ranges := range invalid {
+		if _, err := NewPool(ranges); err == nil {
+			t.Errorf("Expected error for pool %q", ranges)
+		}
+	}
+}
+
+func TestPool_Allocate(t *testing.T) {
+	pool, _ := NewPool("172.18.255.200-172.18.255.201,fd00:10:20::/127")
+
+	first, _ := pool.Allocate("default/a", false)
+	second, _ := pool.Allocate("default/b", false)
+	if first.String() != "172.18.255.200" || second.String() != "172.18.255.201" {
+		t.Errorf("Expected ips to be allocated in order, got %s %s", first, second)
+	}
+	// 地址池耗尽
+	if _, err := pool.Allocate("default/c", false); err == nil {
+		t.Error("Expected error when pool is exhausted")
+	}
+	if ip, err := pool.Allocate("default/c", true); err != nil || ip.String() != "fd00:10:20::" {
+		t.Errorf("Expected IPv6 ip to be allocated, got %s %v", ip, err)
+	}
+
+	// 释放后可以重新分配
+	pool.Release("default/a")
+	if ip, err := pool.Allocate("default/d", false); err != nil || ip != first {
+		t.Errorf("Expected released ip to be reused, got %s %v", ip, err)
+	}
+	if len(pool.Allocated("default/c")) != 1 {
+		t.Errorf("Expected default/c to have 1 ip, got %v", pool.Allocated("default/c"))
+	}
+}
+
+func TestPool_Claim(t *testing.T) {
+	pool, _ := NewPool("10.20.0.0/30")
+
+	ip := netip.MustParseAddr("10.20.0.2")
+	if err := pool.Claim("default/a", ip); err != nil {
+		t.Fatalf("Claim should not return error: %v", err)
+	}
+	// 同一个 Service 重复申请不报错
+	if err := pool.Claim("default/a", ip); err != nil {
+		t.Errorf("Claim by the same owner should not return error: %v", err)
+	}
+	if err := pool.Claim("default/b", ip); err == nil {
+		t.Error("Expected error when ip is used by another service")
+	}
+	if err := pool.Claim("default/b", netip.MustParseAddr("10.20.1.1")); err == nil {
+		t.Error("Expected error when ip is out of pool")
+	}
+}
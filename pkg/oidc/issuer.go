@@ -0,0 +1,191 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/pkg/serviceaccount"
+)
+
+const (
+	// DefaultClientID is the audience of id tokens, apiserver only accepts tokens issued to it
+	DefaultClientID = "kube-simulator"
+	// DefaultPrefix is prepended to users and groups from id tokens, so they never collide with other users
+	DefaultPrefix = "oidc:"
+	// UsernameClaim and GroupsClaim are claims of id tokens apiserver reads the user and groups from
+	UsernameClaim = "sub"
+	GroupsClaim   = "groups"
+)
+
+// SigningAlgs are algorithms of keys the issuer may sign with
+var SigningAlgs = []string{"RS256", "ES256", "ES384", "ES512"}
+
+var loggerForOIDC = logrus.WithField("component", "oidc-issuer")
+
+// Config represent the oidc issuer stand-in served by simulator, it's disabled if Listen is empty
+type Config struct {
+	// Listen is the address issuer serves discovery documents on over https, the issuer url is https://<Listen>
+	Listen   string
+	ClientID string
+	// UsernamePrefix and GroupsPrefix are prepended to users and groups by apiserver
+	UsernamePrefix string
+	GroupsPrefix   string
+	// Cert is the serving certificate of issuer, it's signed by the cluster ca
+	Cert mycertutil.CertKeyPair
+	// SigningKeyFile signs id tokens, SigningPublicKeyFile is generated along with it
+	SigningKeyFile       string
+	SigningPublicKeyFile string
+}
+
+// Enabled returns true if simulator serves the issuer
+func (c *Config) Enabled() bool {
+	return c.Listen != ""
+}
+
+// IssuerURL returns the url of issuer, which is the iss claim of id tokens
+func (c *Config) IssuerURL() string {
+	return (&url.URL{Scheme: "https", Host: c.Listen}).String()
+}
+
+// ValidateListen checks that issuer listens on a host which clients can connect to, since it's a part of the
+// issuer url
+func ValidateListen(listen string) error {
+	if listen == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.Errorf("host of %s is required", listen)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return errors.Errorf("host of %s can't be unspecified, it's a part of the issuer url", listen)
+	}
+	return nil
+}
+
+// TokenRequest represent the subject and lifetime of an id token
+type TokenRequest struct {
+	User   string
+	Groups []string
+	TTL    time.Duration
+}
+
+// Validate checks that user is specified and ttl is positive
+func (r *TokenRequest) Validate() error {
+	if r.User == "" {
+		return errors.New("user is required")
+	}
+	if r.TTL <= 0 {
+		return errors.New("ttl should be positive")
+	}
+	return nil
+}
+
+// idTokenClaims are claims of id tokens besides the registered ones
+type idTokenClaims struct {
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Issuer issues id tokens and serves the discovery documents and keys apiserver verifies them with, like an
+// identity provider does
+type Issuer struct {
+	url       string
+	clientID  string
+	generator serviceaccount.TokenGenerator
+	metadata  *serviceaccount.OpenIDMetadata
+}
+
+func NewIssuer(issuerURL, clientID string, signingKey crypto.Signer) (*Issuer, error) {
+	if clientID == "" {
+		clientID = DefaultClientID
+	}
+	generator, err := serviceaccount.JWTTokenGenerator(issuerURL, signingKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := serviceaccount.NewOpenIDMetadata(issuerURL, issuerURL+serviceaccount.JWKSPath, "", []interface{}{signingKey.Public()})
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{url: issuerURL, clientID: clientID, generator: generator, metadata: metadata}, nil
+}
+
+// LoadIssuer returns the issuer of config with the signing key on disk
+func LoadIssuer(config *Config) (*Issuer, error) {
+	key, err := keyutil.PrivateKeyFromFile(config.SigningKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load signing key of oidc issuer")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("signing key %s is not supported", config.SigningKeyFile)
+	}
+	return NewIssuer(config.IssuerURL(), config.ClientID, signer)
+}
+
+// Token issues an id token of request, it returns the token and when it expires
+func (i *Issuer) Token(request TokenRequest) (string, time.Time, error) {
+	if err := request.Validate(); err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiry := now.Add(request.TTL)
+	token, err := i.generator.GenerateToken(&jwt.Claims{
+		Subject:   request.User,
+		Audience:  jwt.Audience{i.clientID},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(expiry),
+	}, &idTokenClaims{Groups: request.Groups})
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "sign id token")
+	}
+	return token, expiry, nil
+}
+
+// ServeHTTP serves the discovery document and the keys of issuer
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case serviceaccount.OpenIDConfigPath:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(i.metadata.ConfigJSON)
+	case serviceaccount.JWKSPath:
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Write(i.metadata.PublicKeysetJSON)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Run serves issuer on listen over https until ctx is done, it returns once the listener is ready so that
+// apiserver can fetch the keys as soon as it starts
+func (i *Issuer) Run(ctx context.Context, listen string, cert mycertutil.CertKeyPair) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrapf(err, "oidc issuer listen on %s", listen)
+	}
+	server := &http.Server{Handler: i, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		loggerForOIDC.Infof("oidc issuer %s listen on %s", i.url, listen)
+		if err := server.ServeTLS(listener, cert.CertFile, cert.KeyFile); err != nil && err != http.ErrServerClosed {
+			loggerForOIDC.WithError(err).Error("oidc issuer exited")
+		}
+	}()
+	return nil
+}
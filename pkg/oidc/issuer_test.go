@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func newTestIssuer(t *testing.T, algorithm mycertutil.KeyAlgorithm) *Issuer {
+	t.Helper()
+	dir := t.TempDir()
+	config := &Config{
+		Listen:               "127.0.0.1:10281",
+		SigningKeyFile:       filepath.Join(dir, "oidc-signing.key"),
+		SigningPublicKeyFile: filepath.Join(dir, "oidc-signing.pub"),
+	}
+	generator, err := mycertutil.NewGenerator(mycertutil.Options{KeyAlgorithm: algorithm})
+	if err != nil {
+		t.Fatalf("NewGenerator failed: %v", err)
+	}
+	if err := generator.CreateServiceAccountKeyAndPublicKeyFiles(config.SigningKeyFile, config.SigningPublicKeyFile); err != nil {
+		t.Fatalf("create signing key failed: %v", err)
+	}
+	issuer, err := LoadIssuer(config)
+	if err != nil {
+		t.Fatalf("LoadIssuer failed: %v", err)
+	}
+	return issuer
+}
+
+func getJSON(t *testing.T, issuer *Issuer, path string, out interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	issuer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %s to be served, got %d", path, recorder.Code)
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode %s failed: %v", path, err)
+	}
+}
+
+func TestIssuer_Token(t *testing.T) {
+	for _, algorithm := range []mycertutil.KeyAlgorithm{mycertutil.KeyAlgorithmRSA2048, mycertutil.KeyAlgorithmECDSAP256} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			issuer := newTestIssuer(t, algorithm)
+
+			var discovery struct {
+				Issuer  string `json:"issuer"`
+				JWKSURI string `json:"jwks_uri"`
+			}
+			getJSON(t, issuer, "/.well-known/openid-configuration", &discovery)
+			if discovery.Issuer != "https://127.0.0.1:10281" || discovery.JWKSURI != "https://127.0.0.1:10281/openid/v1/jwks" {
+				t.Errorf("Unexpected discovery document %+v", discovery)
+			}
+
+			token, expiry, err := issuer.Token(TokenRequest{User: "alice", Groups: []string{"dev", "ops"}, TTL: time.Hour})
+			if err != nil {
+				t.Fatalf("Token failed: %v", err)
+			}
+			// 用 jwks 中的公钥验证 token, 和 apiserver 的行为一致
+			var keys jose.JSONWebKeySet
+			getJSON(t, issuer, "/openid/v1/jwks", &keys)
+			parsed, err := jwt.ParseSigned(token)
+			if err != nil {
+				t.Fatalf("parse token failed: %v", err)
+			}
+			if len(parsed.Headers) != 1 || len(keys.Key(parsed.Headers[0].KeyID)) != 1 {
+				t.Fatalf("Expected key of token in jwks, got %v", parsed.Headers)
+			}
+			var claims jwt.Claims
+			var private idTokenClaims
+			if err := parsed.Claims(keys.Key(parsed.Headers[0].KeyID)[0].Key, &claims, &private); err != nil {
+				t.Fatalf("verify token failed: %v", err)
+			}
+			if err := claims.Validate(jwt.Expected{Issuer: "https://127.0.0.1:10281", Subject: "alice", Audience: jwt.Audience{DefaultClientID}, Time: time.Now()}); err != nil {
+				t.Errorf("Unexpected claims %+v: %v", claims, err)
+			}
+			if !slices.Equal(private.Groups, []string{"dev", "ops"}) {
+				t.Errorf("Expected groups dev,ops, got %v", private.Groups)
+			}
+			if claims.Expiry.Time().Unix() != expiry.Unix() {
+				t.Errorf("Expected expiry %s, got %s", expiry, claims.Expiry.Time())
+			}
+		})
+	}
+}
+
+func TestIssuer_TokenInvalidRequest(t *testing.T) {
+	issuer := newTestIssuer(t, mycertutil.KeyAlgorithmECDSAP256)
+	for _, request := range []TokenRequest{{TTL: time.Hour}, {User: "alice"}} {
+		if _, _, err := issuer.Token(request); err == nil {
+			t.Errorf("Expected request %+v to be invalid", request)
+		}
+	}
+}
+
+func TestValidateListen(t *testing.T) {
+	for _, listen := range []string{"", "127.0.0.1:10281", "simulator.local:10281", "[::1]:10281"} {
+		if err := ValidateListen(listen); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", listen, err)
+		}
+	}
+	for _, listen := range []string{"127.0.0.1", ":10281", "0.0.0.0:10281", "[::]:10281"} {
+		if err := ValidateListen(listen); err == nil {
+			t.Errorf("Expected %q to be invalid", listen)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+)
+
+// ResolvePath is the path of the simulator api to resolve requests to services
+const ResolvePath = "/proxy/resolve"
+
+// ServeHTTP resolves the request described by query parameters, e.g.
+// /proxy/resolve?namespace=default&name=nginx&port=http&sourcePod=default/client
+func (p *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	request := Request{
+		Namespace:  query.Get("namespace"),
+		Name:       query.Get("name"),
+		Port:       query.Get("port"),
+		ClientIP:   query.Get("clientIP"),
+		SourceNode: query.Get("sourceNode"),
+		SourcePod:  query.Get("sourcePod"),
+		Mode:       query.Get("mode"),
+	}
+	if request.Namespace == "" {
+		request.Namespace = "default"
+	}
+	result, err := p.Resolve(request)
+	if err != nil {
+		simapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	simapi.WriteJSON(w, http.StatusOK, result)
+}
+
+// Query returns the query parameters of request for ResolvePath
+func (r Request) Query() url.Values {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"namespace":  r.Namespace,
+		"name":       r.Name,
+		"port":       r.Port,
+		"clientIP":   r.ClientIP,
+		"sourceNode": r.SourceNode,
+		"sourcePod":  r.SourcePod,
+		"mode":       r.Mode,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
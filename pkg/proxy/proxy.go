@@ -0,0 +1,470 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	discoveryapi "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var loggerForProxy = logrus.WithField("component", "service-proxy")
+
+// proxy modes of kube-proxy, iptables picks endpoints randomly while ipvs picks them round robin
+const (
+	ModeIPTables = "iptables"
+	ModeIPVS     = "ipvs"
+)
+
+const (
+	// DefaultSessionAffinityTimeout is the same as kube-apiserver defaults for ClientIP affinity
+	DefaultSessionAffinityTimeout = 10800 * time.Second
+
+	annotationTopologyMode       = "service.kubernetes.io/topology-mode"
+	annotationTopologyAwareHints = "service.kubernetes.io/topology-aware-hints"
+)
+
+// Config represent config of service proxy
+type Config struct {
+	Mode string
+}
+
+// Request represent a request to a service, the source of request is either a node or a pod
+type Request struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Port is the name or the number of the service port, it can be omitted if service has only one port
+	Port       string `json:"port,omitempty"`
+	ClientIP   string `json:"clientIP,omitempty"`
+	SourceNode string `json:"sourceNode,omitempty"`
+	// SourcePod is namespace/name of the client pod, its node and ip are used if not given
+	SourcePod string `json:"sourcePod,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+}
+
+// Endpoint represent an endpoint of service port
+type Endpoint struct {
+	IP          string   `json:"ip"`
+	Port        int32    `json:"port"`
+	NodeName    string   `json:"nodeName,omitempty"`
+	Zone        string   `json:"zone,omitempty"`
+	Pod         string   `json:"pod,omitempty"`
+	ZoneHints   []string `json:"zoneHints,omitempty"`
+	Ready       bool     `json:"ready"`
+	Serving     bool     `json:"serving"`
+	Terminating bool     `json:"terminating"`
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(int(e.Port)))
+}
+
+// Result represent how a request is routed, Endpoint is nil if the request is dropped
+type Result struct {
+	Service    string     `json:"service"`
+	ClusterIPs []string   `json:"clusterIPs"`
+	Port       int32      `json:"port"`
+	Mode       string     `json:"mode"`
+	Endpoint   *Endpoint  `json:"endpoint,omitempty"`
+	Candidates []Endpoint `json:"candidates"`
+	// Notes explains which rules selected the candidates
+	Notes []string `json:"notes,omitempty"`
+}
+
+// affinity records the endpoint a client sticks to
+type affinity struct {
+	endpoint string
+	lastUsed time.Time
+}
+
+// ServiceProxy emulates the service data plane of kube-proxy, it doesn't forward any traffic but tells
+// which endpoint a request would hit
+type ServiceProxy struct {
+	mode     string
+	factory  informers.SharedInformerFactory
+	services corelisters.ServiceLister
+	slices   discoverylisters.EndpointSliceLister
+	nodes    corelisters.NodeLister
+	pods     corelisters.PodLister
+
+	lock sync.Mutex
+	// roundRobin is the next endpoint index of service ports in ipvs mode
+	roundRobin map[string]int
+	// affinities are keyed by service port and client ip
+	affinities map[string]affinity
+	random     func(n int) int
+	clock      func() time.Time
+}
+
+func NewServiceProxy(client kubeclientset.Interface, config Config) *ServiceProxy {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeIPTables
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	return &ServiceProxy{
+		mode:       mode,
+		factory:    factory,
+		services:   factory.Core().V1().Services().Lister(),
+		slices:     factory.Discovery().V1().EndpointSlices().Lister(),
+		nodes:      factory.Core().V1().Nodes().Lister(),
+		pods:       factory.Core().V1().Pods().Lister(),
+		roundRobin: make(map[string]int),
+		affinities: make(map[string]affinity),
+		random:     rand.Intn,
+		clock:      time.Now,
+	}
+}
+
+// Run starts watching services and endpointslices, it returns once caches are synced
+func (p *ServiceProxy) Run(ctx context.Context) error {
+	p.factory.Start(ctx.Done())
+	for informerType, synced := range p.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("sync cache of %v failed", informerType)
+		}
+	}
+	loggerForProxy.Infof("service proxy is running in %s mode", p.mode)
+	return nil
+}
+
+// Resolve returns the endpoint that request would hit, like kube-proxy does for traffic to cluster ips
+func (p *ServiceProxy) Resolve(request Request) (*Result, error) {
+	mode := request.Mode
+	if mode == "" {
+		mode = p.mode
+	}
+	if mode != ModeIPTables && mode != ModeIPVS {
+		return nil, errors.Errorf("proxy mode %s is not supported", mode)
+	}
+	if err := p.completeSource(&request); err != nil {
+		return nil, err
+	}
+	svc, err := p.services.Services(request.Namespace).Get(request.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get service %s/%s failed", request.Namespace, request.Name)
+	}
+	if svc.Spec.Type == coreapi.ServiceTypeExternalName {
+		return nil, errors.Errorf("service %s/%s is an alias of %s by dns, it's not proxied", svc.Namespace, svc.Name, svc.Spec.ExternalName)
+	}
+	if svc.Spec.ClusterIP == coreapi.ClusterIPNone {
+		return nil, errors.Errorf("service %s/%s is headless, clients connect to pods directly", svc.Namespace, svc.Name)
+	}
+	servicePort, err := findServicePort(svc, request.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Service:    svc.Namespace + "/" + svc.Name,
+		ClusterIPs: svc.Spec.ClusterIPs,
+		Port:       servicePort.Port,
+		Mode:       mode,
+		Candidates: []Endpoint{},
+	}
+	endpoints, err := p.endpointsOf(svc, servicePort, addressTypeOf(svc, request.ClientIP))
+	if err != nil {
+		return nil, err
+	}
+	candidates, notes := p.categorizeEndpoints(svc, endpoints, request.SourceNode)
+	result.Candidates = candidates
+	result.Notes = notes
+	if len(candidates) == 0 {
+		result.Notes = append(result.Notes, "no endpoints are usable, the request is rejected")
+		return result, nil
+	}
+	result.Endpoint = p.selectEndpoint(svc, servicePort, candidates, request.ClientIP, mode, result)
+	return result, nil
+}
+
+// completeSource fills node and ip of request from its source pod
+func (p *ServiceProxy) completeSource(request *Request) error {
+	if request.SourcePod == "" {
+		return nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(request.SourcePod)
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = coreapi.NamespaceDefault
+	}
+	pod, err := p.pods.Pods(namespace).Get(name)
+	if err != nil {
+		return errors.Wrapf(err, "get source pod %s failed", request.SourcePod)
+	}
+	if request.SourceNode == "" {
+		request.SourceNode = pod.Spec.NodeName
+	}
+	if request.ClientIP == "" {
+		request.ClientIP = pod.Status.PodIP
+	}
+	return nil
+}
+
+// endpointsOf collects endpoints of servicePort from endpointslices of svc, sorted by address
+func (p *ServiceProxy) endpointsOf(svc *coreapi.Service, servicePort *coreapi.ServicePort, addressType discoveryapi.AddressType) ([]Endpoint, error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryapi.LabelServiceName: svc.Name})
+	slices, err := p.slices.EndpointSlices(svc.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var endpoints []Endpoint
+	for _, slice := range slices {
+		if slice.AddressType != addressType {
+			continue
+		}
+		port, ok := endpointSlicePort(slice, servicePort)
+		if !ok {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+			ep := newEndpoint(endpoint, port)
+			// the same endpoint may be in multiple slices while they're being updated
+			if seen[ep.String()] {
+				continue
+			}
+			seen[ep.String()] = true
+			endpoints = append(endpoints, ep)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		left, leftErr := netip.ParseAddr(endpoints[i].IP)
+		right, rightErr := netip.ParseAddr(endpoints[j].IP)
+		if leftErr != nil || rightErr != nil {
+			return endpoints[i].IP < endpoints[j].IP
+		}
+		return left.Less(right)
+	})
+	return endpoints, nil
+}
+
+// categorizeEndpoints picks usable endpoints like kube-proxy: ready endpoints, filtered by topology hints,
+// falling back to serving terminating endpoints. internalTrafficPolicy Local only uses endpoints on the source node
+func (p *ServiceProxy) categorizeEndpoints(svc *coreapi.Service, endpoints []Endpoint, sourceNode string) ([]Endpoint, []string) {
+	var notes []string
+	if svc.Spec.InternalTrafficPolicy != nil && *svc.Spec.InternalTrafficPolicy == coreapi.ServiceInternalTrafficPolicyLocal {
+		notes = append(notes, fmt.Sprintf("internalTrafficPolicy is Local, only endpoints on node %q are used", sourceNode))
+		local := filterEndpoints(endpoints, func(ep Endpoint) bool { return ep.NodeName == sourceNode && ep.Ready })
+		if len(local) == 0 {
+			local = filterEndpoints(endpoints, func(ep Endpoint) bool { return ep.NodeName == sourceNode && ep.Serving && ep.Terminating })
+			if len(local) != 0 {
+				notes = append(notes, "no local endpoints are ready, falling back to serving terminating endpoints")
+			}
+		}
+		return local, notes
+	}
+
+	zone := p.zoneOfNode(sourceNode)
+	useTopology := canUseTopology(svc, endpoints, zone)
+	if useTopology {
+		notes = append(notes, fmt.Sprintf("topology aware routing is used, only endpoints hinted for zone %q are used", zone))
+	}
+	cluster := filterEndpoints(endpoints, func(ep Endpoint) bool {
+		return ep.Ready && (!useTopology || containsString(ep.ZoneHints, zone))
+	})
+	if len(cluster) == 0 {
+		cluster = filterEndpoints(endpoints, func(ep Endpoint) bool { return ep.Serving && ep.Terminating })
+		if len(cluster) != 0 {
+			notes = append(notes, "no endpoints are ready, falling back to serving terminating endpoints")
+		}
+	}
+	return cluster, notes
+}
+
+// selectEndpoint picks one of candidates, clients stick to the same endpoint if service has ClientIP affinity
+func (p *ServiceProxy) selectEndpoint(svc *coreapi.Service, servicePort *coreapi.ServicePort, candidates []Endpoint, clientIP, mode string, result *Result) *Endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	portKey := fmt.Sprintf("%s/%s:%s", svc.Namespace, svc.Name, servicePort.Name)
+	now := p.clock()
+	sticky := svc.Spec.SessionAffinity == coreapi.ServiceAffinityClientIP && clientIP != ""
+	affinityKey := portKey + "/" + clientIP
+	if sticky {
+		if last, ok := p.affinities[affinityKey]; ok && now.Sub(last.lastUsed) < sessionAffinityTimeout(svc) {
+			for idx := range candidates {
+				if candidates[idx].String() == last.endpoint {
+					p.affinities[affinityKey] = affinity{endpoint: last.endpoint, lastUsed: now}
+					result.Notes = append(result.Notes, fmt.Sprintf("client %s sticks to %s by session affinity", clientIP, last.endpoint))
+					return &candidates[idx]
+				}
+			}
+		}
+	}
+
+	var selected *Endpoint
+	switch mode {
+	case ModeIPVS:
+		next := p.roundRobin[portKey] % len(candidates)
+		p.roundRobin[portKey] = next + 1
+		selected = &candidates[next]
+	default:
+		selected = &candidates[p.random(len(candidates))]
+	}
+	if sticky {
+		p.affinities[affinityKey] = affinity{endpoint: selected.String(), lastUsed: now}
+	}
+	return selected
+}
+
+func (p *ServiceProxy) zoneOfNode(nodeName string) string {
+	if nodeName == "" {
+		return ""
+	}
+	node, err := p.nodes.Get(nodeName)
+	if err != nil {
+		return ""
+	}
+	return node.Labels[coreapi.LabelTopologyZone]
+}
+
+// canUseTopology returns true if service asks for topology aware routing, all ready endpoints have
+// zone hints and at least one of them is hinted for zone, the same as kube-proxy
+func canUseTopology(svc *coreapi.Service, endpoints []Endpoint, zone string) bool {
+	hints, ok := svc.Annotations[annotationTopologyMode]
+	if !ok {
+		hints = svc.Annotations[annotationTopologyAwareHints]
+	}
+	if hints == "" || hints == "disabled" || hints == "Disabled" || zone == "" {
+		return false
+	}
+	hasEndpointForZone := false
+	for _, ep := range endpoints {
+		if !ep.Ready {
+			continue
+		}
+		if len(ep.ZoneHints) == 0 {
+			return false
+		}
+		if containsString(ep.ZoneHints, zone) {
+			hasEndpointForZone = true
+		}
+	}
+	return hasEndpointForZone
+}
+
+// findServicePort finds port of svc by name or number
+func findServicePort(svc *coreapi.Service, port string) (*coreapi.ServicePort, error) {
+	if port == "" {
+		if len(svc.Spec.Ports) == 1 {
+			return &svc.Spec.Ports[0], nil
+		}
+		return nil, errors.Errorf("service %s/%s has %d ports, port is required", svc.Namespace, svc.Name, len(svc.Spec.Ports))
+	}
+	number, _ := strconv.Atoi(port)
+	for idx := range svc.Spec.Ports {
+		servicePort := &svc.Spec.Ports[idx]
+		if servicePort.Name == port || int(servicePort.Port) == number {
+			return servicePort, nil
+		}
+	}
+	return nil, errors.Errorf("service %s/%s has no port %s", svc.Namespace, svc.Name, port)
+}
+
+// endpointSlicePort finds the port of slice for servicePort, they're matched by name
+func endpointSlicePort(slice *discoveryapi.EndpointSlice, servicePort *coreapi.ServicePort) (int32, bool) {
+	for _, port := range slice.Ports {
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+		if name != servicePort.Name || port.Port == nil {
+			continue
+		}
+		if port.Protocol != nil && *port.Protocol != servicePort.Protocol && servicePort.Protocol != "" {
+			continue
+		}
+		return *port.Port, true
+	}
+	return 0, false
+}
+
+// addressTypeOf returns the family of client ip, or the primary family of svc
+func addressTypeOf(svc *coreapi.Service, clientIP string) discoveryapi.AddressType {
+	if addr, err := netip.ParseAddr(clientIP); err == nil {
+		if addr.Unmap().Is4() {
+			return discoveryapi.AddressTypeIPv4
+		}
+		return discoveryapi.AddressTypeIPv6
+	}
+	if len(svc.Spec.IPFamilies) != 0 && svc.Spec.IPFamilies[0] == coreapi.IPv6Protocol {
+		return discoveryapi.AddressTypeIPv6
+	}
+	return discoveryapi.AddressTypeIPv4
+}
+
+func newEndpoint(endpoint discoveryapi.Endpoint, port int32) Endpoint {
+	ep := Endpoint{IP: endpoint.Addresses[0], Port: port, Ready: true}
+	if endpoint.Conditions.Ready != nil {
+		ep.Ready = *endpoint.Conditions.Ready
+	}
+	ep.Serving = ep.Ready
+	if endpoint.Conditions.Serving != nil {
+		ep.Serving = *endpoint.Conditions.Serving
+	}
+	if endpoint.Conditions.Terminating != nil {
+		ep.Terminating = *endpoint.Conditions.Terminating
+	}
+	if endpoint.NodeName != nil {
+		ep.NodeName = *endpoint.NodeName
+	}
+	if endpoint.Zone != nil {
+		ep.Zone = *endpoint.Zone
+	}
+	if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+		ep.Pod = endpoint.TargetRef.Namespace + "/" + endpoint.TargetRef.Name
+	}
+	if endpoint.Hints != nil {
+		for _, hint := range endpoint.Hints.ForZones {
+			ep.ZoneHints = append(ep.ZoneHints, hint.Name)
+		}
+	}
+	return ep
+}
+
+func sessionAffinityTimeout(svc *coreapi.Service) time.Duration {
+	config := svc.Spec.SessionAffinityConfig
+	if config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+		return time.Duration(*config.ClientIP.TimeoutSeconds) * time.Second
+	}
+	return DefaultSessionAffinityTimeout
+}
+
+func filterEndpoints(endpoints []Endpoint, predicate func(Endpoint) bool) []Endpoint {
+	filtered := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if predicate(ep) {
+			filtered = append(filtered, ep)
+		}
+	}
+	return filtered
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	discoveryapi "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// testEndpoint describes an endpoint of the test endpointslice
+type testEndpoint struct {
+	ip          string
+	node        string
+	zone        string
+	hints       []string
+	ready       bool
+	terminating bool
+}
+
+func newTestService(name string) *coreapi.Service {
+	return &coreapi.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: coreapi.ServiceSpec{
+			ClusterIP:  "10.96.0.10",
+			ClusterIPs: []string{"10.96.0.10"},
+			Ports:      []coreapi.ServicePort{{Name: "http", Port: 80, Protocol: coreapi.ProtocolTCP}},
+		},
+	}
+}
+
+func newTestEndpointSlice(svc *coreapi.Service, endpoints ...testEndpoint) *discoveryapi.EndpointSlice {
+	slice := &discoveryapi.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name + "-abcde",
+			Namespace: svc.Namespace,
+			Labels:    map[string]string{discoveryapi.LabelServiceName: svc.Name},
+		},
+		AddressType: discoveryapi.AddressTypeIPv4,
+		Ports:       []discoveryapi.EndpointPort{{Name: ptr.To("http"), Port: ptr.To(int32(8080)), Protocol: ptr.To(coreapi.ProtocolTCP)}},
+	}
+	for idx, ep := range endpoints {
+		endpoint := discoveryapi.Endpoint{
+			Addresses: []string{ep.ip},
+			Conditions: discoveryapi.EndpointConditions{
+				Ready:       ptr.To(ep.ready),
+				Serving:     ptr.To(ep.ready || ep.terminating),
+				Terminating: ptr.To(ep.terminating),
+			},
+			NodeName:  ptr.To(ep.node),
+			TargetRef: &coreapi.ObjectReference{Kind: "Pod", Namespace: svc.Namespace, Name: fmt.Sprintf("%s-%d", svc.Name, idx)},
+		}
+		if ep.zone != "" {
+			endpoint.Zone = ptr.To(ep.zone)
+		}
+		if len(ep.hints) != 0 {
+			endpoint.Hints = &discoveryapi.EndpointHints{}
+			for _, hint := range ep.hints {
+				endpoint.Hints.ForZones = append(endpoint.Hints.ForZones, discoveryapi.ForZone{Name: hint})
+			}
+		}
+		slice.Endpoints = append(slice.Endpoints, endpoint)
+	}
+	return slice
+}
+
+func newTestNode(name, zone string) *coreapi.Node {
+	return &coreapi.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{coreapi.LabelTopologyZone: zone}}}
+}
+
+func newTestProxy(t *testing.T, mode string, objects ...runtime.Object) *ServiceProxy {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	proxy := NewServiceProxy(fake.NewSimpleClientset(objects...), Config{Mode: mode})
+	if err := proxy.Run(ctx); err != nil {
+		t.Fatalf("Run should not return error: %v", err)
+	}
+	return proxy
+}
+
+func TestServiceProxy_IPTablesRandom(t *testing.T) {
+	svc := newTestService("nginx")
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.3", node: "node-1", ready: true},
+		testEndpoint{ip: "10.244.1.2", node: "node-1", ready: true},
+		testEndpoint{ip: "10.244.2.2", node: "node-2", ready: false},
+	)
+	proxy := newTestProxy(t, ModeIPTables, svc, slice)
+	proxy.random = func(n int) int { return n - 1 }
+
+	result, err := proxy.Resolve(Request{Namespace: "default", Name: "nginx"})
+	if err != nil {
+		t.Fatalf("Resolve should not return error: %v", err)
+	}
+	// 未就绪的 endpoint 不参与选择, 候选按 IP 排序
+	if len(result.Candidates) != 2 || result.Candidates[0].IP != "10.244.1.2" {
+		t.Fatalf("Expected 2 ready candidates sorted by ip, got %+v", result.Candidates)
+	}
+	if result.Endpoint == nil || result.Endpoint.String() != "10.244.1.3:8080" {
+		t.Errorf("Expected the randomly picked endpoint 10.244.1.3:8080, got %+v", result.Endpoint)
+	}
+	if result.Endpoint.Pod != "default/nginx-0" || result.Port != 80 || result.Mode != ModeIPTables {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestServiceProxy_IPVSRoundRobin(t *testing.T) {
+	svc := newTestService("nginx")
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.2", node: "node-1", ready: true},
+		testEndpoint{ip: "10.244.2.2", node: "node-2", ready: true},
+	)
+	proxy := newTestProxy(t, ModeIPTables, svc, slice)
+
+	var hits []string
+	for i := 0; i < 4; i++ {
+		result, err := proxy.Resolve(Request{Namespace: "default", Name: "nginx", Port: "80", Mode: ModeIPVS})
+		if err != nil {
+			t.Fatalf("Resolve should not return error: %v", err)
+		}
+		hits = append(hits, result.Endpoint.IP)
+	}
+	expected := []string{"10.244.1.2", "10.244.2.2", "10.244.1.2", "10.244.2.2"}
+	if fmt.Sprint(hits) != fmt.Sprint(expected) {
+		t.Errorf("Expected round robin %v, got %v", expected, hits)
+	}
+}
+
+func TestServiceProxy_SessionAffinity(t *testing.T) {
+	svc := newTestService("nginx")
+	svc.Spec.SessionAffinity = coreapi.ServiceAffinityClientIP
+	svc.Spec.SessionAffinityConfig = &coreapi.SessionAffinityConfig{ClientIP: &coreapi.ClientIPConfig{TimeoutSeconds: ptr.To(int32(60))}}
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.2", node: "node-1", ready: true},
+		testEndpoint{ip: "10.244.2.2", node: "node-2", ready: true},
+	)
+	proxy := newTestProxy(t, ModeIPVS, svc, slice)
+	now := time.Now()
+	proxy.clock = func() time.Time { return now }
+
+	request := Request{Namespace: "default", Name: "nginx", ClientIP: "10.244.3.7"}
+	first, _ := proxy.Resolve(request)
+	second, _ := proxy.Resolve(request)
+	// 同一个客户端在超时前总是访问同一个 endpoint
+	if first.Endpoint.IP != second.Endpoint.IP || len(second.Notes) != 1 {
+		t.Errorf("Expected client to stick to %s, got %s", first.Endpoint.IP, second.Endpoint.IP)
+	}
+	// 其他客户端不受影响
+	other, _ := proxy.Resolve(Request{Namespace: "default", Name: "nginx", ClientIP: "10.244.3.8"})
+	if other.Endpoint.IP == first.Endpoint.IP {
+		t.Errorf("Expected another client to be balanced to another endpoint")
+	}
+	// 超时后重新选择
+	now = now.Add(2 * time.Minute)
+	third, _ := proxy.Resolve(request)
+	if len(third.Notes) != 0 {
+		t.Errorf("Expected affinity to expire after timeout, got notes %v", third.Notes)
+	}
+}
+
+func TestServiceProxy_TopologyAwareHints(t *testing.T) {
+	svc := newTestService("nginx")
+	svc.Annotations = map[string]string{annotationTopologyMode: "Auto"}
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.2", node: "node-1", zone: "zone-a", hints: []string{"zone-a"}, ready: true},
+		testEndpoint{ip: "10.244.2.2", node: "node-2", zone: "zone-b", hints: []string{"zone-b"}, ready: true},
+	)
+	proxy := newTestProxy(t, ModeIPTables, svc, slice, newTestNode("node-1", "zone-a"), newTestNode("node-3", "zone-c"))
+
+	result, err := proxy.Resolve(Request{Namespace: "default", Name: "nginx", SourceNode: "node-1"})
+	if err != nil {
+		t.Fatalf("Resolve should not return error: %v", err)
+	}
+	if len(result.Candidates) != 1 || result.Candidates[0].IP != "10.244.1.2" {
+		t.Errorf("Expected only endpoints hinted for zone-a, got %+v", result.Candidates)
+	}
+
+	// 没有 endpoint 提示给该 zone 时使用所有 endpoint
+	result, _ = proxy.Resolve(Request{Namespace: "default", Name: "nginx", SourceNode: "node-3"})
+	if len(result.Candidates) != 2 {
+		t.Errorf("Expected all endpoints when no endpoint is hinted for zone-c, got %+v", result.Candidates)
+	}
+}
+
+func TestServiceProxy_InternalTrafficPolicyLocal(t *testing.T) {
+	svc := newTestService("nginx")
+	svc.Spec.InternalTrafficPolicy = ptr.To(coreapi.ServiceInternalTrafficPolicyLocal)
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.2", node: "node-1", ready: true},
+		testEndpoint{ip: "10.244.2.2", node: "node-2", ready: true},
+	)
+	client := &coreapi.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "default"},
+		Spec:       coreapi.PodSpec{NodeName: "node-2"},
+		Status:     coreapi.PodStatus{PodIP: "10.244.2.9"},
+	}
+	proxy := newTestProxy(t, ModeIPTables, svc, slice, client)
+
+	// 来源节点由 Pod 推断
+	result, err := proxy.Resolve(Request{Namespace: "default", Name: "nginx", SourcePod: "default/client"})
+	if err != nil {
+		t.Fatalf("Resolve should not return error: %v", err)
+	}
+	if result.Endpoint == nil || result.Endpoint.NodeName != "node-2" {
+		t.Errorf("Expected endpoint on node-2, got %+v", result.Endpoint)
+	}
+
+	// 本节点没有 endpoint 时请求被拒绝
+	result, _ = proxy.Resolve(Request{Namespace: "default", Name: "nginx", SourceNode: "node-3"})
+	if result.Endpoint != nil || len(result.Candidates) != 0 {
+		t.Errorf("Expected request to be rejected, got %+v", result.Endpoint)
+	}
+}
+
+func TestServiceProxy_TerminatingFallback(t *testing.T) {
+	svc := newTestService("nginx")
+	slice := newTestEndpointSlice(svc,
+		testEndpoint{ip: "10.244.1.2", node: "node-1", terminating: true},
+	)
+	proxy := newTestProxy(t, ModeIPTables, svc, slice)
+
+	result, err := proxy.Resolve(Request{Namespace: "default", Name: "nginx"})
+	if err != nil {
+		t.Fatalf("Resolve should not return error: %v", err)
+	}
+	if result.Endpoint == nil || !result.Endpoint.Terminating {
+		t.Errorf("Expected serving terminating endpoint to be used, got %+v", result.Endpoint)
+	}
+}
+
+func TestServiceProxy_ResolveErrors(t *testing.T) {
+	headless := newTestService("headless")
+	headless.Spec.ClusterIP = coreapi.ClusterIPNone
+	multiPort := newTestService("multi")
+	multiPort.Spec.Ports = append(multiPort.Spec.Ports, coreapi.ServicePort{Name: "https", Port: 443})
+	proxy := newTestProxy(t, ModeIPTables, headless, multiPort)
+
+	cases := []Request{
+		{Namespace: "default", Name: "missing"},
+		{Namespace: "default", Name: "headless"},
+		{Namespace: "default", Name: "multi"},
+		{Namespace: "default", Name: "multi", Port: "8443"},
+		{Namespace: "default", Name: "multi", Port: "https", Mode: "userspace"},
+	}
+	for _, request := range cases {
+		if _, err := proxy.Resolve(request); err == nil {
+			t.Errorf("Expected error for request %+v", request)
+		}
+	}
+}
+
+func TestServiceProxy_ServeHTTP(t *testing.T) {
+	svc := newTestService("nginx")
+	slice := newTestEndpointSlice(svc, testEndpoint{ip: "10.244.1.2", node: "node-1", ready: true})
+	proxy := newTestProxy(t, ModeIPTables, svc, slice)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	request := Request{Name: "nginx", Port: "http"}
+	resp, err := http.Get(server.URL + ResolvePath + "?" + request.Query().Encode())
+	if err != nil {
+		t.Fatalf("Request should not return error: %v", err)
+	}
+	defer resp.Body.Close()
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Decode response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Service != "default/nginx" || result.Endpoint == nil {
+		t.Errorf("Unexpected response %d %+v", resp.StatusCode, result)
+	}
+
+	resp, err = http.Get(server.URL + ResolvePath + "?name=missing")
+	if err != nil {
+		t.Fatalf("Request should not return error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected bad request for missing service, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,115 @@
+package simapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultListen is the address the simulator api listens on, it's only meant for local tools like the cli
+const DefaultListen = "127.0.0.1:10280"
+
+var loggerForSimApi = logrus.WithField("component", "simulator-api")
+
+// Server represent the http api of simulator, components register handlers to expose their state,
+// e.g. the service proxy exposes how requests to services are routed
+type Server struct {
+	listen string
+	mux    *http.ServeMux
+}
+
+func NewServer(listen string) *Server {
+	if listen == "" {
+		listen = DefaultListen
+	}
+	return &Server{listen: listen, mux: http.NewServeMux()}
+}
+
+// Handle registers handler for pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the handler of all registered apis
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Run serves the api until ctx is done
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{Addr: s.listen, Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	loggerForSimApi.Infof("simulator api listen on %s", s.listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WriteJSON writes obj as the json response
+func WriteJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		loggerForSimApi.WithError(err).Warn("write response failed")
+	}
+}
+
+// WriteError writes err as the json response
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, Status{Message: err.Error()})
+}
+
+// Status represent the response of failed requests
+type Status struct {
+	Message string `json:"message"`
+}
+
+// Get requests path of the simulator api at address, and decodes the json response into out
+func Get(address, path string, query url.Values, out interface{}) error {
+	return do(http.MethodGet, address, path, query, out)
+}
+
+// Post is like Get but requests with POST, it's used by apis which change state of simulator
+func Post(address, path string, query url.Values, out interface{}) error {
+	return do(http.MethodPost, address, path, query, out)
+}
+
+func do(method, address, path string, query url.Values, out interface{}) error {
+	if address == "" {
+		address = DefaultListen
+	}
+	endpoint := url.URL{Scheme: "http", Host: address, Path: path, RawQuery: query.Encode()}
+	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequest(method, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request simulator api failed, is simulator running?")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var status Status
+		if err := json.Unmarshal(body, &status); err == nil && status.Message != "" {
+			return errors.New(status.Message)
+		}
+		return fmt.Errorf("simulator api returns %s", resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
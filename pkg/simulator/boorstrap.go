@@ -1,129 +1,136 @@
 package simulator
 
 import (
-	"crypto"
-	"crypto/x509"
-	"fmt"
 	"net"
 	"net/url"
+	"os"
 
+	"3Xpl0it3r.com/kube-simulator/pkg/audit"
 	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
 	"3Xpl0it3r.com/kube-simulator/pkg/cluster"
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
 	"github.com/pkg/errors"
-	"k8s.io/client-go/tools/clientcmd"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
-	k8certutil "k8s.io/client-go/util/cert"
-	"k8s.io/client-go/util/keyutil"
 )
 
 // bootstrapAllNecessaryClusterCertificates is response for prepare some necessary ceitificetes that needed by k8s components
-// if certificated existed on disk, then  return
-// if cetigicated file not existed on disk, then create new one
+// if certificated existed on disk and still valid, then  return
+// if cetigicated file not existed on disk or invalid, then create new one
 func bootstrapAllNecessaryClusterCertificates(config *Config) error {
-	// create ca certificated for kubernetes
-	if err := mycertutil.CreateCACertFiles(config.Cluster.TLS.CA, mycertutil.NewCACertificateConfig("kubernetes")); err != nil {
-		return errors.Wrap(err, "create certificate file for rootca failed")
+	generator, err := mycertutil.NewGenerator(config.Cert)
+	if err != nil {
+		return err
 	}
-
-	// create ca certificated for etcd
-	if err := mycertutil.CreateCACertFiles(config.Etcd.CACert, mycertutil.NewCACertificateConfig("etcd-ca")); err != nil {
-		return errors.Wrap(err, "create certificate file for etcd-ca failed")
+	// create ca certificated for kubernetes and etcd
+	for _, ca := range clusterCAs(config) {
+		if err := generator.CreateCACertFiles(ca.pair, ca.config); err != nil {
+			return errors.Wrapf(err, "create certificate file for %s failed", ca.name)
+		}
 	}
 
-	kubeExtAlt := k8certutil.AltNames{
-		DNSNames: []string{
-			"kubernetes",
-			"kubernetes.default",
-			"kubernetes.default.svc",
-		},
-		IPs: []net.IP{
-			net.ParseIP("127.0.0.1"),
-			net.ParseIP(config.Cluster.ListenHost),
-		},
-	}
-	if err := mycertutil.CreateGenericCertFiles(config.Cluster.TLS.Server, config.Cluster.TLS.CA, mycertutil.NewServerCerfiticateConfig("kube-apiserver", kubeExtAlt, "kubernetes")); err != nil {
-		return errors.Wrap(err, "create certificate file for kube-apiserver failed")
-	}
-
-	etcdExtAlt := k8certutil.AltNames{
-		IPs: []net.IP{
-			net.ParseIP("127.0.0.1"),
-		},
-	}
-	if err := mycertutil.CreateGenericCertFiles(config.Etcd.ServerCert, config.Etcd.CACert, mycertutil.NewServerCerfiticateConfig("etcd-server", etcdExtAlt)); err != nil {
-		return errors.Wrap(err, "create certificate file for etcd-server failed")
+	leaves, err := leafCertificates(config)
+	if err != nil {
+		return err
 	}
-
-	if err := mycertutil.CreateGenericCertFiles(config.Cluster.TLS.EtcdClient, config.Etcd.CACert, mycertutil.NewClientCertificateConfig("etcd-client")); err != nil {
-		return errors.Wrap(err, "create certificate file for etcd-client failed")
+	for _, leaf := range leaves {
+		if err := generator.CreateGenericCertFiles(leaf.pair, leaf.ca.pair, leaf.config); err != nil {
+			return errors.Wrapf(err, "create certificate file for %s failed", leaf.name)
+		}
 	}
 
-	if err := mycertutil.CreateServiceAccountKeyAndPublicKeyFiles(config.Cluster.TLS.ServiceAccountSigningKeyFile, config.Cluster.TLS.ServiceAccountKeyFile); err != nil {
+	if err := generator.CreateServiceAccountKeyAndPublicKeyFiles(config.Cluster.TLS.ServiceAccountSigningKeyFile, config.Cluster.TLS.ServiceAccountKeyFile); err != nil {
 		return errors.Wrap(err, "create ServiceAccountSigningKey failed")
 	}
+	if config.OIDC.Enabled() {
+		if err := generator.CreateServiceAccountKeyAndPublicKeyFiles(config.OIDC.SigningKeyFile, config.OIDC.SigningPublicKeyFile); err != nil {
+			return errors.Wrap(err, "create signing key of oidc issuer failed")
+		}
+	}
 
 	return nil
 }
 
-func bootstrapComponentClusterConfigs(clusterCfg *cluster.Config) error {
-	caKey, caCert, err := mycertutil.TryLoadCertAndKeyFromFile(clusterCfg.TLS.CA.KeyFile, clusterCfg.TLS.CA.CertFile)
+// bootstrapComponentClusterConfigs prepares kubeconfigs of components, kubeconfigs on disk are kept unless their
+// client certificates are invalid or they don't point to the current apiserver and ca
+func bootstrapComponentClusterConfigs(config *Config) error {
+	generator, err := mycertutil.NewGenerator(config.Cert)
+	if err != nil {
+		return err
+	}
+	caKey, caCert, err := mycertutil.TryLoadCertAndKeyFromFile(config.Cluster.TLS.CA.KeyFile, config.Cluster.TLS.CA.CertFile)
 	if err != nil {
 		return errors.Wrap(err, "load ca from file")
 	}
+	controlPlane := controlPlaneOf(&config.Cluster)
+	for _, kubeconfig := range clientKubeconfigs(config) {
+		_, err := checkKubeconfig(kubeconfig.file, controlPlane, caCert, kubeconfig.config, generator.RenewBefore())
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(errors.Cause(err)) {
+			loggerForCert.WithError(err).Infof("renew kubeconfig %s", kubeconfig.file)
+		}
+		if err := generator.WriteCertKubeconfig(caKey, caCert, controlPlane, kubeconfig.config, kubeconfig.file); err != nil {
+			return errors.Wrapf(err, "generate kubeconfig for %s failed", kubeconfig.name)
+		}
+	}
+	return nil
+}
+
+// controlPlaneOf returns the url components access apiserver with
+func controlPlaneOf(clusterCfg *cluster.Config) string {
 	controlPlaneURL := url.URL{
 		Scheme: "https",
 		Host:   net.JoinHostPort(clusterCfg.ListenHost, clusterCfg.ListenPort),
 	}
-	controlPlane := controlPlaneURL.String()
-	adminCertConf := mycertutil.NewClientCertificateConfig(KubeUserAdmin, KubeGroupWithAdmin)
-	if err := generateClusterClientsConfig(caKey, caCert, controlPlane, adminCertConf, clusterCfg.ClientConfigFile.Administrator); err != nil {
-		return errors.Wrap(err, "generate kubeconfig for admin failed")
-	}
+	return controlPlaneURL.String()
+}
 
-	controlManagerCertConf := mycertutil.NewClientCertificateConfig(KubeGroupWithControllerManager, KubeGroupWithDefault)
-	if err := generateClusterClientsConfig(caKey, caCert, controlPlane, controlManagerCertConf, clusterCfg.ClientConfigFile.ControllerManager); err != nil {
-		return errors.Wrap(err, "generate kubeconfig for controller-manager failed")
+// bootstrapAuditConfigs generates the default audit policy if it doesn't exist, and the kubeconfig of the webhook
+// which posts audit events to the simulator api
+func bootstrapAuditConfigs(config *Config) error {
+	if err := audit.EnsurePolicyFile(config.Cluster.Audit.PolicyFile); err != nil {
+		return errors.Wrap(err, "generate audit policy failed")
 	}
-
-	scheduleCertConf := mycertutil.NewClientCertificateConfig(KubeGroupWithScheduler, KubeGroupWithDefault)
-	if err := generateClusterClientsConfig(caKey, caCert, controlPlane, scheduleCertConf, clusterCfg.ClientConfigFile.Scheduler); err != nil {
-		return errors.Wrap(err, "generate kubeconfig for scheduler failed")
+	if config.AuditBufferSize <= 0 {
+		return nil
+	}
+	if err := audit.WriteWebhookConfig(config.Cluster.Audit.WebhookConfigFile, simulatorApiAddress(config.ApiListen)); err != nil {
+		return errors.Wrap(err, "generate kubeconfig of audit webhook failed")
 	}
 	return nil
-
 }
 
-func generateClusterClientsConfig(caKey crypto.Signer, caCert *x509.Certificate, controlPlane string, clientCertConfig k8certutil.Config, fileName string) error {
-	clientKey, clientCrt, err := mycertutil.NewCertAndKey(caKey, caCert, clientCertConfig)
-	if err != nil {
-		return errors.Wrap(err, "create new cert and key failed")
-	}
-	encodedClientKey, err := keyutil.MarshalPrivateKeyToPEM(clientKey)
+// simulatorApiAddress returns the address local clients connect to the simulator api listening on listen with
+func simulatorApiAddress(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
 	if err != nil {
-		return err
+		return simapi.DefaultListen
 	}
-	contextName := fmt.Sprintf("%s@%s", clientCertConfig.CommonName, ClusterDefaultName)
-	kubeCfg := clientcmdapi.Config{
-		Clusters: map[string]*clientcmdapi.Cluster{
-			ClusterDefaultName: {
-				Server:                   controlPlane,
-				CertificateAuthorityData: mycertutil.EncodeCertPEM(caCert),
-			},
-		},
-		Contexts: map[string]*clientcmdapi.Context{
-			contextName: {
-				Cluster:  ClusterDefaultName,
-				AuthInfo: clientCertConfig.CommonName,
-			},
-		},
-		AuthInfos: map[string]*clientcmdapi.AuthInfo{
-			clientCertConfig.CommonName: {
-				ClientKeyData:         encodedClientKey,
-				ClientCertificateData: mycertutil.EncodeCertPEM(clientCrt),
-			},
-		},
-		CurrentContext: contextName,
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
 	}
+	return net.JoinHostPort(host, port)
+}
 
-	return clientcmd.WriteToFile(kubeCfg, fileName)
+// bootstrapEncryptionConfig generates the EncryptionConfiguration of apiserver, or puts the provider first in the
+// existing one. resources encrypted before can't be read without it, so the existing one is still used if no
+// provider is specified
+func bootstrapEncryptionConfig(config *Config) error {
+	if !config.Encryption.Enabled() {
+		if _, err := os.Stat(config.Encryption.ConfigFile); err != nil {
+			return nil
+		}
+		if _, err := encryption.LoadConfigFile(config.Encryption.ConfigFile); err != nil {
+			return errors.Wrap(err, "load existing encryption config failed")
+		}
+		config.Cluster.EncryptionProviderConfigFile = config.Encryption.ConfigFile
+		loggerForKvStorage.Infof("no encryption provider is specified, keep using %s. rewrite resources with "+
+			"--encryption-provider=identity and remove it to disable encryption", config.Encryption.ConfigFile)
+		return nil
+	}
+	if err := encryption.EnsureConfigFile(&config.Encryption); err != nil {
+		return errors.Wrap(err, "generate encryption config failed")
+	}
+	return nil
 }
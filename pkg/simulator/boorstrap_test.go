@@ -0,0 +1,46 @@
+package simulator
+
+import (
+	"os"
+	"testing"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+)
+
+func TestBootstrapEncryptionConfig_ExistingConfigWithoutProvider(t *testing.T) {
+	config := newTestCertConfig(t, "127.0.0.1")
+	// 未指定 provider 且没有加密配置时不加密
+	if err := bootstrapEncryptionConfig(config); err != nil {
+		t.Fatalf("bootstrapEncryptionConfig failed: %v", err)
+	}
+	if config.Cluster.EncryptionProviderConfigFile != "" {
+		t.Fatalf("Expected no encryption config, got %s", config.Cluster.EncryptionProviderConfigFile)
+	}
+
+	config.Encryption.Provider, config.Encryption.Resources = encryption.ProviderKMS, encryption.DefaultResources
+	if err := bootstrapEncryptionConfig(config); err != nil {
+		t.Fatalf("bootstrapEncryptionConfig failed: %v", err)
+	}
+
+	// 重启时省略 provider, 仍使用已有的配置和其引用的 kms 插件, 否则已加密的资源无法读取
+	config.Encryption.Provider = ""
+	config.Cluster.EncryptionProviderConfigFile = ""
+	if err := bootstrapEncryptionConfig(config); err != nil {
+		t.Fatalf("bootstrapEncryptionConfig failed: %v", err)
+	}
+	if config.Cluster.EncryptionProviderConfigFile != config.Encryption.ConfigFile {
+		t.Errorf("Expected existing encryption config %s to be used, got %q", config.Encryption.ConfigFile, config.Cluster.EncryptionProviderConfigFile)
+	}
+	if !encryption.UsesKMSPlugin(&config.Encryption) {
+		t.Error("Expected kms plugin of existing encryption config to be used")
+	}
+
+	// 已有的配置无效时拒绝启动
+	if err := os.WriteFile(config.Encryption.ConfigFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Cluster.EncryptionProviderConfigFile = ""
+	if err := bootstrapEncryptionConfig(config); err == nil {
+		t.Error("Expected invalid encryption config to fail")
+	}
+}
@@ -0,0 +1,440 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"3Xpl0it3r.com/kube-simulator/pkg/agent"
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"3Xpl0it3r.com/kube-simulator/pkg/cluster"
+	"3Xpl0it3r.com/kube-simulator/pkg/dns"
+	myutil "3Xpl0it3r.com/kube-simulator/pkg/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	k8certutil "k8s.io/client-go/util/cert"
+)
+
+// names of certificates of cluster, they are the same as kubeadm's
+const (
+	CertNameCA                  = "ca"
+	CertNameEtcdCA              = "etcd-ca"
+	CertNameApiServer           = "apiserver"
+	CertNameApiServerEtcdClient = "apiserver-etcd-client"
+	CertNameEtcdServer          = "etcd-server"
+	CertNameAdminConf           = "admin.conf"
+	CertNameControllerManager   = "controller-manager.conf"
+	CertNameScheduler           = "scheduler.conf"
+	CertNameOIDCIssuer          = "oidc-issuer"
+	CertNameWebhook             = "webhook"
+	CertNameFrontProxyCA        = "front-proxy-ca"
+	CertNameFrontProxyClient    = "front-proxy-client"
+	CertNameAPIService          = "apiservice"
+
+	// CertNameAll renews all certificates except cas
+	CertNameAll = "all"
+)
+
+// CertificateStatus represent state of a certificate of cluster, or of the client certificate of a kubeconfig
+type CertificateStatus struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// CA is the name of the ca signing the certificate, it's empty for cas
+	CA       string    `json:"ca,omitempty"`
+	NotAfter time.Time `json:"notAfter"`
+	// Problem is why the certificate should be renewed, it's empty if the certificate is valid
+	Problem string `json:"problem,omitempty"`
+}
+
+// caCertificate represent a ca of cluster
+type caCertificate struct {
+	name   string
+	pair   mycertutil.CertKeyPair
+	config k8certutil.Config
+}
+
+// leafCertificate represent a certificate signed by a ca of cluster
+type leafCertificate struct {
+	name   string
+	ca     caCertificate
+	pair   mycertutil.CertKeyPair
+	config k8certutil.Config
+}
+
+// clientKubeconfig represent a kubeconfig of component, which embeds a client certificate signed by the cluster ca
+type clientKubeconfig struct {
+	name   string
+	file   string
+	config k8certutil.Config
+}
+
+func clusterCAs(config *Config) []caCertificate {
+	return []caCertificate{
+		{name: CertNameCA, pair: config.Cluster.TLS.CA, config: mycertutil.NewCACertificateConfig("kubernetes")},
+		{name: CertNameEtcdCA, pair: config.Etcd.CACert, config: mycertutil.NewCACertificateConfig("etcd-ca")},
+		{name: CertNameFrontProxyCA, pair: config.Cluster.TLS.FrontProxyCA, config: mycertutil.NewCACertificateConfig("front-proxy-ca")},
+	}
+}
+
+func leafCertificates(config *Config) ([]leafCertificate, error) {
+	cas := clusterCAs(config)
+	kubeExtAlt, err := apiserverAltNames(config)
+	if err != nil {
+		return nil, err
+	}
+	etcdExtAlt := k8certutil.AltNames{
+		IPs: []net.IP{
+			net.ParseIP("127.0.0.1"),
+		},
+	}
+	leaves := []leafCertificate{
+		{
+			name:   CertNameApiServer,
+			ca:     cas[0],
+			pair:   config.Cluster.TLS.Server,
+			config: mycertutil.NewServerCerfiticateConfig("kube-apiserver", kubeExtAlt, "kubernetes"),
+		},
+		{
+			name:   CertNameEtcdServer,
+			ca:     cas[1],
+			pair:   config.Etcd.ServerCert,
+			config: mycertutil.NewServerCerfiticateConfig("etcd-server", etcdExtAlt),
+		},
+		{
+			name:   CertNameApiServerEtcdClient,
+			ca:     cas[1],
+			pair:   config.Cluster.TLS.EtcdClient,
+			config: mycertutil.NewClientCertificateConfig("etcd-client"),
+		},
+		{
+			name:   CertNameFrontProxyClient,
+			ca:     cas[2],
+			pair:   config.Cluster.TLS.FrontProxyClient,
+			config: mycertutil.NewClientCertificateConfig(cluster.FrontProxyClientName),
+		},
+	}
+	if config.OIDC.Enabled() {
+		issuerAltNames, err := listenAltNames(config.OIDC.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid listen of oidc issuer")
+		}
+		leaves = append(leaves, leafCertificate{
+			name:   CertNameOIDCIssuer,
+			ca:     cas[0],
+			pair:   config.OIDC.Cert,
+			config: mycertutil.NewServerCerfiticateConfig("oidc-issuer", issuerAltNames),
+		})
+	}
+	if config.Webhook.Enabled() {
+		webhookAltNames, err := listenAltNames(config.Webhook.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid listen of webhook proxy")
+		}
+		leaves = append(leaves, leafCertificate{
+			name:   CertNameWebhook,
+			ca:     cas[0],
+			pair:   config.Webhook.Cert,
+			config: mycertutil.NewServerCerfiticateConfig("kube-simulator-webhook", webhookAltNames),
+		})
+	}
+	if config.Aggregator.Enabled() {
+		leaves = append(leaves, leafCertificate{
+			name:   CertNameAPIService,
+			ca:     cas[0],
+			pair:   config.Aggregator.Cert,
+			config: mycertutil.NewServerCerfiticateConfig("kube-simulator-apiservice", hostAltNames(config.Aggregator.ServingNames())),
+		})
+	}
+	return leaves, nil
+}
+
+// hostAltNames returns hosts and the local host, e.g. for aggregated apiservers served on hosts
+func hostAltNames(hosts []string) k8certutil.AltNames {
+	dnsNames, ips := sets.New("localhost"), []net.IP{net.ParseIP("127.0.0.1")}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip == nil {
+			dnsNames.Insert(host)
+		} else if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	return k8certutil.AltNames{DNSNames: sets.List(dnsNames), IPs: ips}
+}
+
+// listenAltNames returns the host of listen and the local host, e.g. for the oidc issuer whose url is
+// https://<listen>
+func listenAltNames(listen string) (k8certutil.AltNames, error) {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return k8certutil.AltNames{}, err
+	}
+	altNames := k8certutil.AltNames{DNSNames: []string{"localhost"}, IPs: []net.IP{net.ParseIP("127.0.0.1")}}
+	if ip := net.ParseIP(host); ip == nil {
+		altNames.DNSNames = append(altNames.DNSNames, host)
+	} else if !ip.Equal(altNames.IPs[0]) {
+		altNames.IPs = append(altNames.IPs, ip)
+	}
+	return altNames, nil
+}
+
+// apiserverAltNames returns names apiserver is accessed with: the kubernetes service and its cluster ips, the
+// listen host, names of the local host and the extra sans
+func apiserverAltNames(config *Config) (k8certutil.AltNames, error) {
+	domain := config.DNS.Domain
+	if domain == "" {
+		domain = dns.DefaultDomain
+	}
+	dnsNames := []string{"kubernetes", "kubernetes.default", "kubernetes.default.svc", "kubernetes.default.svc." + domain, "localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		dnsNames = append(dnsNames, strings.ToLower(hostname))
+	}
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}
+	serviceIPs, err := kubernetesServiceIPs(config.Cluster.ServiceCIDR)
+	if err != nil {
+		return k8certutil.AltNames{}, err
+	}
+	ips = append(ips, serviceIPs...)
+
+	for _, san := range append([]string{config.Cluster.ListenHost}, config.Cluster.CertSANs...) {
+		if san == "" {
+			continue
+		}
+		if ip := net.ParseIP(san); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, san)
+		}
+	}
+
+	altNames := k8certutil.AltNames{DNSNames: sets.List(sets.New(dnsNames...))}
+	seen := sets.New[string]()
+	for _, ip := range ips {
+		if !seen.Has(ip.String()) {
+			seen.Insert(ip.String())
+			altNames.IPs = append(altNames.IPs, ip)
+		}
+	}
+	return altNames, nil
+}
+
+// kubernetesServiceIPs returns cluster ips of the kubernetes service, the first address of each service cidr
+func kubernetesServiceIPs(serviceCIDR string) ([]net.IP, error) {
+	if serviceCIDR == "" {
+		return nil, nil
+	}
+	prefixes, err := myutil.ParseDualStackCIDRs(serviceCIDR)
+	if err != nil {
+		return nil, errors.Wrap(err, "service cidr invalid")
+	}
+	var ips []net.IP
+	for _, prefix := range prefixes {
+		ips = append(ips, net.IP(myutil.AddToAddr(prefix.Masked().Addr(), big.NewInt(1)).AsSlice()))
+	}
+	return ips, nil
+}
+
+// ValidateCertSANs checks that each of sans is an ip or a dns name, which may be a wildcard like *.example.com
+func ValidateCertSANs(sans []string) error {
+	for _, san := range sans {
+		if net.ParseIP(san) != nil {
+			continue
+		}
+		if len(validation.IsDNS1123Subdomain(san)) != 0 && len(validation.IsWildcardDNS1123Subdomain(san)) != 0 {
+			return errors.Errorf("%s is neither an ip nor a dns name", san)
+		}
+	}
+	return nil
+}
+
+// clientKubeconfigs returns kubeconfigs of components and of the bootstrap nodes, nodes are named <node>.conf
+func clientKubeconfigs(config *Config) []clientKubeconfig {
+	files := config.Cluster.ClientConfigFile
+	kubeconfigs := []clientKubeconfig{
+		{name: CertNameAdminConf, file: files.Administrator, config: mycertutil.NewClientCertificateConfig(KubeUserAdmin, KubeGroupWithAdmin)},
+		{name: CertNameControllerManager, file: files.ControllerManager, config: mycertutil.NewClientCertificateConfig(KubeGroupWithControllerManager, KubeGroupWithDefault)},
+		{name: CertNameScheduler, file: files.Scheduler, config: mycertutil.NewClientCertificateConfig(KubeGroupWithScheduler, KubeGroupWithDefault)},
+	}
+	for idx := 0; idx < config.Agent.NodeNum; idx++ {
+		nodeName := agent.BootstrapNodeName(idx)
+		kubeconfigs = append(kubeconfigs, clientKubeconfig{
+			name:   nodeName + ".conf",
+			file:   NodeKubeconfigFile(config, nodeName),
+			config: mycertutil.NewClientCertificateConfig(agent.NodeUserPrefix+nodeName, KubeGroupWithNodes),
+		})
+	}
+	return kubeconfigs
+}
+
+// NodeKubeconfigFile returns the kubeconfig of node, whose client certificate is issued to system:node:<node> like
+// kubelets in kubeadm clusters. the agent acts as the node with it
+func NodeKubeconfigFile(config *Config, nodeName string) string {
+	return config.Agent.NodeIdentity.KubeconfigFile(nodeName)
+}
+
+// CheckCertificates reports the state of cas, certificates and kubeconfigs of cluster, like kubeadm certs check-expiration
+func CheckCertificates(config *Config) ([]CertificateStatus, error) {
+	generator, err := mycertutil.NewGenerator(config.Cert)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []CertificateStatus
+	caCerts := make(map[string]*x509.Certificate)
+	for _, ca := range clusterCAs(config) {
+		status := CertificateStatus{Name: ca.name, File: ca.pair.CertFile}
+		key, certificate, err := mycertutil.TryLoadCertAndKeyFromFile(ca.pair.KeyFile, ca.pair.CertFile)
+		if err == nil {
+			status.NotAfter = certificate.NotAfter
+			err = mycertutil.CheckCertificate(certificate, key, nil, k8certutil.AltNames{}, generator.RenewBefore())
+			caCerts[ca.name] = certificate
+		}
+		statuses = append(statuses, withProblem(status, err))
+	}
+
+	leaves, err := leafCertificates(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, leaf := range leaves {
+		status := CertificateStatus{Name: leaf.name, File: leaf.pair.CertFile, CA: leaf.ca.name}
+		certificate, err := checkCertFiles(leaf.pair, caCerts[leaf.ca.name], leaf.config.AltNames, generator.RenewBefore())
+		if certificate != nil {
+			status.NotAfter = certificate.NotAfter
+		}
+		statuses = append(statuses, withProblem(status, err))
+	}
+
+	controlPlane := controlPlaneOf(&config.Cluster)
+	for _, kubeconfig := range clientKubeconfigs(config) {
+		status := CertificateStatus{Name: kubeconfig.name, File: kubeconfig.file, CA: CertNameCA}
+		var certificate *x509.Certificate
+		err := errors.New("ca is invalid")
+		if caCert := caCerts[CertNameCA]; caCert != nil {
+			certificate, err = checkKubeconfig(kubeconfig.file, controlPlane, caCert, kubeconfig.config, generator.RenewBefore())
+		}
+		if certificate != nil {
+			status.NotAfter = certificate.NotAfter
+		}
+		statuses = append(statuses, withProblem(status, err))
+	}
+	return statuses, nil
+}
+
+// RenewCertificates renews certificates and kubeconfigs of names no matter whether they are valid, cas are never
+// renewed. all of them are renewed if names is empty or all
+func RenewCertificates(config *Config, names ...string) error {
+	generator, err := mycertutil.NewGenerator(config.Cert)
+	if err != nil {
+		return err
+	}
+	leaves, err := leafCertificates(config)
+	if err != nil {
+		return err
+	}
+	renewable := sets.New(RenewableCertificates(config)...)
+	renew := sets.New(names...)
+	if renew.Len() == 0 || renew.Has(CertNameAll) {
+		renew = renewable
+	}
+	if unknown := renew.Difference(renewable); unknown.Len() != 0 {
+		return errors.Errorf("unknown certificates %s, should be %s or one of %s", strings.Join(sets.List(unknown), ","), CertNameAll, strings.Join(RenewableCertificates(config), ","))
+	}
+
+	for _, leaf := range leaves {
+		if !renew.Has(leaf.name) {
+			continue
+		}
+		if err := generator.RenewGenericCertFiles(leaf.pair, leaf.ca.pair, leaf.config); err != nil {
+			return errors.Wrapf(err, "renew certificate %s failed", leaf.name)
+		}
+	}
+
+	caKey, caCert, err := mycertutil.TryLoadCertAndKeyFromFile(config.Cluster.TLS.CA.KeyFile, config.Cluster.TLS.CA.CertFile)
+	if err != nil {
+		return errors.Wrap(err, "load ca from file")
+	}
+	controlPlane := controlPlaneOf(&config.Cluster)
+	for _, kubeconfig := range clientKubeconfigs(config) {
+		if !renew.Has(kubeconfig.name) {
+			continue
+		}
+		if err := generator.WriteCertKubeconfig(caKey, caCert, controlPlane, kubeconfig.config, kubeconfig.file); err != nil {
+			return errors.Wrapf(err, "renew kubeconfig %s failed", kubeconfig.name)
+		}
+	}
+	return nil
+}
+
+// RenewableCertificates returns names of certificates and kubeconfigs which can be renewed
+func RenewableCertificates(config *Config) []string {
+	names := []string{CertNameApiServer, CertNameEtcdServer, CertNameApiServerEtcdClient, CertNameFrontProxyClient}
+	if config.OIDC.Enabled() {
+		names = append(names, CertNameOIDCIssuer)
+	}
+	if config.Webhook.Enabled() {
+		names = append(names, CertNameWebhook)
+	}
+	if config.Aggregator.Enabled() {
+		names = append(names, CertNameAPIService)
+	}
+	for _, kubeconfig := range clientKubeconfigs(config) {
+		names = append(names, kubeconfig.name)
+	}
+	return names
+}
+
+// checkCertFiles loads the certificate of pair and checks it, the certificate is returned if it can be loaded
+func checkCertFiles(pair mycertutil.CertKeyPair, caCert *x509.Certificate, altNames k8certutil.AltNames, renewBefore time.Duration) (*x509.Certificate, error) {
+	key, certificate, err := mycertutil.TryLoadCertAndKeyFromFile(pair.KeyFile, pair.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	if caCert == nil {
+		return certificate, errors.New("ca is invalid")
+	}
+	return certificate, mycertutil.CheckCertificate(certificate, key, caCert, altNames, renewBefore)
+}
+
+// checkKubeconfig checks that kubeconfig points to controlPlane, trusts caCert and its client certificate is valid
+// for the user of config, the client certificate is returned if it can be loaded
+func checkKubeconfig(file, controlPlane string, caCert *x509.Certificate, config k8certutil.Config, renewBefore time.Duration) (*x509.Certificate, error) {
+	kubeconfig, err := clientcmd.LoadFromFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load kubeconfig %s failed", file)
+	}
+	context := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if context == nil {
+		return nil, errors.Errorf("current context %q not found", kubeconfig.CurrentContext)
+	}
+	cluster, authInfo := kubeconfig.Clusters[context.Cluster], kubeconfig.AuthInfos[context.AuthInfo]
+	if cluster == nil || authInfo == nil {
+		return nil, errors.Errorf("cluster or user of context %q not found", kubeconfig.CurrentContext)
+	}
+	key, certificate, err := mycertutil.ParseCertAndKeyPEM(authInfo.ClientKeyData, authInfo.ClientCertificateData)
+	if err != nil {
+		return nil, errors.Wrap(err, "load client certificate failed")
+	}
+	if cluster.Server != controlPlane {
+		return certificate, errors.Errorf("server is %s instead of %s", cluster.Server, controlPlane)
+	}
+	if !bytes.Equal(cluster.CertificateAuthorityData, mycertutil.EncodeCertPEM(caCert)) {
+		return certificate, errors.New("ca of cluster is outdated")
+	}
+	if certificate.Subject.CommonName != config.CommonName {
+		return certificate, errors.Errorf("client certificate is issued to %s instead of %s", certificate.Subject.CommonName, config.CommonName)
+	}
+	return certificate, mycertutil.CheckCertificate(certificate, key, caCert, k8certutil.AltNames{}, renewBefore)
+}
+
+func withProblem(status CertificateStatus, err error) CertificateStatus {
+	if err != nil {
+		status.Problem = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,257 @@
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+)
+
+func newTestCertConfig(t *testing.T, listenHost string) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	config := &Config{DataDir: dir, CertificateDir: filepath.Join(dir, "pki")}
+	config.Cluster.ListenHost, config.Cluster.ListenPort = listenHost, "6443"
+	config.Cluster.ClientConfigFile.Administrator = filepath.Join(dir, DefaultConfKubeAdmin)
+	if err := config.Complete(); err != nil {
+		t.Fatalf("complete config failed: %v", err)
+	}
+	return config
+}
+
+func bootstrapTestCerts(t *testing.T, config *Config) {
+	t.Helper()
+	if err := bootstrapAllNecessaryClusterCertificates(config); err != nil {
+		t.Fatalf("bootstrap certificates failed: %v", err)
+	}
+	if err := bootstrapComponentClusterConfigs(config); err != nil {
+		t.Fatalf("bootstrap kubeconfigs failed: %v", err)
+	}
+}
+
+func certificateProblems(t *testing.T, config *Config) map[string]string {
+	t.Helper()
+	statuses, err := CheckCertificates(config)
+	if err != nil {
+		t.Fatalf("check certificates failed: %v", err)
+	}
+	problems := make(map[string]string)
+	for _, status := range statuses {
+		if status.Problem != "" {
+			problems[status.Name] = status.Problem
+		}
+	}
+	return problems
+}
+
+func TestCertificates_ListenHostChanged(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	bootstrapTestCerts(t, config)
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Fatalf("Expected all certificates to be valid, got %v", problems)
+	}
+
+	// 监听地址变化后, apiserver 证书和 kubeconfig 都需要更新
+	config.Cluster.ListenHost = "10.0.0.2"
+	problems := certificateProblems(t, config)
+	for _, name := range []string{CertNameApiServer, CertNameAdminConf, CertNameControllerManager, CertNameScheduler} {
+		if problems[name] == "" {
+			t.Errorf("Expected %s to be invalid after listen host changed", name)
+		}
+	}
+	if problems[CertNameEtcdServer] != "" || problems[CertNameCA] != "" {
+		t.Errorf("Expected etcd-server and ca to stay valid, got %v", problems)
+	}
+
+	bootstrapTestCerts(t, config)
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Fatalf("Expected certificates to be renewed on bootstrap, got %v", problems)
+	}
+
+	// 有效的 kubeconfig 不会被重新生成
+	admin, _ := os.ReadFile(config.Cluster.ClientConfigFile.Administrator)
+	bootstrapTestCerts(t, config)
+	if current, _ := os.ReadFile(config.Cluster.ClientConfigFile.Administrator); string(current) != string(admin) {
+		t.Error("Expected valid kubeconfig to be kept")
+	}
+}
+
+func TestRenewCertificates(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	bootstrapTestCerts(t, config)
+	apiserver, _ := os.ReadFile(config.Cluster.TLS.Server.CertFile)
+	admin, _ := os.ReadFile(config.Cluster.ClientConfigFile.Administrator)
+	scheduler, _ := os.ReadFile(config.Cluster.ClientConfigFile.Scheduler)
+
+	if err := RenewCertificates(config, CertNameApiServer, CertNameAdminConf); err != nil {
+		t.Fatalf("renew certificates failed: %v", err)
+	}
+	if current, _ := os.ReadFile(config.Cluster.TLS.Server.CertFile); string(current) == string(apiserver) {
+		t.Error("Expected apiserver certificate to be renewed")
+	}
+	if current, _ := os.ReadFile(config.Cluster.ClientConfigFile.Administrator); string(current) == string(admin) {
+		t.Error("Expected admin.conf to be renewed")
+	}
+	if current, _ := os.ReadFile(config.Cluster.ClientConfigFile.Scheduler); string(current) != string(scheduler) {
+		t.Error("Expected scheduler.conf not to be renewed")
+	}
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Errorf("Expected renewed certificates to be valid, got %v", problems)
+	}
+
+	if err := RenewCertificates(config, CertNameCA); err == nil {
+		t.Error("Expected ca not to be renewable")
+	}
+}
+
+func TestApiserverAltNames(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	config.Cluster.ServiceCIDR = "10.96.0.0/12,fd00:10:96::/112"
+	config.Cluster.CertSANs = []string{"192.168.56.10", "simulator.local", "10.0.0.1"}
+	altNames, err := apiserverAltNames(config)
+	if err != nil {
+		t.Fatalf("apiserverAltNames failed: %v", err)
+	}
+	for _, name := range []string{"kubernetes.default.svc", "kubernetes.default.svc.cluster.local", "localhost", "simulator.local"} {
+		if !slices.Contains(altNames.DNSNames, name) {
+			t.Errorf("Expected dns name %s in %v", name, altNames.DNSNames)
+		}
+	}
+	var ips []string
+	for _, ip := range altNames.IPs {
+		ips = append(ips, ip.String())
+	}
+	// 监听地址和额外 SAN 重复时只出现一次
+	expected := []string{"127.0.0.1", "::1", "10.96.0.1", "fd00:10:96::1", "10.0.0.1", "192.168.56.10"}
+	if !slices.Equal(ips, expected) {
+		t.Errorf("Expected ips %v, got %v", expected, ips)
+	}
+
+	// 新增的 SAN 会让已有的 apiserver 证书失效
+	bootstrapTestCerts(t, config)
+	config.Cluster.CertSANs = append(config.Cluster.CertSANs, "10.0.0.9")
+	if problems := certificateProblems(t, config); problems[CertNameApiServer] == "" {
+		t.Error("Expected apiserver certificate to be renewed for new san")
+	}
+}
+
+func TestValidateCertSANs(t *testing.T) {
+	if err := ValidateCertSANs([]string{"10.0.0.1", "fd00::1", "simulator.local", "*.example.com"}); err != nil {
+		t.Errorf("Expected sans to be valid, got %v", err)
+	}
+	if err := ValidateCertSANs([]string{"under_score"}); err == nil {
+		t.Error("Expected invalid dns name to be rejected")
+	}
+}
+
+func TestCertificates_OIDCIssuer(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{DataDir: dir, CertificateDir: filepath.Join(dir, "pki")}
+	config.Cluster.ListenHost, config.Cluster.ListenPort = "10.0.0.1", "6443"
+	config.Cluster.ClientConfigFile.Administrator = filepath.Join(dir, DefaultConfKubeAdmin)
+	config.OIDC.Listen = "127.0.0.1:10281"
+	if err := config.Complete(); err != nil {
+		t.Fatalf("complete config failed: %v", err)
+	}
+	// apiserver 信任 oidc issuer, 并用集群 ca 校验它的证书
+	oidc := config.Cluster.Authentication.OIDC
+	if oidc.IssuerURL != "https://127.0.0.1:10281" || oidc.CAFile != config.Cluster.TLS.CA.CertFile || oidc.ClientID == "" {
+		t.Errorf("Unexpected oidc authentication %+v", oidc)
+	}
+
+	bootstrapTestCerts(t, config)
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Fatalf("Expected all certificates to be valid, got %v", problems)
+	}
+	if _, err := os.Stat(config.OIDC.SigningKeyFile); err != nil {
+		t.Errorf("Expected signing key of oidc issuer: %v", err)
+	}
+	if !slices.Contains(RenewableCertificates(config), CertNameOIDCIssuer) {
+		t.Error("Expected oidc issuer certificate to be renewable")
+	}
+
+	config.OIDC.Listen = "127.0.0.2:10281"
+	if problems := certificateProblems(t, config); problems[CertNameOIDCIssuer] == "" {
+		t.Error("Expected oidc issuer certificate to be renewed after listen changed")
+	}
+}
+
+func TestCertificates_Webhook(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{DataDir: dir, CertificateDir: filepath.Join(dir, "pki")}
+	config.Cluster.ListenHost, config.Cluster.ListenPort = "10.0.0.1", "6443"
+	config.Cluster.ClientConfigFile.Administrator = filepath.Join(dir, DefaultConfKubeAdmin)
+	if err := config.Complete(); err != nil {
+		t.Fatalf("complete config failed: %v", err)
+	}
+	// 没有 webhook 时不签发代理的证书
+	if slices.Contains(RenewableCertificates(config), CertNameWebhook) {
+		t.Error("Expected no webhook certificate without webhooks")
+	}
+
+	config.Webhook.Webhooks = []string{"validating:http://127.0.0.1:8080/validate"}
+	config.Webhook.Listen = "127.0.0.1:10282"
+	if err := config.Complete(); err != nil {
+		t.Fatalf("complete config failed: %v", err)
+	}
+	if config.Webhook.CACertFile != config.Cluster.TLS.CA.CertFile {
+		t.Errorf("Expected webhooks to trust the cluster ca, got %s", config.Webhook.CACertFile)
+	}
+	bootstrapTestCerts(t, config)
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Fatalf("Expected all certificates to be valid, got %v", problems)
+	}
+	if !slices.Contains(RenewableCertificates(config), CertNameWebhook) {
+		t.Error("Expected webhook certificate to be renewable")
+	}
+}
+
+func TestCertificates_FrontProxy(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	config.Aggregator.APIServices = []string{"v1alpha1.wardle.example.com=https://wardle.local:8443"}
+	if err := config.Complete(); err != nil {
+		t.Fatalf("complete config failed: %v", err)
+	}
+	bootstrapTestCerts(t, config)
+	statuses, err := CheckCertificates(config)
+	if err != nil {
+		t.Fatalf("check certificates failed: %v", err)
+	}
+	// front-proxy-client 由 front-proxy-ca 签发, 与 kubeadm 相同
+	found := false
+	for _, status := range statuses {
+		if status.Problem != "" {
+			t.Errorf("Expected %s to be valid, got %s", status.Name, status.Problem)
+		}
+		if status.Name == CertNameFrontProxyClient {
+			found = status.CA == CertNameFrontProxyCA
+		}
+	}
+	if !found {
+		t.Errorf("Expected front-proxy-client signed by front-proxy-ca, got %+v", statuses)
+	}
+	if renewable := RenewableCertificates(config); !slices.Contains(renewable, CertNameFrontProxyClient) || !slices.Contains(renewable, CertNameAPIService) {
+		t.Errorf("Expected front-proxy-client and apiservice to be renewable, got %v", renewable)
+	}
+	// 聚合 apiserver 的证书包含其主机名和 service 域名, apiserver 以后者校验证书
+	_, certificate, err := mycertutil.TryLoadCertAndKeyFromFile(config.Aggregator.Cert.KeyFile, config.Aggregator.Cert.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"wardle.local", "apiservice-v1alpha1-wardle-example-com.kube-system.svc"} {
+		if err := certificate.VerifyHostname(name); err != nil {
+			t.Errorf("Expected apiservice certificate to be valid for %s: %v", name, err)
+		}
+	}
+	if err := RenewCertificates(config, CertNameAPIService); err != nil {
+		t.Fatalf("renew apiservice certificate failed: %v", err)
+	}
+	if problems := certificateProblems(t, config); len(problems) != 0 {
+		t.Errorf("Expected all certificates to be valid after renewal, got %v", problems)
+	}
+	if header := config.Cluster.RequestHeader; !slices.Equal(header.AllowedNames, []string{"front-proxy-client"}) || len(header.UsernameHeaders) == 0 {
+		t.Errorf("Expected request headers of kubeadm, got %+v", header)
+	}
+}
@@ -4,8 +4,16 @@ import (
 	"path/filepath"
 
 	"3Xpl0it3r.com/kube-simulator/pkg/agent"
+	"3Xpl0it3r.com/kube-simulator/pkg/aggregator"
 	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
 	"3Xpl0it3r.com/kube-simulator/pkg/cluster"
+	"3Xpl0it3r.com/kube-simulator/pkg/dns"
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+	"3Xpl0it3r.com/kube-simulator/pkg/loadbalancer"
+	"3Xpl0it3r.com/kube-simulator/pkg/oidc"
+	"3Xpl0it3r.com/kube-simulator/pkg/proxy"
+	"3Xpl0it3r.com/kube-simulator/pkg/storage"
+	"3Xpl0it3r.com/kube-simulator/pkg/webhook"
 )
 
 const (
@@ -15,10 +23,27 @@ const (
 	DefaultCertNameApiServer  = "apiserver"
 	DefaultCertNameEtcdClient = "apiserver-etcd"
 	DefaultServiceAccountName = "service-account"
+	DefaultCertNameOIDCIssuer = "oidc-issuer"
+	DefaultOIDCSigningKeyName = "oidc-signing"
+	DefaultCertNameWebhook    = "webhook"
+	// front proxy certificates are named as kubeadm's
+	DefaultCertNameFrontProxyCA     = "front-proxy-ca"
+	DefaultCertNameFrontProxyClient = "front-proxy-client"
+	DefaultCertNameAPIService       = "apiservice"
 
 	DefaultConfKubeControllerManager = "kube-controller-manager.yml"
 	DefaultConfKubeScheduler         = "kube-scheduler.yml"
 	DefaultConfKubeAdmin             = "admin.conf"
+	// DefaultNodeKubeconfigDir is where kubeconfigs of nodes are in the data dir
+	DefaultNodeKubeconfigDir = "nodes"
+
+	DefaultAuditPolicyFile        = "audit-policy.yaml"
+	DefaultAuditWebhookConfigFile = "audit-webhook.conf"
+	DefaultEncryptionConfigFile   = "encryption-config.yaml"
+	DefaultKMSSocket              = "kms.sock"
+	DefaultKMSKeyName             = "kms"
+	// DefaultKineDatabase is the sqlite database of kine in the etcd data dir
+	DefaultKineDatabase = "simukube.db"
 )
 
 // EtcdConfig represent etcdconfig
@@ -33,9 +58,27 @@ type EtcdConfig struct {
 type Config struct {
 	DataDir        string
 	CertificateDir string
-	Etcd           EtcdConfig
-	Cluster        cluster.Config
-	Agent          agent.Config
+	// Cert is how keys and certificates of the cluster are generated
+	Cert         mycertutil.Options
+	Etcd         EtcdConfig
+	Cluster      cluster.Config
+	Agent        agent.Config
+	Proxy        proxy.Config
+	DNS          dns.Config
+	LoadBalancer loadbalancer.Config
+	Storage      storage.Config
+	// OIDC is the oidc issuer stand-in whose id tokens apiserver trusts
+	OIDC oidc.Config
+	// Encryption is encryption at rest of resources like secrets
+	Encryption encryption.Config
+	// Webhook is admission webhooks under development which apiserver calls through a capturing proxy
+	Webhook webhook.Config
+	// Aggregator is local aggregated apiservers apiserver proxies requests to
+	Aggregator aggregator.Config
+	// ApiListen is the address of the simulator api, which exposes state of simulated components
+	ApiListen string
+	// AuditBufferSize is how many audit events the simulator api keeps in memory, 0 disables it
+	AuditBufferSize int
 }
 
 // Complete [#TODO](should add some comments)
@@ -69,6 +112,19 @@ func (c *Config) Complete() error {
 		c.Cluster.TLS.EtcdClient.CertFile = pathForCert(c.CertificateDir, DefaultCertNameEtcdClient)
 	}
 
+	// for the aggregation layer, apiserver proxies requests with the front proxy client certificate
+	if c.Cluster.TLS.FrontProxyCA.Name == "" {
+		c.Cluster.TLS.FrontProxyCA.Name = DefaultCertNameFrontProxyCA
+		c.Cluster.TLS.FrontProxyCA.KeyFile = pathForKey(c.CertificateDir, DefaultCertNameFrontProxyCA)
+		c.Cluster.TLS.FrontProxyCA.CertFile = pathForCert(c.CertificateDir, DefaultCertNameFrontProxyCA)
+	}
+	if c.Cluster.TLS.FrontProxyClient.Name == "" {
+		c.Cluster.TLS.FrontProxyClient.Name = DefaultCertNameFrontProxyClient
+		c.Cluster.TLS.FrontProxyClient.KeyFile = pathForKey(c.CertificateDir, DefaultCertNameFrontProxyClient)
+		c.Cluster.TLS.FrontProxyClient.CertFile = pathForCert(c.CertificateDir, DefaultCertNameFrontProxyClient)
+	}
+	c.completeRequestHeader()
+
 	if c.Cluster.TLS.ServiceAccountSigningKeyFile == "" {
 		c.Cluster.TLS.ServiceAccountSigningKeyFile = pathForKey(c.CertificateDir, DefaultServiceAccountName)
 	}
@@ -82,6 +138,9 @@ func (c *Config) Complete() error {
 	if c.Cluster.ClientConfigFile.Scheduler == "" {
 		c.Cluster.ClientConfigFile.Scheduler = filepath.Join(c.DataDir, DefaultConfKubeScheduler)
 	}
+	if c.Cluster.AuthorizationMode == "" {
+		c.Cluster.AuthorizationMode = cluster.DefaultAuthorizationMode
+	}
 	if c.Cluster.ClientConfigFile.Administrator == "" {
 		c.Cluster.ClientConfigFile.Administrator = filepath.Join(DefaultConfKubeAdmin)
 	}
@@ -90,10 +149,118 @@ func (c *Config) Complete() error {
 	if c.Agent.ClientConfig == "" {
 		c.Agent.ClientConfig = c.Cluster.ClientConfigFile.Administrator
 	}
+	if c.Agent.NodeIdentity.CACertFile == "" {
+		c.Agent.NodeIdentity.CACertFile = c.Cluster.TLS.CA.CertFile
+		c.Agent.NodeIdentity.CAKeyFile = c.Cluster.TLS.CA.KeyFile
+	}
+	c.Agent.NodeIdentity.Cert = c.Cert
+	if c.Agent.NodeIdentity.KubeconfigDir == "" {
+		c.Agent.NodeIdentity.KubeconfigDir = filepath.Join(c.DataDir, DefaultNodeKubeconfigDir)
+	}
+
+	// for audit, policy is generated into the data dir unless it's specified
+	if c.Cluster.Audit.PolicyFile == "" {
+		c.Cluster.Audit.PolicyFile = filepath.Join(c.DataDir, DefaultAuditPolicyFile)
+	}
+	if c.AuditBufferSize > 0 && c.Cluster.Audit.WebhookConfigFile == "" {
+		c.Cluster.Audit.WebhookConfigFile = filepath.Join(c.DataDir, DefaultAuditWebhookConfigFile)
+	}
+
+	// for oidc issuer
+	if c.OIDC.Enabled() {
+		c.completeOIDC()
+	}
+
+	// for encryption at rest, the kms plugin serves on an absolute path as it's a part of the endpoint url
+	if c.Encryption.ConfigFile == "" {
+		c.Encryption.ConfigFile = filepath.Join(c.DataDir, DefaultEncryptionConfigFile)
+	}
+	if c.Encryption.KMSSocket == "" {
+		socket, err := filepath.Abs(filepath.Join(c.DataDir, DefaultKMSSocket))
+		if err != nil {
+			return err
+		}
+		c.Encryption.KMSSocket = socket
+	}
+	if c.Encryption.KMSKeyFile == "" {
+		c.Encryption.KMSKeyFile = pathForKey(c.CertificateDir, DefaultKMSKeyName)
+	}
+	if c.Encryption.Enabled() {
+		c.Cluster.EncryptionProviderConfigFile = c.Encryption.ConfigFile
+	}
+
+	// for aggregated apiservers
+	if c.Aggregator.Enabled() && c.Aggregator.Cert.Name == "" {
+		c.Aggregator.Cert.Name = DefaultCertNameAPIService
+		c.Aggregator.Cert.KeyFile = pathForKey(c.CertificateDir, DefaultCertNameAPIService)
+		c.Aggregator.Cert.CertFile = pathForCert(c.CertificateDir, DefaultCertNameAPIService)
+	}
+	if c.Aggregator.CACertFile == "" {
+		c.Aggregator.CACertFile = c.Cluster.TLS.CA.CertFile
+	}
+
+	// for admission webhooks
+	if c.Webhook.Enabled() && c.Webhook.Cert.Name == "" {
+		c.Webhook.Cert.Name = DefaultCertNameWebhook
+		c.Webhook.Cert.KeyFile = pathForKey(c.CertificateDir, DefaultCertNameWebhook)
+		c.Webhook.Cert.CertFile = pathForCert(c.CertificateDir, DefaultCertNameWebhook)
+	}
+	if c.Webhook.CACertFile == "" {
+		c.Webhook.CACertFile = c.Cluster.TLS.CA.CertFile
+	}
 
 	return nil
 }
 
+// completeRequestHeader fills headers which aren't specified as kubeadm does, allowed names may be empty to allow
+// any client certificate signed by the front proxy ca
+func (c *Config) completeRequestHeader() {
+	header := &c.Cluster.RequestHeader
+	if header.AllowedNames == nil {
+		header.AllowedNames = cluster.DefaultRequestHeader.AllowedNames
+	}
+	if len(header.UsernameHeaders) == 0 {
+		header.UsernameHeaders = cluster.DefaultRequestHeader.UsernameHeaders
+	}
+	if len(header.GroupHeaders) == 0 {
+		header.GroupHeaders = cluster.DefaultRequestHeader.GroupHeaders
+	}
+	if len(header.ExtraHeaderPrefixes) == 0 {
+		header.ExtraHeaderPrefixes = cluster.DefaultRequestHeader.ExtraHeaderPrefixes
+	}
+}
+
+// KineDatabase returns the sqlite database objects of apiserver are stored in
+func (c *Config) KineDatabase() string {
+	return filepath.Join(c.Etcd.DataDir, DefaultKineDatabase)
+}
+
+// completeOIDC fills files of the oidc issuer, and lets apiserver trust it
+func (c *Config) completeOIDC() {
+	if c.OIDC.ClientID == "" {
+		c.OIDC.ClientID = oidc.DefaultClientID
+	}
+	if c.OIDC.Cert.Name == "" {
+		c.OIDC.Cert.Name = DefaultCertNameOIDCIssuer
+		c.OIDC.Cert.KeyFile = pathForKey(c.CertificateDir, DefaultCertNameOIDCIssuer)
+		c.OIDC.Cert.CertFile = pathForCert(c.CertificateDir, DefaultCertNameOIDCIssuer)
+	}
+	if c.OIDC.SigningKeyFile == "" {
+		c.OIDC.SigningKeyFile = pathForKey(c.CertificateDir, DefaultOIDCSigningKeyName)
+		c.OIDC.SigningPublicKeyFile = pathForPublicKey(c.CertificateDir, DefaultOIDCSigningKeyName)
+	}
+	c.Cluster.Authentication.OIDC = cluster.OIDCAuthentication{
+		IssuerURL:      c.OIDC.IssuerURL(),
+		ClientID:       c.OIDC.ClientID,
+		CAFile:         c.Cluster.TLS.CA.CertFile,
+		UsernameClaim:  oidc.UsernameClaim,
+		GroupsClaim:    oidc.GroupsClaim,
+		UsernamePrefix: c.OIDC.UsernamePrefix,
+		GroupsPrefix:   c.OIDC.GroupsPrefix,
+		SigningAlgs:    oidc.SigningAlgs,
+	}
+}
+
 func pathForKey(certDir, name string) string {
 	return filepath.Join(certDir, name+".key")
 }
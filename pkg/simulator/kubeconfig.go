@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"github.com/pkg/errors"
+	authenticationapi "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// MinServiceAccountTokenTTL is the shortest lifetime of tokens apiserver issues
+const MinServiceAccountTokenTTL = 10 * time.Minute
+
+// KubeconfigRequest represent the identity and lifetime of a kubeconfig to create
+type KubeconfigRequest struct {
+	// User and Groups are the subject of the client certificate
+	User   string
+	Groups []string
+	// ServiceAccount is namespace/name of a service account, a token of it is used instead of a client certificate
+	ServiceAccount string
+	TTL            time.Duration
+	// Server is the url of apiserver in the kubeconfig, it defaults to the listen address of apiserver
+	Server string
+}
+
+// Validate checks that exactly one of user and service account is requested
+func (r *KubeconfigRequest) Validate() error {
+	switch {
+	case r.User == "" && r.ServiceAccount == "":
+		return errors.New("either user or service account is required")
+	case r.User != "" && r.ServiceAccount != "":
+		return errors.New("user and service account can't be both specified")
+	case r.ServiceAccount != "" && len(r.Groups) != 0:
+		return errors.New("groups of service accounts can't be specified")
+	case r.TTL <= 0:
+		return errors.New("ttl should be positive")
+	case r.ServiceAccount != "" && r.TTL < MinServiceAccountTokenTTL:
+		return errors.Errorf("ttl of service account tokens should be at least %s", MinServiceAccountTokenTTL)
+	}
+	if r.ServiceAccount != "" {
+		if _, _, err := splitServiceAccount(r.ServiceAccount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateKubeconfig creates a kubeconfig with a client certificate signed by the cluster ca, or with a token of the
+// service account requested with client. client is only used for service accounts
+func CreateKubeconfig(ctx context.Context, config *Config, client kubeclientset.Interface, request KubeconfigRequest) (*clientcmdapi.Config, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	caKey, caCert, err := mycertutil.TryLoadCertAndKeyFromFile(config.Cluster.TLS.CA.KeyFile, config.Cluster.TLS.CA.CertFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load ca from file")
+	}
+	server := request.Server
+	if server == "" {
+		server = controlPlaneOf(&config.Cluster)
+	}
+
+	if request.ServiceAccount == "" {
+		generator, err := mycertutil.NewGenerator(mycertutil.Options{KeyAlgorithm: config.Cert.KeyAlgorithm, CertValidity: request.TTL})
+		if err != nil {
+			return nil, err
+		}
+		certConfig := mycertutil.NewClientCertificateConfig(request.User, request.Groups...)
+		return generator.NewCertKubeconfig(caKey, caCert, server, certConfig)
+	}
+
+	namespace, name, _ := splitServiceAccount(request.ServiceAccount)
+	expiration := int64(request.TTL.Seconds())
+	tokenRequest := &authenticationapi.TokenRequest{
+		Spec: authenticationapi.TokenRequestSpec{ExpirationSeconds: &expiration},
+	}
+	token, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, tokenRequest, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "request token of service account %s failed", request.ServiceAccount)
+	}
+	return mycertutil.NewKubeconfig(server, caCert, serviceaccount.MakeUsername(namespace, name), &clientcmdapi.AuthInfo{
+		Token: token.Status.Token,
+	}), nil
+}
+
+// splitServiceAccount parses namespace/name of a service account
+func splitServiceAccount(value string) (string, string, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("service account %s should be namespace/name", value)
+	}
+	return parts[0], parts[1], nil
+}
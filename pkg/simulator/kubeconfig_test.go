@@ -0,0 +1,80 @@
+package simulator
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	authenticationapi "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCreateKubeconfig_User(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	bootstrapTestCerts(t, config)
+
+	kubeconfig, err := CreateKubeconfig(context.Background(), config, nil, KubeconfigRequest{User: "alice", Groups: []string{"dev", "qa"}, TTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("CreateKubeconfig failed: %v", err)
+	}
+	if kubeconfig.CurrentContext != "alice@"+ClusterDefaultName || kubeconfig.Clusters[ClusterDefaultName].Server != "https://10.0.0.1:6443" {
+		t.Errorf("Unexpected kubeconfig %+v", kubeconfig)
+	}
+	authInfo := kubeconfig.AuthInfos["alice"]
+	_, certificate, err := mycertutil.ParseCertAndKeyPEM(authInfo.ClientKeyData, authInfo.ClientCertificateData)
+	if err != nil {
+		t.Fatalf("parse client certificate failed: %v", err)
+	}
+	if certificate.Subject.CommonName != "alice" || len(certificate.Subject.Organization) != 2 || !slices.Contains(certificate.Subject.Organization, "qa") {
+		t.Errorf("Unexpected subject %v", certificate.Subject)
+	}
+	if ttl := certificate.NotAfter.Sub(certificate.NotBefore); ttl != 24*time.Hour {
+		t.Errorf("Expected client certificate valid for 24h, got %s", ttl)
+	}
+}
+
+func TestCreateKubeconfig_ServiceAccount(t *testing.T) {
+	config := newTestCertConfig(t, "10.0.0.1")
+	bootstrapTestCerts(t, config)
+	client := fake.NewSimpleClientset()
+	var expiration int64
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		request := action.(k8stesting.CreateAction).GetObject().(*authenticationapi.TokenRequest)
+		expiration = *request.Spec.ExpirationSeconds
+		request.Status.Token = "test-token"
+		return true, request, nil
+	})
+
+	request := KubeconfigRequest{ServiceAccount: "dev/builder", TTL: time.Hour, Server: "https://192.168.56.10:6443"}
+	kubeconfig, err := CreateKubeconfig(context.Background(), config, client, request)
+	if err != nil {
+		t.Fatalf("CreateKubeconfig failed: %v", err)
+	}
+	user := "system:serviceaccount:dev:builder"
+	if kubeconfig.AuthInfos[user] == nil || kubeconfig.AuthInfos[user].Token != "test-token" {
+		t.Errorf("Expected token of service account, got %+v", kubeconfig.AuthInfos)
+	}
+	if kubeconfig.Clusters[ClusterDefaultName].Server != request.Server || expiration != 3600 {
+		t.Errorf("Unexpected server %s or expiration %d", kubeconfig.Clusters[ClusterDefaultName].Server, expiration)
+	}
+}
+
+func TestKubeconfigRequest_Validate(t *testing.T) {
+	invalid := []KubeconfigRequest{
+		{TTL: time.Hour},
+		{User: "alice", ServiceAccount: "dev/builder", TTL: time.Hour},
+		{User: "alice"},
+		{ServiceAccount: "builder", TTL: time.Hour},
+		{ServiceAccount: "dev/builder", TTL: time.Minute},
+		{ServiceAccount: "dev/builder", Groups: []string{"dev"}, TTL: time.Hour},
+	}
+	for _, request := range invalid {
+		if err := request.Validate(); err == nil {
+			t.Errorf("Expected request %+v to be invalid", request)
+		}
+	}
+}
@@ -3,31 +3,52 @@ package simulator
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"3Xpl0it3r.com/kube-simulator/pkg/agent"
+	"3Xpl0it3r.com/kube-simulator/pkg/agent/netpol"
+	"3Xpl0it3r.com/kube-simulator/pkg/aggregator"
+	"3Xpl0it3r.com/kube-simulator/pkg/audit"
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
 	"3Xpl0it3r.com/kube-simulator/pkg/cluster"
+	"3Xpl0it3r.com/kube-simulator/pkg/dns"
+	"3Xpl0it3r.com/kube-simulator/pkg/encryption"
+	"3Xpl0it3r.com/kube-simulator/pkg/kuberes"
+	"3Xpl0it3r.com/kube-simulator/pkg/loadbalancer"
+	"3Xpl0it3r.com/kube-simulator/pkg/oidc"
+	"3Xpl0it3r.com/kube-simulator/pkg/proxy"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"3Xpl0it3r.com/kube-simulator/pkg/storage"
 	myutil "3Xpl0it3r.com/kube-simulator/pkg/util"
+	"3Xpl0it3r.com/kube-simulator/pkg/webhook"
 	kvapp "github.com/k3s-io/kine/pkg/app"
 	kvep "github.com/k3s-io/kine/pkg/endpoint"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	aggregatorclientset "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 )
 
 const (
-	ClusterDefaultName             = "kuberntetes"
+	ClusterDefaultName             = mycertutil.KubeconfigClusterName
 	KubeGroupWithDefault           = "kubernetes"
 	KubeGroupWithAdmin             = "system:masters"
 	KubeGroupWithControllerManager = "system:kube-controller-manager"
 	KubeGroupWithScheduler         = "system:kube-scheduler"
+	KubeGroupWithNodes             = "system:nodes"
 	KubeConfigControllerManager    = "controller-manager.yml"
 	KubeConfigScheduler            = "scheduler.yml"
 	KubeConfigAdmin                = "kube-admin.yml"
 	KubeUserAdmin                  = "kuberntetes-admin"
 )
 
-var loggerForKvStorage = logrus.WithField("component", "kvstorage")
+var (
+	loggerForKvStorage = logrus.WithField("component", "kvstorage")
+	loggerForCert      = logrus.WithField("component", "cert")
+	loggerForSimApi    = logrus.WithField("component", "simulator-api")
+)
 
 // Start [#TODO](should add some comments)
 func Start(parent context.Context, config Config) error {
@@ -36,15 +57,44 @@ func Start(parent context.Context, config Config) error {
 		return errors.Wrap(err, "bootstrap certificated failed")
 	}
 	// prepare kubeconfig for some clients like kube-controller/scheduler/kubelet.... to access apiserver
-	if err := bootstrapComponentClusterConfigs(&config.Cluster); err != nil {
+	if err := bootstrapComponentClusterConfigs(&config); err != nil {
 		return errors.Wrap(err, "bootstrap some kubeconfigs failed")
 	}
+	if err := bootstrapAuditConfigs(&config); err != nil {
+		return err
+	}
+	if err := bootstrapEncryptionConfig(&config); err != nil {
+		return err
+	}
 	// run kv storage(mock etcd) and wait kv storage ready then go on
 	runKvStorage(&config.Etcd)
 	if err := waitForKvStorageReady("", ""); err != nil {
 		return err
 	}
 
+	// simulator api runs before apiserver, which posts audit events to it
+	server := simapi.NewServer(config.ApiListen)
+	if config.AuditBufferSize > 0 {
+		auditBuffer := audit.NewBuffer(config.AuditBufferSize)
+		server.Handle(audit.WebhookPath, auditBuffer.WebhookHandler())
+		server.Handle(audit.EventsPath, auditBuffer.EventsHandler())
+	}
+	go func() {
+		if err := server.Run(parent); err != nil {
+			loggerForSimApi.WithError(err).Error("simulator api exited")
+		}
+	}()
+
+	// kms plugin runs before apiserver, which checks its status on start
+	if err := runKMSPlugin(parent, &config); err != nil {
+		return errors.Wrap(err, "start kms plugin failed")
+	}
+
+	// oidc issuer runs before apiserver, which fetches its keys on start
+	if err := runOIDCIssuer(parent, &config); err != nil {
+		return errors.Wrap(err, "start oidc issuer failed")
+	}
+
 	if err := cluster.Run(&config.Cluster); err != nil {
 		return errors.Wrap(err, "start cluster failed")
 	}
@@ -53,9 +103,162 @@ func Start(parent context.Context, config Config) error {
 		return errors.Wrap(err, "start agent failed")
 	}
 
+	client, err := kuberes.NewClusterClient("", config.Agent.ClientConfig)
+	if err != nil {
+		return errors.Wrap(err, "build clientconfig for simulator failed")
+	}
+	if err := runClusterDns(parent, client, &config); err != nil {
+		return errors.Wrap(err, "start cluster dns failed")
+	}
+	if err := runLoadBalancerController(parent, client, &config); err != nil {
+		return errors.Wrap(err, "start load balancer controller failed")
+	}
+	if err := runStorageProvisioner(parent, client, &config); err != nil {
+		return errors.Wrap(err, "start storage provisioner failed")
+	}
+	if err := runAdmissionWebhooks(parent, server, client, &config); err != nil {
+		return errors.Wrap(err, "start admission webhook proxy failed")
+	}
+	if err := registerAPIServices(parent, client, &config); err != nil {
+		return errors.Wrap(err, "register apiservices failed")
+	}
+	if err := runSimulatorApi(parent, server, client, &config); err != nil {
+		return errors.Wrap(err, "start simulator api failed")
+	}
+
 	return nil
 
 }
+
+// runSimulatorApi runs components that emulate the data plane and exposes them by the simulator api,
+// e.g. how services route requests and which connections network policies allow
+func runSimulatorApi(ctx context.Context, server *simapi.Server, client kubeclientset.Interface, config *Config) error {
+	serviceProxy := proxy.NewServiceProxy(client, config.Proxy)
+	if err := serviceProxy.Run(ctx); err != nil {
+		return err
+	}
+	server.Handle(proxy.ResolvePath, serviceProxy)
+
+	policyEngine := netpol.NewEngine(client)
+	if err := policyEngine.Run(ctx); err != nil {
+		return err
+	}
+	server.Handle(netpol.CheckPath, policyEngine.CheckHandler())
+	server.Handle(netpol.MatrixPath, policyEngine.MatrixHandler())
+	return nil
+}
+
+// runAdmissionWebhooks serves the proxy of local webhooks and registers them with apiserver, captured calls are
+// exposed by the simulator api
+func runAdmissionWebhooks(ctx context.Context, server *simapi.Server, client kubeclientset.Interface, config *Config) error {
+	if !config.Webhook.Enabled() {
+		// configurations of the last start are deleted even if no webhook is registered now
+		return webhook.EnsureConfigurations(ctx, client, &config.Webhook, nil, nil)
+	}
+	webhooks, err := config.Webhook.ParseWebhooks()
+	if err != nil {
+		return err
+	}
+	proxy, err := webhook.NewProxy(webhooks, config.Webhook.CACertFile, webhook.NewRecorder(config.Webhook.CaptureSize))
+	if err != nil {
+		return err
+	}
+	if err := proxy.Run(ctx, config.Webhook.Listen, config.Webhook.Cert); err != nil {
+		return err
+	}
+	server.Handle(webhook.ExchangesPath, proxy.ExchangesHandler())
+	server.Handle(webhook.ReplayPath, proxy.ReplayHandler())
+
+	caBundle, err := os.ReadFile(config.Webhook.CACertFile)
+	if err != nil {
+		return errors.Wrap(err, "load cluster ca")
+	}
+	return webhook.EnsureConfigurations(ctx, client, &config.Webhook, webhooks, caBundle)
+}
+
+// registerAPIServices registers local aggregated apiservers, apiserver proxies requests to them with the front
+// proxy client certificate
+func registerAPIServices(ctx context.Context, client kubeclientset.Interface, config *Config) error {
+	services, err := config.Aggregator.ParseAPIServices()
+	if err != nil {
+		return err
+	}
+	restConfig, err := kuberes.NewClusterRestConfig("", config.Agent.ClientConfig)
+	if err != nil {
+		return err
+	}
+	aggregatorClient, err := aggregatorclientset.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	var caBundle []byte
+	if config.Aggregator.Enabled() {
+		if caBundle, err = os.ReadFile(config.Aggregator.CACertFile); err != nil {
+			return errors.Wrap(err, "load cluster ca")
+		}
+	}
+	// apiservices of the last start are deleted even if none is registered now
+	return aggregator.EnsureAPIServices(ctx, client, aggregatorClient, &config.Aggregator, services, caBundle)
+}
+
+// runKMSPlugin serves the kms v2 plugin stand-in if apiserver encrypts or decrypts resources with it
+func runKMSPlugin(ctx context.Context, config *Config) error {
+	// the existing encryption config is used even if no provider is specified
+	if config.Cluster.EncryptionProviderConfigFile == "" || !encryption.UsesKMSPlugin(&config.Encryption) {
+		return nil
+	}
+	plugin, err := encryption.NewKMSPlugin(config.Encryption.KMSKeyFile)
+	if err != nil {
+		return err
+	}
+	return plugin.Run(ctx, config.Encryption.KMSSocket)
+}
+
+// runOIDCIssuer serves the oidc issuer stand-in if it's enabled
+func runOIDCIssuer(ctx context.Context, config *Config) error {
+	if !config.OIDC.Enabled() {
+		return nil
+	}
+	issuer, err := oidc.LoadIssuer(&config.OIDC)
+	if err != nil {
+		return err
+	}
+	return issuer.Run(ctx, config.OIDC.Listen, config.OIDC.Cert)
+}
+
+// runClusterDns runs the embedded dns server and registers it as the kube-dns service
+func runClusterDns(ctx context.Context, client kubeclientset.Interface, config *Config) error {
+	server, err := dns.NewServer(client, config.DNS)
+	if err != nil {
+		return err
+	}
+	if err := server.Run(ctx); err != nil {
+		return err
+	}
+	clusterIPs, err := dns.ServiceIPs(config.Cluster.ServiceCIDR)
+	if err != nil {
+		return err
+	}
+	return dns.EnsureService(ctx, client, clusterIPs, config.DNS.Listen)
+}
+
+// runLoadBalancerController runs the controller allocating ingress ips of LoadBalancer services
+func runLoadBalancerController(ctx context.Context, client kubeclientset.Interface, config *Config) error {
+	controller, err := loadbalancer.NewController(client, config.LoadBalancer)
+	if err != nil {
+		return err
+	}
+	return controller.Run(ctx)
+}
+
+// runStorageProvisioner registers the default StorageClass and runs the fake csi provisioner of it
+func runStorageProvisioner(ctx context.Context, client kubeclientset.Interface, config *Config) error {
+	if err := storage.EnsureStorageClass(ctx, client, config.Storage.StorageClass); err != nil {
+		return err
+	}
+	return storage.NewProvisioner(client).Run(ctx)
+}
+
 func runKvStorage(etcd *EtcdConfig) {
 	argsMap := map[string]string{
 		"ca-file":          etcd.CACert.CertFile,
@@ -66,7 +269,7 @@ func runKvStorage(etcd *EtcdConfig) {
 	config := kvapp.Config(args)
 	config.Listener = etcd.Listener
 	config.WaitGroup = &sync.WaitGroup{}
-	config.Endpoint = fmt.Sprintf("sqlite://%s/simukube.db?mode=rwc&_journal_mode=WAL", etcd.DataDir)
+	config.Endpoint = fmt.Sprintf("sqlite://%s/%s?mode=rwc&_journal_mode=WAL", etcd.DataDir, DefaultKineDatabase)
 	loggerForKvStorage.Infof("etcd datadir is %s", config.Endpoint)
 	go func() {
 		loggerForKvStorage.Infof("Running kv-storage")
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+
+	coreapi "k8s.io/api/core/v1"
+	storageapi "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// AnnotationDefaultClass marks the StorageClass used by claims without storageClassName
+const AnnotationDefaultClass = "storageclass.kubernetes.io/is-default-class"
+
+// EnsureStorageClass creates the default StorageClass of provisioner and its CSIDriver. existing objects
+// are left as they are, so that users can change them
+func EnsureStorageClass(ctx context.Context, client kubeclientset.Interface, name string) error {
+	class := &storageapi.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{AnnotationDefaultClass: "true"},
+		},
+		Provisioner:          DriverName,
+		ReclaimPolicy:        ptr.To(coreapi.PersistentVolumeReclaimDelete),
+		VolumeBindingMode:    ptr.To(storageapi.VolumeBindingWaitForFirstConsumer),
+		AllowVolumeExpansion: ptr.To(true),
+	}
+	if _, err := client.StorageV1().StorageClasses().Create(ctx, class, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	driver := &storageapi.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{Name: DriverName},
+		Spec: storageapi.CSIDriverSpec{
+			AttachRequired:       ptr.To(true),
+			PodInfoOnMount:       ptr.To(false),
+			VolumeLifecycleModes: []storageapi.VolumeLifecycleMode{storageapi.VolumeLifecyclePersistent},
+		},
+	}
+	if _, err := client.StorageV1().CSIDrivers().Create(ctx, driver, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coreapi "k8s.io/api/core/v1"
+	storageapi "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	coretyped "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// DriverName is the name of the fake csi driver, it's the provisioner of StorageClasses handled by simulator
+	DriverName = "csi.kube-simulator.io"
+	// DefaultStorageClass is the name of the default StorageClass, the same as kind
+	DefaultStorageClass = "standard"
+)
+
+// annotations set by kube-scheduler and external-provisioner
+const (
+	AnnotationSelectedNode  = "volume.kubernetes.io/selected-node"
+	AnnotationProvisionedBy = "pv.kubernetes.io/provisioned-by"
+)
+
+// parameterFSType is the StorageClass parameter of filesystem type, the same as external-provisioner
+const (
+	parameterFSType = "csi.storage.k8s.io/fstype"
+	defaultFSType   = "ext4"
+)
+
+// event reasons, the same as external-provisioner
+const (
+	EventReasonProvisioning       = "Provisioning"
+	EventReasonProvisioned        = "ProvisioningSucceeded"
+	EventReasonProvisioningFailed = "ProvisioningFailed"
+)
+
+var loggerForStorage = logrus.WithField("component", "csi-provisioner")
+
+// Config represent config of simulated storage
+type Config struct {
+	// StorageClass is the name of the default StorageClass created for the fake csi driver
+	StorageClass string
+}
+
+// Provisioner provisions PersistentVolumes for claims of StorageClasses whose provisioner is DriverName,
+// like external-provisioner of a csi driver does. volumes are fabricated, nothing is stored anywhere
+type Provisioner struct {
+	client      kubeclientset.Interface
+	factory     informers.SharedInformerFactory
+	claims      corelisters.PersistentVolumeClaimLister
+	volumes     corelisters.PersistentVolumeLister
+	nodes       corelisters.NodeLister
+	classes     storagelisters.StorageClassLister
+	claimQueue  workqueue.RateLimitingInterface
+	volumeQueue workqueue.RateLimitingInterface
+	recorder    record.EventRecorder
+}
+
+func NewProvisioner(client kubeclientset.Interface) *Provisioner {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&coretyped.EventSinkImpl{Interface: client.CoreV1().Events(coreapi.NamespaceAll)})
+
+	provisioner := &Provisioner{
+		client:      client,
+		factory:     factory,
+		claims:      factory.Core().V1().PersistentVolumeClaims().Lister(),
+		volumes:     factory.Core().V1().PersistentVolumes().Lister(),
+		nodes:       factory.Core().V1().Nodes().Lister(),
+		classes:     factory.Storage().V1().StorageClasses().Lister(),
+		claimQueue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		volumeQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, coreapi.EventSource{Component: DriverName}),
+	}
+	factory.Core().V1().PersistentVolumeClaims().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(provisioner.claimQueue, obj) },
+		UpdateFunc: func(_, newObj interface{}) { enqueue(provisioner.claimQueue, newObj) },
+	})
+	factory.Core().V1().PersistentVolumes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(provisioner.volumeQueue, obj) },
+		UpdateFunc: func(_, newObj interface{}) { enqueue(provisioner.volumeQueue, newObj) },
+	})
+	factory.Core().V1().Nodes().Informer()
+	factory.Storage().V1().StorageClasses().Informer()
+	return provisioner
+}
+
+// Run syncs caches and then handles claims and volumes until ctx is done, it returns once the provisioner is ready
+func (p *Provisioner) Run(ctx context.Context) error {
+	p.factory.Start(ctx.Done())
+	for informerType, synced := range p.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("sync cache of %v failed", informerType)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		p.claimQueue.ShutDown()
+		p.volumeQueue.ShutDown()
+	}()
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for processNextItem(ctx, p.claimQueue, p.syncClaim) {
+		}
+	}, time.Second)
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for processNextItem(ctx, p.volumeQueue, p.syncVolume) {
+		}
+	}, time.Second)
+	loggerForStorage.Infof("csi provisioner %s is running", DriverName)
+	return nil
+}
+
+func enqueue(queue workqueue.RateLimitingInterface, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	queue.Add(key)
+}
+
+func processNextItem(ctx context.Context, queue workqueue.RateLimitingInterface, sync func(context.Context, string) error) bool {
+	item, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(item)
+	key := item.(string)
+	if err := sync(ctx, key); err != nil {
+		loggerForStorage.WithError(err).Warnf("sync %s failed", key)
+		queue.AddRateLimited(key)
+		return true
+	}
+	queue.Forget(key)
+	return true
+}
+
+// syncClaim provisions a volume for claim key if it's unbound and its StorageClass is ours. claims of
+// WaitForFirstConsumer classes wait until kube-scheduler selected a node for them
+func (p *Provisioner) syncClaim(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	claim, err := p.claims.PersistentVolumeClaims(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if claim.Spec.VolumeName != "" || claim.DeletionTimestamp != nil {
+		return nil
+	}
+	class := p.classOf(claim)
+	if class == nil || class.Provisioner != DriverName {
+		return nil
+	}
+
+	var node *coreapi.Node
+	if class.VolumeBindingMode != nil && *class.VolumeBindingMode == storageapi.VolumeBindingWaitForFirstConsumer {
+		nodeName := claim.Annotations[AnnotationSelectedNode]
+		if nodeName == "" {
+			return nil
+		}
+		if node, err = p.nodes.Get(nodeName); err != nil {
+			return errors.Wrapf(err, "get selected node of claim %s", key)
+		}
+	}
+	volumeName := "pvc-" + string(claim.UID)
+	if _, err := p.volumes.Get(volumeName); err == nil {
+		return nil
+	}
+
+	p.recorder.Eventf(claim, coreapi.EventTypeNormal, EventReasonProvisioning, "External provisioner is provisioning volume for claim %q", key)
+	volume, err := newVolume(volumeName, claim, class, node)
+	if err != nil {
+		// the claim has to be changed by users, it's useless to retry
+		p.recorder.Eventf(claim, coreapi.EventTypeWarning, EventReasonProvisioningFailed, "failed to provision volume with StorageClass %q: %v", class.Name, err)
+		return nil
+	}
+	if _, err := p.client.CoreV1().PersistentVolumes().Create(ctx, volume, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		p.recorder.Eventf(claim, coreapi.EventTypeWarning, EventReasonProvisioningFailed, "failed to provision volume with StorageClass %q: %v", class.Name, err)
+		return err
+	}
+	p.recorder.Eventf(claim, coreapi.EventTypeNormal, EventReasonProvisioned, "Successfully provisioned volume %s", volumeName)
+	loggerForStorage.Infof("provisioned volume %s for claim %s", volumeName, key)
+	return nil
+}
+
+// syncVolume deletes volume key once it's released by its claim and the reclaim policy is Delete
+func (p *Provisioner) syncVolume(ctx context.Context, key string) error {
+	volume, err := p.volumes.Get(key)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if volume.Annotations[AnnotationProvisionedBy] != DriverName || volume.DeletionTimestamp != nil {
+		return nil
+	}
+	if volume.Status.Phase != coreapi.VolumeReleased || volume.Spec.PersistentVolumeReclaimPolicy != coreapi.PersistentVolumeReclaimDelete {
+		return nil
+	}
+	if err := p.client.CoreV1().PersistentVolumes().Delete(ctx, volume.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	loggerForStorage.Infof("deleted released volume %s", volume.Name)
+	return nil
+}
+
+// classOf returns StorageClass of claim, or nil if claim has no class or the class doesn't exist.
+// the default class is assigned to claims by kube-apiserver and kube-controller-manager
+func (p *Provisioner) classOf(claim *coreapi.PersistentVolumeClaim) *storageapi.StorageClass {
+	if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName == "" {
+		return nil
+	}
+	class, err := p.classes.Get(*claim.Spec.StorageClassName)
+	if err != nil {
+		return nil
+	}
+	return class
+}
+
+// newVolume returns a csi volume bound to claim, it's only accessible from the zone of node, or from the
+// allowed topologies of class if the volume is provisioned before scheduling
+func newVolume(name string, claim *coreapi.PersistentVolumeClaim, class *storageapi.StorageClass, node *coreapi.Node) (*coreapi.PersistentVolume, error) {
+	if claim.Spec.Selector != nil {
+		return nil, errors.New("claim Selector is not supported")
+	}
+	size, ok := claim.Spec.Resources.Requests[coreapi.ResourceStorage]
+	if !ok || size.IsZero() {
+		return nil, errors.New("claim has no storage request")
+	}
+	if len(claim.Spec.AccessModes) == 0 {
+		return nil, errors.New("claim has no access modes")
+	}
+	reclaimPolicy := coreapi.PersistentVolumeReclaimDelete
+	if class.ReclaimPolicy != nil {
+		reclaimPolicy = *class.ReclaimPolicy
+	}
+
+	source := &coreapi.CSIPersistentVolumeSource{
+		Driver:           DriverName,
+		VolumeHandle:     string(uuid.NewUUID()),
+		VolumeAttributes: make(map[string]string),
+	}
+	for key, value := range class.Parameters {
+		if !strings.HasPrefix(key, "csi.storage.k8s.io/") {
+			source.VolumeAttributes[key] = value
+		}
+	}
+	if claim.Spec.VolumeMode == nil || *claim.Spec.VolumeMode == coreapi.PersistentVolumeFilesystem {
+		source.FSType = defaultFSType
+		if fsType, ok := class.Parameters[parameterFSType]; ok {
+			source.FSType = fsType
+		}
+	}
+
+	return &coreapi.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{AnnotationProvisionedBy: DriverName},
+		},
+		Spec: coreapi.PersistentVolumeSpec{
+			Capacity:                      coreapi.ResourceList{coreapi.ResourceStorage: size},
+			AccessModes:                   claim.Spec.AccessModes,
+			VolumeMode:                    claim.Spec.VolumeMode,
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              class.Name,
+			MountOptions:                  class.MountOptions,
+			PersistentVolumeSource:        coreapi.PersistentVolumeSource{CSI: source},
+			NodeAffinity:                  volumeNodeAffinity(claim, class, node),
+			ClaimRef: &coreapi.ObjectReference{
+				Kind:            "PersistentVolumeClaim",
+				APIVersion:      "v1",
+				Namespace:       claim.Namespace,
+				Name:            claim.Name,
+				UID:             claim.UID,
+				ResourceVersion: claim.ResourceVersion,
+			},
+		},
+	}, nil
+}
+
+// volumeNodeAffinity pins the volume to the zone of node, or to node itself if nodes have no zones. without
+// node, one value of every allowed topology of class is picked by claim, so that volumes spread over zones
+func volumeNodeAffinity(claim *coreapi.PersistentVolumeClaim, class *storageapi.StorageClass, node *coreapi.Node) *coreapi.VolumeNodeAffinity {
+	var requirements []coreapi.NodeSelectorRequirement
+	switch {
+	case node != nil:
+		if zone, ok := node.Labels[coreapi.LabelTopologyZone]; ok {
+			requirements = append(requirements, coreapi.NodeSelectorRequirement{Key: coreapi.LabelTopologyZone, Operator: coreapi.NodeSelectorOpIn, Values: []string{zone}})
+		} else {
+			requirements = append(requirements, coreapi.NodeSelectorRequirement{Key: coreapi.LabelHostname, Operator: coreapi.NodeSelectorOpIn, Values: []string{node.Name}})
+		}
+	case len(class.AllowedTopologies) != 0:
+		hash := fnv.New32a()
+		hash.Write([]byte(claim.UID))
+		for _, expression := range class.AllowedTopologies[0].MatchLabelExpressions {
+			if len(expression.Values) == 0 {
+				continue
+			}
+			value := expression.Values[int(hash.Sum32()%uint32(len(expression.Values)))]
+			requirements = append(requirements, coreapi.NodeSelectorRequirement{Key: expression.Key, Operator: coreapi.NodeSelectorOpIn, Values: []string{value}})
+		}
+	}
+	if len(requirements) == 0 {
+		return nil
+	}
+	return &coreapi.VolumeNodeAffinity{
+		Required: &coreapi.NodeSelector{NodeSelectorTerms: []coreapi.NodeSelectorTerm{{MatchExpressions: requirements}}},
+	}
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	storageapi "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func newTestProvisioner(t *testing.T, objects ...runtime.Object) (*Provisioner, *record.FakeRecorder) {
+	t.Helper()
+	provisioner := NewProvisioner(fake.NewSimpleClientset(objects...))
+	recorder := record.NewFakeRecorder(10)
+	provisioner.recorder = recorder
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	provisioner.factory.Start(ctx.Done())
+	provisioner.factory.WaitForCacheSync(ctx.Done())
+	return provisioner, recorder
+}
+
+func newTestClass(mode storageapi.VolumeBindingMode) *storageapi.StorageClass {
+	return &storageapi.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: DefaultStorageClass},
+		Provisioner:       DriverName,
+		ReclaimPolicy:     ptr.To(coreapi.PersistentVolumeReclaimDelete),
+		VolumeBindingMode: ptr.To(mode),
+		Parameters:        map[string]string{"type": "ssd", parameterFSType: "xfs"},
+	}
+}
+
+func newTestClaim(name, selectedNode string) *coreapi.PersistentVolumeClaim {
+	claim := &coreapi.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+		Spec: coreapi.PersistentVolumeClaimSpec{
+			StorageClassName: ptr.To(DefaultStorageClass),
+			AccessModes:      []coreapi.PersistentVolumeAccessMode{coreapi.ReadWriteOnce},
+			Resources: coreapi.VolumeResourceRequirements{
+				Requests: coreapi.ResourceList{coreapi.ResourceStorage: resource.MustParse("5Gi")},
+			},
+		},
+	}
+	if selectedNode != "" {
+		claim.Annotations = map[string]string{AnnotationSelectedNode: selectedNode}
+	}
+	return claim
+}
+
+func newTestNode(name, zone string) *coreapi.Node {
+	labels := map[string]string{coreapi.LabelHostname: name}
+	if zone != "" {
+		labels[coreapi.LabelTopologyZone] = zone
+	}
+	return &coreapi.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestProvisioner_WaitForFirstConsumer(t *testing.T) {
+	provisioner, _ := newTestProvisioner(t,
+		newTestClass(storageapi.VolumeBindingWaitForFirstConsumer),
+		newTestClaim("unscheduled", ""),
+		newTestClaim("data", "node-1"),
+		newTestNode("node-1", "zone-a"),
+	)
+	ctx := context.Background()
+
+	// 未选定节点的 claim 不会被创建 PV
+	if err := provisioner.syncClaim(ctx, "default/unscheduled"); err != nil {
+		t.Fatalf("syncClaim should not return error: %v", err)
+	}
+	volumes, _ := provisioner.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if len(volumes.Items) != 0 {
+		t.Fatalf("Expected no volume before a node is selected, got %d", len(volumes.Items))
+	}
+
+	if err := provisioner.syncClaim(ctx, "default/data"); err != nil {
+		t.Fatalf("syncClaim should not return error: %v", err)
+	}
+	volume, err := provisioner.client.CoreV1().PersistentVolumes().Get(ctx, "pvc-data-uid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected volume to be provisioned: %v", err)
+	}
+	if size := volume.Spec.Capacity[coreapi.ResourceStorage]; size.String() != "5Gi" {
+		t.Errorf("Expected capacity 5Gi, got %s", size.String())
+	}
+	if volume.Spec.ClaimRef == nil || volume.Spec.ClaimRef.UID != "data-uid" || volume.Spec.StorageClassName != DefaultStorageClass {
+		t.Errorf("Expected volume to be bound to claim, got %+v", volume.Spec)
+	}
+	csi := volume.Spec.CSI
+	if csi == nil || csi.Driver != DriverName || csi.VolumeHandle == "" || csi.FSType != "xfs" || csi.VolumeAttributes["type"] != "ssd" {
+		t.Errorf("Unexpected csi source %+v", csi)
+	}
+	// PV 被限制在所选节点的 zone
+	terms := volume.Spec.NodeAffinity.Required.NodeSelectorTerms
+	if len(terms) != 1 || terms[0].MatchExpressions[0].Key != coreapi.LabelTopologyZone || terms[0].MatchExpressions[0].Values[0] != "zone-a" {
+		t.Errorf("Expected volume to be pinned to zone-a, got %+v", terms)
+	}
+}
+
+func TestProvisioner_Immediate(t *testing.T) {
+	class := newTestClass(storageapi.VolumeBindingImmediate)
+	class.AllowedTopologies = []coreapi.TopologySelectorTerm{{
+		MatchLabelExpressions: []coreapi.TopologySelectorLabelRequirement{{Key: coreapi.LabelTopologyZone, Values: []string{"zone-a", "zone-b"}}},
+	}}
+	block := newTestClaim("block", "")
+	block.Spec.VolumeMode = ptr.To(coreapi.PersistentVolumeBlock)
+	block.Spec.AccessModes = []coreapi.PersistentVolumeAccessMode{coreapi.ReadWriteOncePod}
+	provisioner, _ := newTestProvisioner(t, class, block)
+	ctx := context.Background()
+
+	if err := provisioner.syncClaim(ctx, "default/block"); err != nil {
+		t.Fatalf("syncClaim should not return error: %v", err)
+	}
+	volume, err := provisioner.client.CoreV1().PersistentVolumes().Get(ctx, "pvc-block-uid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected volume to be provisioned without selected node: %v", err)
+	}
+	if volume.Spec.CSI.FSType != "" || *volume.Spec.VolumeMode != coreapi.PersistentVolumeBlock || volume.Spec.AccessModes[0] != coreapi.ReadWriteOncePod {
+		t.Errorf("Expected block volume with access modes of claim, got %+v", volume.Spec)
+	}
+	zone := volume.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values
+	if len(zone) != 1 || (zone[0] != "zone-a" && zone[0] != "zone-b") {
+		t.Errorf("Expected volume in one of allowed zones, got %v", zone)
+	}
+}
+
+func TestProvisioner_HostnameTopology(t *testing.T) {
+	node := newTestNode("node-1", "")
+	claim := newTestClaim("data", "node-1")
+	affinity := volumeNodeAffinity(claim, newTestClass(storageapi.VolumeBindingWaitForFirstConsumer), node)
+	requirement := affinity.Required.NodeSelectorTerms[0].MatchExpressions[0]
+	if requirement.Key != coreapi.LabelHostname || requirement.Values[0] != "node-1" {
+		t.Errorf("Expected volume to be pinned to node without zone, got %+v", requirement)
+	}
+	if affinity := volumeNodeAffinity(claim, newTestClass(storageapi.VolumeBindingImmediate), nil); affinity != nil {
+		t.Errorf("Expected no affinity without node and allowed topologies, got %+v", affinity)
+	}
+}
+
+func TestProvisioner_Skip(t *testing.T) {
+	other := newTestClass(storageapi.VolumeBindingImmediate)
+	other.Name = "other"
+	other.Provisioner = "ebs.csi.aws.com"
+	otherClaim := newTestClaim("other", "")
+	otherClaim.Spec.StorageClassName = ptr.To("other")
+	bound := newTestClaim("bound", "")
+	bound.Spec.VolumeName = "pv-1"
+	noClass := newTestClaim("no-class", "")
+	noClass.Spec.StorageClassName = nil
+	selector := newTestClaim("selector", "")
+	selector.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+
+	provisioner, recorder := newTestProvisioner(t, newTestClass(storageapi.VolumeBindingImmediate), other, otherClaim, bound, noClass, selector)
+	ctx := context.Background()
+	for _, key := range []string{"default/other", "default/bound", "default/no-class", "default/selector", "default/missing"} {
+		if err := provisioner.syncClaim(ctx, key); err != nil {
+			t.Errorf("syncClaim %s should not return error: %v", key, err)
+		}
+	}
+	volumes, _ := provisioner.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if len(volumes.Items) != 0 {
+		t.Errorf("Expected no volume, got %d", len(volumes.Items))
+	}
+	// 带 selector 的 claim 不支持动态供应, 会产生 ProvisioningFailed 事件
+	var failed bool
+	for len(recorder.Events) != 0 {
+		if event := <-recorder.Events; strings.Contains(event, EventReasonProvisioningFailed) {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Error("Expected ProvisioningFailed event for claim with selector")
+	}
+}
+
+func TestProvisioner_DeleteReleased(t *testing.T) {
+	newVolume := func(name string, phase coreapi.PersistentVolumePhase, policy coreapi.PersistentVolumeReclaimPolicy) *coreapi.PersistentVolume {
+		return &coreapi.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{AnnotationProvisionedBy: DriverName}},
+			Spec:       coreapi.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: policy},
+			Status:     coreapi.PersistentVolumeStatus{Phase: phase},
+		}
+	}
+	foreign := newVolume("foreign", coreapi.VolumeReleased, coreapi.PersistentVolumeReclaimDelete)
+	foreign.Annotations = nil
+	provisioner, _ := newTestProvisioner(t,
+		newVolume("released", coreapi.VolumeReleased, coreapi.PersistentVolumeReclaimDelete),
+		newVolume("retained", coreapi.VolumeReleased, coreapi.PersistentVolumeReclaimRetain),
+		newVolume("bound", coreapi.VolumeBound, coreapi.PersistentVolumeReclaimDelete),
+		foreign,
+	)
+	ctx := context.Background()
+	for _, name := range []string{"released", "retained", "bound", "foreign"} {
+		if err := provisioner.syncVolume(ctx, name); err != nil {
+			t.Errorf("syncVolume %s should not return error: %v", name, err)
+		}
+	}
+	volumes, _ := provisioner.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if len(volumes.Items) != 3 {
+		t.Errorf("Expected only the released volume to be deleted, got %d volumes", len(volumes.Items))
+	}
+	for _, volume := range volumes.Items {
+		if volume.Name == "released" {
+			t.Error("Expected released volume to be deleted")
+		}
+	}
+}
+
+func TestEnsureStorageClass(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	if err := EnsureStorageClass(ctx, client, DefaultStorageClass); err != nil {
+		t.Fatalf("EnsureStorageClass should not return error: %v", err)
+	}
+	class, err := client.StorageV1().StorageClasses().Get(ctx, DefaultStorageClass, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected storage class to be created: %v", err)
+	}
+	if class.Annotations[AnnotationDefaultClass] != "true" || class.Provisioner != DriverName || *class.VolumeBindingMode != storageapi.VolumeBindingWaitForFirstConsumer {
+		t.Errorf("Unexpected storage class %+v", class)
+	}
+	if _, err := client.StorageV1().CSIDrivers().Get(ctx, DriverName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected csi driver to be created: %v", err)
+	}
+	// 已存在的对象保持不变
+	class.Annotations[AnnotationDefaultClass] = "false"
+	client.StorageV1().StorageClasses().Update(ctx, class, metav1.UpdateOptions{})
+	if err := EnsureStorageClass(ctx, client, DefaultStorageClass); err != nil {
+		t.Fatalf("EnsureStorageClass should be idempotent: %v", err)
+	}
+	class, _ = client.StorageV1().StorageClasses().Get(ctx, DefaultStorageClass, metav1.GetOptions{})
+	if class.Annotations[AnnotationDefaultClass] != "false" {
+		t.Error("Expected existing storage class not to be changed")
+	}
+}
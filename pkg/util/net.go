@@ -2,7 +2,11 @@ package util
 
 import (
 	"errors"
+	"fmt"
+	"math/big"
 	"net"
+	"net/netip"
+	"strings"
 )
 
 func GetLocalIP() (string, error) {
@@ -32,3 +36,62 @@ func GetLocalIP() (string, error) {
 
 	return "", errors.New("no valid local IP found")
 }
+
+// ParseDualStackCIDRs parses comma-separated cidrs like "10.244.0.0/16,fd00:10:244::/56",
+// at most one cidr of each family is allowed, the first one is the primary family
+func ParseDualStackCIDRs(cidrs string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("cidr %s is invalid: %v", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("no cidr provided")
+	}
+	if len(prefixes) > 2 {
+		return nil, fmt.Errorf("%s has more than 2 cidrs", cidrs)
+	}
+	if len(prefixes) == 2 && prefixes[0].Addr().Is4() == prefixes[1].Addr().Is4() {
+		return nil, fmt.Errorf("%s should be a pair of IPv4 and IPv6 cidrs", cidrs)
+	}
+	return prefixes, nil
+}
+
+// SubnetOf returns the index-th subnet with prefix length bits of prefix,
+// e.g. the 2nd /24 subnet of 10.244.0.0/16 is 10.244.2.0/24
+func SubnetOf(prefix netip.Prefix, bits int, index uint64) (netip.Prefix, error) {
+	if bits < prefix.Bits() || bits > prefix.Addr().BitLen() {
+		return netip.Prefix{}, fmt.Errorf("prefix length /%d is out of the range of %s", bits, prefix)
+	}
+	if subnetBits := bits - prefix.Bits(); subnetBits < 64 && index >= uint64(1)<<subnetBits {
+		return netip.Prefix{}, fmt.Errorf("%s has only %d subnets of /%d", prefix, uint64(1)<<subnetBits, bits)
+	}
+	addr := AddToAddr(prefix.Masked().Addr(), new(big.Int).Lsh(new(big.Int).SetUint64(index), uint(prefix.Addr().BitLen()-bits)))
+	return netip.PrefixFrom(addr, bits), nil
+}
+
+// AddToAddr returns addr plus offset, it wraps around on overflow
+func AddToAddr(addr netip.Addr, offset *big.Int) netip.Addr {
+	value := new(big.Int).SetBytes(addr.AsSlice())
+	value.Add(value, offset)
+	buf := make([]byte, addr.BitLen()/8)
+	bytes := value.Bytes()
+	if len(bytes) > len(buf) {
+		bytes = bytes[len(bytes)-len(buf):]
+	}
+	copy(buf[len(buf)-len(bytes):], bytes)
+	result, _ := netip.AddrFromSlice(buf)
+	return result
+}
+
+// AddrOffset returns how far addr is from base
+func AddrOffset(base, addr netip.Addr) *big.Int {
+	return new(big.Int).Sub(new(big.Int).SetBytes(addr.AsSlice()), new(big.Int).SetBytes(base.AsSlice()))
+}
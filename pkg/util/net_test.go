@@ -2,6 +2,7 @@ package util
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 )
 
@@ -56,3 +57,61 @@ func TestGetLocalIP_NetworkInterface(t *testing.T) {
 		t.Errorf("返回的IP %s 格式无效", ip)
 	}
 }
+
+func TestParseDualStackCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   string
+		want    int
+		wantErr bool
+	}{
+		{name: "单栈 IPv4", cidrs: "10.244.0.0/16", want: 1},
+		{name: "单栈 IPv6", cidrs: "fd00:10:244::/56", want: 1},
+		{name: "双栈", cidrs: "10.244.0.0/16, fd00:10:244::/56", want: 2},
+		{name: "空", cidrs: "", wantErr: true},
+		{name: "格式错误", cidrs: "10.244.0.0", wantErr: true},
+		{name: "同一地址族", cidrs: "10.244.0.0/16,10.245.0.0/16", wantErr: true},
+		{name: "超过两个", cidrs: "10.244.0.0/16,fd00::/56,fd01::/56", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDualStackCIDRs(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDualStackCIDRs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseDualStackCIDRs() = %v, want %d cidrs", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubnetOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		bits    int
+		index   uint64
+		want    string
+		wantErr bool
+	}{
+		{name: "IPv4", prefix: "10.244.0.0/16", bits: 24, index: 2, want: "10.244.2.0/24"},
+		{name: "IPv4 跨字节", prefix: "10.244.0.0/16", bits: 26, index: 5, want: "10.244.1.64/26"},
+		{name: "IPv6", prefix: "fd00:10:244::/56", bits: 64, index: 1, want: "fd00:10:244:1::/64"},
+		{name: "超出范围", prefix: "10.244.0.0/16", bits: 24, index: 256, wantErr: true},
+		{name: "掩码过短", prefix: "10.244.0.0/16", bits: 8, index: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SubnetOf(netip.MustParsePrefix(tt.prefix), tt.bits, tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SubnetOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("SubnetOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
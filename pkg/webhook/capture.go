@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// paths of the simulator api, exchanges of webhooks are listed at ExchangesPath and replayed at ReplayPath
+const (
+	ExchangesPath = "/webhook/exchanges"
+	ReplayPath    = "/webhook/replay"
+)
+
+// Exchange represent a call of webhook, the AdmissionReview sent to it and the one it responded with
+type Exchange struct {
+	ID      int             `json:"id"`
+	Webhook string          `json:"webhook"`
+	Time    metav1.Time     `json:"time"`
+	Latency metav1.Duration `json:"latency"`
+	// ReplayOf is the id of the exchange whose request is sent again
+	ReplayOf int                          `json:"replayOf,omitempty"`
+	Request  *admissionv1.AdmissionReview `json:"request,omitempty"`
+	Response *admissionv1.AdmissionReview `json:"response,omitempty"`
+	// Error is why the webhook failed to respond, apiserver applies the failure policy in that case
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder keeps the latest exchanges of webhooks in memory, the oldest ones are dropped once it's full
+type Recorder struct {
+	sync.Mutex
+	size      int
+	lastID    int
+	exchanges []Exchange
+}
+
+// NewRecorder returns a recorder keeping size exchanges, nothing is kept if size isn't positive
+func NewRecorder(size int) *Recorder {
+	return &Recorder{size: size}
+}
+
+// Record assigns an id to exchange and keeps it
+func (r *Recorder) Record(exchange *Exchange) {
+	if r.size <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.lastID++
+	exchange.ID = r.lastID
+	r.exchanges = append(r.exchanges, *exchange)
+	if len(r.exchanges) > r.size {
+		r.exchanges = append([]Exchange{}, r.exchanges[len(r.exchanges)-r.size:]...)
+	}
+}
+
+// Get returns the exchange of id if it's still kept
+func (r *Recorder) Get(id int) (Exchange, bool) {
+	r.Lock()
+	defer r.Unlock()
+	for _, exchange := range r.exchanges {
+		if exchange.ID == id {
+			return exchange, true
+		}
+	}
+	return Exchange{}, false
+}
+
+// List returns exchanges of webhook from the oldest to the newest, at most limit newest ones if it's positive.
+// exchanges of all webhooks are returned if webhook is empty
+func (r *Recorder) List(webhook string, limit int) []Exchange {
+	r.Lock()
+	defer r.Unlock()
+	exchanges := make([]Exchange, 0, len(r.exchanges))
+	for _, exchange := range r.exchanges {
+		if webhook == "" || exchange.Webhook == webhook {
+			exchanges = append(exchanges, exchange)
+		}
+	}
+	if limit > 0 && len(exchanges) > limit {
+		exchanges = exchanges[len(exchanges)-limit:]
+	}
+	return exchanges
+}
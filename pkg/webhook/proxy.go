@@ -0,0 +1,221 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"3Xpl0it3r.com/kube-simulator/pkg/simapi"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// callTimeout is the same as the default timeout of webhooks in apiserver
+	callTimeout = 10 * time.Second
+	// maxReviewSize bounds AdmissionReviews read from apiserver and webhooks
+	maxReviewSize = 16 << 20
+)
+
+// Proxy serves apiserver over https with a certificate of the cluster ca, and forwards AdmissionReviews to local
+// webhooks. each call is recorded so that it can be inspected and replayed
+type Proxy struct {
+	webhooks map[string]Webhook
+	client   *http.Client
+	recorder *Recorder
+}
+
+// NewProxy returns a proxy of webhooks, webhooks serving over https are verified with the system roots and caCertFile
+func NewProxy(webhooks []Webhook, caCertFile string, recorder *Recorder) (*Proxy, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if caCertFile != "" {
+		caPEM, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load cluster ca for webhooks")
+		}
+		roots.AppendCertsFromPEM(caPEM)
+	}
+	proxy := &Proxy{
+		webhooks: make(map[string]Webhook, len(webhooks)),
+		client: &http.Client{
+			Timeout:   callTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+		recorder: recorder,
+	}
+	for _, webhook := range webhooks {
+		proxy.webhooks[webhook.Name] = webhook
+	}
+	return proxy, nil
+}
+
+// ServeHTTP forwards the AdmissionReview of apiserver to the webhook of the path, webhooks which fail to respond
+// are reported with 502 so that apiserver applies the failure policy
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := p.webhooks[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exchange, response := p.call(r.Context(), webhook, body)
+	p.recorder.Record(exchange)
+	if exchange.Error != "" {
+		loggerForWebhook.Warnf("webhook %s failed: %s", webhook.Name, exchange.Error)
+		http.Error(w, exchange.Error, http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// call sends body to webhook, it returns the exchange and the raw response of webhook
+func (p *Proxy) call(ctx context.Context, webhook Webhook, body []byte) (*Exchange, []byte) {
+	exchange := &Exchange{Webhook: webhook.Name, Time: metav1.Now()}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err == nil {
+		exchange.Request = review
+	}
+	defer func() {
+		exchange.Latency = metav1.Duration{Duration: time.Since(exchange.Time.Time)}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		exchange.Error = err.Error()
+		return exchange, nil
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		return exchange, nil
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(io.LimitReader(resp.Body, maxReviewSize))
+	if err != nil {
+		exchange.Error = err.Error()
+		return exchange, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		exchange.Error = "webhook returns " + resp.Status + ": " + strings.TrimSpace(string(response))
+		return exchange, nil
+	}
+	review = &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(response, review); err != nil {
+		exchange.Error = "decode response of webhook: " + err.Error()
+		return exchange, nil
+	}
+	if review.Response == nil {
+		exchange.Error = "webhook returns no response in AdmissionReview"
+	}
+	exchange.Response = review
+	return exchange, response
+}
+
+// Replay sends the request of exchange id to its webhook again, e.g. after the webhook is fixed, and records
+// the new exchange
+func (p *Proxy) Replay(ctx context.Context, id int) (*Exchange, error) {
+	original, ok := p.recorder.Get(id)
+	if !ok {
+		return nil, errors.Errorf("exchange %d isn't captured or is dropped", id)
+	}
+	if original.Request == nil {
+		return nil, errors.Errorf("request of exchange %d isn't an AdmissionReview", id)
+	}
+	webhook, ok := p.webhooks[original.Webhook]
+	if !ok {
+		return nil, errors.Errorf("webhook %s of exchange %d isn't registered", original.Webhook, id)
+	}
+	body, err := json.Marshal(original.Request)
+	if err != nil {
+		return nil, err
+	}
+	exchange, _ := p.call(ctx, webhook, body)
+	exchange.ReplayOf = id
+	p.recorder.Record(exchange)
+	return exchange, nil
+}
+
+// ExchangesHandler lists exchanges as json, query parameters are webhook and limit
+func (p *Proxy) ExchangesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+				simapi.WriteError(w, http.StatusBadRequest, errors.Errorf("invalid limit %s", value))
+				return
+			}
+		}
+		simapi.WriteJSON(w, http.StatusOK, p.recorder.List(r.URL.Query().Get("webhook"), limit))
+	})
+}
+
+// ReplayHandler replays the exchange of the id query parameter, and returns the new exchange
+func (p *Proxy) ReplayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			simapi.WriteError(w, http.StatusBadRequest, errors.New("id of exchange is required"))
+			return
+		}
+		exchange, err := p.Replay(r.Context(), id)
+		if err != nil {
+			simapi.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		simapi.WriteJSON(w, http.StatusOK, exchange)
+	})
+}
+
+// Run serves proxy on listen over https until ctx is done, it returns once the listener is ready
+func (p *Proxy) Run(ctx context.Context, listen string, cert mycertutil.CertKeyPair) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrapf(err, "webhook proxy listen on %s", listen)
+	}
+	server := &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		loggerForWebhook.Infof("webhook proxy listen on %s", listen)
+		if err := server.ServeTLS(listener, cert.CertFile, cert.KeyFile); err != nil && err != http.ErrServerClosed {
+			loggerForWebhook.WithError(err).Error("webhook proxy exited")
+		}
+	}()
+	return nil
+}
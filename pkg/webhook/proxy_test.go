@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newTestBackend returns a webhook which denies objects named bad, and counts the calls
+func newTestBackend(t *testing.T, calls *int) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		review := &admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: review.Request.Name != "bad"}
+		if !response.Allowed {
+			response.Result = &metav1.Status{Message: "bad name"}
+		}
+		review.Request, review.Response = nil, response
+		json.NewEncoder(w).Encode(review)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func newTestReview(uid, name string) []byte {
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID(uid),
+			Operation: admissionv1.Create,
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Namespace: "default",
+			Name:      name,
+		},
+	}
+	body, _ := json.Marshal(review)
+	return body
+}
+
+func postReview(proxy *Proxy, path string, body []byte) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	return recorder
+}
+
+func TestProxy_Capture(t *testing.T) {
+	calls := 0
+	backend := newTestBackend(t, &calls)
+	proxy, err := NewProxy([]Webhook{{Name: "validating-0", Type: TypeValidating, URL: backend.URL}}, "", NewRecorder(10))
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+
+	recorder := postReview(proxy, "/validating-0", newTestReview("uid-1", "bad"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected response of webhook, got %d", recorder.Code)
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), review); err != nil || review.Response.Allowed || review.Response.UID != "uid-1" {
+		t.Fatalf("Expected response of webhook to be passed through, got %s", recorder.Body.String())
+	}
+	if recorder := postReview(proxy, "/mutating-0", newTestReview("uid-2", "good")); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown webhook to be not found, got %d", recorder.Code)
+	}
+
+	// 请求和响应都被记录下来
+	exchanges := proxy.recorder.List("", 0)
+	if len(exchanges) != 1 {
+		t.Fatalf("Expected 1 exchange, got %d", len(exchanges))
+	}
+	exchange := exchanges[0]
+	if exchange.ID != 1 || exchange.Webhook != "validating-0" || exchange.Request.Request.Name != "bad" || exchange.Response.Response.Result.Message != "bad name" {
+		t.Errorf("Unexpected exchange %+v", exchange)
+	}
+}
+
+func TestProxy_Replay(t *testing.T) {
+	calls := 0
+	backend := newTestBackend(t, &calls)
+	proxy, _ := NewProxy([]Webhook{{Name: "validating-0", Type: TypeValidating, URL: backend.URL}}, "", NewRecorder(10))
+	postReview(proxy, "/validating-0", newTestReview("uid-1", "good"))
+
+	recorder := httptest.NewRecorder()
+	proxy.ReplayHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ReplayPath+"?id=1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected exchange to be replayed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	replayed := &Exchange{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), replayed); err != nil {
+		t.Fatalf("decode exchange failed: %v", err)
+	}
+	if calls != 2 || replayed.ID != 2 || replayed.ReplayOf != 1 || !replayed.Response.Response.Allowed {
+		t.Errorf("Unexpected replayed exchange %+v after %d calls", replayed, calls)
+	}
+
+	recorder = httptest.NewRecorder()
+	proxy.ReplayHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ReplayPath+"?id=10", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown exchange to be not found, got %d", recorder.Code)
+	}
+}
+
+func TestProxy_WebhookFailure(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "panic", http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+	proxy, _ := NewProxy([]Webhook{{Name: "mutating-0", Type: TypeMutating, URL: backend.URL}}, "", NewRecorder(10))
+
+	// 失败时返回 502, 由 apiserver 按 failurePolicy 处理
+	if recorder := postReview(proxy, "/mutating-0", newTestReview("uid-1", "good")); recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected failure of webhook to be reported, got %d", recorder.Code)
+	}
+	exchanges := proxy.recorder.List("mutating-0", 0)
+	if len(exchanges) != 1 || exchanges[0].Error == "" || exchanges[0].Request == nil {
+		t.Errorf("Expected failed exchange to be captured, got %+v", exchanges)
+	}
+}
+
+func TestRecorder_List(t *testing.T) {
+	recorder := NewRecorder(3)
+	for _, name := range []string{"validating-0", "mutating-0", "validating-0", "validating-0"} {
+		recorder.Record(&Exchange{Webhook: name})
+	}
+	// 只保留最新的 3 个
+	if exchanges := recorder.List("", 0); len(exchanges) != 3 || exchanges[0].ID != 2 {
+		t.Errorf("Expected the latest 3 exchanges, got %+v", exchanges)
+	}
+	if exchanges := recorder.List("validating-0", 1); len(exchanges) != 1 || exchanges[0].ID != 4 {
+		t.Errorf("Expected the latest exchange of validating-0, got %+v", exchanges)
+	}
+	if _, ok := recorder.Get(1); ok {
+		t.Error("Expected the oldest exchange to be dropped")
+	}
+}
@@ -0,0 +1,327 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	mycertutil "3Xpl0it3r.com/kube-simulator/pkg/cert"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// Type is the type of admission webhook
+type Type string
+
+const (
+	TypeValidating Type = "validating"
+	TypeMutating   Type = "mutating"
+)
+
+const (
+	// DefaultListen is the address the webhook proxy serves apiserver on over https
+	DefaultListen = "127.0.0.1:10282"
+	// DefaultCaptureSize is how many requests and responses of webhooks are kept in memory
+	DefaultCaptureSize = 1000
+	// LabelManaged marks webhook configurations registered by simulator, stale ones are deleted on start
+	LabelManaged = "kube-simulator.io/webhook"
+	// nameSuffix makes names of webhooks fully qualified as apiserver requires
+	nameSuffix = ".webhooks.kube-simulator.io"
+)
+
+// DefaultResources and DefaultOperations are what webhooks are called for unless they are specified
+var (
+	DefaultResources  = []string{"pods"}
+	DefaultOperations = []string{string(admissionregv1.Create), string(admissionregv1.Update)}
+)
+
+// excludedNamespaces are never sent to webhooks, so that a broken webhook can't break the cluster itself
+var excludedNamespaces = []string{metav1.NamespaceSystem, "kube-node-lease"}
+
+var loggerForWebhook = logrus.WithField("component", "admission-webhook")
+
+// Webhook represent a local admission webhook under development, which apiserver calls through the proxy
+type Webhook struct {
+	// Name is the path of the webhook on the proxy, e.g. validating-0
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+	// URL is where the webhook really serves, it can be plain http as the proxy terminates tls for apiserver
+	URL string `json:"url"`
+}
+
+// ParseWebhook parses a webhook of <validating|mutating>:<url>, idx numbers webhooks of the same type
+func ParseWebhook(spec string, idx int) (Webhook, error) {
+	webhookType, rawURL, found := strings.Cut(spec, ":")
+	if !found {
+		return Webhook{}, errors.Errorf("webhook %s should be <validating|mutating>:<url>", spec)
+	}
+	if Type(webhookType) != TypeValidating && Type(webhookType) != TypeMutating {
+		return Webhook{}, errors.Errorf("type of webhook %s should be validating or mutating", spec)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Webhook{}, errors.Wrapf(err, "invalid url of webhook %s", spec)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, errors.Errorf("url of webhook %s should be http(s)://<host>[:port][/path]", spec)
+	}
+	return Webhook{Name: webhookType + "-" + strconv.Itoa(idx), Type: Type(webhookType), URL: rawURL}, nil
+}
+
+// Config represent admission webhooks registered by simulator, it's disabled if there are no webhooks
+type Config struct {
+	// Webhooks are specs of <validating|mutating>:<url>
+	Webhooks []string
+	// Listen is the address of the proxy which captures requests and responses of webhooks
+	Listen string
+	// Resources are resource[.group][/subresource] webhooks are called for, e.g. pods or deployments.apps/scale
+	Resources     []string
+	Operations    []string
+	FailurePolicy string
+	// Cert is the serving certificate of the proxy signed by the cluster ca, webhooks may serve with it too
+	Cert mycertutil.CertKeyPair
+	// CACertFile is the cluster ca, apiserver verifies the proxy and the proxy verifies webhooks with it
+	CACertFile  string
+	CaptureSize int
+}
+
+// Enabled returns true if any webhook is registered
+func (c *Config) Enabled() bool {
+	return len(c.Webhooks) != 0
+}
+
+// ParseWebhooks returns webhooks of config, webhooks of each type are numbered from 0
+func (c *Config) ParseWebhooks() ([]Webhook, error) {
+	counts := map[string]int{}
+	webhooks := make([]Webhook, 0, len(c.Webhooks))
+	for _, spec := range c.Webhooks {
+		webhookType, _, _ := strings.Cut(spec, ":")
+		webhook, err := ParseWebhook(spec, counts[webhookType])
+		if err != nil {
+			return nil, err
+		}
+		counts[webhookType]++
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// Validate checks webhooks, rules and the listen of the proxy
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if _, err := c.ParseWebhooks(); err != nil {
+		return err
+	}
+	if _, err := rulesOf(c.Resources, c.Operations); err != nil {
+		return err
+	}
+	switch admissionregv1.FailurePolicyType(c.FailurePolicy) {
+	case admissionregv1.Fail, admissionregv1.Ignore:
+	default:
+		return errors.Errorf("failure policy %s should be Fail or Ignore", c.FailurePolicy)
+	}
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return errors.Wrap(err, "invalid webhook listen")
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return errors.Errorf("host of webhook listen %s is required, it's a part of the url apiserver calls", c.Listen)
+	}
+	if c.CaptureSize < 0 {
+		return errors.New("webhook capture size should not be negative")
+	}
+	return nil
+}
+
+// rulesOf returns rules of resources and operations, resources of the same group share a rule
+func rulesOf(resources, operations []string) ([]admissionregv1.RuleWithOperations, error) {
+	if len(resources) == 0 {
+		return nil, errors.New("resources of webhooks are required")
+	}
+	var ops []admissionregv1.OperationType
+	for _, operation := range operations {
+		op := admissionregv1.OperationType(strings.ToUpper(operation))
+		switch op {
+		case admissionregv1.Create, admissionregv1.Update, admissionregv1.Delete, admissionregv1.Connect, admissionregv1.OperationAll:
+		default:
+			return nil, errors.Errorf("operation %s should be one of CREATE, UPDATE, DELETE, CONNECT and *", operation)
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("operations of webhooks are required")
+	}
+
+	groups := map[string]sets.Set[string]{}
+	for _, resource := range resources {
+		name, subresource, _ := strings.Cut(resource, "/")
+		name, group, _ := strings.Cut(name, ".")
+		if name == "" {
+			return nil, errors.Errorf("invalid resource %s of webhooks", resource)
+		}
+		if subresource != "" {
+			name += "/" + subresource
+		}
+		if groups[group] == nil {
+			groups[group] = sets.New[string]()
+		}
+		groups[group].Insert(name)
+	}
+	rules := make([]admissionregv1.RuleWithOperations, 0, len(groups))
+	for _, group := range sets.List(sets.KeySet(groups)) {
+		rules = append(rules, admissionregv1.RuleWithOperations{
+			Operations: ops,
+			Rule: admissionregv1.Rule{
+				APIGroups:   []string{group},
+				APIVersions: []string{"*"},
+				Resources:   sets.List(groups[group]),
+				Scope:       ptr.To(admissionregv1.AllScopes),
+			},
+		})
+	}
+	return rules, nil
+}
+
+// configurationName is the name of the webhook configuration registering webhook
+func configurationName(webhook Webhook) string {
+	return "kube-simulator-" + webhook.Name
+}
+
+// EnsureConfigurations registers each webhook as a Validating/MutatingWebhookConfiguration which calls the proxy
+// at https://<listen>/<name>. configurations of webhooks removed since the last start are deleted
+func EnsureConfigurations(ctx context.Context, client kubeclientset.Interface, config *Config, webhooks []Webhook, caBundle []byte) error {
+	// the proxy doesn't run without webhooks, configurations calling it would fail requests
+	if len(webhooks) == 0 {
+		return deleteStaleConfigurations(ctx, client, sets.New[string]())
+	}
+	rules, err := rulesOf(config.Resources, config.Operations)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{LabelManaged: "true"}
+	namespaceSelector := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: coreapi.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: excludedNamespaces},
+	}}
+	failurePolicy := admissionregv1.FailurePolicyType(config.FailurePolicy)
+	sideEffects := admissionregv1.SideEffectClassNone
+
+	names := sets.New[string]()
+	for _, webhook := range webhooks {
+		clientConfig := admissionregv1.WebhookClientConfig{
+			URL:      ptr.To((&url.URL{Scheme: "https", Host: config.Listen, Path: "/" + webhook.Name}).String()),
+			CABundle: caBundle,
+		}
+		meta := metav1.ObjectMeta{Name: configurationName(webhook), Labels: labels}
+		names.Insert(meta.Name)
+		if webhook.Type == TypeValidating {
+			err = ensureValidating(ctx, client, &admissionregv1.ValidatingWebhookConfiguration{
+				ObjectMeta: meta,
+				Webhooks: []admissionregv1.ValidatingWebhook{{
+					Name:                    webhook.Name + nameSuffix,
+					ClientConfig:            clientConfig,
+					Rules:                   rules,
+					FailurePolicy:           &failurePolicy,
+					SideEffects:             &sideEffects,
+					NamespaceSelector:       namespaceSelector,
+					AdmissionReviewVersions: []string{"v1"},
+				}},
+			})
+		} else {
+			err = ensureMutating(ctx, client, &admissionregv1.MutatingWebhookConfiguration{
+				ObjectMeta: meta,
+				Webhooks: []admissionregv1.MutatingWebhook{{
+					Name:                    webhook.Name + nameSuffix,
+					ClientConfig:            clientConfig,
+					Rules:                   rules,
+					FailurePolicy:           &failurePolicy,
+					SideEffects:             &sideEffects,
+					NamespaceSelector:       namespaceSelector,
+					AdmissionReviewVersions: []string{"v1"},
+					ReinvocationPolicy:      ptr.To(admissionregv1.NeverReinvocationPolicy),
+				}},
+			})
+		}
+		if err != nil {
+			return errors.Wrapf(err, "register webhook %s failed", webhook.Name)
+		}
+	}
+	return deleteStaleConfigurations(ctx, client, names)
+}
+
+func ensureValidating(ctx context.Context, client kubeclientset.Interface, configuration *admissionregv1.ValidatingWebhookConfiguration) error {
+	configurations := client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existing, err := configurations.Get(ctx, configuration.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configurations.Create(ctx, configuration, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	configuration.ResourceVersion = existing.ResourceVersion
+	_, err = configurations.Update(ctx, configuration, metav1.UpdateOptions{})
+	return err
+}
+
+func ensureMutating(ctx context.Context, client kubeclientset.Interface, configuration *admissionregv1.MutatingWebhookConfiguration) error {
+	configurations := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	existing, err := configurations.Get(ctx, configuration.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configurations.Create(ctx, configuration, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	configuration.ResourceVersion = existing.ResourceVersion
+	_, err = configurations.Update(ctx, configuration, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteStaleConfigurations deletes configurations registered by simulator except those of names
+func deleteStaleConfigurations(ctx context.Context, client kubeclientset.Interface, names sets.Set[string]) error {
+	selector := metav1.ListOptions{LabelSelector: LabelManaged + "=true"}
+	admissionreg := client.AdmissionregistrationV1()
+	validating, err := admissionreg.ValidatingWebhookConfigurations().List(ctx, selector)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, configuration := range validating.Items {
+		if !names.Has(configuration.Name) {
+			stale = append(stale, configuration.Name)
+			err = admissionreg.ValidatingWebhookConfigurations().Delete(ctx, configuration.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	mutating, err := admissionreg.MutatingWebhookConfigurations().List(ctx, selector)
+	if err != nil {
+		return err
+	}
+	for _, configuration := range mutating.Items {
+		if !names.Has(configuration.Name) {
+			stale = append(stale, configuration.Name)
+			err = admissionreg.MutatingWebhookConfigurations().Delete(ctx, configuration.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	if len(stale) != 0 {
+		sort.Strings(stale)
+		loggerForWebhook.Infof("deleted stale webhook configurations %v", stale)
+	}
+	return nil
+}
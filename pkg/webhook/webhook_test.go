@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestConfig(webhooks ...string) *Config {
+	return &Config{
+		Webhooks:      webhooks,
+		Listen:        DefaultListen,
+		Resources:     DefaultResources,
+		Operations:    DefaultOperations,
+		FailurePolicy: string(admissionregv1.Fail),
+		CaptureSize:   DefaultCaptureSize,
+	}
+}
+
+func TestConfig_ParseWebhooks(t *testing.T) {
+	config := newTestConfig("validating:http://127.0.0.1:8080/validate", "mutating:https://localhost:8443/mutate", "validating:http://127.0.0.1:8081")
+	webhooks, err := config.ParseWebhooks()
+	if err != nil {
+		t.Fatalf("ParseWebhooks failed: %v", err)
+	}
+	// 同一类型的 webhook 按顺序编号
+	expected := []Webhook{
+		{Name: "validating-0", Type: TypeValidating, URL: "http://127.0.0.1:8080/validate"},
+		{Name: "mutating-0", Type: TypeMutating, URL: "https://localhost:8443/mutate"},
+		{Name: "validating-1", Type: TypeValidating, URL: "http://127.0.0.1:8081"},
+	}
+	for i := range expected {
+		if webhooks[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], webhooks[i])
+		}
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := newTestConfig("validating:http://127.0.0.1:8080/validate").Validate(); err != nil {
+		t.Errorf("Expected config to be valid, got %v", err)
+	}
+	invalid := map[string]func(*Config){
+		"no type":        func(c *Config) { c.Webhooks = []string{"http://127.0.0.1:8080"} },
+		"unknown type":   func(c *Config) { c.Webhooks = []string{"converting:http://127.0.0.1:8080"} },
+		"no host":        func(c *Config) { c.Webhooks = []string{"mutating:http:///mutate"} },
+		"operation":      func(c *Config) { c.Operations = []string{"PATCH"} },
+		"no resources":   func(c *Config) { c.Resources = nil },
+		"failure policy": func(c *Config) { c.FailurePolicy = "Retry" },
+		"listen":         func(c *Config) { c.Listen = "0.0.0.0:10282" },
+	}
+	for name, mutate := range invalid {
+		config := newTestConfig("validating:http://127.0.0.1:8080/validate")
+		mutate(config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected config with invalid %s to be rejected", name)
+		}
+	}
+}
+
+func TestRulesOf(t *testing.T) {
+	rules, err := rulesOf([]string{"pods", "deployments.apps", "pods/exec", "replicasets.apps/scale"}, []string{"create", "UPDATE"})
+	if err != nil {
+		t.Fatalf("rulesOf failed: %v", err)
+	}
+	// 同一 group 的资源合并为一条规则
+	if len(rules) != 2 {
+		t.Fatalf("Expected a rule for each group, got %+v", rules)
+	}
+	if rules[0].APIGroups[0] != "" || len(rules[0].Resources) != 2 || rules[0].Resources[0] != "pods" || rules[0].Resources[1] != "pods/exec" {
+		t.Errorf("Unexpected rule of core group %+v", rules[0])
+	}
+	if rules[1].APIGroups[0] != "apps" || len(rules[1].Resources) != 2 || rules[1].Resources[1] != "replicasets/scale" {
+		t.Errorf("Unexpected rule of apps group %+v", rules[1])
+	}
+	if rules[0].Operations[0] != admissionregv1.Create || rules[0].Operations[1] != admissionregv1.Update {
+		t.Errorf("Unexpected operations %v", rules[0].Operations)
+	}
+}
+
+func TestEnsureConfigurations(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	config := newTestConfig("validating:http://127.0.0.1:8080/validate", "mutating:http://127.0.0.1:8080/mutate")
+	webhooks, _ := config.ParseWebhooks()
+	if err := EnsureConfigurations(ctx, client, config, webhooks, []byte("ca")); err != nil {
+		t.Fatalf("EnsureConfigurations failed: %v", err)
+	}
+	validating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "kube-simulator-validating-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected validating webhook configuration: %v", err)
+	}
+	// apiserver 调用代理, 并用集群 ca 校验代理的证书
+	hook := validating.Webhooks[0]
+	if *hook.ClientConfig.URL != "https://127.0.0.1:10282/validating-0" || string(hook.ClientConfig.CABundle) != "ca" {
+		t.Errorf("Unexpected client config %+v", hook.ClientConfig)
+	}
+	if hook.Name != "validating-0.webhooks.kube-simulator.io" || *hook.FailurePolicy != admissionregv1.Fail || len(hook.NamespaceSelector.MatchExpressions) != 1 {
+		t.Errorf("Unexpected webhook %+v", hook)
+	}
+	if _, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "kube-simulator-mutating-0", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected mutating webhook configuration: %v", err)
+	}
+
+	// 再次启动时更新已有的配置, 删除不再使用的 webhook
+	config = newTestConfig("validating:http://127.0.0.1:9090/validate")
+	config.FailurePolicy = string(admissionregv1.Ignore)
+	webhooks, _ = config.ParseWebhooks()
+	if err := EnsureConfigurations(ctx, client, config, webhooks, []byte("ca")); err != nil {
+		t.Fatalf("EnsureConfigurations failed: %v", err)
+	}
+	validating, _ = client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "kube-simulator-validating-0", metav1.GetOptions{})
+	if *validating.Webhooks[0].FailurePolicy != admissionregv1.Ignore {
+		t.Errorf("Expected configuration to be updated, got %+v", validating.Webhooks[0])
+	}
+	mutating, _ := client.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if len(mutating.Items) != 0 {
+		t.Errorf("Expected stale mutating configuration to be deleted, got %d", len(mutating.Items))
+	}
+	// 不再注册 webhook 时代理不运行, 所有配置都被删除
+	if err := EnsureConfigurations(ctx, client, &Config{}, nil, nil); err != nil {
+		t.Fatalf("EnsureConfigurations failed: %v", err)
+	}
+	validatingList, _ := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if len(validatingList.Items) != 0 {
+		t.Errorf("Expected stale validating configuration to be deleted, got %d", len(validatingList.Items))
+	}
+}